@@ -0,0 +1,196 @@
+package hraftdispatcher
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+)
+
+// errBatcherStopped is the error of the writes that come after the dispatcher is shut down.
+var errBatcherStopped = http.NewError(http.ErrorCodeUnavailable, "the dispatcher is shut down")
+
+// defaultBatchSize is the maximum number of calls in a batch if Config.BatchSize is not set.
+const defaultBatchSize = 128
+
+// batchCall is a write of a caller that waits in a batch.
+type batchCall struct {
+	ctx  context.Context
+	item *command.BatchItem
+	done chan error
+}
+
+// batch is the calls of a namespace that are sent to the leader together.
+type batch struct {
+	namespace string
+	calls     []*batchCall
+	// rules is the number of rules of the calls, it is at most http.MaxRulesPerRequest.
+	rules int
+	timer *time.Timer
+}
+
+// batcher coalesces the concurrent writes of a namespace within a window into one request to the leader,
+// which enqueues their raft entries together. Each caller gets the result of its own write.
+// A batch is sent early when it is full, or when the next write would take it over http.MaxRulesPerRequest rules.
+type batcher struct {
+	service *http.Service
+	window  time.Duration
+	size    int
+
+	l sync.Mutex
+	// pending is the batch of each namespace that is waiting for its window.
+	pending map[string]*batch
+	// sending tracks the batches that are taken from pending and not delivered yet, see stop.
+	sending sync.WaitGroup
+	stopped bool
+}
+
+// newBatcher returns a batcher that sends the batches with service.
+func newBatcher(service *http.Service, window time.Duration, size int) *batcher {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	return &batcher{
+		service: service,
+		window:  window,
+		size:    size,
+		pending: make(map[string]*batch),
+	}
+}
+
+// do adds a write to the batch of the namespace and waits for its result until ctx is done.
+// The write may still be applied after ctx is done, if the batch has been sent.
+func (b *batcher) do(ctx context.Context, namespace string, item *command.BatchItem) error {
+	item.RequestId = http.RequestID(ctx)
+	call := &batchCall{
+		ctx:  ctx,
+		item: item,
+		done: make(chan error, 1),
+	}
+
+	rules := len(item.GetAddPolicies().GetRules()) + len(item.GetRemovePolicies().GetRules())
+	var full []*batch
+
+	b.l.Lock()
+	if b.stopped {
+		b.l.Unlock()
+		return errBatcherStopped
+	}
+	p, ok := b.pending[namespace]
+	if ok && p.rules+rules > http.MaxRulesPerRequest {
+		// The pending batch is sent on its own, so that a request does not carry more rules than the limit.
+		p.timer.Stop()
+		delete(b.pending, namespace)
+		b.sending.Add(1)
+		full = append(full, p)
+		ok = false
+	}
+	if !ok {
+		p = &batch{namespace: namespace}
+		b.pending[namespace] = p
+		p.timer = time.AfterFunc(b.window, func() {
+			b.flush(p)
+		})
+	}
+	p.calls = append(p.calls, call)
+	p.rules += rules
+	if len(p.calls) >= b.size {
+		p.timer.Stop()
+		delete(b.pending, namespace)
+		b.sending.Add(1)
+		full = append(full, p)
+	}
+	b.l.Unlock()
+
+	for _, p := range full {
+		go func(p *batch) {
+			defer b.sending.Done()
+			b.send(p)
+		}(p)
+	}
+
+	select {
+	case err := <-call.done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// flush sends a batch when its window ends, unless it has been sent because it is full.
+func (b *batcher) flush(p *batch) {
+	b.l.Lock()
+	if b.pending[p.namespace] != p {
+		b.l.Unlock()
+		return
+	}
+	delete(b.pending, p.namespace)
+	b.sending.Add(1)
+	b.l.Unlock()
+
+	defer b.sending.Done()
+	b.send(p)
+}
+
+// stop sends the pending batches without waiting for their windows, and waits until every batch is delivered.
+// The writes after stop fail with errBatcherStopped.
+func (b *batcher) stop() {
+	b.l.Lock()
+	b.stopped = true
+	pending := b.pending
+	b.pending = make(map[string]*batch)
+	for _, p := range pending {
+		p.timer.Stop()
+		b.sending.Add(1)
+	}
+	b.l.Unlock()
+
+	for _, p := range pending {
+		go func(p *batch) {
+			defer b.sending.Done()
+			b.send(p)
+		}(p)
+	}
+	b.sending.Wait()
+}
+
+// send sends the calls of a batch whose callers are still waiting, and delivers the result of each call.
+// The request has the earliest deadline of the callers, a caller that is canceled does not cancel the others.
+func (b *batcher) send(p *batch) {
+	var (
+		calls    []*batchCall
+		request  = &command.BatchRequest{}
+		deadline time.Time
+	)
+	for _, call := range p.calls {
+		if err := call.ctx.Err(); err != nil {
+			call.done <- err
+			continue
+		}
+		calls = append(calls, call)
+		request.Items = append(request.Items, call.item)
+		if d, ok := call.ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+			deadline = d
+		}
+	}
+	if len(calls) == 0 {
+		return
+	}
+
+	ctx := context.Background()
+	if !deadline.IsZero() {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline)
+		defer cancel()
+	}
+	errs, err := b.service.WithNamespace(p.namespace).WithContext(ctx).DoBatchRequest(request)
+	for i, call := range calls {
+		if err != nil {
+			call.done <- err
+		} else {
+			call.done <- errs[i]
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package hraftdispatcher
+
+import (
+	"context"
+	nethttp "net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatcher(t *testing.T) {
+	canceled := make(chan struct{}, 1)
+	ts := httptest.NewUnstartedServer(nethttp.HandlerFunc(func(w nethttp.ResponseWriter, r *nethttp.Request) {
+		var request command.BatchRequest
+		err := jsoniter.NewDecoder(r.Body).Decode(&request)
+		assert.NoError(t, err)
+		if request.Items[0].RequestId == "slow" {
+			// The request is canceled at the deadline of the caller, not at the timeout of the HTTP client.
+			<-r.Context().Done()
+			canceled <- struct{}{}
+			return
+		}
+		response := &command.BatchResponse{}
+		for range request.Items {
+			response.Results = append(response.Results, &command.BatchResult{})
+		}
+		b, err := jsoniter.Marshal(response)
+		assert.NoError(t, err)
+		_, _ = w.Write(b)
+	}))
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	service := http.NewClient(ts.Listener.Addr().String(), ts.Client().Transport.(*nethttp.Transport).TLSClientConfig)
+
+	b := newBatcher(service, 10*time.Millisecond, 8)
+	ctx, cancel := context.WithTimeout(http.WithRequestID(context.Background(), "slow"), 200*time.Millisecond)
+	defer cancel()
+	err := b.do(ctx, "", &command.BatchItem{})
+	// The caller gets either its own context error or the error of the canceled request.
+	assert.Equal(t, http.ErrorCodeTimeout, http.CodeOf(err))
+	select {
+	case <-canceled:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the batch request is not canceled at the deadline of the caller")
+	}
+
+	// stop sends the pending batch without waiting for its window.
+	b = newBatcher(service, time.Hour, 8)
+	done := make(chan error, 1)
+	go func() {
+		done <- b.do(context.Background(), "", &command.BatchItem{})
+	}()
+	<-time.After(100 * time.Millisecond)
+	b.stop()
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("the pending batch is not sent by stop")
+	}
+	err = b.do(context.Background(), "", &command.BatchItem{})
+	assert.Equal(t, errBatcherStopped, err)
+}
@@ -0,0 +1,451 @@
+// Package client is a Go client of the HTTP API of a casbin cluster.
+//
+// A Client is created with the addresses of some nodes of the cluster, it discovers the leader through them,
+// sends the writes to the leader and follows the redirects of the nodes. The failures that are safe to retry
+// are retried with an exponential backoff until the context is done.
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/cenkalti/backoff/v4"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	hraft "github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// defaultTimeout is the default timeout of a request.
+	defaultTimeout = 10 * time.Second
+	// defaultMaxElapsedTime is the default time after which the retries of a request stop.
+	defaultMaxElapsedTime = 30 * time.Second
+	// maxRedirects is the maximum number of redirects followed by a request.
+	maxRedirects = 5
+)
+
+// Config holds the client config.
+type Config struct {
+	// Addresses are the HTTP addresses of some nodes of the cluster, at least one is required.
+	Addresses []string
+	// TLSConfig is used to configure the mTLS client, the certificate must be accepted by the nodes.
+	TLSConfig *tls.Config
+	// Timeout is the timeout of a request that is sent once, the default is 10 seconds.
+	// The streamed requests, such as Import and Backup, are only limited by the context.
+	Timeout time.Duration
+	// MaxElapsedTime is the time after which a request is not retried any more, the default is 30 seconds.
+	// The retries also stop when the context is done, which returns the error of the context, or when the deadline
+	// of the context is before the next retry, which returns the last error. A negative value disables the retries.
+	MaxElapsedTime time.Duration
+}
+
+// Client sends the requests to a casbin cluster, it is safe for concurrent use.
+type Client struct {
+	*state
+	// namespace is the namespace that the requests are sent to.
+	namespace string
+	// group is the raft group that the requests are sent to, -1 means the group is routed by the namespace.
+	group int
+}
+
+// state is shared by a Client and the Clients returned by its With* methods.
+type state struct {
+	addresses      []string
+	httpClient     *http.Client
+	timeout        time.Duration
+	maxElapsedTime time.Duration
+
+	mu sync.Mutex
+	// next is the index of the address that the requests to any node are sent to.
+	next int
+	// leaders are the HTTP addresses of the known leaders, keyed by the routing query of a Client.
+	leaders map[string]string
+}
+
+// New returns a Client.
+func New(config *Config) (*Client, error) {
+	if config == nil {
+		return nil, errors.New("config is not provided")
+	}
+	if len(config.Addresses) == 0 {
+		return nil, errors.New("Addresses is not provided in config")
+	}
+	if config.TLSConfig == nil {
+		return nil, errors.New("TLSConfig is not provided in config")
+	}
+
+	s := &state{
+		addresses: append([]string{}, config.Addresses...),
+		httpClient: &http.Client{
+			Transport: &http2.Transport{
+				TLSClientConfig: config.TLSConfig,
+			},
+			// The redirects are followed by the Client, which remembers the leader.
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		timeout:        config.Timeout,
+		maxElapsedTime: config.MaxElapsedTime,
+		leaders:        make(map[string]string),
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+	if s.maxElapsedTime == 0 {
+		s.maxElapsedTime = defaultMaxElapsedTime
+	}
+	return &Client{state: s, group: -1}, nil
+}
+
+// WithNamespace returns a Client that sends the requests to the given namespace.
+func (c *Client) WithNamespace(namespace string) *Client {
+	n := *c
+	n.namespace = namespace
+	return &n
+}
+
+// WithGroup returns a Client that sends the requests to the given raft group.
+func (c *Client) WithGroup(group int) *Client {
+	n := *c
+	n.group = group
+	return &n
+}
+
+// request is a request to a node.
+type request struct {
+	method string
+	path   string
+	query  url.Values
+	// body is the body of the request, it can be sent several times.
+	body []byte
+	// stream is the body of a streamed request, which is sent once to the leader and never retried.
+	stream io.Reader
+	// streaming is true if the request or the response is streamed, it is not limited by the timeout.
+	streaming bool
+	// leader is true if the request must be served by the leader.
+	leader bool
+	// idempotent is true if the request is safe to repeat.
+	idempotent bool
+}
+
+// newRequest returns a request with the JSON-encoded body.
+func newRequest(method string, path string, body interface{}) (*request, error) {
+	r := &request{method: method, path: path, query: url.Values{}}
+	if body != nil {
+		b, err := jsoniter.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		r.body = b
+	}
+	return r, nil
+}
+
+// routingQuery returns the namespace and group query parameters of the Client.
+func (c *Client) routingQuery() url.Values {
+	query := url.Values{}
+	if len(c.namespace) > 0 {
+		query.Set("namespace", c.namespace)
+	}
+	if c.group >= 0 {
+		query.Set("group", strconv.Itoa(c.group))
+	}
+	return query
+}
+
+// url returns the URL of a request on the given address.
+func (c *Client) url(address string, r *request) string {
+	query := c.routingQuery()
+	for key, values := range r.query {
+		query[key] = values
+	}
+	u := url.URL{Scheme: "https", Host: address, Path: r.path, RawQuery: query.Encode()}
+	return u.String()
+}
+
+// do sends a request and calls handle with the response whose status is OK.
+// The request is retried with a backoff when it is safe, see retryable.
+func (c *Client) do(ctx context.Context, r *request, handle func(resp *http.Response) error) error {
+	if r.stream != nil {
+		// The stream cannot be sent again, so the leader is discovered before it is sent once.
+		err := c.retry(ctx, true, func() error {
+			_, err := c.leader(ctx)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		return unwrapHandleError(c.send(ctx, r, handle))
+	}
+	// A write with a request ID is applied once by the cluster, so it is safe to repeat.
+	idempotent := r.idempotent || len(hraft.RequestID(ctx)) > 0
+	return c.retry(ctx, idempotent, func() error {
+		return c.send(ctx, r, handle)
+	})
+}
+
+// retry calls fn until it succeeds, the retries stop when the error is not retryable,
+// the context is done or MaxElapsedTime is reached. A retry waits at least the Retry-After time of an overloaded error.
+func (c *Client) retry(ctx context.Context, idempotent bool, fn func() error) error {
+	var b backoff.BackOff = &backoff.StopBackOff{}
+	if c.maxElapsedTime > 0 {
+		exponential := backoff.NewExponentialBackOff()
+		exponential.InitialInterval = 100 * time.Millisecond
+		exponential.MaxElapsedTime = c.maxElapsedTime
+		b = exponential
+	}
+	retryAfter := &retryAfterBackOff{BackOff: b}
+	err := backoff.Retry(func() error {
+		err := fn()
+		if err == nil {
+			return nil
+		}
+		if e, ok := err.(*Error); ok {
+			retryAfter.wait = e.RetryAfter
+		}
+		if _, ok := err.(*handleError); !ok && retryable(err, idempotent) {
+			return err
+		}
+		return backoff.Permanent(unwrapHandleError(err))
+	}, backoff.WithContext(retryAfter, ctx))
+	if err != nil && ctx.Err() != nil {
+		// The request is given up because the context is done.
+		return ctx.Err()
+	}
+	return err
+}
+
+// retryAfterBackOff waits at least the Retry-After time of the last error before the next retry.
+type retryAfterBackOff struct {
+	backoff.BackOff
+	wait time.Duration
+}
+
+// NextBackOff implements the backoff.BackOff interface.
+func (b *retryAfterBackOff) NextBackOff() time.Duration {
+	next := b.BackOff.NextBackOff()
+	wait := b.wait
+	b.wait = 0
+	if next == backoff.Stop || next >= wait {
+		return next
+	}
+	return wait
+}
+
+// handleError is an error of the handle function of a request, the response may be partially handled,
+// so the request is not retried.
+type handleError struct {
+	err error
+}
+
+func (e *handleError) Error() string {
+	return e.err.Error()
+}
+
+// unwrapHandleError returns the error of the handle function.
+func unwrapHandleError(err error) error {
+	if e, ok := err.(*handleError); ok {
+		return e.err
+	}
+	return err
+}
+
+// send sends a request once, following the redirects to the leader.
+func (c *Client) send(ctx context.Context, r *request, handle func(resp *http.Response) error) error {
+	var address string
+	var err error
+	if r.leader {
+		address, err = c.leader(ctx)
+	} else {
+		address = c.node()
+	}
+	if err != nil {
+		return err
+	}
+
+	for i := 0; ; i++ {
+		resp, cancel, err := c.roundTrip(ctx, address, r)
+		if err != nil {
+			c.failed(address)
+			return err
+		}
+
+		if resp.StatusCode == http.StatusTemporaryRedirect || resp.StatusCode == http.StatusPermanentRedirect {
+			resp.Body.Close()
+			cancel()
+			location, err := url.Parse(resp.Header.Get("Location"))
+			if err != nil {
+				return errors.Wrap(err, "invalid redirect")
+			}
+			if i >= maxRedirects || r.stream != nil {
+				return errors.Errorf("too many redirects to %s", location.Host)
+			}
+			address = location.Host
+			c.setLeader(address)
+			continue
+		}
+
+		err = func() error {
+			defer cancel()
+			defer resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				return responseError(resp)
+			}
+			if r.leader {
+				c.setLeader(address)
+			}
+			if handle == nil {
+				return nil
+			}
+			err := handle(resp)
+			if err != nil {
+				return &handleError{err: err}
+			}
+			return nil
+		}()
+		if r.leader && leaderChanged(err) {
+			// The leader given by the node is used by the next request, otherwise it is discovered again.
+			c.failed(address)
+			if e := err.(*Error); len(e.Leader) > 0 {
+				c.setLeader(e.Leader)
+			}
+		}
+		return err
+	}
+}
+
+// roundTrip sends a request to the given address, the returned function releases the resources of the request.
+func (c *Client) roundTrip(ctx context.Context, address string, r *request) (*http.Response, context.CancelFunc, error) {
+	cancel := context.CancelFunc(func() {})
+	if !r.streaming {
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+	}
+	var body io.Reader
+	if r.stream != nil {
+		body = r.stream
+	} else if r.body != nil {
+		body = bytes.NewReader(r.body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, r.method, c.url(address, r), body)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	if id := hraft.RequestID(ctx); len(id) > 0 {
+		req.Header.Set(hraft.RequestIDHeader, id)
+	}
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	return resp, cancel, nil
+}
+
+// node returns the address that the requests to any node are sent to.
+func (c *Client) node() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addresses[c.next%len(c.addresses)]
+}
+
+// failed forgets a node that has failed, the next requests are sent to the next address.
+func (c *Client) failed(address string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, leader := range c.leaders {
+		if leader == address {
+			delete(c.leaders, key)
+		}
+	}
+	if c.addresses[c.next%len(c.addresses)] == address {
+		c.next++
+	}
+}
+
+// setLeader remembers the leader of the raft group of the Client.
+func (c *Client) setLeader(address string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.leaders[c.routingQuery().Encode()] = address
+}
+
+// leader returns the HTTP address of the leader of the raft group of the Client,
+// it is discovered from the status of the nodes if it is not known.
+func (c *Client) leader(ctx context.Context) (string, error) {
+	key := c.routingQuery().Encode()
+	c.mu.Lock()
+	address, ok := c.leaders[key]
+	c.mu.Unlock()
+	if ok {
+		return address, nil
+	}
+
+	c.mu.Lock()
+	next := c.next
+	c.mu.Unlock()
+
+	// The nodes are asked in turn, starting from the node that the other requests are sent to.
+	var lastErr error = ErrNoLeader
+	for i := 0; i < len(c.addresses); i++ {
+		status, err := c.status(ctx, c.addresses[(next+i)%len(c.addresses)])
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if len(status.Leader) == 0 {
+			continue
+		}
+		address, err := hraft.ConvertRaftAddressToHTTPAddress(status.Leader)
+		if err != nil {
+			return "", err
+		}
+		c.setLeader(address)
+		return address, nil
+	}
+	return "", lastErr
+}
+
+// status returns the status of the node of the given address.
+func (c *Client) status(ctx context.Context, address string) (*command.NodeStatus, error) {
+	r, err := newRequest(http.MethodGet, "/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, cancel, err := c.roundTrip(ctx, address, r)
+	if err != nil {
+		c.failed(address)
+		return nil, err
+	}
+	defer cancel()
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		c.failed(address)
+		return nil, responseError(resp)
+	}
+
+	var status command.NodeStatus
+	err = jsoniter.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// decodeJSON returns a handle function that decodes the JSON response into v.
+func decodeJSON(v interface{}) func(resp *http.Response) error {
+	return func(resp *http.Response) error {
+		return jsoniter.NewDecoder(resp.Body).Decode(v)
+	}
+}
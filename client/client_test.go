@@ -0,0 +1,187 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	nethttp "net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/nodece/casbin-hraft-dispatcher/http/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+// testNode is a Service with a mock store.
+type testNode struct {
+	store       *mocks.MockStore
+	service     *http.Service
+	raftAddress string
+}
+
+// newTestNode starts a Service, its raft address is the port of the Service minus 1.
+func newTestNode(t *testing.T, ctl *gomock.Controller, ts *httptest.Server) *testNode {
+	store := mocks.NewMockStore(ctl)
+	s, err := http.NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start())
+
+	host, port, err := net.SplitHostPort(s.Addr())
+	assert.NoError(t, err)
+	p, err := strconv.Atoi(port)
+	assert.NoError(t, err)
+	return &testNode{store: store, service: s, raftAddress: fmt.Sprintf("%s:%d", host, p-1)}
+}
+
+func newTestServer() (*httptest.Server, *tls.Config) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	return ts, ts.Client().Transport.(*nethttp.Transport).TLSClientConfig
+}
+
+func TestClient(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	ts, tlsConfig := newTestServer()
+	defer ts.Close()
+
+	follower := newTestNode(t, ctl, ts)
+	defer follower.service.Stop(context.Background())
+	leader := newTestNode(t, ctl, ts)
+	defer leader.service.Stop(context.Background())
+
+	follower.store.EXPECT().Leader().Return(false, leader.raftAddress).AnyTimes()
+	leader.store.EXPECT().Leader().Return(true, leader.raftAddress).AnyTimes()
+	leader.store.EXPECT().Status().Return(&command.NodeStatus{Address: leader.raftAddress, Leader: leader.raftAddress}).AnyTimes()
+
+	c, err := New(&Config{
+		Addresses:      []string{"127.0.0.1:1", follower.service.Addr()},
+		TLSConfig:      tlsConfig,
+		MaxElapsedTime: 5 * time.Second,
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// The follower does not know the leader yet, then it reports a stale leader, which is redirected.
+	stale := follower.store.EXPECT().Status().Return(&command.NodeStatus{Address: follower.raftAddress, Leader: follower.raftAddress})
+	gomock.InOrder(
+		follower.store.EXPECT().Status().Return(&command.NodeStatus{Address: follower.raftAddress}),
+		stale,
+	)
+	follower.store.EXPECT().Status().Return(&command.NodeStatus{Address: follower.raftAddress, Leader: leader.raftAddress}).
+		After(stale).AnyTimes()
+	leader.store.EXPECT().AddPolicies(gomock.Any(), &command.AddPoliciesRequest{
+		Sec:   "p",
+		PType: "p",
+		Rules: []*command.StringArray{{Items: []string{"alice", "data1", "read"}}},
+	}).Return(nil)
+	err = c.AddPolicies(ctx, "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.NoError(t, err)
+	address, err := c.Leader(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, leader.service.Addr(), address)
+
+	// The error of the server is returned with its code, a write that is rejected is not retried.
+	leader.store.EXPECT().RemovePolicies(gomock.Any(), gomock.Any()).Return(http.NewError(http.ErrorCodeRejected, "the rule does not exist"))
+	err = c.RemovePolicies(ctx, "p", "p", [][]string{{"alice", "data1", "read"}})
+	assert.Equal(t, &Error{StatusCode: nethttp.StatusUnprocessableEntity, Code: http.ErrorCodeRejected, Message: "the rule does not exist"}, err)
+	assert.EqualError(t, err, "Unprocessable Entity: the rule does not exist")
+	assert.Equal(t, ErrRejected, errors.Cause(err))
+
+	// A write that may have been applied is not retried.
+	leader.store.EXPECT().UpdatePolicy(gomock.Any(), gomock.Any()).Return(errors.New("raft is shutdown"))
+	err = c.UpdatePolicy(ctx, "p", "p", []string{"alice", "data1", "read"}, []string{"alice", "data1", "write"})
+	assert.Equal(t, ErrUnavailable, errors.Cause(err))
+
+	// An overloaded write has not been applied, it is retried after the Retry-After time.
+	gomock.InOrder(
+		leader.store.EXPECT().DeleteRole(gomock.Any(), &command.DeleteRoleRequest{Role: "guest"}).
+			Return(&http.Error{Code: http.ErrorCodeOverloaded, Message: "too many writes", RetryAfter: time.Second}),
+		leader.store.EXPECT().DeleteRole(gomock.Any(), &command.DeleteRoleRequest{Role: "guest"}).Return(nil),
+	)
+	start := time.Now()
+	err = c.DeleteRole(ctx, "guest")
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) >= time.Second)
+
+	// A read is retried.
+	gomock.InOrder(
+		follower.store.EXPECT().GetUsersForRole("admin", "domain1").Return(nil, errors.New("raft is shutdown")),
+		follower.store.EXPECT().GetUsersForRole("admin", "domain1").Return([]string{"alice"}, nil),
+	)
+	users, err := c.GetUsersForRole(ctx, "admin", "domain1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, users)
+
+	// An unauthorized request is not retried, even a read.
+	follower.store.EXPECT().GetUsersForRole("admin").Return(nil, http.NewError(http.ErrorCodeUnauthorized, "the client is not allowed")).Times(1)
+	_, err = c.GetUsersForRole(ctx, "admin")
+	assert.Equal(t, ErrUnauthorized, errors.Cause(err))
+	assert.Equal(t, nethttp.StatusUnauthorized, err.(*Error).StatusCode)
+
+	follower.store.EXPECT().Enforce("alice", "data1", "read").Return(true, nil)
+	ok, err := c.Enforce(ctx, "alice", "data1", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	follower.store.EXPECT().Export().Return(&command.NamespaceExport{
+		Rules: []*command.PolicyRule{{Sec: "p", PType: "p", Rule: []string{"alice", "data1", "read"}}},
+	}, nil)
+	var buf bytes.Buffer
+	err = c.Export(ctx, &buf, http.FormatCSV)
+	assert.NoError(t, err)
+	assert.Equal(t, "p, alice, data1, read\n", buf.String())
+
+	leader.store.EXPECT().ImportPolicies(gomock.Any(), gomock.Any()).Return(nil)
+	rules, err := c.Import(ctx, bytes.NewBufferString("p, bob, data2, write\n"), http.FormatCSV, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, rules)
+
+	leader.store.EXPECT().TransferLeadership(gomock.Any(), "node2").Return(nil)
+	assert.NoError(t, c.TransferLeadership(ctx, "node2"))
+
+	// The namespace is sent to the server.
+	leader.store.EXPECT().SetModel(gomock.Any(), &command.SetModelRequest{Text: "model"}).Return(nil)
+	assert.NoError(t, c.WithNamespace("tenant1").SetModel(ctx, "model"))
+
+	// The retries stop when the context is done.
+	follower.store.EXPECT().GetRolesForUser("alice").Return(nil, errors.New("raft is shutdown")).AnyTimes()
+	ctx, cancel := context.WithCancel(ctx)
+	time.AfterFunc(500*time.Millisecond, cancel)
+	_, err = c.GetRolesForUser(ctx, "alice")
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestClient_NoLeader(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	ts, tlsConfig := newTestServer()
+	defer ts.Close()
+
+	node := newTestNode(t, ctl, ts)
+	defer node.service.Stop(context.Background())
+	node.store.EXPECT().Status().Return(&command.NodeStatus{Address: node.raftAddress}).AnyTimes()
+
+	c, err := New(&Config{
+		Addresses:      []string{node.service.Addr()},
+		TLSConfig:      tlsConfig,
+		MaxElapsedTime: -1,
+	})
+	assert.NoError(t, err)
+	err = c.ClearPolicy(context.Background())
+	assert.Equal(t, ErrNoLeader, err)
+
+	_, err = New(&Config{TLSConfig: tlsConfig})
+	assert.EqualError(t, err, "Addresses is not provided in config")
+}
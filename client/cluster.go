@@ -0,0 +1,81 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+)
+
+// Status returns the status of a node of the cluster.
+func (c *Client) Status(ctx context.Context) (*command.NodeStatus, error) {
+	r, err := newRequest(http.MethodGet, "/status", nil)
+	if err != nil {
+		return nil, err
+	}
+	r.idempotent = true
+	var status command.NodeStatus
+	err = c.do(ctx, r, decodeJSON(&status))
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// Leader returns the HTTP address of the leader, it is discovered again if the known leader has failed.
+func (c *Client) Leader(ctx context.Context) (string, error) {
+	var address string
+	err := c.retry(ctx, true, func() error {
+		var err error
+		address, err = c.leader(ctx)
+		return err
+	})
+	return address, err
+}
+
+// JoinNode joins a node with a given serverID and raft address to the cluster.
+func (c *Client) JoinNode(ctx context.Context, serverID string, address string) error {
+	return c.write(ctx, "/nodes/join", &command.AddNodeRequest{Id: serverID, Address: address}, true)
+}
+
+// RemoveNode removes a node with a given serverID from the cluster.
+func (c *Client) RemoveNode(ctx context.Context, serverID string) error {
+	return c.write(ctx, "/nodes/remove", &command.RemoveNodeRequest{Id: serverID}, true)
+}
+
+// TransferLeadership transfers the leadership to a node with a given serverID, empty means any follower.
+func (c *Client) TransferLeadership(ctx context.Context, serverID string) error {
+	return c.write(ctx, "/nodes/transfer", &command.TransferLeadershipRequest{Id: serverID}, false)
+}
+
+// Repair forces the followers to install a snapshot of the leader, which repairs the diverged nodes.
+func (c *Client) Repair(ctx context.Context) error {
+	return c.write(ctx, "/nodes/repair", nil, true)
+}
+
+// Backup writes a backup of a node of the cluster, see Restore.
+func (c *Client) Backup(ctx context.Context, w io.Writer) error {
+	r, err := newRequest(http.MethodGet, "/backup", nil)
+	if err != nil {
+		return err
+	}
+	r.idempotent = true
+	r.streaming = true
+	return c.do(ctx, r, func(resp *http.Response) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
+}
+
+// Restore replaces the state of the cluster with a backup, the request is not retried.
+func (c *Client) Restore(ctx context.Context, reader io.Reader) error {
+	r, err := newRequest(http.MethodPut, "/restore", nil)
+	if err != nil {
+		return err
+	}
+	r.stream = reader
+	r.streaming = true
+	r.leader = true
+	return c.do(ctx, r, nil)
+}
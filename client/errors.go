@@ -0,0 +1,98 @@
+package client
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	hraft "github.com/nodece/casbin-hraft-dispatcher/http"
+)
+
+// The sentinel errors of the error codes of the nodes, errors.Cause returns one of them for an *Error.
+// ErrNoLeader is also returned when none of the nodes knows the leader of the cluster, such as during an election.
+var (
+	ErrNotLeader      = hraft.ErrNotLeader
+	ErrNoLeader       = hraft.ErrNoLeader
+	ErrTimeout        = hraft.ErrTimeout
+	ErrInvalidRequest = hraft.ErrInvalidRequest
+	ErrTooLarge       = hraft.ErrTooLarge
+	ErrConflict       = hraft.ErrConflict
+	ErrRejected       = hraft.ErrRejected
+	ErrUnauthorized   = hraft.ErrUnauthorized
+	ErrUnavailable    = hraft.ErrUnavailable
+	ErrInternal       = hraft.ErrInternal
+	ErrOverloaded     = hraft.ErrOverloaded
+)
+
+// Error is an error response of a node, with the HTTP status, the error code and the message of the server.
+type Error struct {
+	// StatusCode is the HTTP status of the response.
+	StatusCode int
+	// Code is the kind of the error, see hraft.ErrorCode.
+	Code hraft.ErrorCode
+	// Message is the error message of the server, it may be empty.
+	Message string
+	// Leader is the HTTP address of the leader given by the node, it may be empty.
+	Leader string
+	// RetryAfter is the time to wait before an overloaded request is sent again, it may be zero.
+	RetryAfter time.Duration
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if len(e.Message) == 0 {
+		return http.StatusText(e.StatusCode)
+	}
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// Cause returns the sentinel error of the code, which is returned by errors.Cause.
+func (e *Error) Cause() error {
+	return (&hraft.Error{Code: e.Code}).Cause()
+}
+
+// Unwrap returns the sentinel error of the code for the errors package of the standard library.
+func (e *Error) Unwrap() error {
+	return e.Cause()
+}
+
+// responseError returns the error of a response whose status is not OK.
+func responseError(resp *http.Response) error {
+	e := hraft.ResponseError(resp)
+	return &Error{StatusCode: resp.StatusCode, Code: e.Code, Message: e.Message, Leader: e.Leader, RetryAfter: e.RetryAfter}
+}
+
+// leaderChanged reports whether the error shows the node is not the leader any more.
+func leaderChanged(err error) bool {
+	e, ok := err.(*Error)
+	return ok && (e.Code == hraft.ErrorCodeNotLeader || e.Code == hraft.ErrorCodeNoLeader)
+}
+
+// retryable reports whether a request of the error can be sent again.
+// A request that is not safe to repeat is only sent again when the node has certainly not handled it.
+func retryable(err error, idempotent bool) bool {
+	switch err := err.(type) {
+	case *Error:
+		switch err.Code {
+		case hraft.ErrorCodeNotLeader, hraft.ErrorCodeNoLeader, hraft.ErrorCodeOverloaded:
+			return true
+		case hraft.ErrorCodeUnavailable, hraft.ErrorCodeTimeout:
+			return idempotent
+		default:
+			return false
+		}
+	case *url.Error:
+		if idempotent {
+			return true
+		}
+		// The request has not been sent if the connection cannot be established.
+		if opErr, ok := err.Err.(*net.OpError); ok && opErr.Op == "dial" {
+			return true
+		}
+		return false
+	default:
+		return err == ErrNoLeader
+	}
+}
@@ -0,0 +1,241 @@
+package client
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	hraft "github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
+)
+
+// toStringArrays converts the rules to the command type.
+func toStringArrays(rules [][]string) []*command.StringArray {
+	var items []*command.StringArray
+	for _, rule := range rules {
+		items = append(items, &command.StringArray{Items: rule})
+	}
+	return items
+}
+
+// write sends a write request to the leader.
+func (c *Client) write(ctx context.Context, path string, body interface{}, idempotent bool) error {
+	r, err := newRequest(http.MethodPut, path, body)
+	if err != nil {
+		return err
+	}
+	r.leader = true
+	r.idempotent = idempotent
+	return c.do(ctx, r, nil)
+}
+
+// AddPolicies adds a set of rules to the current policy.
+func (c *Client) AddPolicies(ctx context.Context, sec string, pType string, rules [][]string) error {
+	return c.write(ctx, "/policies/add", &command.AddPoliciesRequest{
+		Sec:   sec,
+		PType: pType,
+		Rules: toStringArrays(rules),
+	}, false)
+}
+
+// RemovePolicies removes a set of rules from the current policy.
+func (c *Client) RemovePolicies(ctx context.Context, sec string, pType string, rules [][]string) error {
+	return c.write(ctx, "/policies/remove", &command.RemovePoliciesRequest{
+		Sec:   sec,
+		PType: pType,
+		Rules: toStringArrays(rules),
+	}, false)
+}
+
+// RemoveFilteredPolicy removes a set of rules that match a pattern from the current policy.
+func (c *Client) RemoveFilteredPolicy(ctx context.Context, sec string, pType string, fieldIndex int, fieldValues ...string) error {
+	r, err := newRequest(http.MethodPut, "/policies/remove", &command.RemoveFilteredPolicyRequest{
+		Sec:         sec,
+		PType:       pType,
+		FieldIndex:  int32(fieldIndex),
+		FieldValues: fieldValues,
+	})
+	if err != nil {
+		return err
+	}
+	r.query.Set("type", "filtered")
+	r.leader = true
+	r.idempotent = true
+	return c.do(ctx, r, nil)
+}
+
+// ClearPolicy clears all rules of the current policy.
+func (c *Client) ClearPolicy(ctx context.Context) error {
+	r, err := newRequest(http.MethodPut, "/policies/remove", nil)
+	if err != nil {
+		return err
+	}
+	r.query.Set("type", "all")
+	r.leader = true
+	r.idempotent = true
+	return c.do(ctx, r, nil)
+}
+
+// UpdatePolicy updates a rule of the current policy.
+func (c *Client) UpdatePolicy(ctx context.Context, sec string, pType string, oldRule, newRule []string) error {
+	return c.write(ctx, "/policies/update", &command.UpdatePolicyRequest{
+		Sec:     sec,
+		PType:   pType,
+		OldRule: oldRule,
+		NewRule: newRule,
+	}, false)
+}
+
+// UpdatePolicies updates a set of rules of the current policy.
+func (c *Client) UpdatePolicies(ctx context.Context, sec string, pType string, oldRules, newRules [][]string) error {
+	r, err := newRequest(http.MethodPut, "/policies/update", &command.UpdatePoliciesRequest{
+		Sec:      sec,
+		PType:    pType,
+		OldRules: toStringArrays(oldRules),
+		NewRules: toStringArrays(newRules),
+	})
+	if err != nil {
+		return err
+	}
+	r.query.Set("type", "batch")
+	r.leader = true
+	return c.do(ctx, r, nil)
+}
+
+// SetModel replaces the model of all nodes.
+func (c *Client) SetModel(ctx context.Context, text string) error {
+	return c.write(ctx, "/model", &command.SetModelRequest{Text: text}, true)
+}
+
+// AssignRole assigns a role to a user.
+func (c *Client) AssignRole(ctx context.Context, user string, role string, domain ...string) error {
+	return c.write(ctx, "/roles/assign", &command.AssignRoleRequest{
+		User:   user,
+		Role:   role,
+		Domain: domain,
+	}, true)
+}
+
+// UnassignRole unassigns a role from a user.
+func (c *Client) UnassignRole(ctx context.Context, user string, role string, domain ...string) error {
+	return c.write(ctx, "/roles/unassign", &command.UnassignRoleRequest{
+		User:   user,
+		Role:   role,
+		Domain: domain,
+	}, true)
+}
+
+// DeleteRole deletes a role with its grouping rules and policy rules.
+func (c *Client) DeleteRole(ctx context.Context, role string) error {
+	return c.write(ctx, "/roles/delete", &command.DeleteRoleRequest{Role: role}, true)
+}
+
+// GetUsersForRole returns the users that have a role.
+func (c *Client) GetUsersForRole(ctx context.Context, role string, domain ...string) ([]string, error) {
+	r, err := newRequest(http.MethodGet, "/roles/users", nil)
+	if err != nil {
+		return nil, err
+	}
+	r.query.Set("role", role)
+	r.query["domain"] = domain
+	r.idempotent = true
+	var users []string
+	err = c.do(ctx, r, decodeJSON(&users))
+	return users, err
+}
+
+// GetRolesForUser returns the roles that a user has directly.
+func (c *Client) GetRolesForUser(ctx context.Context, user string, domain ...string) ([]string, error) {
+	return c.getRolesForUser(ctx, user, false, domain)
+}
+
+// GetImplicitRolesForUser returns the roles that a user has directly or through role inheritance.
+func (c *Client) GetImplicitRolesForUser(ctx context.Context, user string, domain ...string) ([]string, error) {
+	return c.getRolesForUser(ctx, user, true, domain)
+}
+
+func (c *Client) getRolesForUser(ctx context.Context, user string, implicit bool, domain []string) ([]string, error) {
+	r, err := newRequest(http.MethodGet, "/users/roles", nil)
+	if err != nil {
+		return nil, err
+	}
+	r.query.Set("user", user)
+	if implicit {
+		r.query.Set("implicit", "true")
+	}
+	r.query["domain"] = domain
+	r.idempotent = true
+	var roles []string
+	err = c.do(ctx, r, decodeJSON(&roles))
+	return roles, err
+}
+
+// Enforce decides whether a subject can access an object, it is decided by the node that receives the request.
+func (c *Client) Enforce(ctx context.Context, params ...string) (bool, error) {
+	r, err := newRequest(http.MethodPost, "/enforce", &command.EnforceRequest{Params: params})
+	if err != nil {
+		return false, err
+	}
+	r.idempotent = true
+	var response command.EnforceResponse
+	err = c.do(ctx, r, decodeJSON(&response))
+	return response.Allowed, err
+}
+
+// Export writes the rules as a policy stream of the given format.
+func (c *Client) Export(ctx context.Context, w io.Writer, format hraft.Format) error {
+	r, err := newRequest(http.MethodGet, "/policies/export", nil)
+	if err != nil {
+		return err
+	}
+	r.query.Set("format", string(format))
+	r.idempotent = true
+	r.streaming = true
+	return c.do(ctx, r, func(resp *http.Response) error {
+		_, err := io.Copy(w, resp.Body)
+		return err
+	})
+}
+
+// Import streams a policy stream of the given format to the leader, which imports it in chunks.
+// The rules are added to the current policy, or replace it if replace is true.
+// It returns the number of rules applied, the request is not retried.
+func (c *Client) Import(ctx context.Context, reader io.Reader, format hraft.Format, replace bool) (int, error) {
+	r, err := newRequest(http.MethodPut, "/policies/import", nil)
+	if err != nil {
+		return 0, err
+	}
+	r.query.Set("format", string(format))
+	if replace {
+		r.query.Set("replace", "true")
+	}
+	r.stream = reader
+	r.streaming = true
+	r.leader = true
+
+	rules := 0
+	err = c.do(ctx, r, func(resp *http.Response) error {
+		decoder := jsoniter.NewDecoder(resp.Body)
+		for {
+			var p command.ImportProgress
+			err := decoder.Decode(&p)
+			if err == io.EOF {
+				return errors.New("the import is interrupted")
+			}
+			if err != nil {
+				return err
+			}
+			rules = int(p.Rules)
+			if len(p.Error) > 0 {
+				code := hraft.ErrorCode(p.Code)
+				return &Error{StatusCode: code.StatusCode(), Code: code, Message: p.Error}
+			}
+			if p.Done {
+				return nil
+			}
+		}
+	})
+	return rules, err
+}
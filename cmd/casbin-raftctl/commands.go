@@ -0,0 +1,368 @@
+package main
+
+import (
+	"encoding/csv"
+	"flag"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
+)
+
+// cli runs the commands with a client of a node.
+type cli struct {
+	client  *http.Service
+	printer *printer
+	stdin   io.Reader
+	stdout  io.Writer
+}
+
+// run runs the command of the arguments.
+func (c *cli) run(args []string) error {
+	switch args[0] {
+	case "status":
+		return c.status(args[1:])
+	case "enforce":
+		return c.enforce(args[1:])
+	}
+
+	if len(args) < 2 {
+		return errors.Errorf("unknown command %s", strings.Join(args, " "))
+	}
+	name := args[0] + " " + args[1]
+	args = args[2:]
+	switch name {
+	case "node join":
+		return c.joinNode(args)
+	case "node remove":
+		return c.removeNode(args)
+	case "leader transfer":
+		return c.transferLeadership(args)
+	case "snapshot save":
+		return c.saveSnapshot(args)
+	case "snapshot restore":
+		return c.restoreSnapshot(args)
+	case "policy list":
+		return c.listPolicies(args)
+	case "policy add":
+		return c.addPolicy(args)
+	case "policy remove":
+		return c.removePolicy(args)
+	case "policy update":
+		return c.updatePolicy(args)
+	case "policy import":
+		return c.importPolicies(args)
+	case "policy export":
+		return c.exportPolicies(args)
+	default:
+		return errors.Errorf("unknown command %s", name)
+	}
+}
+
+// checkArgs checks the number of the arguments of a command, max < 0 means unlimited.
+func checkArgs(name string, args []string, min int, max int) error {
+	if len(args) < min || (max >= 0 && len(args) > max) {
+		return errors.Errorf("wrong number of arguments for %s, see casbin-raftctl -h", name)
+	}
+	return nil
+}
+
+// parseRule splits a comma-separated rule into its fields.
+func parseRule(text string) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(text))
+	reader.TrimLeadingSpace = true
+	fields, err := reader.Read()
+	if err != nil {
+		return nil, errors.Wrapf(err, "invalid rule %q", text)
+	}
+	return fields, nil
+}
+
+// sec returns the section of a policy type, like casbin does, the policy type must start with p or g.
+func sec(pType string) (string, error) {
+	if len(pType) == 0 || (pType[0] != 'p' && pType[0] != 'g') {
+		return "", errors.Errorf("invalid policy type %q, expected p, g or one of their variants like p2", pType)
+	}
+	return pType[:1], nil
+}
+
+func (c *cli) status(args []string) error {
+	err := checkArgs("status", args, 0, 0)
+	if err != nil {
+		return err
+	}
+	status, err := c.client.DoStatusRequest()
+	if err != nil {
+		return err
+	}
+	// The members are a second table, the empty row ends the alignment of the first one.
+	rows := [][]string{
+		{status.Id, status.Address, status.Leader, strconv.FormatUint(status.AppliedIndex, 10), strconv.FormatBool(status.Diverged)},
+		{},
+		{"MEMBER", "ADDRESS", "SUFFRAGE"},
+	}
+	for _, member := range status.Members {
+		rows = append(rows, []string{member.Id, member.Address, member.Suffrage})
+	}
+	return c.printer.print(status, []string{"ID", "ADDRESS", "LEADER", "APPLIED INDEX", "DIVERGED"}, rows)
+}
+
+func (c *cli) enforce(args []string) error {
+	err := checkArgs("enforce", args, 1, -1)
+	if err != nil {
+		return err
+	}
+	allowed, err := c.client.DoEnforceRequest(&command.EnforceRequest{Params: args})
+	if err != nil {
+		return err
+	}
+	return c.printer.print(&command.EnforceResponse{Allowed: allowed},
+		[]string{"ALLOWED"}, [][]string{{strconv.FormatBool(allowed)}})
+}
+
+func (c *cli) joinNode(args []string) error {
+	err := checkArgs("node join", args, 2, 2)
+	if err != nil {
+		return err
+	}
+	return c.client.DoJoinNodeRequest(&command.AddNodeRequest{Id: args[0], Address: args[1]})
+}
+
+func (c *cli) removeNode(args []string) error {
+	err := checkArgs("node remove", args, 1, 1)
+	if err != nil {
+		return err
+	}
+	return c.client.DoRemoveNodeRequest(&command.RemoveNodeRequest{Id: args[0]})
+}
+
+func (c *cli) transferLeadership(args []string) error {
+	err := checkArgs("leader transfer", args, 0, 1)
+	if err != nil {
+		return err
+	}
+	request := &command.TransferLeadershipRequest{}
+	if len(args) > 0 {
+		request.Id = args[0]
+	}
+	return c.client.DoTransferLeadershipRequest(request)
+}
+
+func (c *cli) saveSnapshot(args []string) error {
+	err := checkArgs("snapshot save", args, 1, 1)
+	if err != nil {
+		return err
+	}
+	// The backup is written to a temporary file first, so that a failed backup does not replace the file.
+	tmp := args[0] + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	err = c.client.DoBackupRequest(f)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, args[0])
+}
+
+func (c *cli) restoreSnapshot(args []string) error {
+	err := checkArgs("snapshot restore", args, 1, 1)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(args[0])
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return c.client.DoRestoreRequest(f)
+}
+
+func (c *cli) listPolicies(args []string) error {
+	flags := flag.NewFlagSet("policy list", flag.ContinueOnError)
+	pType := flags.String("ptype", "", "only lists the rules of the policy type")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	err = checkArgs("policy list", flags.Args(), 0, 0)
+	if err != nil {
+		return err
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(c.client.DoExportRequest(pw, http.FormatJSON))
+	}()
+	defer pr.Close()
+	reader, err := http.NewPolicyReader(pr, http.FormatJSON)
+	if err != nil {
+		return err
+	}
+
+	rules := []*command.PolicyRule{}
+	var rows [][]string
+	for {
+		rule, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if len(*pType) > 0 && rule.PType != *pType {
+			continue
+		}
+		rules = append(rules, rule)
+		rows = append(rows, []string{rule.PType, strings.Join(rule.Rule, ", ")})
+	}
+	return c.printer.print(rules, []string{"PTYPE", "RULE"}, rows)
+}
+
+func (c *cli) addPolicy(args []string) error {
+	err := checkArgs("policy add", args, 2, -1)
+	if err != nil {
+		return err
+	}
+	section, err := sec(args[0])
+	if err != nil {
+		return err
+	}
+	return c.client.DoAddPolicyRequest(&command.AddPoliciesRequest{
+		Sec:   section,
+		PType: args[0],
+		Rules: []*command.StringArray{{Items: args[1:]}},
+	})
+}
+
+func (c *cli) removePolicy(args []string) error {
+	flags := flag.NewFlagSet("policy remove", flag.ContinueOnError)
+	fieldIndex := flags.Int("field-index", -1, "removes the rules whose fields from the index match the given fields")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	args = flags.Args()
+	err = checkArgs("policy remove", args, 2, -1)
+	if err != nil {
+		return err
+	}
+	section, err := sec(args[0])
+	if err != nil {
+		return err
+	}
+
+	if *fieldIndex >= 0 {
+		return c.client.DoRemoveFilteredPolicyRequest(&command.RemoveFilteredPolicyRequest{
+			Sec:         section,
+			PType:       args[0],
+			FieldIndex:  int32(*fieldIndex),
+			FieldValues: args[1:],
+		})
+	}
+	return c.client.DoRemovePolicyRequest(&command.RemovePoliciesRequest{
+		Sec:   section,
+		PType: args[0],
+		Rules: []*command.StringArray{{Items: args[1:]}},
+	})
+}
+
+func (c *cli) updatePolicy(args []string) error {
+	err := checkArgs("policy update", args, 3, 3)
+	if err != nil {
+		return err
+	}
+	section, err := sec(args[0])
+	if err != nil {
+		return err
+	}
+	oldRule, err := parseRule(args[1])
+	if err != nil {
+		return err
+	}
+	newRule, err := parseRule(args[2])
+	if err != nil {
+		return err
+	}
+	return c.client.DoUpdatePolicyRequest(&command.UpdatePolicyRequest{
+		Sec:     section,
+		PType:   args[0],
+		OldRule: oldRule,
+		NewRule: newRule,
+	})
+}
+
+func (c *cli) importPolicies(args []string) error {
+	flags := flag.NewFlagSet("policy import", flag.ContinueOnError)
+	name := flags.String("format", "csv", "the format of the file, csv or json")
+	replace := flags.Bool("replace", false, "replaces all rules with the rules of the file")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	args = flags.Args()
+	err = checkArgs("policy import", args, 1, 1)
+	if err != nil {
+		return err
+	}
+	format, err := http.ParseFormat(*name)
+	if err != nil {
+		return err
+	}
+
+	reader := c.stdin
+	if args[0] != "-" {
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		reader = f
+	}
+	rules, err := c.client.DoImportRequest(reader, format, *replace, nil)
+	if err != nil {
+		return err
+	}
+	return c.printer.print(&command.ImportProgress{Rules: int64(rules), Done: true},
+		[]string{"IMPORTED RULES"}, [][]string{{strconv.Itoa(rules)}})
+}
+
+func (c *cli) exportPolicies(args []string) error {
+	flags := flag.NewFlagSet("policy export", flag.ContinueOnError)
+	name := flags.String("format", "csv", "the format of the file, csv or json")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	args = flags.Args()
+	err = checkArgs("policy export", args, 0, 1)
+	if err != nil {
+		return err
+	}
+	format, err := http.ParseFormat(*name)
+	if err != nil {
+		return err
+	}
+
+	if len(args) == 0 {
+		return c.client.DoExportRequest(c.stdout, format)
+	}
+	f, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	err = c.client.DoExportRequest(f, format)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,117 @@
+// Command casbin-raftctl manages a casbin cluster through the HTTP API of any of its nodes.
+//
+//	casbin-raftctl [flags] <command> [arguments]
+//
+// The commands are:
+//
+//	status                                    shows the status of the node and the members of the cluster
+//	node join <id> <raft-address>             joins a node to the cluster
+//	node remove <id>                          removes a node from the cluster
+//	leader transfer [id]                      transfers the leadership to a node, or to any follower
+//	snapshot save <file>                      writes a backup of the node to a file
+//	snapshot restore <file>                   restores a backup to the cluster
+//	policy list [-ptype p]                    lists the rules
+//	policy add <ptype> <field>...             adds a rule
+//	policy remove [-field-index i] <ptype> <field>...
+//	                                          removes a rule, or the rules matching the fields from the index
+//	policy update <ptype> <old-rule> <new-rule>
+//	                                          updates a rule, the rules are comma-separated fields
+//	policy import [-format csv] [-replace] <file>
+//	                                          imports a policy file, - reads the standard input
+//	policy export [-format csv] [file]        exports the rules, the default is the standard output
+//	enforce <param>...                        decides whether a request is allowed
+//
+// The certificates of mTLS and the address of the node can also be given by the environment variables
+// CASBIN_RAFTCTL_CA, CASBIN_RAFTCTL_CERT, CASBIN_RAFTCTL_KEY and CASBIN_RAFTCTL_ADDRESS.
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
+)
+
+// envPrefix is the prefix of the environment variables that provide the default values of the flags.
+const envPrefix = "CASBIN_RAFTCTL_"
+
+func main() {
+	err := run(os.Args[1:], os.Stdin, os.Stdout)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "casbin-raftctl:", err)
+		os.Exit(1)
+	}
+}
+
+// env returns the environment variable of a flag, or the default value if it is not set.
+func env(name string, value string) string {
+	if v, ok := os.LookupEnv(envPrefix + name); ok {
+		return v
+	}
+	return value
+}
+
+// run parses the global flags and runs a command.
+func run(args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("casbin-raftctl", flag.ContinueOnError)
+	address := flags.String("address", env("ADDRESS", "127.0.0.1:6791"), "the HTTP address of a node")
+	ca := flags.String("ca", env("CA", ""), "the path of the CA certificate")
+	cert := flags.String("cert", env("CERT", ""), "the path of the client certificate")
+	key := flags.String("key", env("KEY", ""), "the path of the client key")
+	output := flags.String("output", env("OUTPUT", "table"), "the output format, table or json")
+	namespace := flags.String("namespace", "", "the namespace of the policy commands")
+	group := flags.Int("group", -1, "the raft group, the default is routed by the namespace")
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return errors.New("no command is given")
+	}
+
+	p, err := newPrinter(stdout, *output)
+	if err != nil {
+		return err
+	}
+	tlsConfig, err := loadTLSConfig(*ca, *cert, *key)
+	if err != nil {
+		return err
+	}
+	client := http.NewClient(*address, tlsConfig).WithNamespace(*namespace)
+	if *group >= 0 {
+		client = client.WithGroup(*group)
+	}
+
+	c := &cli{client: client, printer: p, stdin: stdin, stdout: stdout}
+	return c.run(flags.Args())
+}
+
+// loadTLSConfig loads the certificates of mTLS, the server is verified by the CA.
+func loadTLSConfig(ca, cert, key string) (*tls.Config, error) {
+	if len(ca) == 0 || len(cert) == 0 || len(key) == 0 {
+		return nil, errors.New("the CA, certificate and key are required, see -ca, -cert and -key")
+	}
+	b, err := ioutil.ReadFile(ca)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(b) {
+		return nil, errors.Errorf("no certificate is found in %s", ca)
+	}
+	certificate, err := tls.LoadX509KeyPair(cert, key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		RootCAs:      pool,
+		Certificates: []tls.Certificate{certificate},
+	}, nil
+}
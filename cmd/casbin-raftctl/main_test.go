@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	nethttp "net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/nodece/casbin-hraft-dispatcher/http/mocks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCLI(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := http.NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start())
+	defer s.Stop(context.Background())
+
+	tlsConfig := ts.Client().Transport.(*nethttp.Transport).TLSClientConfig
+	run := func(output string, args ...string) (string, error) {
+		var out bytes.Buffer
+		p, err := newPrinter(&out, output)
+		assert.NoError(t, err)
+		c := &cli{client: http.NewClient(s.Addr(), tlsConfig), printer: p, stdin: strings.NewReader(""), stdout: &out}
+		err = c.run(args)
+		return out.String(), err
+	}
+
+	store.EXPECT().Leader().Return(true, "127.0.0.1:6790").AnyTimes()
+	store.EXPECT().Status().Return(&command.NodeStatus{
+		Id:           "node1",
+		Address:      "127.0.0.1:6790",
+		Leader:       "127.0.0.1:6790",
+		AppliedIndex: 42,
+		Members: []*command.NodeMember{
+			{Id: "node1", Address: "127.0.0.1:6790", Suffrage: "Voter"},
+			{Id: "node2", Address: "127.0.0.1:6800", Suffrage: "Nonvoter"},
+		},
+	}).Times(2)
+	out, err := run("table", "status")
+	assert.NoError(t, err)
+	assert.Equal(t, "ID     ADDRESS         LEADER          APPLIED INDEX  DIVERGED\n"+
+		"node1  127.0.0.1:6790  127.0.0.1:6790  42             false\n"+
+		"\n"+
+		"MEMBER  ADDRESS         SUFFRAGE\n"+
+		"node1   127.0.0.1:6790  Voter\n"+
+		"node2   127.0.0.1:6800  Nonvoter\n", out)
+	out, err = run("json", "status")
+	assert.NoError(t, err)
+	assert.Contains(t, out, `"appliedIndex": 42`)
+	assert.Contains(t, out, `"suffrage": "Nonvoter"`)
+
+	store.EXPECT().AddPolicies(gomock.Any(), &command.AddPoliciesRequest{
+		Sec:   "p",
+		PType: "p",
+		Rules: []*command.StringArray{{Items: []string{"alice", "data1", "read"}}},
+	}).Return(nil)
+	_, err = run("table", "policy", "add", "p", "alice", "data1", "read")
+	assert.NoError(t, err)
+
+	store.EXPECT().RemoveFilteredPolicy(gomock.Any(), &command.RemoveFilteredPolicyRequest{
+		Sec:         "g",
+		PType:       "g",
+		FieldIndex:  1,
+		FieldValues: []string{"admin"},
+	}).Return(nil)
+	_, err = run("table", "policy", "remove", "-field-index", "1", "g", "admin")
+	assert.NoError(t, err)
+
+	store.EXPECT().UpdatePolicy(gomock.Any(), &command.UpdatePolicyRequest{
+		Sec:     "p",
+		PType:   "p",
+		OldRule: []string{"alice", "data1", "read"},
+		NewRule: []string{"alice", "data1", "write"},
+	}).Return(nil)
+	_, err = run("table", "policy", "update", "p", "alice, data1, read", "alice,data1,write")
+	assert.NoError(t, err)
+
+	store.EXPECT().Export().Return(&command.NamespaceExport{
+		Rules: []*command.PolicyRule{
+			{Sec: "p", PType: "p", Rule: []string{"alice", "data1", "read"}},
+			{Sec: "g", PType: "g", Rule: []string{"alice", "admin"}},
+		},
+	}, nil).Times(2)
+	out, err = run("table", "policy", "list", "-ptype", "g")
+	assert.NoError(t, err)
+	assert.Equal(t, "PTYPE  RULE\ng      alice, admin\n", out)
+	out, err = run("table", "policy", "export")
+	assert.NoError(t, err)
+	assert.Equal(t, "p, alice, data1, read\ng, alice, admin\n", out)
+
+	store.EXPECT().Enforce("alice", "data1", "read").Return(true, nil)
+	out, err = run("json", "enforce", "alice", "data1", "read")
+	assert.NoError(t, err)
+	assert.Contains(t, out, `"allowed": true`)
+
+	store.EXPECT().JoinNode(gomock.Any(), "node2", "127.0.0.1:6800").Return(nil)
+	_, err = run("table", "node", "join", "node2", "127.0.0.1:6800")
+	assert.NoError(t, err)
+
+	store.EXPECT().TransferLeadership(gomock.Any(), "node2").Return(nil)
+	_, err = run("table", "leader", "transfer", "node2")
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "casbin-raftctl-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "backup")
+	store.EXPECT().Backup(gomock.Any()).DoAndReturn(func(w io.Writer) error {
+		_, err := io.WriteString(w, "backup")
+		return err
+	})
+	_, err = run("table", "snapshot", "save", path)
+	assert.NoError(t, err)
+	b, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup", string(b))
+
+	_, err = run("table", "policy", "add", "p")
+	assert.EqualError(t, err, "wrong number of arguments for policy add, see casbin-raftctl -h")
+	_, err = run("table", "policy", "add", "", "alice")
+	assert.EqualError(t, err, `invalid policy type "", expected p, g or one of their variants like p2`)
+	_, err = run("table", "policy", "remove", "x", "alice")
+	assert.EqualError(t, err, `invalid policy type "x", expected p, g or one of their variants like p2`)
+	_, err = run("table", "cluster", "status")
+	assert.EqualError(t, err, "unknown command cluster status")
+	_, err = newPrinter(ioutil.Discard, "yaml")
+	assert.EqualError(t, err, "unsupported output yaml, expected table or json")
+}
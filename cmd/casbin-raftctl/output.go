@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+)
+
+// printer writes the result of a command as a table or JSON.
+type printer struct {
+	w    io.Writer
+	json bool
+}
+
+// newPrinter returns a printer of the given output format.
+func newPrinter(w io.Writer, output string) (*printer, error) {
+	switch output {
+	case "table":
+		return &printer{w: w}, nil
+	case "json":
+		return &printer{w: w, json: true}, nil
+	default:
+		return nil, errors.Errorf("unsupported output %s, expected table or json", output)
+	}
+}
+
+// print writes v as JSON, or the rows as a table with the header.
+func (p *printer) print(v interface{}, header []string, rows [][]string) error {
+	if p.json {
+		b, err := jsoniter.MarshalIndent(v, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(p.w, string(b))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(p.w, 0, 4, 2, ' ', 0)
+	if len(header) > 0 {
+		fmt.Fprintln(tw, strings.Join(header, "\t"))
+	}
+	for _, row := range rows {
+		fmt.Fprintln(tw, strings.Join(row, "\t"))
+	}
+	return tw.Flush()
+}
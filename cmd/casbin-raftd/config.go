@@ -0,0 +1,237 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/casbin/casbin/v2"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	hraftdispatcher "github.com/nodece/casbin-hraft-dispatcher"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/nodece/casbin-hraft-dispatcher/store"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v3"
+)
+
+// duration is a time.Duration written as a string such as "30s" in the config file.
+type duration time.Duration
+
+// UnmarshalText implements the encoding.TextUnmarshaler interface.
+func (d *duration) UnmarshalText(text []byte) error {
+	v, err := time.ParseDuration(string(text))
+	if err != nil {
+		return err
+	}
+	*d = duration(v)
+	return nil
+}
+
+// TLSConfig holds the paths of the certificates, the same certificate is used by the server and the client
+// of the node, the peers must be signed by the CA.
+type TLSConfig struct {
+	CA   string `yaml:"ca" toml:"ca"`
+	Cert string `yaml:"cert" toml:"cert"`
+	Key  string `yaml:"key" toml:"key"`
+}
+
+// EventLogConfig configures the event log, see store.EventLogConfig.
+type EventLogConfig struct {
+	Dir         string   `yaml:"dir" toml:"dir"`
+	Format      string   `yaml:"format" toml:"format"`
+	SegmentSize int64    `yaml:"segment_size" toml:"segment_size"`
+	SegmentAge  duration `yaml:"segment_age" toml:"segment_age"`
+	MaxSegments int      `yaml:"max_segments" toml:"max_segments"`
+	MaxAge      duration `yaml:"max_age" toml:"max_age"`
+	Compress    bool     `yaml:"compress" toml:"compress"`
+}
+
+// RateLimitConfig configures the rate limits of the writes, see http.RateLimitConfig.
+type RateLimitConfig struct {
+	Rate        float64 `yaml:"rate" toml:"rate"`
+	Burst       int     `yaml:"burst" toml:"burst"`
+	ClientRate  float64 `yaml:"client_rate" toml:"client_rate"`
+	ClientBurst int     `yaml:"client_burst" toml:"client_burst"`
+}
+
+// Config is the config file of casbin-raftd, in YAML or TOML.
+type Config struct {
+	// Model is the path of the casbin model.
+	Model string `yaml:"model" toml:"model"`
+	// ServerID is the unique ID of the node, the default is RaftAddress.
+	ServerID string `yaml:"server_id" toml:"server_id"`
+	// DataDir holds the raft data and the policies.
+	DataDir string `yaml:"data_dir" toml:"data_dir"`
+	// RaftAddress is the listen address of raft, the HTTP API listens on its port plus 1.
+	RaftAddress string `yaml:"raft_address" toml:"raft_address"`
+	// Peers are the raft addresses of the existing nodes, the node joins the cluster through the first reachable one.
+	// The first node of a cluster has no peers, it bootstraps a new cluster.
+	Peers []string  `yaml:"peers" toml:"peers"`
+	TLS   TLSConfig `yaml:"tls" toml:"tls"`
+	// InitialPolicy is the path of a CSV policy file that seeds a new cluster.
+	InitialPolicy      string           `yaml:"initial_policy" toml:"initial_policy"`
+	ChecksumInterval   duration         `yaml:"checksum_interval" toml:"checksum_interval"`
+	RaftGroups         int              `yaml:"raft_groups" toml:"raft_groups"`
+	EventLog           *EventLogConfig  `yaml:"event_log" toml:"event_log"`
+	RateLimit          *RateLimitConfig `yaml:"rate_limit" toml:"rate_limit"`
+	MaxInFlightApplies int              `yaml:"max_in_flight_applies" toml:"max_in_flight_applies"`
+}
+
+// LoadConfig reads a config file, the format is given by the extension, .yaml, .yml or .toml.
+// The relative paths in the file are relative to the directory of the file.
+func LoadConfig(path string) (*Config, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var config Config
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &config)
+	case ".toml":
+		err = toml.Unmarshal(b, &config)
+	default:
+		return nil, errors.Errorf("unknown config format %s, expected .yaml, .yml or .toml", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse %s", path)
+	}
+
+	dir := filepath.Dir(path)
+	for _, p := range []*string{&config.Model, &config.DataDir, &config.TLS.CA, &config.TLS.Cert, &config.TLS.Key, &config.InitialPolicy} {
+		if len(*p) > 0 && !filepath.IsAbs(*p) {
+			*p = filepath.Join(dir, *p)
+		}
+	}
+	if config.EventLog != nil && len(config.EventLog.Dir) > 0 && !filepath.IsAbs(config.EventLog.Dir) {
+		config.EventLog.Dir = filepath.Join(dir, config.EventLog.Dir)
+	}
+	return &config, config.validate()
+}
+
+// validate checks the required fields.
+func (c *Config) validate() error {
+	if len(c.Model) == 0 {
+		return errors.New("model is not provided")
+	}
+	if len(c.DataDir) == 0 {
+		return errors.New("data_dir is not provided")
+	}
+	if len(c.RaftAddress) == 0 {
+		return errors.New("raft_address is not provided")
+	}
+	if len(c.TLS.CA) == 0 || len(c.TLS.Cert) == 0 || len(c.TLS.Key) == 0 {
+		return errors.New("tls.ca, tls.cert and tls.key are not provided")
+	}
+	if c.EventLog != nil && len(c.EventLog.Dir) == 0 {
+		return errors.New("event_log.dir is not provided")
+	}
+	return nil
+}
+
+// loadTLSConfig loads the certificates, the peers are verified by the CA in both directions.
+func (c *Config) loadTLSConfig() (*tls.Config, error) {
+	ca, err := ioutil.ReadFile(c.TLS.CA)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, errors.Errorf("no certificate is found in %s", c.TLS.CA)
+	}
+	cert, err := tls.LoadX509KeyPair(c.TLS.Cert, c.TLS.Key)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{
+		RootCAs:      pool,
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		Certificates: []tls.Certificate{cert},
+	}, nil
+}
+
+// DispatcherConfig creates the enforcer and returns the config of HRaftDispatcher.
+// The join address is the first peer that accepts a connection, the node must not join itself.
+func (c *Config) DispatcherConfig() (*hraftdispatcher.Config, error) {
+	enforcer, err := casbin.NewDistributedEnforcer(c.Model)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig, err := c.loadTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	config := &hraftdispatcher.Config{
+		Enforcer:           enforcer,
+		ServerID:           c.ServerID,
+		DataDir:            c.DataDir,
+		RaftListenAddress:  c.RaftAddress,
+		TLSConfig:          tlsConfig,
+		ChecksumInterval:   time.Duration(c.ChecksumInterval),
+		RaftGroups:         c.RaftGroups,
+		MaxInFlightApplies: c.MaxInFlightApplies,
+	}
+	if len(c.InitialPolicy) > 0 {
+		config.InitialAdapter = fileadapter.NewAdapter(c.InitialPolicy)
+	}
+	if c.EventLog != nil {
+		config.EventLog = &store.EventLogConfig{
+			Dir:         c.EventLog.Dir,
+			Format:      store.EventLogFormat(c.EventLog.Format),
+			SegmentSize: c.EventLog.SegmentSize,
+			SegmentAge:  time.Duration(c.EventLog.SegmentAge),
+			MaxSegments: c.EventLog.MaxSegments,
+			MaxAge:      time.Duration(c.EventLog.MaxAge),
+			Compress:    c.EventLog.Compress,
+		}
+	}
+	if c.RateLimit != nil {
+		config.RateLimit = &http.RateLimitConfig{
+			Rate:        c.RateLimit.Rate,
+			Burst:       c.RateLimit.Burst,
+			ClientRate:  c.RateLimit.ClientRate,
+			ClientBurst: c.RateLimit.ClientBurst,
+		}
+	}
+	config.JoinAddress, err = c.joinAddress()
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// joinAddress returns the first reachable peer, it is empty if there are no peers
+// or the node has joined a cluster before, a restarted node reconnects to the cluster by itself.
+func (c *Config) joinAddress() (string, error) {
+	_, err := os.Stat(filepath.Join(c.DataDir, "raft.db"))
+	if err == nil {
+		return "", nil
+	}
+
+	var peers []string
+	for _, peer := range c.Peers {
+		if peer != c.RaftAddress {
+			peers = append(peers, peer)
+		}
+	}
+	if len(peers) == 0 {
+		return "", nil
+	}
+	for _, peer := range peers {
+		conn, err := net.DialTimeout("tcp", peer, 3*time.Second)
+		if err == nil {
+			conn.Close()
+			return peer, nil
+		}
+	}
+	return "", errors.Errorf("none of the peers %s is reachable", strings.Join(peers, ", "))
+}
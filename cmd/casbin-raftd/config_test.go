@@ -0,0 +1,122 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfig(t *testing.T, dir, name, text string) string {
+	path := filepath.Join(dir, name)
+	assert.NoError(t, ioutil.WriteFile(path, []byte(text), 0644))
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-raftd-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	yamlPath := writeConfig(t, dir, "node.yaml", `
+model: model.conf
+server_id: node1
+data_dir: /var/lib/casbin-raftd
+raft_address: 127.0.0.1:6790
+peers:
+  - 127.0.0.1:6800
+  - 127.0.0.1:6810
+tls:
+  ca: ca/ca.pem
+  cert: ca/peer.pem
+  key: ca/peer-key.pem
+checksum_interval: 30s
+raft_groups: 4
+event_log:
+  dir: events
+  format: protobuf
+  segment_age: 1h
+  compress: true
+rate_limit:
+  rate: 1000
+  client_rate: 100
+  client_burst: 20
+max_in_flight_applies: 256
+`)
+	tomlPath := writeConfig(t, dir, "node.toml", `
+model = "model.conf"
+server_id = "node1"
+data_dir = "/var/lib/casbin-raftd"
+raft_address = "127.0.0.1:6790"
+peers = ["127.0.0.1:6800", "127.0.0.1:6810"]
+checksum_interval = "30s"
+raft_groups = 4
+max_in_flight_applies = 256
+
+[tls]
+ca = "ca/ca.pem"
+cert = "ca/peer.pem"
+key = "ca/peer-key.pem"
+
+[event_log]
+dir = "events"
+format = "protobuf"
+segment_age = "1h"
+compress = true
+
+[rate_limit]
+rate = 1000.0
+client_rate = 100.0
+client_burst = 20
+`)
+
+	for _, path := range []string{yamlPath, tomlPath} {
+		config, err := LoadConfig(path)
+		assert.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "model.conf"), config.Model)
+		assert.Equal(t, "node1", config.ServerID)
+		assert.Equal(t, "/var/lib/casbin-raftd", config.DataDir)
+		assert.Equal(t, "127.0.0.1:6790", config.RaftAddress)
+		assert.Equal(t, []string{"127.0.0.1:6800", "127.0.0.1:6810"}, config.Peers)
+		assert.Equal(t, filepath.Join(dir, "ca/peer-key.pem"), config.TLS.Key)
+		assert.Equal(t, 30*time.Second, time.Duration(config.ChecksumInterval))
+		assert.Equal(t, 4, config.RaftGroups)
+		assert.Equal(t, filepath.Join(dir, "events"), config.EventLog.Dir)
+		assert.Equal(t, "protobuf", config.EventLog.Format)
+		assert.Equal(t, time.Hour, time.Duration(config.EventLog.SegmentAge))
+		assert.True(t, config.EventLog.Compress)
+		assert.Equal(t, &RateLimitConfig{Rate: 1000, ClientRate: 100, ClientBurst: 20}, config.RateLimit)
+		assert.Equal(t, 256, config.MaxInFlightApplies)
+	}
+
+	_, err = LoadConfig(writeConfig(t, dir, "node.json", `{}`))
+	assert.EqualError(t, err, "unknown config format .json, expected .yaml, .yml or .toml")
+	_, err = LoadConfig(writeConfig(t, dir, "empty.yaml", `model: model.conf`))
+	assert.EqualError(t, err, "data_dir is not provided")
+	_, err = LoadConfig(writeConfig(t, dir, "invalid.yaml", `checksum_interval: often`))
+	assert.Error(t, err)
+}
+
+func TestConfig_JoinAddress(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-raftd-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	config := &Config{DataDir: dir, RaftAddress: "127.0.0.1:6790", Peers: []string{"127.0.0.1:6790"}}
+	address, err := config.joinAddress()
+	assert.NoError(t, err)
+	assert.Empty(t, address)
+
+	config.Peers = append(config.Peers, "127.0.0.1:1")
+	_, err = config.joinAddress()
+	assert.EqualError(t, err, "none of the peers 127.0.0.1:1 is reachable")
+
+	// A node that has joined a cluster before does not join again.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "raft.db"), nil, 0644))
+	address, err = config.joinAddress()
+	assert.NoError(t, err)
+	assert.Empty(t, address)
+}
@@ -0,0 +1,95 @@
+// Command casbin-raftd runs a node of a casbin cluster replicated by raft.
+//
+// The node is configured by a YAML or TOML file, see Config. The first node bootstraps the cluster,
+// the other nodes join it through their peers. The policies are managed with the HTTP API,
+// which listens on the port of the raft address plus 1.
+//
+//	casbin-raftd -config node.yaml -log-level info
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	hraftdispatcher "github.com/nodece/casbin-hraft-dispatcher"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+func main() {
+	configPath := flag.String("config", "casbin-raftd.yaml", "the path of the config file, in YAML or TOML")
+	forceNewCluster := flag.Bool("force-new-cluster", false, "start as a new single-node cluster with the policies of the node, used when the quorum is permanently lost")
+	logLevel := flag.String("log-level", "info", "the minimum level of the logs, one of debug, info, warn and error")
+	flag.Parse()
+
+	logger, err := newLogger(*logLevel)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	defer logger.Sync()
+
+	err = run(*configPath, *forceNewCluster, logger)
+	if err != nil {
+		logger.Error("casbin-raftd failed", zap.Error(err))
+		os.Exit(1)
+	}
+}
+
+// newLogger returns a production logger, which writes JSON logs to stderr from the given level.
+func newLogger(level string) (*zap.Logger, error) {
+	config := zap.NewProductionConfig()
+	err := config.Level.UnmarshalText([]byte(level))
+	if err != nil {
+		return nil, errors.Errorf("invalid log level %s", level)
+	}
+	return config.Build()
+}
+
+// run starts the node and blocks until it receives SIGINT or SIGTERM.
+func run(configPath string, forceNewCluster bool, logger *zap.Logger) error {
+	config, err := LoadConfig(configPath)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(config.DataDir, 0755)
+	if err != nil {
+		return err
+	}
+
+	dispatcherConfig, err := config.DispatcherConfig()
+	if err != nil {
+		return err
+	}
+	if forceNewCluster {
+		err = hraftdispatcher.ForceNewCluster(dispatcherConfig)
+		if err != nil {
+			return err
+		}
+	}
+
+	dispatcher, err := hraftdispatcher.NewHRaftDispatcher(dispatcherConfig)
+	if err != nil {
+		return err
+	}
+	dispatcherConfig.Enforcer.SetDispatcher(dispatcher)
+
+	httpAddress, err := http.ConvertRaftAddressToHTTPAddress(config.RaftAddress)
+	if err != nil {
+		dispatcher.Shutdown()
+		return err
+	}
+	logger.Info("casbin-raftd is running", zap.String("serverID", dispatcherConfig.ServerID),
+		zap.String("raftAddress", config.RaftAddress), zap.String("httpAddress", httpAddress))
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+	logger.Info("shutting down", zap.String("signal", sig.String()))
+
+	return dispatcher.Shutdown()
+}
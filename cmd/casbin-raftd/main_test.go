@@ -0,0 +1,18 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"go.uber.org/zap"
+)
+
+func TestNewLogger(t *testing.T) {
+	logger, err := newLogger("warn")
+	assert.NoError(t, err)
+	assert.False(t, logger.Core().Enabled(zap.InfoLevel))
+	assert.True(t, logger.Core().Enabled(zap.WarnLevel))
+
+	_, err = newLogger("verbose")
+	assert.EqualError(t, err, "invalid log level verbose")
+}
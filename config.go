@@ -2,7 +2,12 @@ package hraftdispatcher
 
 import (
 	"crypto/tls"
+	"time"
+
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/nodece/casbin-hraft-dispatcher/store"
 )
 
 // Config holds dispatcher config.
@@ -23,4 +28,53 @@ type Config struct {
 	// You have to provide a peer certificate.
 	// We recommend using cfssl tool to create this certificates.
 	TLSConfig *tls.Config
+	// ChecksumInterval is the interval at which the leader verifies that the state of all nodes is consistent.
+	// A node whose state is different from the leader is flagged as diverged, see HRaftDispatcher.Status.
+	// Zero disables the verification.
+	ChecksumInterval time.Duration
+	// RaftGroups is the number of raft groups, the namespaces are spread over the groups by the hash of their names,
+	// so each group has its own leader and the writes of different namespaces are replicated in parallel.
+	// The groups share the raft listen address and the HTTP server, the default namespace is served by the first group.
+	// See HRaftDispatcher.MoveNamespace to rebalance the namespaces. It cannot be changed once the cluster is initialized.
+	// Zero or one runs a single raft group.
+	RaftGroups int
+	// InitialAdapter is used to seed a new cluster with the policies of an existing adapter, such as a file or gorm adapter.
+	// The policies are loaded with the model of Enforcer and replicated as the first rules of the default namespace,
+	// only when the current node bootstraps a new cluster. The cluster records that it is seeded with the rules,
+	// if the node stops before that, the seeding is retried on its next start. After that, the cluster is the source
+	// of truth, the adapter is not read or written again.
+	InitialAdapter persist.Adapter
+	// SinkAdapter is an external adapter, such as a SQL adapter, that the rules of the default namespace are mirrored to,
+	// so that other systems can read the policies from it. The rules are mirrored after they are applied,
+	// failures are retried with a backoff, and the index of the last mirrored log is kept in the raft data,
+	// so the mirroring resumes after a restart. When the leader writes, the index is also replicated,
+	// so a new leader resumes the mirroring too. The cluster stays the source of truth, the adapter is only written.
+	// Nil disables the mirroring.
+	SinkAdapter persist.Adapter
+	// SinkServerID is the ID of the node that writes to SinkAdapter, empty means the leader writes.
+	SinkServerID string
+	// EventLog writes the commands applied by the current node to rotating segment files, so that the batch jobs can
+	// tail the changes of the policy with store.EventLogReader. The events of the raft group i other than the first one
+	// are written to the group-i subdirectory of EventLog.Dir. Nil disables it.
+	EventLog *store.EventLogConfig
+	// BatchWindow is the time that the AddPolicies and RemovePolicies calls of a namespace are held when the current
+	// node is not the leader, so that the concurrent calls are forwarded to the leader in one request. The leader enqueues their raft entries together,
+	// so they are appended and replicated together, and each call still gets its own result.
+	// Zero disables the batching, then each call is sent on its own. Shutdown sends the held calls before it stops the node.
+	BatchWindow time.Duration
+	// BatchSize is the maximum number of calls in a batch, a full batch is sent without waiting for BatchWindow.
+	// Zero means 128.
+	BatchSize int
+	// RateLimit limits the rate of the writes that the current node accepts over HTTP as the leader, globally and
+	// for each client. The writes over the limits get the overloaded error with a Retry-After header.
+	// Nil disables the rate limits.
+	RateLimit *http.RateLimitConfig
+	// MaxInFlightApplies is the maximum number of writes that each raft group of the current node is applying at once,
+	// the writes over it get the overloaded error instead of waiting in the raft queue. A batch of the writes forwarded
+	// by a follower counts as one write, whatever its size. Zero means no limit.
+	MaxInFlightApplies int
+	// OnModelChange is called after a model set by HRaftDispatcher.SetModel, or restored from the cluster, replaces the
+	// model of Enforcer. casbin resets the watcher, the effector and the flags of an enforcer when its model is replaced,
+	// so they can be set again here. The role manager of g is kept. Nil means nothing is set again.
+	OnModelChange func(e casbin.IDistributedEnforcer)
 }
@@ -3,8 +3,15 @@ package hraftdispatcher
 import (
 	"context"
 	"crypto/tls"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
 	"github.com/hashicorp/go-multierror"
 
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
 	"github.com/casbin/casbin/v2/persist"
 	"github.com/hashicorp/raft"
 	"github.com/nodece/casbin-hraft-dispatcher/command"
@@ -16,12 +23,30 @@ import (
 
 var _ persist.Dispatcher = &HRaftDispatcher{}
 
+var errNotSharded = errors.New("the dispatcher is not started with several raft groups")
+
+// ImportOptions configures HRaftDispatcher.Import.
+type ImportOptions struct {
+	// ReplaceAll replaces all rules of the namespace with the imported rules at once.
+	// The rules are staged in all nodes until the stream is completely imported, so a failed import changes nothing.
+	ReplaceAll bool
+	// Progress is called with the number of imported rules after each chunk is applied.
+	Progress func(rules int)
+}
+
 // HRaftDispatcher implements the persist.Dispatcher interface.
+// The writes are applied with the Store of the current node when it is the leader of the namespace,
+// otherwise they are forwarded to the HTTP server of the leader.
 type HRaftDispatcher struct {
 	store       http.Store
+	groups      int
 	tlsConfig   *tls.Config
 	httpService *http.Service
-	shutdownFn  func() error
+	// namespace is the namespace of the policy changes, it is empty for the default namespace.
+	namespace string
+	// batcher coalesces the concurrent AddPolicies and RemovePolicies calls, it is nil if the batching is disabled.
+	batcher    *batcher
+	shutdownFn func() error
 
 	logger *zap.Logger
 }
@@ -59,27 +84,77 @@ func NewHRaftDispatcher(config *Config) (*HRaftDispatcher, error) {
 
 	logger := zap.NewExample()
 
+	groups := config.RaftGroups
+	if groups <= 0 {
+		groups = 1
+	}
+	if groups > 256 {
+		return nil, errors.New("RaftGroups cannot exceed 256")
+	}
+
 	streamLayer, err := store.NewTCPStreamLayer(config.RaftListenAddress, config.TLSConfig)
 	if err != nil {
 		logger.Error(err.Error())
 		return nil, err
 	}
 
-	storeConfig := &store.Config{
-		ID:  config.ServerID,
-		Dir: config.DataDir,
-		NetworkTransportConfig: &raft.NetworkTransportConfig{
-			Stream:  streamLayer,
-			MaxPool: 5,
-			Logger:  nil,
-		},
-		Enforcer: config.Enforcer,
+	// The raft groups share the stream layer through a mux.
+	var mux *store.StreamMux
+	if groups > 1 {
+		mux = store.NewStreamMux(streamLayer)
 	}
-	s, err := store.NewStore(storeConfig)
-	if err != nil {
-		logger.Error(err.Error())
-		return nil, err
+
+	var stores []*store.Store
+	for i := 0; i < groups; i++ {
+		var stream raft.StreamLayer = streamLayer
+		if mux != nil {
+			stream = mux.Layer(byte(i))
+		}
+
+		dir := groupDataDir(config.DataDir, i)
+		enforcer := config.Enforcer
+		if i > 0 {
+			err = os.MkdirAll(dir, 0755)
+			if err != nil {
+				return nil, err
+			}
+			// The default namespace is served by the first group only.
+			enforcer, err = casbin.NewDistributedEnforcer(model.NewModel())
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		storeConfig := &store.Config{
+			ID:  config.ServerID,
+			Dir: dir,
+			NetworkTransportConfig: &raft.NetworkTransportConfig{
+				Stream:  stream,
+				MaxPool: 5,
+				Logger:  nil,
+			},
+			Enforcer:         enforcer,
+			ChecksumInterval: config.ChecksumInterval,
+			MaxInFlight:      config.MaxInFlightApplies,
+		}
+		if i == 0 {
+			storeConfig.SinkAdapter = config.SinkAdapter
+			storeConfig.SinkServerID = config.SinkServerID
+			storeConfig.OnModelChange = config.OnModelChange
+		}
+		if config.EventLog != nil {
+			eventLog := *config.EventLog
+			eventLog.Dir = groupDataDir(eventLog.Dir, i)
+			storeConfig.EventLog = &eventLog
+		}
+		gs, err := store.NewStore(storeConfig)
+		if err != nil {
+			logger.Error(err.Error())
+			return nil, err
+		}
+		stores = append(stores, gs)
 	}
+	s := stores[0]
 
 	isNewCluster := !s.IsInitializedCluster()
 	enableBootstrap := false
@@ -98,16 +173,37 @@ func NewHRaftDispatcher(config *Config) (*HRaftDispatcher, error) {
 		logger.Info("skip bootstrapping a new cluster")
 	}
 
-	err = s.Start(enableBootstrap)
-	if err != nil {
-		logger.Error("failed to start raft service", zap.Error(err))
-		return nil, err
+	if enableBootstrap && config.InitialAdapter != nil {
+		// The pending file is written before the cluster is bootstrapped, so the seeding is retried until it is recorded.
+		err = ioutil.WriteFile(filepath.Join(config.DataDir, seedPendingFileName), nil, 0644)
+		if err != nil {
+			logger.Error("failed to mark the seeding as pending", zap.Error(err))
+			return nil, err
+		}
+	}
+
+	for _, gs := range stores {
+		err = gs.Start(enableBootstrap)
+		if err != nil {
+			logger.Error("failed to start raft service", zap.Error(err))
+			return nil, err
+		}
 	}
 
 	if enableBootstrap {
-		err = s.WaitLeader()
+		for _, gs := range stores {
+			err = gs.WaitLeader()
+			if err != nil {
+				logger.Error(err.Error())
+			}
+		}
+	}
+
+	if config.InitialAdapter != nil {
+		err = seedCluster(s, config, logger)
 		if err != nil {
-			logger.Error(err.Error())
+			logger.Error("failed to seed the policies from the initial adapter", zap.Error(err))
+			return nil, err
 		}
 	}
 
@@ -117,17 +213,32 @@ func NewHRaftDispatcher(config *Config) (*HRaftDispatcher, error) {
 			logger.Error("failed to convert the Raft address to HTTP address", zap.String("nodeID", config.ServerID), zap.String("nodeAddress", config.RaftListenAddress), zap.String("clusterAddress", config.JoinAddress), zap.Error(err))
 			return nil, err
 		}
-		err = http.DoJoinNodeRequest(entryAddress, config.ServerID, config.RaftListenAddress, config.TLSConfig)
+		for i := range stores {
+			if groups > 1 {
+				err = http.DoJoinGroupRequest(entryAddress, i, config.ServerID, config.RaftListenAddress, config.TLSConfig)
+			} else {
+				err = http.DoJoinNodeRequest(entryAddress, config.ServerID, config.RaftListenAddress, config.TLSConfig)
+			}
+			if err != nil {
+				logger.Error("failed to join the current node to existing cluster", zap.String("nodeID", config.ServerID), zap.String("nodeAddress", config.RaftListenAddress), zap.String("clusterAddress", config.JoinAddress), zap.Int("group", i), zap.Error(err))
+				return nil, err
+			}
+		}
+	}
+
+	var httpStore http.Store = s
+	if groups > 1 {
+		httpStore, err = store.NewRouter(stores)
 		if err != nil {
-			logger.Error("failed to join the current node to existing cluster", zap.String("nodeID", config.ServerID), zap.String("nodeAddress", config.RaftListenAddress), zap.String("clusterAddress", config.JoinAddress), zap.Error(err))
 			return nil, err
 		}
 	}
 
-	httpService, err := http.NewService(httpListenAddress, config.TLSConfig, s)
+	httpService, err := http.NewService(httpListenAddress, config.TLSConfig, httpStore)
 	if err != nil {
 		return nil, err
 	}
+	httpService.SetRateLimit(config.RateLimit)
 
 	err = httpService.Start()
 	if err != nil {
@@ -135,19 +246,35 @@ func NewHRaftDispatcher(config *Config) (*HRaftDispatcher, error) {
 	}
 
 	h := &HRaftDispatcher{
-		store:       s,
+		store:       httpStore,
+		groups:      groups,
 		tlsConfig:   config.TLSConfig,
 		httpService: httpService,
 		logger:      logger,
 	}
+	if config.BatchWindow > 0 {
+		h.batcher = newBatcher(httpService, config.BatchWindow, config.BatchSize)
+	}
 
 	h.shutdownFn = func() error {
 		var ret error
-		err := s.Stop()
-		if err != nil {
-			ret = multierror.Append(ret, err)
+		// The pending batches are sent while the stores still run, so their callers get a result.
+		if h.batcher != nil {
+			h.batcher.stop()
+		}
+		for _, gs := range stores {
+			err := gs.Stop()
+			if err != nil {
+				ret = multierror.Append(ret, err)
+			}
 		}
-		err = httpService.Stop(context.Background())
+		if mux != nil {
+			err := mux.Close()
+			if err != nil {
+				ret = multierror.Append(ret, err)
+			}
+		}
+		err := httpService.Stop(context.Background())
 		if err != nil {
 			ret = multierror.Append(ret, err)
 		}
@@ -157,8 +284,39 @@ func NewHRaftDispatcher(config *Config) (*HRaftDispatcher, error) {
 	return h, nil
 }
 
+// leaderState is implemented by a Store that knows whether the current node is the leader without waiting for an election.
+type leaderState interface {
+	IsLeader() bool
+}
+
+// applyLocal applies a write with the Store of the current node when it is the leader, so the write is not sent
+// through the HTTP server of the node. It reports false if the write has to be forwarded to the leader, which is the case
+// when the node is not the leader, or when it has stepped down before the write is enqueued.
+// The raft state is checked without waiting, a node without a known leader forwards the write, which waits within its context.
+func applyLocal(store http.Store, fn func(store http.Store) error) (bool, error) {
+	var isLeader bool
+	if s, ok := store.(leaderState); ok {
+		isLeader = s.IsLeader()
+	} else {
+		isLeader, _ = store.Leader()
+	}
+	if !isLeader {
+		return false, nil
+	}
+	err := fn(store)
+	if err != nil && http.CodeOf(err) == http.ErrorCodeNotLeader {
+		return false, nil
+	}
+	return true, err
+}
+
 //AddPolicies implements the persist.Dispatcher interface.
 func (h *HRaftDispatcher) AddPolicies(sec string, pType string, rules [][]string) error {
+	return h.AddPoliciesContext(context.Background(), sec, pType, rules)
+}
+
+// AddPoliciesContext is like AddPolicies, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) AddPoliciesContext(ctx context.Context, sec string, pType string, rules [][]string) error {
 	var items []*command.StringArray
 	for _, rule := range rules {
 		var item = &command.StringArray{Items: rule}
@@ -170,11 +328,24 @@ func (h *HRaftDispatcher) AddPolicies(sec string, pType string, rules [][]string
 		PType: pType,
 		Rules: items,
 	}
-	return h.httpService.DoAddPolicyRequest(addPolicyRequest)
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.AddPolicies(ctx, addPolicyRequest)
+	}); ok {
+		return err
+	}
+	if h.batcher != nil {
+		return h.batcher.do(ctx, h.namespace, &command.BatchItem{AddPolicies: addPolicyRequest})
+	}
+	return h.httpService.WithContext(ctx).DoAddPolicyRequest(addPolicyRequest)
 }
 
 // RemovePolicies implements the persist.Dispatcher interface.
 func (h *HRaftDispatcher) RemovePolicies(sec string, pType string, rules [][]string) error {
+	return h.RemovePoliciesContext(context.Background(), sec, pType, rules)
+}
+
+// RemovePoliciesContext is like RemovePolicies, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) RemovePoliciesContext(ctx context.Context, sec string, pType string, rules [][]string) error {
 	var items []*command.StringArray
 	for _, rule := range rules {
 		var item = &command.StringArray{Items: rule}
@@ -186,38 +357,81 @@ func (h *HRaftDispatcher) RemovePolicies(sec string, pType string, rules [][]str
 		PType: pType,
 		Rules: items,
 	}
-	return h.httpService.DoRemovePolicyRequest(request)
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.RemovePolicies(ctx, request)
+	}); ok {
+		return err
+	}
+	if h.batcher != nil {
+		return h.batcher.do(ctx, h.namespace, &command.BatchItem{RemovePolicies: request})
+	}
+	return h.httpService.WithContext(ctx).DoRemovePolicyRequest(request)
 }
 
 // RemoveFilteredPolicy implements the persist.Dispatcher interface.
 func (h *HRaftDispatcher) RemoveFilteredPolicy(sec string, pType string, fieldIndex int, fieldValues ...string) error {
+	return h.RemoveFilteredPolicyContext(context.Background(), sec, pType, fieldIndex, fieldValues...)
+}
+
+// RemoveFilteredPolicyContext is like RemoveFilteredPolicy, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) RemoveFilteredPolicyContext(ctx context.Context, sec string, pType string, fieldIndex int, fieldValues ...string) error {
 	request := &command.RemoveFilteredPolicyRequest{
 		Sec:         sec,
 		PType:       pType,
 		FieldIndex:  int32(fieldIndex),
 		FieldValues: fieldValues,
 	}
-	return h.httpService.DoRemoveFilteredPolicyRequest(request)
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.RemoveFilteredPolicy(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoRemoveFilteredPolicyRequest(request)
 }
 
 // ClearPolicy implements the persist.Dispatcher interface.
 func (h *HRaftDispatcher) ClearPolicy() error {
-	return h.httpService.DoClearPolicyRequest()
+	return h.ClearPolicyContext(context.Background())
+}
+
+// ClearPolicyContext is like ClearPolicy, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) ClearPolicyContext(ctx context.Context) error {
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.ClearPolicy(ctx)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoClearPolicyRequest()
 }
 
 // UpdatePolicy implements the persist.Dispatcher interface.
 func (h *HRaftDispatcher) UpdatePolicy(sec string, pType string, oldRule, newRule []string) error {
+	return h.UpdatePolicyContext(context.Background(), sec, pType, oldRule, newRule)
+}
+
+// UpdatePolicyContext is like UpdatePolicy, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) UpdatePolicyContext(ctx context.Context, sec string, pType string, oldRule, newRule []string) error {
 	request := &command.UpdatePolicyRequest{
 		Sec:     sec,
 		PType:   pType,
 		OldRule: oldRule,
 		NewRule: newRule,
 	}
-	return h.httpService.DoUpdatePolicyRequest(request)
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.UpdatePolicy(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoUpdatePolicyRequest(request)
 }
 
 // UpdatePolicies implements the persist.Dispatcher interface.
 func (h *HRaftDispatcher) UpdatePolicies(sec string, pType string, oldRules, newRules [][]string) error {
+	return h.UpdatePoliciesContext(context.Background(), sec, pType, oldRules, newRules)
+}
+
+// UpdatePoliciesContext is like UpdatePolicies, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) UpdatePoliciesContext(ctx context.Context, sec string, pType string, oldRules, newRules [][]string) error {
 	var olds []*command.StringArray
 	for _, rule := range oldRules {
 		var item = &command.StringArray{Items: rule}
@@ -236,24 +450,467 @@ func (h *HRaftDispatcher) UpdatePolicies(sec string, pType string, oldRules, new
 		OldRules: olds,
 		NewRules: news,
 	}
-	return h.httpService.DoUpdatePoliciesRequest(request)
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.UpdatePolicies(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoUpdatePoliciesRequest(request)
+}
+
+// MovePolicy moves a rule to the given position among the rules of the same sec and pType on all nodes.
+// The position is zero-based, a position beyond the last rule moves the rule to the end.
+// It is used to reorder the rules for the models that depend on the order of rules, such as the priority effect.
+func (h *HRaftDispatcher) MovePolicy(sec string, pType string, rule []string, position int) error {
+	return h.MovePolicyContext(context.Background(), sec, pType, rule, position)
+}
+
+// MovePolicyContext is like MovePolicy, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) MovePolicyContext(ctx context.Context, sec string, pType string, rule []string, position int) error {
+	request := &command.MovePolicyRequest{
+		Sec:      sec,
+		PType:    pType,
+		Rule:     rule,
+		Position: int32(position),
+	}
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.MovePolicy(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoMovePolicyRequest(request)
+}
+
+// SetModel replaces the model of all nodes with the given model text.
+// The model is persisted and included in snapshots, so every node evaluates with the identical model.
+func (h *HRaftDispatcher) SetModel(text string) error {
+	return h.SetModelContext(context.Background(), text)
+}
+
+// SetModelContext is like SetModel, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) SetModelContext(ctx context.Context, text string) error {
+	request := &command.SetModelRequest{
+		Text: text,
+	}
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.SetModel(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoSetModelRequest(request)
+}
+
+// AssignRole assigns a role to a user in all nodes, the domain is optional.
+func (h *HRaftDispatcher) AssignRole(user, role string, domain ...string) error {
+	return h.AssignRoleContext(context.Background(), user, role, domain...)
+}
+
+// AssignRoleContext is like AssignRole, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) AssignRoleContext(ctx context.Context, user, role string, domain ...string) error {
+	request := &command.AssignRoleRequest{
+		PType:  "g",
+		User:   user,
+		Role:   role,
+		Domain: domain,
+	}
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.AssignRole(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoAssignRoleRequest(request)
+}
+
+// UnassignRole unassigns a role from a user in all nodes, the domain is optional.
+func (h *HRaftDispatcher) UnassignRole(user, role string, domain ...string) error {
+	return h.UnassignRoleContext(context.Background(), user, role, domain...)
+}
+
+// UnassignRoleContext is like UnassignRole, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) UnassignRoleContext(ctx context.Context, user, role string, domain ...string) error {
+	request := &command.UnassignRoleRequest{
+		PType:  "g",
+		User:   user,
+		Role:   role,
+		Domain: domain,
+	}
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.UnassignRole(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoUnassignRoleRequest(request)
+}
+
+// DeleteRole deletes a role in all nodes, including the assignments of the role,
+// the roles it inherits and the policies whose subject is the role.
+func (h *HRaftDispatcher) DeleteRole(role string) error {
+	return h.DeleteRoleContext(context.Background(), role)
+}
+
+// DeleteRoleContext is like DeleteRole, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) DeleteRoleContext(ctx context.Context, role string) error {
+	request := &command.DeleteRoleRequest{
+		Role: role,
+	}
+	if ok, err := applyLocal(h.store, func(store http.Store) error {
+		return store.DeleteRole(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoDeleteRoleRequest(request)
+}
+
+// GetUsersForRole returns the users that have a role from the local node.
+func (h *HRaftDispatcher) GetUsersForRole(role string, domain ...string) ([]string, error) {
+	return h.store.GetUsersForRole(role, domain...)
+}
+
+// GetRolesForUser returns the roles that a user has directly from the local node.
+func (h *HRaftDispatcher) GetRolesForUser(user string, domain ...string) ([]string, error) {
+	return h.store.GetRolesForUser(user, domain...)
+}
+
+// GetImplicitRolesForUser returns the roles that a user has directly or through role inheritance from the local node.
+func (h *HRaftDispatcher) GetImplicitRolesForUser(user string, domain ...string) ([]string, error) {
+	return h.store.GetImplicitRolesForUser(user, domain...)
+}
+
+// Enforce decides whether a subject can access an object with the enforcer of the namespace on the local node.
+func (h *HRaftDispatcher) Enforce(rvals ...interface{}) (bool, error) {
+	return h.store.Enforce(rvals...)
+}
+
+// Namespace returns a HRaftDispatcher that applies the policy changes and enforce calls to the given namespace.
+// The returned HRaftDispatcher shares the node with h, an empty name means the default namespace.
+func (h *HRaftDispatcher) Namespace(name string) *HRaftDispatcher {
+	c := *h
+	c.store = h.store.(http.NamespacedStore).WithNamespace(name)
+	c.httpService = h.httpService.WithNamespace(name)
+	c.namespace = name
+	return &c
+}
+
+// CreateNamespace creates a namespace with the given model text in all nodes.
+func (h *HRaftDispatcher) CreateNamespace(name, modelText string) error {
+	return h.CreateNamespaceContext(context.Background(), name, modelText)
+}
+
+// CreateNamespaceContext is like CreateNamespace, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) CreateNamespaceContext(ctx context.Context, name, modelText string) error {
+	request := &command.CreateNamespaceRequest{
+		Namespace: name,
+		Model:     modelText,
+	}
+	if ok, err := applyLocal(h.store.(http.NamespacedStore).WithNamespace(name), func(store http.Store) error {
+		return store.CreateNamespace(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoCreateNamespaceRequest(request)
+}
+
+// DeleteNamespace deletes a namespace with its model and policies in all nodes.
+func (h *HRaftDispatcher) DeleteNamespace(name string) error {
+	return h.DeleteNamespaceContext(context.Background(), name)
+}
+
+// DeleteNamespaceContext is like DeleteNamespace, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) DeleteNamespaceContext(ctx context.Context, name string) error {
+	request := &command.DeleteNamespaceRequest{
+		Namespace: name,
+	}
+	if ok, err := applyLocal(h.store.(http.NamespacedStore).WithNamespace(name), func(store http.Store) error {
+		return store.DeleteNamespace(ctx, request)
+	}); ok {
+		return err
+	}
+	return h.httpService.WithContext(ctx).DoDeleteNamespaceRequest(request)
+}
+
+// Namespaces returns the names of the namespaces on the local node.
+func (h *HRaftDispatcher) Namespaces() []string {
+	return h.store.Namespaces()
+}
+
+// Import imports a policy stream in the casbin CSV policy format or JSON, see http.FormatCSV and http.FormatJSON.
+// The stream is sent to the leader, which applies the rules in chunks that fit in raft entries.
+// The options can be nil, then the rules are added to the current rules. It returns the number of imported rules.
+func (h *HRaftDispatcher) Import(reader io.Reader, format string, options *ImportOptions) (int, error) {
+	return h.ImportContext(context.Background(), reader, format, options)
+}
+
+// ImportContext is like Import, the request is cancelled when ctx is done.
+func (h *HRaftDispatcher) ImportContext(ctx context.Context, reader io.Reader, format string, options *ImportOptions) (int, error) {
+	f, err := http.ParseFormat(format)
+	if err != nil {
+		return 0, err
+	}
+	if options == nil {
+		options = &ImportOptions{}
+	}
+	return h.httpService.WithContext(ctx).DoImportRequest(reader, f, options.ReplaceAll, options.Progress)
+}
+
+// Export writes the rules of the namespace on the local node in insertion order as a policy stream,
+// the format is the casbin CSV policy format or JSON, see http.FormatCSV and http.FormatJSON.
+func (h *HRaftDispatcher) Export(writer io.Writer, format string) error {
+	f, err := http.ParseFormat(format)
+	if err != nil {
+		return err
+	}
+	export, err := h.store.Export()
+	if err != nil {
+		return err
+	}
+	w, err := http.NewPolicyWriter(writer, f)
+	if err != nil {
+		return err
+	}
+	for _, rule := range export.Rules {
+		err = w.Write(rule)
+		if err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// Backup takes a snapshot of the current node and writes it to the writer with its index, term and configuration,
+// a backup of several raft groups holds a snapshot of each group. It can be restored by Restore.
+func (h *HRaftDispatcher) Backup(writer io.Writer) error {
+	return h.store.Backup(writer)
+}
+
+// Restore replaces the state of the whole cluster with a backup written by Backup, the snapshot of each raft group
+// is sent to the leader of the group, the followers install it as a snapshot. The configuration of the cluster is kept.
+func (h *HRaftDispatcher) Restore(reader io.Reader) error {
+	return h.RestoreContext(context.Background(), reader)
+}
+
+// RestoreContext is like Restore, the requests are cancelled when ctx is done.
+func (h *HRaftDispatcher) RestoreContext(ctx context.Context, reader io.Reader) error {
+	restored := 0
+	for {
+		section, err := store.ReadBackupSection(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = h.restoreSection(ctx, section)
+		section.Close()
+		if err != nil {
+			return err
+		}
+		restored++
+	}
+	if restored != h.groups {
+		return errors.Errorf("the backup has %d sections, expected %d", restored, h.groups)
+	}
+	return nil
+}
+
+// restoreSection sends a section of a backup to the leader of its raft group.
+func (h *HRaftDispatcher) restoreSection(ctx context.Context, section *store.BackupSection) error {
+	if int(section.Header.Groups) != h.groups {
+		return errors.Errorf("the backup has %d groups, the cluster has %d", section.Header.Groups, h.groups)
+	}
+	reader, err := section.Reader()
+	if err != nil {
+		return err
+	}
+	service := h.httpService.WithContext(ctx)
+	if h.groups > 1 {
+		service = service.WithGroup(int(section.Header.Group))
+	}
+	return service.DoRestoreRequest(reader)
 }
 
 // JoinNode joins a node to the current cluster.
 func (h *HRaftDispatcher) JoinNode(serverID, serverAddress string) error {
+	return h.JoinNodeContext(context.Background(), serverID, serverAddress)
+}
+
+// JoinNodeContext is like JoinNode, the requests are cancelled when ctx is done.
+func (h *HRaftDispatcher) JoinNodeContext(ctx context.Context, serverID, serverAddress string) error {
 	request := &command.AddNodeRequest{
 		Id:      serverID,
 		Address: serverAddress,
 	}
-	return h.httpService.DoJoinNodeRequest(request)
+	return h.forEachGroup(ctx, func(service *http.Service) error {
+		return service.DoJoinNodeRequest(request)
+	})
 }
 
 // JoinNode joins a node from the current cluster.
 func (h *HRaftDispatcher) RemoveNode(serverID string) error {
+	return h.RemoveNodeContext(context.Background(), serverID)
+}
+
+// RemoveNodeContext is like RemoveNode, the requests are cancelled when ctx is done.
+func (h *HRaftDispatcher) RemoveNodeContext(ctx context.Context, serverID string) error {
 	request := &command.RemoveNodeRequest{
 		Id: serverID,
 	}
-	return h.httpService.DoRemoveNodeRequest(request)
+	return h.forEachGroup(ctx, func(service *http.Service) error {
+		return service.DoRemoveNodeRequest(request)
+	})
+}
+
+// TransferLeadership transfers the leadership of the current cluster to a node, an empty serverID means any follower.
+func (h *HRaftDispatcher) TransferLeadership(serverID string) error {
+	return h.TransferLeadershipContext(context.Background(), serverID)
+}
+
+// TransferLeadershipContext is like TransferLeadership, the requests are cancelled when ctx is done.
+func (h *HRaftDispatcher) TransferLeadershipContext(ctx context.Context, serverID string) error {
+	request := &command.TransferLeadershipRequest{
+		Id: serverID,
+	}
+	return h.forEachGroup(ctx, func(service *http.Service) error {
+		return service.DoTransferLeadershipRequest(request)
+	})
+}
+
+// forEachGroup calls fn with the Service of each raft group, the requests of the Service are cancelled when ctx is done.
+func (h *HRaftDispatcher) forEachGroup(ctx context.Context, fn func(service *http.Service) error) error {
+	service := h.httpService.WithContext(ctx)
+	if h.groups <= 1 {
+		return fn(service)
+	}
+	for i := 0; i < h.groups; i++ {
+		err := fn(service.WithGroup(i))
+		if err != nil {
+			return errors.Wrapf(err, "group %d", i)
+		}
+	}
+	return nil
+}
+
+// RoutingTable returns the raft group of each namespace from the local node.
+func (h *HRaftDispatcher) RoutingTable() (*command.RoutingTable, error) {
+	sharded, ok := h.store.(http.ShardedStore)
+	if !ok {
+		return nil, errNotSharded
+	}
+	return sharded.RoutingTable()
+}
+
+// MoveNamespace moves a namespace to the given raft group, which rebalances the writes between the groups.
+// The namespace is frozen in the previous group, copied to the group before the routing table is updated,
+// and then it is deleted from the previous group. The writes to the namespace during the move are rejected
+// with the unavailable code, they can be sent again once the move is done. If the move fails,
+// the namespace is unfrozen and stays in the previous group.
+func (h *HRaftDispatcher) MoveNamespace(name string, group int) error {
+	return h.MoveNamespaceContext(context.Background(), name, group)
+}
+
+// MoveNamespaceContext is like MoveNamespace, the requests are cancelled when ctx is done.
+func (h *HRaftDispatcher) MoveNamespaceContext(ctx context.Context, name string, group int) error {
+	// The writes of the move are several commands, which cannot share a request ID.
+	ctx = http.WithRequestID(ctx, "")
+	table, err := h.RoutingTable()
+	if err != nil {
+		return err
+	}
+	if group < 0 || group >= int(table.Groups) {
+		return errors.Errorf("the group %d does not exist", group)
+	}
+	source := -1
+	for _, route := range table.Routes {
+		if route.Namespace == name {
+			source = int(route.Group)
+		}
+	}
+	if source < 0 {
+		return errors.Errorf("the namespace %s does not exist", name)
+	}
+	if source == group {
+		return nil
+	}
+
+	service := h.httpService.WithContext(ctx).WithNamespace(name)
+	// The namespace is frozen before it is exported, so the writes applied before the freeze are exported,
+	// and the writes after it are rejected until the namespace is served by the new group.
+	err = service.WithGroup(source).DoFreezeNamespaceRequest(&command.FreezeNamespaceRequest{
+		Namespace: name,
+		Frozen:    true,
+	})
+	if err != nil {
+		return err
+	}
+	err = h.copyNamespace(ctx, name, source, group)
+	if err != nil {
+		// The namespace is unfrozen even if ctx is done, otherwise it rejects the writes until it is moved again.
+		unfreezeErr := h.httpService.WithNamespace(name).WithGroup(source).DoFreezeNamespaceRequest(&command.FreezeNamespaceRequest{
+			Namespace: name,
+		})
+		if unfreezeErr != nil {
+			h.logger.Error("failed to unfreeze the namespace after a failed move", zap.String("namespace", name), zap.Error(unfreezeErr))
+		}
+		return err
+	}
+
+	return service.WithGroup(source).DoDeleteNamespaceRequest(&command.DeleteNamespaceRequest{
+		Namespace: name,
+	})
+}
+
+// copyNamespace copies a namespace from the source group to the target group, and routes it to the target group.
+func (h *HRaftDispatcher) copyNamespace(ctx context.Context, name string, source, group int) error {
+	service := h.httpService.WithContext(ctx).WithNamespace(name)
+	export, err := service.WithGroup(source).DoExportNamespaceRequest()
+	if err != nil {
+		return err
+	}
+
+	target := service.WithGroup(group)
+	err = target.DoCreateNamespaceRequest(&command.CreateNamespaceRequest{
+		Namespace: name,
+		Model:     export.Model,
+	})
+	if err != nil {
+		return err
+	}
+	// The rules are added in insertion order, the consecutive rules of the same sec and pType are batched
+	// up to http.MaxRulesPerRequest rules.
+	for i := 0; i < len(export.Rules); {
+		request := &command.AddPoliciesRequest{
+			Sec:   export.Rules[i].Sec,
+			PType: export.Rules[i].PType,
+		}
+		for ; i < len(export.Rules) && export.Rules[i].Sec == request.Sec && export.Rules[i].PType == request.PType && len(request.Rules) < http.MaxRulesPerRequest; i++ {
+			request.Rules = append(request.Rules, &command.StringArray{Items: export.Rules[i].Rule})
+		}
+		err = target.DoAddPolicyRequest(request)
+		if err != nil {
+			return err
+		}
+	}
+
+	return h.httpService.WithContext(ctx).DoSetRouteRequest(&command.SetRouteRequest{
+		Namespace: name,
+		Group:     int32(group),
+	})
+}
+
+// Status returns the status of the current node, including whether its state has diverged from the leader.
+func (h *HRaftDispatcher) Status() *command.NodeStatus {
+	return h.store.Status()
+}
+
+// Repair forces the followers to install a snapshot of the leader, which repairs the diverged nodes.
+func (h *HRaftDispatcher) Repair() error {
+	return h.RepairContext(context.Background())
+}
+
+// RepairContext is like Repair, the requests are cancelled when ctx is done.
+func (h *HRaftDispatcher) RepairContext(ctx context.Context) error {
+	return h.forEachGroup(ctx, func(service *http.Service) error {
+		return service.DoRepairRequest()
+	})
 }
 
 // Shutdown is used to close the http and raft service.
@@ -1,15 +1,25 @@
 package hraftdispatcher
 
 import (
+	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"fmt"
 	"io/ioutil"
+	"net/url"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/casbin/casbin/v2"
 	"github.com/casbin/casbin/v2/model"
+	fileadapter "github.com/casbin/casbin/v2/persist/file-adapter"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
 )
@@ -33,12 +43,12 @@ func TestDispatcher(t *testing.T) {
 	defer os.RemoveAll(dataDir)
 
 	leaderRaftAddress := "127.0.0.1:6780"
-	leaderEnforcer, leaderDispatcher, err = newNode(dataDir, leaderRaftAddress, "")
+	leaderEnforcer, leaderDispatcher, err = newNode(dataDir, leaderRaftAddress, "", 0)
 	assert.NoError(t, err)
 	defer leaderDispatcher.Shutdown()
 
 	followerRaftAddress := "127.0.0.1:6790"
-	followerEnforcer, followerDispatcher, err = newNode(dataDir, followerRaftAddress, leaderRaftAddress)
+	followerEnforcer, followerDispatcher, err = newNode(dataDir, followerRaftAddress, leaderRaftAddress, 0)
 	assert.NoError(t, err)
 	defer followerDispatcher.Shutdown()
 
@@ -241,6 +251,175 @@ func TestDispatcher(t *testing.T) {
 				}
 			})
 
+			Convey("test MovePolicy()", func() {
+				rules := [][]string{
+					{"role:admin", "/", "GET"},
+					{"role:admin", "/", "POST"},
+					{"role:admin", "/", "PUT"},
+				}
+				_, err := leaderEnforcer.AddPolicies(rules)
+				So(err, ShouldBeNil)
+
+				err = leaderDispatcher.MovePolicy("p", "p", []string{"role:admin", "/", "PUT"}, 0)
+				So(err, ShouldBeNil)
+
+				<-time.After(3 * time.Second)
+
+				expected := [][]string{
+					{"role:admin", "/", "PUT"},
+					{"role:admin", "/", "GET"},
+					{"role:admin", "/", "POST"},
+				}
+				So(leaderEnforcer.GetPolicy(), ShouldResemble, expected)
+				So(followerEnforcer.GetPolicy(), ShouldResemble, expected)
+
+				err = leaderDispatcher.MovePolicy("p", "p", []string{"role:admin", "/", "DELETE"}, 0)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("test role management", func() {
+				_, err := leaderEnforcer.AddPolicy("role:admin", "/", "GET")
+				So(err, ShouldBeNil)
+				err = leaderDispatcher.AssignRole("alice", "role:admin")
+				So(err, ShouldBeNil)
+				err = leaderDispatcher.AssignRole("role:admin", "role:root")
+				So(err, ShouldBeNil)
+
+				<-time.After(3 * time.Second)
+
+				users, err := followerDispatcher.GetUsersForRole("role:admin")
+				So(err, ShouldBeNil)
+				So(users, ShouldResemble, []string{"alice"})
+				roles, err := followerDispatcher.GetRolesForUser("alice")
+				So(err, ShouldBeNil)
+				So(roles, ShouldResemble, []string{"role:admin"})
+				roles, err = followerDispatcher.GetImplicitRolesForUser("alice")
+				So(err, ShouldBeNil)
+				So(roles, ShouldResemble, []string{"role:admin", "role:root"})
+				ok, err := followerEnforcer.Enforce("alice", "/", "GET")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+
+				err = leaderDispatcher.UnassignRole("role:admin", "role:root")
+				So(err, ShouldBeNil)
+				err = leaderDispatcher.DeleteRole("role:admin")
+				So(err, ShouldBeNil)
+
+				<-time.After(3 * time.Second)
+
+				for _, e := range []casbin.IDistributedEnforcer{leaderEnforcer, followerEnforcer} {
+					So(e.GetPolicy(), ShouldBeEmpty)
+					So(e.GetGroupingPolicy(), ShouldBeEmpty)
+					ok, err := e.Enforce("alice", "/", "GET")
+					So(err, ShouldBeNil)
+					So(ok, ShouldBeFalse)
+				}
+			})
+
+			Convey("test namespaces", func() {
+				modelText := `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`
+				err := leaderDispatcher.CreateNamespace("tenant1", modelText)
+				So(err, ShouldBeNil)
+
+				<-time.After(3 * time.Second)
+
+				So(followerDispatcher.Namespaces(), ShouldResemble, []string{"tenant1"})
+				err = followerDispatcher.Namespace("tenant1").AddPolicies("p", "p", [][]string{{"alice", "/", "GET"}})
+				So(err, ShouldBeNil)
+
+				<-time.After(3 * time.Second)
+
+				for _, d := range []*HRaftDispatcher{leaderDispatcher, followerDispatcher} {
+					ok, err := d.Namespace("tenant1").Enforce("alice", "/", "GET")
+					So(err, ShouldBeNil)
+					So(ok, ShouldBeTrue)
+				}
+				So(leaderEnforcer.GetPolicy(), ShouldBeEmpty)
+
+				err = leaderDispatcher.DeleteNamespace("tenant1")
+				So(err, ShouldBeNil)
+
+				<-time.After(3 * time.Second)
+
+				So(followerDispatcher.Namespaces(), ShouldBeEmpty)
+				_, err = followerDispatcher.Namespace("tenant1").Enforce("alice", "/", "GET")
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("test Import() and Export()", func() {
+				var progress []int
+				rules, err := followerDispatcher.Import(strings.NewReader("p, alice, /import, GET\np, bob, /import, POST\n"), "csv", &ImportOptions{
+					Progress: func(rules int) {
+						progress = append(progress, rules)
+					},
+				})
+				So(err, ShouldBeNil)
+				So(rules, ShouldEqual, 2)
+				So(progress, ShouldResemble, []int{2})
+
+				<-time.After(3 * time.Second)
+
+				for _, e := range []casbin.IDistributedEnforcer{leaderEnforcer, followerEnforcer} {
+					So(e.HasPolicy("alice", "/import", "GET"), ShouldBeTrue)
+					So(e.HasPolicy("bob", "/import", "POST"), ShouldBeTrue)
+				}
+
+				rules, err = followerDispatcher.Import(strings.NewReader(`[{"pType":"p","rule":["carol","/import","GET"]}]`), "json", &ImportOptions{ReplaceAll: true})
+				So(err, ShouldBeNil)
+				So(rules, ShouldEqual, 1)
+
+				<-time.After(3 * time.Second)
+
+				for _, e := range []casbin.IDistributedEnforcer{leaderEnforcer, followerEnforcer} {
+					So(e.GetPolicy(), ShouldResemble, [][]string{{"carol", "/import", "GET"}})
+				}
+
+				var buf bytes.Buffer
+				err = followerDispatcher.Export(&buf, "csv")
+				So(err, ShouldBeNil)
+				So(buf.String(), ShouldEqual, "p, carol, /import, GET\n")
+
+				_, err = followerDispatcher.Import(strings.NewReader(""), "xml", nil)
+				So(err, ShouldNotBeNil)
+			})
+
+			Convey("test Backup() and Restore()", func() {
+				var buf bytes.Buffer
+				err := followerDispatcher.Backup(&buf)
+				So(err, ShouldBeNil)
+
+				err = leaderDispatcher.AddPolicies("p", "p", [][]string{{"dave", "/backup", "GET"}})
+				So(err, ShouldBeNil)
+
+				<-time.After(3 * time.Second)
+
+				So(followerEnforcer.HasPolicy("dave", "/backup", "GET"), ShouldBeTrue)
+
+				err = followerDispatcher.Restore(bytes.NewReader(buf.Bytes()))
+				So(err, ShouldBeNil)
+
+				<-time.After(3 * time.Second)
+
+				for _, e := range []casbin.IDistributedEnforcer{leaderEnforcer, followerEnforcer} {
+					So(e.GetPolicy(), ShouldResemble, [][]string{{"carol", "/import", "GET"}})
+				}
+
+				err = followerDispatcher.Restore(strings.NewReader("p, carol, /import, GET\n"))
+				So(err, ShouldNotBeNil)
+			})
+
 			Convey("cleanup test", func() {
 				leaderEnforcer.ClearPolicy()
 
@@ -454,6 +633,246 @@ func TestDispatcher(t *testing.T) {
 	})
 }
 
+func TestDispatcher_RaftGroups(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "casbin-hraft-dispatcher-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+
+	leaderRaftAddress := "127.0.0.1:6800"
+	_, leaderDispatcher, err := newNode(dataDir, leaderRaftAddress, "", 2)
+	assert.NoError(t, err)
+	defer leaderDispatcher.Shutdown()
+
+	followerRaftAddress := "127.0.0.1:6810"
+	_, followerDispatcher, err := newNode(dataDir, followerRaftAddress, leaderRaftAddress, 2)
+	assert.NoError(t, err)
+	defer followerDispatcher.Shutdown()
+
+	modelText := `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`
+
+	Convey("test raft groups", t, func() {
+		names := []string{"tenant0", "tenant1", "tenant2", "tenant3"}
+		for _, name := range names {
+			err := leaderDispatcher.CreateNamespace(name, modelText)
+			So(err, ShouldBeNil)
+		}
+
+		<-time.After(3 * time.Second)
+
+		So(followerDispatcher.Namespaces(), ShouldResemble, names)
+		for _, name := range names {
+			err := followerDispatcher.Namespace(name).AddPolicies("p", "p", [][]string{{name, "/", "GET"}})
+			So(err, ShouldBeNil)
+		}
+
+		<-time.After(3 * time.Second)
+
+		for _, name := range names {
+			ok, err := leaderDispatcher.Namespace(name).Enforce(name, "/", "GET")
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		}
+
+		table, err := followerDispatcher.RoutingTable()
+		So(err, ShouldBeNil)
+		So(table.Groups, ShouldEqual, 2)
+		So(table.Routes, ShouldHaveLength, len(names))
+		route := table.Routes[0]
+		target := 1 - int(route.Group)
+
+		err = followerDispatcher.MoveNamespace(route.Namespace, target)
+		So(err, ShouldBeNil)
+
+		<-time.After(3 * time.Second)
+
+		// The moved namespace accepts the writes in its new group.
+		err = followerDispatcher.Namespace(route.Namespace).AddPolicies("p", "p", [][]string{{route.Namespace, "/moved", "GET"}})
+		So(err, ShouldBeNil)
+
+		for _, d := range []*HRaftDispatcher{leaderDispatcher, followerDispatcher} {
+			table, err := d.RoutingTable()
+			So(err, ShouldBeNil)
+			So(table.Routes[0].Namespace, ShouldEqual, route.Namespace)
+			So(table.Routes[0].Group, ShouldEqual, target)
+			So(d.Namespaces(), ShouldResemble, names)
+
+			ok, err := d.Namespace(route.Namespace).Enforce(route.Namespace, "/", "GET")
+			So(err, ShouldBeNil)
+			So(ok, ShouldBeTrue)
+		}
+
+		// A backup holds the snapshots of all groups, each of them is restored by the leader of its group.
+		var buf bytes.Buffer
+		err = followerDispatcher.Backup(&buf)
+		So(err, ShouldBeNil)
+		for _, name := range names {
+			err := leaderDispatcher.Namespace(name).AddPolicies("p", "p", [][]string{{name, "/backup", "GET"}})
+			So(err, ShouldBeNil)
+		}
+		err = followerDispatcher.Restore(bytes.NewReader(buf.Bytes()))
+		So(err, ShouldBeNil)
+
+		<-time.After(3 * time.Second)
+
+		for _, d := range []*HRaftDispatcher{leaderDispatcher, followerDispatcher} {
+			for _, name := range names {
+				ok, err := d.Namespace(name).Enforce(name, "/backup", "GET")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeFalse)
+				ok, err = d.Namespace(name).Enforce(name, "/", "GET")
+				So(err, ShouldBeNil)
+				So(ok, ShouldBeTrue)
+			}
+		}
+	})
+}
+
+func TestDispatcher_InitialAdapter(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "casbin-hraft-dispatcher-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+
+	policyPath := filepath.Join(dataDir, "policy.csv")
+	err = ioutil.WriteFile(policyPath, []byte("p, role:admin, /, GET\np, role:admin, /, POST\ng, alice, role:admin\n"), 0644)
+	assert.NoError(t, err)
+
+	config := &Config{
+		RaftListenAddress: "127.0.0.1:6820",
+		InitialAdapter:    fileadapter.NewAdapter(policyPath),
+	}
+	e, dispatcher, err := newNodeWithConfig(dataDir, config)
+	assert.NoError(t, err)
+
+	Convey("test initial adapter", t, func() {
+		So(e.GetPolicy(), ShouldResemble, [][]string{{"role:admin", "/", "GET"}, {"role:admin", "/", "POST"}})
+		So(e.GetGroupingPolicy(), ShouldResemble, [][]string{{"alice", "role:admin"}})
+		ok, err := e.Enforce("alice", "/", "POST")
+		So(err, ShouldBeNil)
+		So(ok, ShouldBeTrue)
+
+		_, err = e.RemovePolicy("role:admin", "/", "POST")
+		So(err, ShouldBeNil)
+		dispatcher.Shutdown()
+
+		// The initialized cluster is the source of truth, the adapter is not loaded again.
+		config.Enforcer = nil
+		e, dispatcher, err = newNodeWithConfig(dataDir, config)
+		So(err, ShouldBeNil)
+		So(e.GetPolicy(), ShouldResemble, [][]string{{"role:admin", "/", "GET"}})
+		So(e.GetGroupingPolicy(), ShouldResemble, [][]string{{"alice", "role:admin"}})
+		dispatcher.Shutdown()
+
+		// A node that stopped after bootstrapping a cluster and before seeding it seeds the cluster on its next start.
+		config.Enforcer = nil
+		config.DataDir = ""
+		config.InitialAdapter = nil
+		_, dispatcher, err = newNodeWithConfig(dataDir, config)
+		So(err, ShouldBeNil)
+		dispatcher.Shutdown()
+		err = ioutil.WriteFile(filepath.Join(config.DataDir, seedPendingFileName), nil, 0644)
+		So(err, ShouldBeNil)
+
+		config.Enforcer = nil
+		config.InitialAdapter = fileadapter.NewAdapter(policyPath)
+		e, dispatcher, err = newNodeWithConfig(dataDir, config)
+		So(err, ShouldBeNil)
+		So(e.GetPolicy(), ShouldResemble, [][]string{{"role:admin", "/", "GET"}, {"role:admin", "/", "POST"}})
+		_, err = os.Stat(filepath.Join(config.DataDir, seedPendingFileName))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		dispatcher.Shutdown()
+	})
+}
+
+func TestDispatcher_ForceNewCluster(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "casbin-hraft-dispatcher-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+
+	leaderConfig := &Config{RaftListenAddress: "127.0.0.1:6830"}
+	_, leaderDispatcher, err := newNodeWithConfig(dataDir, leaderConfig)
+	assert.NoError(t, err)
+	followerConfig := &Config{RaftListenAddress: "127.0.0.1:6840", JoinAddress: "127.0.0.1:6830"}
+	_, followerDispatcher, err := newNodeWithConfig(dataDir, followerConfig)
+	assert.NoError(t, err)
+
+	Convey("test ForceNewCluster()", t, func() {
+		err := leaderDispatcher.AddPolicies("p", "p", [][]string{{"alice", "/", "GET"}})
+		So(err, ShouldBeNil)
+
+		<-time.After(3 * time.Second)
+
+		// The quorum is lost with the leader.
+		leaderDispatcher.Shutdown()
+		followerDispatcher.Shutdown()
+
+		err = ForceNewCluster(&Config{DataDir: dataDir, RaftListenAddress: "127.0.0.1:6840"})
+		So(err, ShouldNotBeNil)
+
+		err = ForceNewCluster(followerConfig)
+		So(err, ShouldBeNil)
+		e, dispatcher, err := newNodeWithConfig(dataDir, followerConfig)
+		So(err, ShouldBeNil)
+		defer dispatcher.Shutdown()
+
+		<-time.After(3 * time.Second)
+
+		_, err = os.Stat(filepath.Join(followerConfig.DataDir, "peers.json"))
+		So(os.IsNotExist(err), ShouldBeTrue)
+		So(e.HasPolicy("alice", "/", "GET"), ShouldBeTrue)
+
+		// The survivor is the leader of the new cluster.
+		err = dispatcher.AddPolicies("p", "p", [][]string{{"bob", "/", "GET"}})
+		So(err, ShouldBeNil)
+		So(e.HasPolicy("bob", "/", "GET"), ShouldBeTrue)
+	})
+}
+
+func TestDispatcher_TransferLeadership(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "casbin-hraft-dispatcher-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+
+	_, leaderDispatcher, err := newNode(dataDir, "127.0.0.1:6850", "", 0)
+	assert.NoError(t, err)
+	defer leaderDispatcher.Shutdown()
+	followerEnforcer, followerDispatcher, err := newNode(dataDir, "127.0.0.1:6860", "127.0.0.1:6850", 0)
+	assert.NoError(t, err)
+	defer followerDispatcher.Shutdown()
+
+	Convey("test TransferLeadership()", t, func() {
+		<-time.After(3 * time.Second)
+
+		err := leaderDispatcher.TransferLeadership("127.0.0.1:6870")
+		So(err, ShouldNotBeNil)
+
+		err = leaderDispatcher.TransferLeadership("127.0.0.1:6860")
+		So(err, ShouldBeNil)
+
+		<-time.After(3 * time.Second)
+
+		So(followerDispatcher.Status().Leader, ShouldEqual, "127.0.0.1:6860")
+		So(leaderDispatcher.Status().Leader, ShouldEqual, "127.0.0.1:6860")
+
+		// The writes are served by the new leader.
+		err = leaderDispatcher.AddPolicies("p", "p", [][]string{{"alice", "/", "GET"}})
+		So(err, ShouldBeNil)
+		<-time.After(time.Second)
+		So(followerEnforcer.HasPolicy("alice", "/", "GET"), ShouldBeTrue)
+	})
+}
+
 func getTLSConfig() (*tls.Config, error) {
 	rootCAPool := x509.NewCertPool()
 	rootCA, err := ioutil.ReadFile("./testdata/ca/ca.pem")
@@ -477,7 +896,16 @@ func getTLSConfig() (*tls.Config, error) {
 	return config, nil
 }
 
-func newNode(dataDir, raftListenAddress, joinAddress string) (casbin.IDistributedEnforcer, *HRaftDispatcher, error) {
+func newNode(dataDir, raftListenAddress, joinAddress string, raftGroups int) (casbin.IDistributedEnforcer, *HRaftDispatcher, error) {
+	return newNodeWithConfig(dataDir, &Config{
+		JoinAddress:       joinAddress,
+		RaftListenAddress: raftListenAddress,
+		RaftGroups:        raftGroups,
+	})
+}
+
+// newNodeWithConfig starts a node with the given config, the enforcer, TLS config and data directory are filled if they are not set.
+func newNodeWithConfig(dataDir string, config *Config) (casbin.IDistributedEnforcer, *HRaftDispatcher, error) {
 	var modelText = `
 [request_definition]
 r = sub, obj, act
@@ -509,18 +937,16 @@ m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
 		return nil, nil, err
 	}
 
-	dir, err := ioutil.TempDir(dataDir, "data-")
-	if err != nil {
-		return nil, nil, err
+	if len(config.DataDir) == 0 {
+		config.DataDir, err = ioutil.TempDir(dataDir, "data-")
+		if err != nil {
+			return nil, nil, err
+		}
 	}
+	config.Enforcer = e
+	config.TLSConfig = tlsConfig
 
-	dispatcher, err := NewHRaftDispatcher(&Config{
-		Enforcer:          e,
-		JoinAddress:       joinAddress,
-		RaftListenAddress: raftListenAddress,
-		TLSConfig:         tlsConfig,
-		DataDir:           dir,
-	})
+	dispatcher, err := NewHRaftDispatcher(config)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -529,3 +955,128 @@ m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
 
 	return e, dispatcher, nil
 }
+
+func TestDispatcher_Context(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "casbin-hraft-dispatcher-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+
+	e, dispatcher, err := newNode(dataDir, "127.0.0.1:6880", "", 0)
+	assert.NoError(t, err)
+	defer dispatcher.Shutdown()
+
+	Convey("test the context variants", t, func() {
+		status, err := dispatcher.httpService.DoStatusRequest()
+		So(err, ShouldBeNil)
+		So(status.Leader, ShouldEqual, "127.0.0.1:6880")
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		err = dispatcher.AddPoliciesContext(ctx, "p", "p", [][]string{{"alice", "/", "GET"}})
+		So(err, ShouldBeNil)
+		So(e.HasPolicy("alice", "/", "GET"), ShouldBeTrue)
+
+		ctx, cancel = context.WithCancel(context.Background())
+		cancel()
+		err = dispatcher.RemovePoliciesContext(ctx, "p", "p", [][]string{{"alice", "/", "GET"}})
+		So(err == context.Canceled, ShouldBeTrue)
+		So(e.HasPolicy("alice", "/", "GET"), ShouldBeTrue)
+
+		err = dispatcher.RepairContext(ctx)
+		So(err, ShouldNotBeNil)
+		So(err.(*url.Error).Err, ShouldEqual, context.Canceled)
+
+		// The writes of the leader are applied with its Store, without a request to an HTTP server.
+		dispatcher.httpService = http.NewClient("127.0.0.1:1", nil)
+		err = dispatcher.AddPolicies("p", "p", [][]string{{"bob", "/", "GET"}})
+		So(err, ShouldBeNil)
+		So(e.HasPolicy("bob", "/", "GET"), ShouldBeTrue)
+		err = dispatcher.Namespace("tenant1").AddPolicies("p", "p", [][]string{{"bob", "/", "GET"}})
+		So(errors.Cause(err), ShouldEqual, http.ErrRejected)
+	})
+}
+
+func TestDispatcher_Batch(t *testing.T) {
+	dataDir, err := ioutil.TempDir("", "casbin-hraft-dispatcher-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dataDir)
+
+	leaderEnforcer, leaderDispatcher, err := newNode(dataDir, "127.0.0.1:6890", "", 0)
+	assert.NoError(t, err)
+	defer leaderDispatcher.Shutdown()
+	// The writes of a follower are batched, the writes of the leader are applied locally.
+	_, dispatcher, err := newNodeWithConfig(dataDir, &Config{
+		RaftListenAddress: "127.0.0.1:6900",
+		JoinAddress:       "127.0.0.1:6890",
+		BatchWindow:       20 * time.Millisecond,
+		BatchSize:         8,
+	})
+	assert.NoError(t, err)
+	defer dispatcher.Shutdown()
+
+	Convey("test the batched writes", t, func() {
+		<-time.After(3 * time.Second)
+
+		var wg sync.WaitGroup
+		errs := make([]error, 20)
+		for i := range errs {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				errs[i] = dispatcher.AddPolicies("p", "p", [][]string{{fmt.Sprintf("user%d", i), "/", "GET"}})
+			}(i)
+		}
+		wg.Wait()
+		for i, err := range errs {
+			So(err, ShouldBeNil)
+			So(leaderEnforcer.HasPolicy(fmt.Sprintf("user%d", i), "/", "GET"), ShouldBeTrue)
+		}
+
+		err := dispatcher.RemovePolicies("p", "p", [][]string{{"user0", "/", "GET"}})
+		So(err, ShouldBeNil)
+		So(leaderEnforcer.HasPolicy("user0", "/", "GET"), ShouldBeFalse)
+
+		// Each caller gets the result of its own write.
+		errs = make([]error, 2)
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs[0] = dispatcher.AddPolicies("p", "p", [][]string{{"user0", "/", "GET"}})
+		}()
+		go func() {
+			defer wg.Done()
+			errs[1] = dispatcher.Namespace("tenant1").AddPolicies("p", "p", [][]string{{"alice", "/", "GET"}})
+		}()
+		wg.Wait()
+		So(errs[0], ShouldBeNil)
+		So(leaderEnforcer.HasPolicy("user0", "/", "GET"), ShouldBeTrue)
+		So(errors.Cause(errs[1]), ShouldEqual, http.ErrRejected)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		err = dispatcher.RemovePoliciesContext(ctx, "p", "p", [][]string{{"user1", "/", "GET"}})
+		So(err == context.Canceled, ShouldBeTrue)
+		<-time.After(100 * time.Millisecond)
+		So(leaderEnforcer.HasPolicy("user1", "/", "GET"), ShouldBeTrue)
+	})
+}
+
+// followerStore is a Store whose node is not the leader, its Leader method is not implemented as it would wait for an election.
+type followerStore struct {
+	http.Store
+}
+
+func (followerStore) IsLeader() bool {
+	return false
+}
+
+func TestApplyLocal(t *testing.T) {
+	applied := false
+	ok, err := applyLocal(followerStore{}, func(store http.Store) error {
+		applied = true
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	assert.False(t, applied)
+}
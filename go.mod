@@ -3,6 +3,7 @@ module github.com/nodece/casbin-hraft-dispatcher
 go 1.15
 
 require (
+	github.com/BurntSushi/toml v0.4.1
 	github.com/Knetic/govaluate v3.0.1-0.20171022003610-9aa49832a739+incompatible
 	github.com/casbin/casbin/v2 v2.23.4
 	github.com/cenkalti/backoff/v4 v4.1.0
@@ -20,4 +21,5 @@ require (
 	go.uber.org/zap v1.16.0
 	golang.org/x/net v0.0.0-20201202161906-c7110b5ffcbb
 	google.golang.org/protobuf v1.25.0
+	gopkg.in/yaml.v3 v3.0.0-20200313102051-9f266ea9e77c
 )
@@ -0,0 +1,97 @@
+package http
+
+import (
+	"io"
+	"net/http"
+
+	"go.uber.org/zap"
+)
+
+// countingWriter counts the bytes written.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// handleBackup streams a backup of the current node.
+func (s *Service) handleBackup(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	cw := &countingWriter{w: w}
+	err := s.storeOf(r).Backup(cw)
+	if err != nil {
+		s.logger.Error("failed to back up the current node", zap.Error(err))
+		if cw.n == 0 {
+			s.writeError(w, r, err)
+			return
+		}
+		// The backup is truncated, the connection is aborted so that the client sees the failure.
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// handleRestore restores a backup on the leader.
+func (s *Service) handleRestore(w http.ResponseWriter, r *http.Request) {
+	err := s.storeOf(r).Restore(r.Context(), r.Body)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DoRestoreRequest streams a backup to the leader, which restores it.
+func (s *Service) DoRestoreRequest(reader io.Reader) error {
+	// The body is streamed, so it cannot be sent again when redirected, the leader is resolved first.
+	// The namespace does not matter for a restore.
+	address, err := s.WithNamespace("").leaderAddr()
+	if err != nil {
+		return err
+	}
+
+	r, err := s.newRequest(http.MethodPut, s.urlAt(address, "/restore", nil), reader)
+	if err != nil {
+		return err
+	}
+
+	// A restore may take longer than the timeout of the other requests.
+	client := *s.httpClient
+	client.Timeout = 0
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+	return nil
+}
+
+// DoBackupRequest writes a backup of the node that the requests are sent to.
+func (s *Service) DoBackupRequest(writer io.Writer) error {
+	r, err := s.newRequest(http.MethodGet, s.WithNamespace("").url("/backup"), nil)
+	if err != nil {
+		return err
+	}
+
+	// A backup may take longer than the timeout of the other requests.
+	client := *s.httpClient
+	client.Timeout = 0
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	_, err = io.Copy(writer, resp.Body)
+	return err
+}
@@ -0,0 +1,72 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nodece/casbin-hraft-dispatcher/http/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupRestore(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	store.EXPECT().Leader().Return(true, s.Addr()).AnyTimes()
+
+	gomock.InOrder(
+		store.EXPECT().Backup(gomock.Any()).DoAndReturn(func(w io.Writer) error {
+			_, err := io.WriteString(w, "backup")
+			return err
+		}),
+		store.EXPECT().Backup(gomock.Any()).Return(errors.New("raft is shutdown")),
+	)
+	resp, err := ts.Client().Get(fmt.Sprintf("https://%s/backup", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "backup", string(b))
+
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/backup", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+
+	gomock.InOrder(
+		store.EXPECT().Restore(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, r io.Reader) error {
+			b, err := ioutil.ReadAll(r)
+			assert.NoError(t, err)
+			assert.Equal(t, "backup", string(b))
+			return nil
+		}),
+		store.EXPECT().Restore(gomock.Any(), gomock.Any()).Return(errors.New("the stream is not a backup")),
+	)
+	err = s.DoRestoreRequest(strings.NewReader("backup"))
+	assert.NoError(t, err)
+	err = s.DoRestoreRequest(strings.NewReader("p, alice, data1, read"))
+	assert.EqualError(t, err, "the stream is not a backup")
+	assert.Equal(t, ErrorCodeUnavailable, CodeOf(err))
+}
@@ -0,0 +1,266 @@
+package http
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/pkg/errors"
+)
+
+// ErrorCode is the kind of an error response, so the callers can react to an error without parsing its message.
+type ErrorCode string
+
+const (
+	// ErrorCodeNotLeader means the node is not the leader any more, the request has not been applied.
+	ErrorCodeNotLeader ErrorCode = "not_leader"
+	// ErrorCodeNoLeader means the leader is unknown, such as during an election.
+	ErrorCodeNoLeader ErrorCode = "no_leader"
+	// ErrorCodeTimeout means the request is not done in time, a change may still be applied.
+	ErrorCodeTimeout ErrorCode = "timeout"
+	// ErrorCodeInvalidRequest means the request is malformed.
+	ErrorCodeInvalidRequest ErrorCode = "invalid_request"
+	// ErrorCodeTooLarge means the body of the request is larger than MaxRequestBodySize.
+	ErrorCodeTooLarge ErrorCode = "too_large"
+	// ErrorCodeConflict means the request conflicts with the current state, such as an existing namespace.
+	ErrorCodeConflict ErrorCode = "conflict"
+	// ErrorCodeRejected means the command is rejected by the state machine, the state is not changed.
+	ErrorCodeRejected ErrorCode = "fsm_rejected"
+	// ErrorCodeUnauthorized means the client is not allowed to send the request, such as a 401 or 403 response of
+	// a proxy that authenticates the clients. The request is not retried.
+	ErrorCodeUnauthorized ErrorCode = "unauthorized"
+	// ErrorCodeUnavailable means the node cannot serve the request, it is the code of the unclassified errors.
+	ErrorCodeUnavailable ErrorCode = "unavailable"
+	// ErrorCodeInternal means the node failed to encode the response.
+	ErrorCodeInternal ErrorCode = "internal"
+	// ErrorCodeOverloaded means the node has too many writes to serve, the request has not been applied,
+	// it can be sent again after the Retry-After header.
+	ErrorCodeOverloaded ErrorCode = "overloaded"
+)
+
+// The sentinel errors of the error codes, errors.Cause returns one of them for an *Error.
+var (
+	ErrNotLeader      = errors.New("the node is not the leader")
+	ErrNoLeader       = errors.New("the leader of the cluster is unknown")
+	ErrTimeout        = errors.New("the request timed out")
+	ErrInvalidRequest = errors.New("the request is invalid")
+	ErrTooLarge       = errors.New("the request is too large")
+	ErrConflict       = errors.New("the request conflicts with the current state")
+	ErrRejected       = errors.New("the command is rejected by the state machine")
+	ErrUnauthorized   = errors.New("the request is unauthorized")
+	ErrUnavailable    = errors.New("the node is unavailable")
+	ErrInternal       = errors.New("the node has an internal error")
+	ErrOverloaded     = errors.New("the node is overloaded")
+)
+
+// errorKind is an error code with its HTTP status and its sentinel error.
+type errorKind struct {
+	code   ErrorCode
+	status int
+	err    error
+}
+
+var errorKinds = []errorKind{
+	{ErrorCodeNotLeader, http.StatusServiceUnavailable, ErrNotLeader},
+	{ErrorCodeNoLeader, http.StatusServiceUnavailable, ErrNoLeader},
+	{ErrorCodeTimeout, http.StatusGatewayTimeout, ErrTimeout},
+	{ErrorCodeInvalidRequest, http.StatusBadRequest, ErrInvalidRequest},
+	{ErrorCodeTooLarge, http.StatusRequestEntityTooLarge, ErrTooLarge},
+	{ErrorCodeConflict, http.StatusConflict, ErrConflict},
+	{ErrorCodeRejected, http.StatusUnprocessableEntity, ErrRejected},
+	{ErrorCodeUnauthorized, http.StatusUnauthorized, ErrUnauthorized},
+	{ErrorCodeUnavailable, http.StatusServiceUnavailable, ErrUnavailable},
+	{ErrorCodeInternal, http.StatusInternalServerError, ErrInternal},
+	{ErrorCodeOverloaded, http.StatusTooManyRequests, ErrOverloaded},
+}
+
+// kind returns the errorKind of the code, an unknown code is unavailable.
+func (c ErrorCode) kind() errorKind {
+	for _, k := range errorKinds {
+		if k.code == c {
+			return k
+		}
+	}
+	return errorKind{ErrorCodeUnavailable, http.StatusServiceUnavailable, ErrUnavailable}
+}
+
+// StatusCode returns the HTTP status of the error responses of the code.
+func (c ErrorCode) StatusCode() int {
+	return c.kind().status
+}
+
+// Error is an error with a code, it is sent as the JSON body of an error response, see command.ErrorResponse.
+type Error struct {
+	Code    ErrorCode
+	Message string
+	// Leader is the HTTP address of the leader, it is empty if the node does not know the leader.
+	Leader string
+	// RetryAfter is the time after which an overloaded request can be sent again, it is sent in the Retry-After header.
+	RetryAfter time.Duration
+}
+
+// NewError returns an Error of the code with the message.
+func NewError(code ErrorCode, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if len(e.Message) == 0 {
+		return e.Cause().Error()
+	}
+	return e.Message
+}
+
+// Cause returns the sentinel error of the code, which is returned by errors.Cause.
+func (e *Error) Cause() error {
+	return e.Code.kind().err
+}
+
+// Unwrap returns the sentinel error of the code for the errors package of the standard library.
+func (e *Error) Unwrap() error {
+	return e.Cause()
+}
+
+// asError returns the *Error in the chain of causes of an error, or nil.
+func asError(err error) *Error {
+	for err != nil {
+		if e, ok := err.(*Error); ok {
+			return e
+		}
+		cause, ok := err.(interface{ Cause() error })
+		if !ok {
+			return nil
+		}
+		err = cause.Cause()
+	}
+	return nil
+}
+
+// CodeOf returns the code of an error, context errors are timeouts, and unclassified errors are unavailable.
+func CodeOf(err error) ErrorCode {
+	if e := asError(err); e != nil {
+		return e.Code
+	}
+	cause := errors.Cause(err)
+	if e, ok := cause.(*url.Error); ok {
+		if e.Timeout() {
+			return ErrorCodeTimeout
+		}
+		cause = e.Err
+	}
+	if cause == context.DeadlineExceeded || cause == context.Canceled {
+		return ErrorCodeTimeout
+	}
+	for _, k := range errorKinds {
+		if k.err == cause {
+			return k.code
+		}
+	}
+	return ErrorCodeUnavailable
+}
+
+// codeOfStatus returns the code of a response without an error body, such as a response of a proxy.
+func codeOfStatus(status int) ErrorCode {
+	switch status {
+	case http.StatusBadRequest:
+		return ErrorCodeInvalidRequest
+	case http.StatusRequestEntityTooLarge:
+		return ErrorCodeTooLarge
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return ErrorCodeUnauthorized
+	case http.StatusConflict:
+		return ErrorCodeConflict
+	case http.StatusUnprocessableEntity:
+		return ErrorCodeRejected
+	case http.StatusGatewayTimeout:
+		return ErrorCodeTimeout
+	case http.StatusTooManyRequests:
+		return ErrorCodeOverloaded
+	case http.StatusInternalServerError:
+		return ErrorCodeInternal
+	default:
+		return ErrorCodeUnavailable
+	}
+}
+
+// writeError writes an error response with the code of err, the leader is given as a hint if the node knows it.
+func (s *Service) writeError(w http.ResponseWriter, r *http.Request, err error) {
+	code := CodeOf(err)
+	body := &command.ErrorResponse{
+		Code:    string(code),
+		Message: err.Error(),
+	}
+	if e := asError(err); e != nil {
+		body.Leader = e.Leader
+	}
+	if len(body.Leader) == 0 && code == ErrorCodeNotLeader {
+		body.Leader = s.leaderHint(r)
+	}
+
+	b, marshalErr := jsoniter.Marshal(body)
+	if marshalErr != nil {
+		http.Error(w, err.Error(), code.StatusCode())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	if code == ErrorCodeOverloaded {
+		var retryAfter time.Duration
+		if e := asError(err); e != nil {
+			retryAfter = e.RetryAfter
+		}
+		w.Header().Set("Retry-After", strconv.Itoa(retryAfterSeconds(retryAfter)))
+	}
+	w.WriteHeader(code.StatusCode())
+	_, _ = w.Write(b)
+}
+
+// leaderHint returns the HTTP address of the leader of the Store that serves a request, it is empty if it is unknown.
+func (s *Service) leaderHint(r *http.Request) string {
+	isLeader, leaderAddr := s.storeOf(r).Leader()
+	if isLeader || len(leaderAddr) == 0 {
+		return ""
+	}
+	address, err := ConvertRaftAddressToHTTPAddress(leaderAddr)
+	if err != nil {
+		return ""
+	}
+	return address
+}
+
+// ResponseError returns the *Error of a response whose status is not OK.
+// A response without an error body gets the code of its status, with the body as the message.
+func ResponseError(resp *http.Response) *Error {
+	b, _ := ioutil.ReadAll(resp.Body)
+	var e *Error
+	var body command.ErrorResponse
+	if err := jsoniter.Unmarshal(b, &body); err == nil && len(body.Code) > 0 {
+		e = &Error{Code: ErrorCode(body.Code), Message: body.Message, Leader: body.Leader}
+	} else {
+		message := strings.TrimSpace(string(b))
+		if len(message) == 0 {
+			message = http.StatusText(resp.StatusCode)
+		}
+		e = NewError(codeOfStatus(resp.StatusCode), message)
+	}
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return e
+}
+
+// retryAfterSeconds returns the seconds of the Retry-After header, which is at least one second.
+func retryAfterSeconds(d time.Duration) int {
+	seconds := int((d + time.Second - 1) / time.Second)
+	if seconds < 1 {
+		return 1
+	}
+	return seconds
+}
@@ -0,0 +1,110 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrors(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	// The code of an error of the store is sent, and it is returned as an *Error.
+	store.EXPECT().Leader().Return(true, "127.0.0.1:6790")
+	store.EXPECT().AddPolicies(gomock.Any(), gomock.Any()).Return(NewError(ErrorCodeRejected, "the model does not define p2"))
+	err = s.DoAddPolicyRequest(&command.AddPoliciesRequest{Sec: "p", PType: "p2"})
+	assert.Equal(t, &Error{Code: ErrorCodeRejected, Message: "the model does not define p2"}, err)
+	assert.Equal(t, ErrRejected, errors.Cause(err))
+
+	// The leader is given as a hint when the node is not the leader.
+	gomock.InOrder(
+		store.EXPECT().GetUsersForRole("admin").Return(nil, NewError(ErrorCodeNotLeader, "node is not the leader")),
+		store.EXPECT().Leader().Return(false, "127.0.0.1:6790"),
+	)
+	resp, err := ts.Client().Get(fmt.Sprintf("https://%s/roles/users?role=admin", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var body command.ErrorResponse
+	err = jsoniter.NewDecoder(resp.Body).Decode(&body)
+	assert.NoError(t, err)
+	assert.Equal(t, string(ErrorCodeNotLeader), body.Code)
+	assert.Equal(t, "node is not the leader", body.Message)
+	assert.Equal(t, "127.0.0.1:6791", body.Leader)
+
+	// A malformed request is invalid.
+	store.EXPECT().Leader().Return(true, "127.0.0.1:6790")
+	resp, err = ts.Client().Do(newTestRequest(t, http.MethodPut, fmt.Sprintf("https://%s/policies/add", s.Addr()), "{"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	assert.Equal(t, ErrorCodeInvalidRequest, ResponseError(resp).Code)
+
+	store.EXPECT().Leader().Return(false, "")
+	err = s.DoClearPolicyRequest()
+	assert.Equal(t, ErrNoLeader, errors.Cause(err))
+}
+
+func newTestRequest(t *testing.T, method string, url string, body string) *http.Request {
+	r, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+	assert.NoError(t, err)
+	return r
+}
+
+func TestCodeOf(t *testing.T) {
+	assert.Equal(t, ErrorCodeConflict, CodeOf(errors.Wrap(NewError(ErrorCodeConflict, "namespace tenant1"), "failed to create")))
+	assert.Equal(t, ErrorCodeNoLeader, CodeOf(ErrNoLeader))
+	assert.Equal(t, ErrorCodeTimeout, CodeOf(context.DeadlineExceeded))
+	assert.Equal(t, ErrorCodeUnavailable, CodeOf(errors.New("raft is shutdown")))
+	assert.Equal(t, http.StatusConflict, ErrorCodeConflict.StatusCode())
+	assert.EqualError(t, &Error{Code: ErrorCodeTimeout}, "the request timed out")
+
+	// A response without an error body, such as a response of a proxy, gets the code of its status.
+	w := httptest.NewRecorder()
+	http.Error(w, "upstream request timeout", http.StatusGatewayTimeout)
+	err := ResponseError(w.Result())
+	assert.Equal(t, &Error{Code: ErrorCodeTimeout, Message: "upstream request timeout"}, err)
+	assert.EqualError(t, err, "upstream request timeout")
+
+	// A 401 or 403, such as the response of an authenticating proxy, is unauthorized.
+	for _, status := range []int{http.StatusUnauthorized, http.StatusForbidden} {
+		w = httptest.NewRecorder()
+		http.Error(w, "access denied", status)
+		err = ResponseError(w.Result())
+		assert.Equal(t, ErrorCodeUnauthorized, err.Code)
+		assert.Equal(t, ErrUnauthorized, errors.Cause(err))
+	}
+	assert.Equal(t, http.StatusUnauthorized, ErrorCodeUnauthorized.StatusCode())
+
+	// A 413, such as the response of a proxy that limits the size of the bodies, is too large.
+	w = httptest.NewRecorder()
+	http.Error(w, "request entity too large", http.StatusRequestEntityTooLarge)
+	err = ResponseError(w.Result())
+	assert.Equal(t, ErrorCodeTooLarge, err.Code)
+	assert.Equal(t, ErrTooLarge, errors.Cause(err))
+	assert.Equal(t, http.StatusRequestEntityTooLarge, ErrorCodeTooLarge.StatusCode())
+}
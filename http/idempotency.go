@@ -0,0 +1,47 @@
+package http
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// RequestIDHeader is the header of the idempotency key of a write, see WithRequestID.
+const RequestIDHeader = "Idempotency-Key"
+
+// maxRequestIDLength is the maximum length of a request ID.
+const maxRequestIDLength = 128
+
+// requestIDContextKey is the context key of the request ID.
+type requestIDContextKey struct{}
+
+// WithRequestID returns a context that carries the idempotency key of a write. The FSM applies the write once for an ID,
+// a retry with the same ID returns the result of the first write instead of applying it again, as long as the first
+// write is among the recent writes that are remembered. The ID must be unique, such as a UUID, a write that reuses
+// the ID of a different write fails with ErrorCodeConflict.
+// It is used by the writes that apply a single command, an empty ID removes the ID of ctx.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey{}, id)
+}
+
+// RequestID returns the request ID carried by ctx, it is empty if there is none.
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDContextKey{}).(string)
+	return id
+}
+
+// requestIDMiddleware puts the request ID of the header into the context of the request.
+func (s *Service) requestIDMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if len(id) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if len(id) > maxRequestIDLength {
+			s.writeError(w, r, NewError(ErrorCodeInvalidRequest, fmt.Sprintf("the request ID cannot be longer than %d bytes", maxRequestIDLength)))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(WithRequestID(r.Context(), id)))
+	})
+}
@@ -0,0 +1,77 @@
+package http
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	// MaxRequestBodySize is the maximum size of the body of a request, except the streams of an import and a restore,
+	// which are applied in chunks.
+	MaxRequestBodySize = 4 << 20
+	// MaxRulesPerRequest is the maximum number of rules of a write, so that its raft entry stays small.
+	MaxRulesPerRequest = 10000
+)
+
+// requestReadTimeout is the maximum duration of reading the body of a request, except the streams of an import and a restore,
+// which are read as long as the client sends them.
+var requestReadTimeout = 30 * time.Second
+
+// streamPaths are the paths whose bodies are streams, their size is not limited.
+var streamPaths = map[string]bool{
+	"/policies/import": true,
+	"/restore":         true,
+}
+
+// bodyLimitMiddleware limits the size of the body of a request to MaxRequestBodySize and the duration of reading it to requestReadTimeout,
+// reading a larger body fails with the too_large code and reading a slower body fails with the invalid_request code.
+// The server has no read timeout, it would cut off the streams.
+func (s *Service) bodyLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamPaths[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.ContentLength > MaxRequestBodySize {
+			s.writeError(w, r, errBodyTooLarge)
+			return
+		}
+		r.Body = &deadlineReader{
+			ReadCloser: http.MaxBytesReader(w, r.Body, MaxRequestBodySize),
+			deadline:   time.Now().Add(requestReadTimeout),
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// errBodyTooLarge is the error of a body larger than MaxRequestBodySize.
+var errBodyTooLarge = NewError(ErrorCodeTooLarge, fmt.Sprintf("the request body cannot be larger than %d bytes", MaxRequestBodySize))
+
+// deadlineReader fails the reads of a body after the deadline, and returns errBodyTooLarge when the body is read
+// over MaxRequestBodySize.
+type deadlineReader struct {
+	io.ReadCloser
+	deadline time.Time
+}
+
+func (r *deadlineReader) Read(p []byte) (int, error) {
+	if time.Now().After(r.deadline) {
+		return 0, NewError(ErrorCodeInvalidRequest, "the request body is not read in time")
+	}
+	n, err := r.ReadCloser.Read(p)
+	if _, ok := err.(*http.MaxBytesError); ok {
+		return n, errBodyTooLarge
+	}
+	return n, err
+}
+
+// bodyError returns the error of reading the body of a request, the errors of deadlineReader keep their code and
+// the other errors are invalid requests.
+func bodyError(err error) error {
+	if asError(err) != nil {
+		return err
+	}
+	return NewError(ErrorCodeInvalidRequest, err.Error())
+}
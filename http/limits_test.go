@@ -0,0 +1,139 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/nodece/casbin-hraft-dispatcher/http/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBodyLimit(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	body := `{"sec": "p", "pType": "p", "rules": [{"items": ["` + strings.Repeat("a", MaxRequestBodySize) + `"]}]}`
+
+	// A body with a larger length is rejected before it is read.
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/add", s.Addr()), bytes.NewBufferString(body))
+	assert.NoError(t, err)
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	e := ResponseError(resp)
+	resp.Body.Close()
+	assert.Equal(t, ErrTooLarge, errors.Cause(e))
+	assert.Equal(t, fmt.Sprintf("the request body cannot be larger than %d bytes", MaxRequestBodySize), e.Message)
+
+	// A body without a length fails when it is read over the limit.
+	store.EXPECT().Leader().Return(true, s.Addr())
+	r, err = http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/add", s.Addr()), io.MultiReader(strings.NewReader(body)))
+	assert.NoError(t, err)
+	resp, err = ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+	e = ResponseError(resp)
+	resp.Body.Close()
+	assert.Equal(t, ErrTooLarge, errors.Cause(e))
+	assert.Equal(t, fmt.Sprintf("the request body cannot be larger than %d bytes", MaxRequestBodySize), e.Message)
+}
+
+// slowReader returns its data after a delay.
+type slowReader struct {
+	delay time.Duration
+	r     io.Reader
+}
+
+func (r *slowReader) Read(p []byte) (int, error) {
+	time.Sleep(r.delay)
+	return r.r.Read(p)
+}
+
+func TestReadTimeout(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	timeout := requestReadTimeout
+	requestReadTimeout = 100 * time.Millisecond
+	defer func() { requestReadTimeout = timeout }()
+
+	// HTTP/1.1 is used, its connection has no read deadline either.
+	tlsConfig := ts.Client().Transport.(*http.Transport).TLSClientConfig.Clone()
+	tlsConfig.NextProtos = []string{"http/1.1"}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig}}
+
+	store.EXPECT().Leader().Return(true, s.Addr()).AnyTimes()
+
+	// A slow body is not read after the deadline.
+	body := `{"sec": "p", "pType": "p", "rules": [{"items": ["alice", "data1", "read"]}]}`
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/add", s.Addr()), &slowReader{delay: 200 * time.Millisecond, r: strings.NewReader(body)})
+	assert.NoError(t, err)
+	resp, err := client.Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	e := ResponseError(resp)
+	resp.Body.Close()
+	assert.Equal(t, ErrInvalidRequest, errors.Cause(e))
+	assert.Equal(t, "the request body is not read in time", e.Message)
+
+	// A slow stream is read until its end.
+	store.EXPECT().ImportPolicies(gomock.Any(), gomock.Any()).Return(nil)
+	r, err = http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/import?format=csv", s.Addr()), &slowReader{delay: 200 * time.Millisecond, r: strings.NewReader("p, alice, data1, read\n")})
+	assert.NoError(t, err)
+	resp, err = client.Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Contains(t, string(b), `"done":true`)
+
+	store.EXPECT().Restore(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, r io.Reader) error {
+		_, err := ioutil.ReadAll(r)
+		return err
+	})
+	r, err = http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/restore", s.Addr()), &slowReader{delay: 200 * time.Millisecond, r: strings.NewReader("backup")})
+	assert.NoError(t, err)
+	resp, err = client.Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	resp.Body.Close()
+}
@@ -5,8 +5,10 @@
 package mocks
 
 import (
+	context "context"
 	gomock "github.com/golang/mock/gomock"
 	command "github.com/nodece/casbin-hraft-dispatcher/command"
+	io "io"
 	reflect "reflect"
 )
 
@@ -34,115 +36,434 @@ func (m *MockStore) EXPECT() *MockStoreMockRecorder {
 }
 
 // AddPolicies mocks base method
-func (m *MockStore) AddPolicies(request *command.AddPoliciesRequest) error {
+func (m *MockStore) AddPolicies(ctx context.Context, request *command.AddPoliciesRequest) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "AddPolicies", request)
+	ret := m.ctrl.Call(m, "AddPolicies", ctx, request)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // AddPolicies indicates an expected call of AddPolicies
-func (mr *MockStoreMockRecorder) AddPolicies(request interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) AddPolicies(ctx, request interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddPolicies", reflect.TypeOf((*MockStore)(nil).AddPolicies), request)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AddPolicies", reflect.TypeOf((*MockStore)(nil).AddPolicies), ctx, request)
 }
 
 // RemovePolicies mocks base method
-func (m *MockStore) RemovePolicies(request *command.RemovePoliciesRequest) error {
+func (m *MockStore) RemovePolicies(ctx context.Context, request *command.RemovePoliciesRequest) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RemovePolicies", request)
+	ret := m.ctrl.Call(m, "RemovePolicies", ctx, request)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RemovePolicies indicates an expected call of RemovePolicies
-func (mr *MockStoreMockRecorder) RemovePolicies(request interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) RemovePolicies(ctx, request interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemovePolicies", reflect.TypeOf((*MockStore)(nil).RemovePolicies), request)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemovePolicies", reflect.TypeOf((*MockStore)(nil).RemovePolicies), ctx, request)
 }
 
 // RemoveFilteredPolicy mocks base method
-func (m *MockStore) RemoveFilteredPolicy(request *command.RemoveFilteredPolicyRequest) error {
+func (m *MockStore) RemoveFilteredPolicy(ctx context.Context, request *command.RemoveFilteredPolicyRequest) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RemoveFilteredPolicy", request)
+	ret := m.ctrl.Call(m, "RemoveFilteredPolicy", ctx, request)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RemoveFilteredPolicy indicates an expected call of RemoveFilteredPolicy
-func (mr *MockStoreMockRecorder) RemoveFilteredPolicy(request interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) RemoveFilteredPolicy(ctx, request interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFilteredPolicy", reflect.TypeOf((*MockStore)(nil).RemoveFilteredPolicy), request)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveFilteredPolicy", reflect.TypeOf((*MockStore)(nil).RemoveFilteredPolicy), ctx, request)
 }
 
 // UpdatePolicy mocks base method
-func (m *MockStore) UpdatePolicy(request *command.UpdatePolicyRequest) error {
+func (m *MockStore) UpdatePolicy(ctx context.Context, request *command.UpdatePolicyRequest) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdatePolicy", request)
+	ret := m.ctrl.Call(m, "UpdatePolicy", ctx, request)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdatePolicy indicates an expected call of UpdatePolicy
-func (mr *MockStoreMockRecorder) UpdatePolicy(request interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) UpdatePolicy(ctx, request interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePolicy", reflect.TypeOf((*MockStore)(nil).UpdatePolicy), request)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePolicy", reflect.TypeOf((*MockStore)(nil).UpdatePolicy), ctx, request)
 }
 
 // UpdatePolicies mocks base method
-func (m *MockStore) UpdatePolicies(request *command.UpdatePoliciesRequest) error {
+func (m *MockStore) UpdatePolicies(ctx context.Context, request *command.UpdatePoliciesRequest) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "UpdatePolicies", request)
+	ret := m.ctrl.Call(m, "UpdatePolicies", ctx, request)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // UpdatePolicies indicates an expected call of UpdatePolicies
-func (mr *MockStoreMockRecorder) UpdatePolicies(request interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) UpdatePolicies(ctx, request interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePolicies", reflect.TypeOf((*MockStore)(nil).UpdatePolicies), request)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdatePolicies", reflect.TypeOf((*MockStore)(nil).UpdatePolicies), ctx, request)
+}
+
+// MovePolicy mocks base method
+func (m *MockStore) MovePolicy(ctx context.Context, request *command.MovePolicyRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "MovePolicy", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// MovePolicy indicates an expected call of MovePolicy
+func (mr *MockStoreMockRecorder) MovePolicy(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "MovePolicy", reflect.TypeOf((*MockStore)(nil).MovePolicy), ctx, request)
 }
 
 // ClearPolicy mocks base method
-func (m *MockStore) ClearPolicy() error {
+func (m *MockStore) ClearPolicy(ctx context.Context) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ClearPolicy")
+	ret := m.ctrl.Call(m, "ClearPolicy", ctx)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // ClearPolicy indicates an expected call of ClearPolicy
-func (mr *MockStoreMockRecorder) ClearPolicy() *gomock.Call {
+func (mr *MockStoreMockRecorder) ClearPolicy(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearPolicy", reflect.TypeOf((*MockStore)(nil).ClearPolicy), ctx)
+}
+
+// ApplyBatch mocks base method
+func (m *MockStore) ApplyBatch(ctx context.Context, request *command.BatchRequest) []error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ApplyBatch", ctx, request)
+	ret0, _ := ret[0].([]error)
+	return ret0
+}
+
+// ApplyBatch indicates an expected call of ApplyBatch
+func (mr *MockStoreMockRecorder) ApplyBatch(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ApplyBatch", reflect.TypeOf((*MockStore)(nil).ApplyBatch), ctx, request)
+}
+
+// SetModel mocks base method
+func (m *MockStore) SetModel(ctx context.Context, request *command.SetModelRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "SetModel", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// SetModel indicates an expected call of SetModel
+func (mr *MockStoreMockRecorder) SetModel(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "SetModel", reflect.TypeOf((*MockStore)(nil).SetModel), ctx, request)
+}
+
+// Model mocks base method
+func (m *MockStore) Model() (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Model")
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Model indicates an expected call of Model
+func (mr *MockStoreMockRecorder) Model() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Model", reflect.TypeOf((*MockStore)(nil).Model))
+}
+
+// AssignRole mocks base method
+func (m *MockStore) AssignRole(ctx context.Context, request *command.AssignRoleRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "AssignRole", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// AssignRole indicates an expected call of AssignRole
+func (mr *MockStoreMockRecorder) AssignRole(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "AssignRole", reflect.TypeOf((*MockStore)(nil).AssignRole), ctx, request)
+}
+
+// UnassignRole mocks base method
+func (m *MockStore) UnassignRole(ctx context.Context, request *command.UnassignRoleRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "UnassignRole", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// UnassignRole indicates an expected call of UnassignRole
+func (mr *MockStoreMockRecorder) UnassignRole(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UnassignRole", reflect.TypeOf((*MockStore)(nil).UnassignRole), ctx, request)
+}
+
+// DeleteRole mocks base method
+func (m *MockStore) DeleteRole(ctx context.Context, request *command.DeleteRoleRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteRole", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteRole indicates an expected call of DeleteRole
+func (mr *MockStoreMockRecorder) DeleteRole(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteRole", reflect.TypeOf((*MockStore)(nil).DeleteRole), ctx, request)
+}
+
+// GetUsersForRole mocks base method
+func (m *MockStore) GetUsersForRole(role string, domain ...string) ([]string, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{role}
+	for _, a := range domain {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetUsersForRole", varargs...)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetUsersForRole indicates an expected call of GetUsersForRole
+func (mr *MockStoreMockRecorder) GetUsersForRole(role interface{}, domain ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{role}, domain...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetUsersForRole", reflect.TypeOf((*MockStore)(nil).GetUsersForRole), varargs...)
+}
+
+// GetRolesForUser mocks base method
+func (m *MockStore) GetRolesForUser(user string, domain ...string) ([]string, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{user}
+	for _, a := range domain {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetRolesForUser", varargs...)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetRolesForUser indicates an expected call of GetRolesForUser
+func (mr *MockStoreMockRecorder) GetRolesForUser(user interface{}, domain ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{user}, domain...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetRolesForUser", reflect.TypeOf((*MockStore)(nil).GetRolesForUser), varargs...)
+}
+
+// GetImplicitRolesForUser mocks base method
+func (m *MockStore) GetImplicitRolesForUser(user string, domain ...string) ([]string, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{user}
+	for _, a := range domain {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "GetImplicitRolesForUser", varargs...)
+	ret0, _ := ret[0].([]string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetImplicitRolesForUser indicates an expected call of GetImplicitRolesForUser
+func (mr *MockStoreMockRecorder) GetImplicitRolesForUser(user interface{}, domain ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{user}, domain...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetImplicitRolesForUser", reflect.TypeOf((*MockStore)(nil).GetImplicitRolesForUser), varargs...)
+}
+
+// Enforce mocks base method
+func (m *MockStore) Enforce(rvals ...interface{}) (bool, error) {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{}
+	for _, a := range rvals {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Enforce", varargs...)
+	ret0, _ := ret[0].(bool)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Enforce indicates an expected call of Enforce
+func (mr *MockStoreMockRecorder) Enforce(rvals ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Enforce", reflect.TypeOf((*MockStore)(nil).Enforce), rvals...)
+}
+
+// CreateNamespace mocks base method
+func (m *MockStore) CreateNamespace(ctx context.Context, request *command.CreateNamespaceRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateNamespace", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CreateNamespace indicates an expected call of CreateNamespace
+func (mr *MockStoreMockRecorder) CreateNamespace(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateNamespace", reflect.TypeOf((*MockStore)(nil).CreateNamespace), ctx, request)
+}
+
+// DeleteNamespace mocks base method
+func (m *MockStore) DeleteNamespace(ctx context.Context, request *command.DeleteNamespaceRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteNamespace", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteNamespace indicates an expected call of DeleteNamespace
+func (mr *MockStoreMockRecorder) DeleteNamespace(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteNamespace", reflect.TypeOf((*MockStore)(nil).DeleteNamespace), ctx, request)
+}
+
+// FreezeNamespace mocks base method
+func (m *MockStore) FreezeNamespace(ctx context.Context, request *command.FreezeNamespaceRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "FreezeNamespace", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// FreezeNamespace indicates an expected call of FreezeNamespace
+func (mr *MockStoreMockRecorder) FreezeNamespace(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "FreezeNamespace", reflect.TypeOf((*MockStore)(nil).FreezeNamespace), ctx, request)
+}
+
+// Namespaces mocks base method
+func (m *MockStore) Namespaces() []string {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Namespaces")
+	ret0, _ := ret[0].([]string)
+	return ret0
+}
+
+// Namespaces indicates an expected call of Namespaces
+func (mr *MockStoreMockRecorder) Namespaces() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Namespaces", reflect.TypeOf((*MockStore)(nil).Namespaces))
+}
+
+// Export mocks base method
+func (m *MockStore) Export() (*command.NamespaceExport, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Export")
+	ret0, _ := ret[0].(*command.NamespaceExport)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Export indicates an expected call of Export
+func (mr *MockStoreMockRecorder) Export() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Export", reflect.TypeOf((*MockStore)(nil).Export))
+}
+
+// ImportPolicies mocks base method
+func (m *MockStore) ImportPolicies(ctx context.Context, request *command.ImportPoliciesRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ImportPolicies", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ImportPolicies indicates an expected call of ImportPolicies
+func (mr *MockStoreMockRecorder) ImportPolicies(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ImportPolicies", reflect.TypeOf((*MockStore)(nil).ImportPolicies), ctx, request)
+}
+
+// CommitImport mocks base method
+func (m *MockStore) CommitImport(ctx context.Context, request *command.CommitImportRequest) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CommitImport", ctx, request)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// CommitImport indicates an expected call of CommitImport
+func (mr *MockStoreMockRecorder) CommitImport(ctx, request interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CommitImport", reflect.TypeOf((*MockStore)(nil).CommitImport), ctx, request)
+}
+
+// Backup mocks base method
+func (m *MockStore) Backup(w io.Writer) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Backup", w)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Backup indicates an expected call of Backup
+func (mr *MockStoreMockRecorder) Backup(w interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearPolicy", reflect.TypeOf((*MockStore)(nil).ClearPolicy))
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Backup", reflect.TypeOf((*MockStore)(nil).Backup), w)
+}
+
+// Restore mocks base method
+func (m *MockStore) Restore(ctx context.Context, r io.Reader) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Restore", ctx, r)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Restore indicates an expected call of Restore
+func (mr *MockStoreMockRecorder) Restore(ctx, r interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Restore", reflect.TypeOf((*MockStore)(nil).Restore), ctx, r)
 }
 
 // JoinNode mocks base method
-func (m *MockStore) JoinNode(serverID, address string) error {
+func (m *MockStore) JoinNode(ctx context.Context, serverID, address string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "JoinNode", serverID, address)
+	ret := m.ctrl.Call(m, "JoinNode", ctx, serverID, address)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // JoinNode indicates an expected call of JoinNode
-func (mr *MockStoreMockRecorder) JoinNode(serverID, address interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) JoinNode(ctx, serverID, address interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "JoinNode", reflect.TypeOf((*MockStore)(nil).JoinNode), serverID, address)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "JoinNode", reflect.TypeOf((*MockStore)(nil).JoinNode), ctx, serverID, address)
 }
 
 // RemoveNode mocks base method
-func (m *MockStore) RemoveNode(serverID string) error {
+func (m *MockStore) RemoveNode(ctx context.Context, serverID string) error {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "RemoveNode", serverID)
+	ret := m.ctrl.Call(m, "RemoveNode", ctx, serverID)
 	ret0, _ := ret[0].(error)
 	return ret0
 }
 
 // RemoveNode indicates an expected call of RemoveNode
-func (mr *MockStoreMockRecorder) RemoveNode(serverID interface{}) *gomock.Call {
+func (mr *MockStoreMockRecorder) RemoveNode(ctx, serverID interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveNode", reflect.TypeOf((*MockStore)(nil).RemoveNode), ctx, serverID)
+}
+
+// TransferLeadership mocks base method
+func (m *MockStore) TransferLeadership(ctx context.Context, serverID string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "TransferLeadership", ctx, serverID)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// TransferLeadership indicates an expected call of TransferLeadership
+func (mr *MockStoreMockRecorder) TransferLeadership(ctx, serverID interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "RemoveNode", reflect.TypeOf((*MockStore)(nil).RemoveNode), serverID)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "TransferLeadership", reflect.TypeOf((*MockStore)(nil).TransferLeadership), ctx, serverID)
 }
 
 // Leader mocks base method
@@ -159,3 +480,31 @@ func (mr *MockStoreMockRecorder) Leader() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Leader", reflect.TypeOf((*MockStore)(nil).Leader))
 }
+
+// Status mocks base method
+func (m *MockStore) Status() *command.NodeStatus {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Status")
+	ret0, _ := ret[0].(*command.NodeStatus)
+	return ret0
+}
+
+// Status indicates an expected call of Status
+func (mr *MockStoreMockRecorder) Status() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Status", reflect.TypeOf((*MockStore)(nil).Status))
+}
+
+// Repair mocks base method
+func (m *MockStore) Repair(ctx context.Context) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Repair", ctx)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Repair indicates an expected call of Repair
+func (mr *MockStoreMockRecorder) Repair(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Repair", reflect.TypeOf((*MockStore)(nil).Repair), ctx)
+}
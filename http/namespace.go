@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+)
+
+var errNotSharded = NewError(ErrorCodeInvalidRequest, "the store does not have several raft groups")
+
+// NamespacedStore is implemented by a Store that serves several namespaces.
+type NamespacedStore interface {
+	// WithNamespace returns a Store that applies the commands to the given namespace.
+	WithNamespace(namespace string) Store
+}
+
+// ShardedStore is implemented by a Store that spreads the namespaces over several raft groups.
+type ShardedStore interface {
+	// Group returns the Store of a raft group.
+	Group(id int) (Store, error)
+	// RoutingTable returns the raft group of each namespace.
+	RoutingTable() (*command.RoutingTable, error)
+	// SetRoute routes a namespace to a raft group.
+	SetRoute(ctx context.Context, request *command.SetRouteRequest) error
+}
+
+// storeContextKey is the context key of the Store that serves a request.
+type storeContextKey struct{}
+
+// routeMiddleware resolves the Store that serves a request by the group and namespace query parameters.
+func (s *Service) routeMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		store, err := s.resolveStore(query.Get("group"), query.Get("namespace"))
+		if err != nil {
+			s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), storeContextKey{}, store)))
+	})
+}
+
+// resolveStore returns the Store of a raft group and a namespace, an empty group means the group is routed by the namespace.
+func (s *Service) resolveStore(group string, namespace string) (Store, error) {
+	store := s.store
+	if len(group) > 0 {
+		sharded, ok := store.(ShardedStore)
+		if !ok {
+			return nil, errNotSharded
+		}
+		id, err := strconv.Atoi(group)
+		if err != nil {
+			return nil, err
+		}
+		store, err = sharded.Group(id)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(namespace) > 0 {
+		if ns, ok := store.(NamespacedStore); ok {
+			store = ns.WithNamespace(namespace)
+		}
+	}
+	return store, nil
+}
+
+// storeOf returns the Store that serves a request.
+func (s *Service) storeOf(r *http.Request) Store {
+	if store, ok := r.Context().Value(storeContextKey{}).(Store); ok {
+		return store
+	}
+	return s.store
+}
@@ -0,0 +1,204 @@
+package http
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// RateLimitConfig limits the rate of the writes that the leader accepts, the writes over the limits are answered
+// with the overloaded code and a Retry-After header instead of being queued.
+// A write is charged one token per rule, so that a request of many rules or a batch of many writes costs as much as
+// the same rules written one by one. The writes without rules, such as a role assignment, cost one token. A write with
+// more rules than the burst takes the whole burst. The cluster management requests, which are the requests to join
+// or remove a node, to repair, to transfer the leadership and to restore a backup, are not limited.
+type RateLimitConfig struct {
+	// Rate is the number of rules per second that are accepted from all clients. Zero means no limit.
+	Rate float64
+	// Burst is the number of rules that are accepted at once over Rate. Zero means Rate, at least one.
+	Burst int
+	// ClientRate is the number of rules per second that are accepted from a client, which is identified by
+	// the IP address of the connection. The dispatcher of a follower sends its writes to the leader itself, so they
+	// are counted for the address of the follower and all the callers of that dispatcher share one client bucket.
+	// The HTTP clients of a follower are redirected to the leader and counted for their own address, except when
+	// a proxy is between them. Zero means no limit.
+	ClientRate float64
+	// ClientBurst is the number of rules of a client that are accepted at once over ClientRate.
+	// Zero means ClientRate, at least one.
+	ClientBurst int
+}
+
+// maxClientBuckets is the maximum number of client buckets. A new client over it first removes the full buckets,
+// then the bucket of the least recently seen client if none is full.
+const maxClientBuckets = 1024
+
+// bucket is a token bucket, it holds up to burst tokens and gains rate tokens per second.
+type bucket struct {
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	// used is the time of the last write checked against the bucket, refill does not change it.
+	used time.Time
+}
+
+// newBucket returns a full bucket.
+func newBucket(rate float64, burst int, now time.Time) *bucket {
+	b := float64(burst)
+	if b <= 0 {
+		b = math.Max(1, rate)
+	}
+	return &bucket{rate: rate, burst: b, tokens: b, last: now, used: now}
+}
+
+// refill adds the tokens gained since the last refill.
+func (b *bucket) refill(now time.Time) {
+	if elapsed := now.Sub(b.last); elapsed > 0 {
+		b.tokens = math.Min(b.burst, b.tokens+elapsed.Seconds()*b.rate)
+		b.last = now
+	}
+}
+
+// cost returns the tokens taken by n rules, which is at most the burst so that any write can be accepted.
+func (b *bucket) cost(n int) float64 {
+	return math.Min(float64(n), b.burst)
+}
+
+// wait returns the time until the bucket has the tokens of n rules, it is zero if they are available.
+func (b *bucket) wait(n int) time.Duration {
+	cost := b.cost(n)
+	if b.tokens >= cost {
+		return 0
+	}
+	return time.Duration((cost - b.tokens) / b.rate * float64(time.Second))
+}
+
+// rateLimiter is a global token bucket and a token bucket of each client.
+type rateLimiter struct {
+	config *RateLimitConfig
+	now    func() time.Time
+
+	l       sync.Mutex
+	global  *bucket
+	clients map[string]*bucket
+}
+
+// newRateLimiter returns a rateLimiter of the config, it is nil if the config has no limit.
+func newRateLimiter(config *RateLimitConfig) *rateLimiter {
+	if config == nil || (config.Rate <= 0 && config.ClientRate <= 0) {
+		return nil
+	}
+	l := &rateLimiter{
+		config:  config,
+		now:     time.Now,
+		clients: make(map[string]*bucket),
+	}
+	if config.Rate > 0 {
+		l.global = newBucket(config.Rate, config.Burst, l.now())
+	}
+	return l
+}
+
+// allow takes the tokens of n rules from the bucket of the client and from the global bucket, it returns the time to
+// wait if either of them does not have them, then no token is taken. n is at least one.
+func (l *rateLimiter) allow(client string, n int) (time.Duration, bool) {
+	if n < 1 {
+		n = 1
+	}
+	l.l.Lock()
+	defer l.l.Unlock()
+
+	now := l.now()
+	var buckets []*bucket
+	if l.global != nil {
+		buckets = append(buckets, l.global)
+	}
+	if l.config.ClientRate > 0 {
+		b, ok := l.clients[client]
+		if !ok {
+			if len(l.clients) >= maxClientBuckets {
+				l.evict(now)
+			}
+			b = newBucket(l.config.ClientRate, l.config.ClientBurst, now)
+			l.clients[client] = b
+		}
+		b.used = now
+		buckets = append(buckets, b)
+	}
+
+	var wait time.Duration
+	for _, b := range buckets {
+		b.refill(now)
+		if w := b.wait(n); w > wait {
+			wait = w
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+	for _, b := range buckets {
+		b.tokens -= b.cost(n)
+	}
+	return 0, true
+}
+
+// evict removes the buckets of the clients that are full, they are the same as new buckets. If none is full, it
+// removes the bucket of the least recently seen client, which then starts again with a full bucket, so that the
+// buckets of many clients cannot grow without limit.
+func (l *rateLimiter) evict(now time.Time) {
+	var oldest string
+	var oldestUsed time.Time
+	for client, b := range l.clients {
+		b.refill(now)
+		if b.tokens >= b.burst {
+			delete(l.clients, client)
+			continue
+		}
+		if oldest == "" || b.used.Before(oldestUsed) {
+			oldest, oldestUsed = client, b.used
+		}
+	}
+	if len(l.clients) >= maxClientBuckets {
+		delete(l.clients, oldest)
+	}
+}
+
+// SetRateLimit limits the rate of the writes that are accepted by the leader, nil removes the limits.
+// It must be called before Start.
+func (s *Service) SetRateLimit(config *RateLimitConfig) {
+	s.rateLimiter = newRateLimiter(config)
+}
+
+// rateLimitMiddleware checks the writes without rules against the rate limits, the writes of rules are checked by
+// their handlers once the rules are decoded, see rateLimit.
+func (s *Service) rateLimitMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.rateLimit(w, r, 1) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// rateLimit answers the writes of n rules over the rate limits with the overloaded code, it reports whether the write
+// can be applied.
+func (s *Service) rateLimit(w http.ResponseWriter, r *http.Request, n int) bool {
+	if s.rateLimiter == nil {
+		return true
+	}
+	client, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		client = r.RemoteAddr
+	}
+	wait, ok := s.rateLimiter.allow(client, n)
+	if !ok {
+		err := NewError(ErrorCodeOverloaded, fmt.Sprintf("too many writes, retry after %s", wait.Round(time.Millisecond)))
+		err.RetryAfter = wait
+		s.writeError(w, r, err)
+		return false
+	}
+	return true
+}
@@ -0,0 +1,187 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiter(t *testing.T) {
+	assert.Nil(t, newRateLimiter(nil))
+	assert.Nil(t, newRateLimiter(&RateLimitConfig{Burst: 10}))
+
+	now := time.Unix(0, 0)
+	l := newRateLimiter(&RateLimitConfig{Rate: 10, Burst: 3, ClientRate: 1})
+	l.now = func() time.Time { return now }
+	l.global = newBucket(10, 3, now)
+
+	// a client gets one write per second, and the clients share three writes at once.
+	_, ok := l.allow("alice", 1)
+	assert.True(t, ok)
+	wait, ok := l.allow("alice", 1)
+	assert.False(t, ok)
+	assert.Equal(t, time.Second, wait)
+	_, ok = l.allow("bob", 1)
+	assert.True(t, ok)
+	_, ok = l.allow("carol", 1)
+	assert.True(t, ok)
+	wait, ok = l.allow("dave", 1)
+	assert.False(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+
+	// a write that is not allowed takes no token.
+	now = now.Add(100 * time.Millisecond)
+	_, ok = l.allow("dave", 1)
+	assert.True(t, ok)
+
+	now = now.Add(time.Second)
+	_, ok = l.allow("alice", 1)
+	assert.True(t, ok)
+
+	// a write is charged one token per rule, and at most the burst.
+	now = now.Add(time.Hour)
+	l = newRateLimiter(&RateLimitConfig{Rate: 10, Burst: 4})
+	l.now = func() time.Time { return now }
+	l.global = newBucket(10, 4, now)
+	_, ok = l.allow("alice", 3)
+	assert.True(t, ok)
+	wait, ok = l.allow("alice", 2)
+	assert.False(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+	now = now.Add(time.Second)
+	_, ok = l.allow("alice", 100)
+	assert.True(t, ok)
+	wait, ok = l.allow("alice", 1)
+	assert.False(t, ok)
+	assert.Equal(t, 100*time.Millisecond, wait)
+
+	// the buckets of the clients are capped even if none of them is full, the least recently seen client is removed.
+	l = newRateLimiter(&RateLimitConfig{ClientRate: 0.001, ClientBurst: 2})
+	l.now = func() time.Time { return now }
+	for i := 0; i < maxClientBuckets+2; i++ {
+		now = now.Add(time.Millisecond)
+		_, ok = l.allow(fmt.Sprintf("client-%d", i), 1)
+		assert.True(t, ok)
+		if i == maxClientBuckets-1 {
+			_, ok = l.allow("client-0", 1)
+			assert.True(t, ok)
+		}
+		assert.LessOrEqual(t, len(l.clients), maxClientBuckets)
+	}
+	assert.Contains(t, l.clients, "client-0")
+	assert.NotContains(t, l.clients, "client-1")
+	assert.NotContains(t, l.clients, "client-2")
+	assert.Contains(t, l.clients, "client-3")
+}
+
+func TestRateLimit(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+	s.SetRateLimit(&RateLimitConfig{ClientRate: 0.5})
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	request := &command.AddPoliciesRequest{
+		Sec:   "p",
+		PType: "p",
+		Rules: []*command.StringArray{{Items: []string{"alice", "/", "GET"}}},
+	}
+	store.EXPECT().Leader().Return(true, s.Addr()).Times(2)
+	store.EXPECT().AddPolicies(gomock.Any(), request).Return(nil)
+
+	err = s.DoAddPolicyRequest(request)
+	assert.NoError(t, err)
+
+	b, err := jsoniter.Marshal(request)
+	assert.NoError(t, err)
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/add", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.Equal(t, "2", resp.Header.Get("Retry-After"))
+
+	e := ResponseError(resp)
+	assert.Equal(t, ErrOverloaded, errors.Cause(e))
+	assert.Equal(t, 2*time.Second, e.RetryAfter)
+
+	// the reads and the cluster management requests are not limited.
+	store.EXPECT().Status().Return(&command.NodeStatus{Id: "node1"})
+	_, err = s.DoStatusRequest()
+	assert.NoError(t, err)
+	store.EXPECT().Leader().Return(true, s.Addr())
+	store.EXPECT().JoinNode(gomock.Any(), "node2", "127.0.0.1:6800").Return(nil)
+	err = s.DoJoinNodeRequest(&command.AddNodeRequest{Id: "node2", Address: "127.0.0.1:6800"})
+	assert.NoError(t, err)
+
+	// a batch is charged the rules of its writes.
+	s, err = NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+	s.SetRateLimit(&RateLimitConfig{ClientRate: 0.5, ClientBurst: 2})
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	store.EXPECT().Leader().Return(true, s.Addr()).Times(2)
+	store.EXPECT().ApplyBatch(gomock.Any(), gomock.Any()).Return([]error{nil, nil})
+	items := []*command.BatchItem{{AddPolicies: request}, {RemovePolicies: &command.RemovePoliciesRequest{Sec: "p", PType: "p", Rules: request.Rules}}}
+	_, err = s.DoBatchRequest(&command.BatchRequest{Items: items})
+	assert.NoError(t, err)
+	_, err = s.DoBatchRequest(&command.BatchRequest{Items: items[:1]})
+	assert.Equal(t, ErrOverloaded, errors.Cause(err))
+
+	// the writes of the dispatcher of a follower are sent to the leader by the follower, so the callers of that
+	// dispatcher share the bucket of the follower.
+	s, err = NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+	s.SetRateLimit(&RateLimitConfig{ClientRate: 0.5})
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+	leaderAddr, err := net.ResolveTCPAddr("tcp", s.Addr())
+	assert.NoError(t, err)
+
+	followerStore := mocks.NewMockStore(ctl)
+	follower, err := NewService("127.0.0.1:0", ts.TLS, followerStore)
+	assert.NoError(t, err)
+	follower.httpClient = ts.Client()
+	err = follower.Start()
+	assert.NoError(t, err)
+	defer follower.Stop(context.Background())
+
+	followerStore.EXPECT().Leader().Return(false, fmt.Sprintf("127.0.0.1:%d", leaderAddr.Port-1)).Times(2)
+	store.EXPECT().Leader().Return(true, s.Addr()).Times(2)
+	store.EXPECT().AddPolicies(gomock.Any(), request).Return(nil)
+	err = follower.WithContext(WithRequestID(context.Background(), "alice")).DoAddPolicyRequest(request)
+	assert.NoError(t, err)
+	err = follower.WithContext(WithRequestID(context.Background(), "bob")).DoAddPolicyRequest(request)
+	assert.Equal(t, ErrOverloaded, errors.Cause(err))
+}
@@ -5,13 +5,14 @@ import (
 	"context"
 	"crypto/tls"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"net/url"
+	"strconv"
 	"time"
 
-	"github.com/hashicorp/go-multierror"
-
 	jsoniter "github.com/json-iterator/go"
 	"github.com/pkg/errors"
 	"golang.org/x/net/http2"
@@ -26,34 +27,94 @@ import (
 //go:generate mockgen -destination ./mocks/mock_store.go -package mocks -source service.go
 
 // Store provides an interface that can be implemented by raft.
+// The methods that change the cluster wait until they are applied or ctx is done.
 type Store interface {
 	// AddPolicies adds a set of rules to the current policy.
-	AddPolicies(request *command.AddPoliciesRequest) error
+	AddPolicies(ctx context.Context, request *command.AddPoliciesRequest) error
 	// RemovePolicies removes a set of rules from the current policy.
-	RemovePolicies(request *command.RemovePoliciesRequest) error
+	RemovePolicies(ctx context.Context, request *command.RemovePoliciesRequest) error
 	// RemoveFilteredPolicy removes a set of rules that match a pattern from the current policy.
-	RemoveFilteredPolicy(request *command.RemoveFilteredPolicyRequest) error
+	RemoveFilteredPolicy(ctx context.Context, request *command.RemoveFilteredPolicyRequest) error
 	// UpdatePolicy updates a rule of policy.
-	UpdatePolicy(request *command.UpdatePolicyRequest) error
+	UpdatePolicy(ctx context.Context, request *command.UpdatePolicyRequest) error
 	// UpdatePolicies updates a set of rules of policy.
-	UpdatePolicies(request *command.UpdatePoliciesRequest) error
+	UpdatePolicies(ctx context.Context, request *command.UpdatePoliciesRequest) error
+	// MovePolicy moves a rule to the given position among the rules of the same sec and pType.
+	MovePolicy(ctx context.Context, request *command.MovePolicyRequest) error
 	// ClearPolicy clears all policies.
-	ClearPolicy() error
+	ClearPolicy(ctx context.Context) error
+	// ApplyBatch applies the writes of a batch, each write is applied on its own and its error is returned at its index.
+	ApplyBatch(ctx context.Context, request *command.BatchRequest) []error
+	// SetModel replaces the model of all nodes.
+	SetModel(ctx context.Context, request *command.SetModelRequest) error
+	// Model returns the replicated model text, it is empty if no model has been replicated.
+	Model() (string, error)
+
+	// AssignRole assigns a role to a user.
+	AssignRole(ctx context.Context, request *command.AssignRoleRequest) error
+	// UnassignRole unassigns a role from a user.
+	UnassignRole(ctx context.Context, request *command.UnassignRoleRequest) error
+	// DeleteRole deletes a role with its grouping rules and policy rules.
+	DeleteRole(ctx context.Context, request *command.DeleteRoleRequest) error
+	// GetUsersForRole returns the users that have a role.
+	GetUsersForRole(role string, domain ...string) ([]string, error)
+	// GetRolesForUser returns the roles that a user has directly.
+	GetRolesForUser(user string, domain ...string) ([]string, error)
+	// GetImplicitRolesForUser returns the roles that a user has directly or through role inheritance.
+	GetImplicitRolesForUser(user string, domain ...string) ([]string, error)
+
+	// Enforce decides whether a subject can access an object.
+	Enforce(rvals ...interface{}) (bool, error)
+
+	// CreateNamespace creates a namespace with its own model and policies.
+	CreateNamespace(ctx context.Context, request *command.CreateNamespaceRequest) error
+	// DeleteNamespace deletes a namespace with its model and policies.
+	DeleteNamespace(ctx context.Context, request *command.DeleteNamespaceRequest) error
+	// FreezeNamespace freezes or unfreezes a namespace, the writes to a frozen namespace are rejected.
+	FreezeNamespace(ctx context.Context, request *command.FreezeNamespaceRequest) error
+	// Namespaces returns the names of the namespaces.
+	Namespaces() []string
+	// Export returns the model and the rules of the namespace.
+	Export() (*command.NamespaceExport, error)
+	// ImportPolicies imports a chunk of rules, the chunk is staged if the request has an import ID.
+	ImportPolicies(ctx context.Context, request *command.ImportPoliciesRequest) error
+	// CommitImport replaces all rules with the staged rules of an import, or discards them.
+	CommitImport(ctx context.Context, request *command.CommitImportRequest) error
+	// Backup takes a snapshot of the current node and writes it with its metadata.
+	Backup(w io.Writer) error
+	// Restore replaces the state of the cluster with a backup, the current node must be the leader.
+	Restore(ctx context.Context, r io.Reader) error
 
 	// JoinNode joins a node with a given serverID and network address to cluster.
-	JoinNode(serverID string, address string) error
+	JoinNode(ctx context.Context, serverID string, address string) error
 	// RemoveNode removes a node with a given serverID from cluster.
-	RemoveNode(serverID string) error
+	RemoveNode(ctx context.Context, serverID string) error
+	// TransferLeadership transfers the leadership to a node with a given serverID, empty means any follower.
+	TransferLeadership(ctx context.Context, serverID string) error
 	// Leader checks if it is a leader and returns network address.
 	Leader() (bool, string)
+	// Status returns the status of the current node.
+	Status() *command.NodeStatus
+	// Repair forces the followers to install a snapshot of the leader.
+	Repair(ctx context.Context) error
 }
 
 // Service setups a HTTP service for forward data of raft node.
 type Service struct {
-	srv        *http.Server
-	ln         net.Listener
+	srv *http.Server
+	ln  net.Listener
+	// address is the node that the requests are sent to, when the Service is a client, see NewClient.
+	address    string
 	store      Store
 	httpClient *http.Client
+	// namespace is the namespace that the requests of this Service are sent to.
+	namespace string
+	// group is the raft group that the requests of this Service are sent to, -1 means the group is routed by the namespace.
+	group int
+	// ctx is the context of the requests of this Service, nil means context.Background().
+	ctx context.Context
+	// rateLimiter limits the writes served by this Service, it is nil if there is no limit.
+	rateLimiter *rateLimiter
 
 	logger *zap.Logger
 }
@@ -75,24 +136,60 @@ func NewService(address string, tlsConfig *tls.Config, store Store) (*Service, e
 		logger:     zap.NewExample(),
 		store:      store,
 		httpClient: httpClient,
+		group:      -1,
 	}
 
 	r := chi.NewRouter()
-	r.With(s.leaderMiddleware).Route("/policies", func(r chi.Router) {
-		r.Put("/add", s.handleAddPolicy)
-		r.Put("/update", s.handleUpdatePolicy)
-		r.Put("/remove", s.handleRemovePolicy)
+	r.Use(s.routeMiddleware)
+	r.Use(s.requestIDMiddleware)
+	r.Use(s.bodyLimitMiddleware)
+	// The writes of rules are checked against the rate limits by their handlers, the cluster management
+	// requests are not limited.
+	r.Route("/policies", func(r chi.Router) {
+		r.With(s.leaderMiddleware).Put("/add", s.handleAddPolicy)
+		r.With(s.leaderMiddleware).Put("/update", s.handleUpdatePolicy)
+		r.With(s.leaderMiddleware).Put("/remove", s.handleRemovePolicy)
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/move", s.handleMovePolicy)
+		r.With(s.leaderMiddleware).Put("/batch", s.handleBatch)
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/import", s.handleImport)
+		r.Get("/export", s.handleExport)
 	})
 	r.With(s.leaderMiddleware).Route("/nodes", func(r chi.Router) {
 		r.Put("/join", s.handleJoinNode)
 		r.Put("/remove", s.handleRemoveNode)
+		r.Put("/repair", s.handleRepair)
+		r.Put("/transfer", s.handleTransferLeadership)
+	})
+	r.Route("/roles", func(r chi.Router) {
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/assign", s.handleAssignRole)
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/unassign", s.handleUnassignRole)
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/delete", s.handleDeleteRole)
+		r.Get("/users", s.handleGetUsersForRole)
+	})
+	r.Get("/users/roles", s.handleGetRolesForUser)
+	r.Post("/enforce", s.handleEnforce)
+	r.Route("/namespaces", func(r chi.Router) {
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/create", s.handleCreateNamespace)
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/delete", s.handleDeleteNamespace)
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/freeze", s.handleFreezeNamespace)
+		r.With(s.leaderMiddleware).Get("/export", s.handleExportNamespace)
+		r.Get("/", s.handleNamespaces)
+	})
+	r.Route("/routes", func(r chi.Router) {
+		r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/", s.handleSetRoute)
+		r.Get("/", s.handleRoutingTable)
 	})
+	r.With(s.leaderMiddleware, s.rateLimitMiddleware).Put("/model", s.handleSetModel)
+	r.Get("/model", s.handleGetModel)
+	r.Get("/status", s.handleStatus)
+	r.Get("/backup", s.handleBackup)
+	r.With(s.leaderMiddleware).Put("/restore", s.handleRestore)
 
 	s.srv = &http.Server{
-		Addr:              address,
-		Handler:           r,
+		Addr:    address,
+		Handler: r,
+		// There is no read timeout, the bodies are read with a deadline by bodyLimitMiddleware, except the streams.
 		ReadHeaderTimeout: 10 * time.Second,
-		ReadTimeout:       30 * time.Second,
 		IdleTimeout:       5 * time.Minute,
 		TLSConfig:         tlsConfig,
 	}
@@ -100,21 +197,38 @@ func NewService(address string, tlsConfig *tls.Config, store Store) (*Service, e
 	return s, nil
 }
 
+// NewClient returns a Service that sends the requests to the node of the given HTTP address,
+// it does not serve requests, so only the Do*Request methods can be used.
+// The logger is a no-op one, only the handlers log and they do not run on a client.
+func NewClient(address string, tlsConfig *tls.Config) *Service {
+	return &Service{
+		logger:  zap.NewNop(),
+		address: address,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+			Transport: &http2.Transport{
+				TLSClientConfig: tlsConfig,
+			},
+		},
+		group: -1,
+	}
+}
+
 // leaderMiddleware checks whether the current node is the leader.
-// If this current node is not a leader, the request is forwarded to the leader node.
+// If this current node is not a leader, the client is redirected to the leader node.
 func (s *Service) leaderMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		isLeader, leaderAddr := s.store.Leader()
+		isLeader, leaderAddr := s.storeOf(r).Leader()
 		if !isLeader {
 			if len(leaderAddr) == 0 {
 				s.logger.Error("failed to get the leader address")
-				w.WriteHeader(http.StatusServiceUnavailable)
+				s.writeError(w, r, ErrNoLeader)
 				return
 			}
 			entryAddress, err := ConvertRaftAddressToHTTPAddress(leaderAddr)
 			if err != nil {
 				s.logger.Error("failed to convert the Raft address to HTTP address")
-				w.WriteHeader(http.StatusServiceUnavailable)
+				s.writeError(w, r, err)
 				return
 			}
 			redirectURL := s.getRedirectURL(r, entryAddress)
@@ -154,16 +268,8 @@ func (s *Service) Start() error {
 
 // Stop stops this service.
 func (s *Service) Stop(ctx context.Context) error {
-	var ret error
-	err := s.srv.Shutdown(ctx)
-	if err != nil {
-		ret = multierror.Append(ret, err)
-	}
-	err = s.ln.Close()
-	if err != nil {
-		ret = multierror.Append(ret, err)
-	}
-	return ret
+	// The listener is closed by the server.
+	return s.srv.Shutdown(ctx)
 }
 
 // getRedirectURL returns a URL by the given host.
@@ -184,18 +290,21 @@ func (s *Service) handleNodes(w http.ResponseWriter, r *http.Request) {
 func (s *Service) handleAddPolicy(w http.ResponseWriter, r *http.Request) {
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeError(w, r, bodyError(err))
 		return
 	}
 	var cmd command.AddPoliciesRequest
 	err = jsoniter.Unmarshal(data, &cmd)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
 		return
 	}
-	err = s.store.AddPolicies(&cmd)
+	if !s.rateLimit(w, r, len(cmd.Rules)) {
+		return
+	}
+	err = s.storeOf(r).AddPolicies(r.Context(), &cmd)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		s.writeError(w, r, err)
 		return
 	}
 }
@@ -205,47 +314,56 @@ func (s *Service) handleRemovePolicy(w http.ResponseWriter, r *http.Request) {
 	removeType := r.URL.Query().Get("type")
 	switch removeType {
 	case "all":
-		err := s.store.ClearPolicy()
+		if !s.rateLimit(w, r, 1) {
+			return
+		}
+		err := s.storeOf(r).ClearPolicy(r.Context())
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, err)
 			return
 		}
 	case "filtered":
 		data, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, bodyError(err))
 			return
 		}
 		var cmd command.RemoveFilteredPolicyRequest
 		err = jsoniter.Unmarshal(data, &cmd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
 			return
 		}
-		err = s.store.RemoveFilteredPolicy(&cmd)
+		if !s.rateLimit(w, r, 1) {
+			return
+		}
+		err = s.storeOf(r).RemoveFilteredPolicy(r.Context(), &cmd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, err)
 			return
 		}
 	case "":
 		data, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, bodyError(err))
 			return
 		}
 		var cmd command.RemovePoliciesRequest
 		err = jsoniter.Unmarshal(data, &cmd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+			return
+		}
+		if !s.rateLimit(w, r, len(cmd.Rules)) {
 			return
 		}
-		err = s.store.RemovePolicies(&cmd)
+		err = s.storeOf(r).RemovePolicies(r.Context(), &cmd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			s.writeError(w, r, err)
 			return
 		}
 	default:
-		w.WriteHeader(http.StatusBadRequest)
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, fmt.Sprintf("unknown remove type %q", removeType)))
 	}
 }
 
@@ -256,57 +374,123 @@ func (s *Service) handleUpdatePolicy(w http.ResponseWriter, r *http.Request) {
 	case "batch":
 		data, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, bodyError(err))
 			return
 		}
 		var cmd command.UpdatePoliciesRequest
 		err = jsoniter.Unmarshal(data, &cmd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
 			return
 		}
-		err = s.store.UpdatePolicies(&cmd)
+		if !s.rateLimit(w, r, len(cmd.NewRules)) {
+			return
+		}
+		err = s.storeOf(r).UpdatePolicies(r.Context(), &cmd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			s.writeError(w, r, err)
 			return
 		}
 	case "":
 		data, err := ioutil.ReadAll(r.Body)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, bodyError(err))
 			return
 		}
 		var cmd command.UpdatePolicyRequest
 		err = jsoniter.Unmarshal(data, &cmd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusBadRequest)
+			s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+			return
+		}
+		if !s.rateLimit(w, r, 1) {
 			return
 		}
-		err = s.store.UpdatePolicy(&cmd)
+		err = s.storeOf(r).UpdatePolicy(r.Context(), &cmd)
 		if err != nil {
-			http.Error(w, err.Error(), http.StatusServiceUnavailable)
+			s.writeError(w, r, err)
 			return
 		}
 	default:
-		w.WriteHeader(http.StatusBadRequest)
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, fmt.Sprintf("unknown update type %q", updateType)))
+	}
+}
+
+// handleMovePolicy handles the request to move a rule.
+func (s *Service) handleMovePolicy(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.writeError(w, r, bodyError(err))
+		return
+	}
+	var cmd command.MovePolicyRequest
+	err = jsoniter.Unmarshal(data, &cmd)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	err = s.storeOf(r).MovePolicy(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+}
+
+// handleBatch handles the request to apply a batch of writes, the result of each write is returned in the response.
+func (s *Service) handleBatch(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		s.writeError(w, r, bodyError(err))
+		return
+	}
+	var cmd command.BatchRequest
+	err = jsoniter.Unmarshal(data, &cmd)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	// Each write of the batch costs its rules, at least one.
+	rules := 0
+	for _, item := range cmd.Items {
+		if n := len(item.GetAddPolicies().GetRules()) + len(item.GetRemovePolicies().GetRules()); n > 0 {
+			rules += n
+		} else {
+			rules++
+		}
+	}
+	if !s.rateLimit(w, r, rules) {
+		return
+	}
+	errs := s.storeOf(r).ApplyBatch(r.Context(), &cmd)
+	response := &command.BatchResponse{
+		Results: make([]*command.BatchResult, len(errs)),
 	}
+	for i, err := range errs {
+		result := &command.BatchResult{}
+		if err != nil {
+			result.Code = string(CodeOf(err))
+			result.Error = err.Error()
+		}
+		response.Results[i] = result
+	}
+	s.writeJSON(w, r, response)
 }
 
 func (s *Service) handleJoinNode(w http.ResponseWriter, r *http.Request) {
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeError(w, r, bodyError(err))
 		return
 	}
 	var cmd command.AddNodeRequest
 	err = jsoniter.Unmarshal(data, &cmd)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
 		return
 	}
-	err = s.store.JoinNode(cmd.Id, cmd.Address)
+	err = s.storeOf(r).JoinNode(r.Context(), cmd.Id, cmd.Address)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		s.writeError(w, r, err)
 		return
 	}
 }
@@ -314,193 +498,986 @@ func (s *Service) handleJoinNode(w http.ResponseWriter, r *http.Request) {
 func (s *Service) handleRemoveNode(w http.ResponseWriter, r *http.Request) {
 	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeError(w, r, bodyError(err))
 		return
 	}
 	var cmd command.RemoveNodeRequest
 	err = jsoniter.Unmarshal(data, &cmd)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
 		return
 	}
-	err = s.store.RemoveNode(cmd.Id)
+	err = s.storeOf(r).RemoveNode(r.Context(), cmd.Id)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		s.writeError(w, r, err)
 		return
 	}
 }
 
-func (s *Service) Addr() string {
-	return s.ln.Addr().String()
-}
-
-func (s *Service) DoAddPolicyRequest(request *command.AddPoliciesRequest) error {
-	b, err := jsoniter.Marshal(request)
+// handleTransferLeadership handles the request to transfer the leadership to another node.
+func (s *Service) handleTransferLeadership(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/add", s.Addr()), bytes.NewBuffer(b))
+	var cmd command.TransferLeadershipRequest
+	err = jsoniter.Unmarshal(data, &cmd)
 	if err != nil {
-		return err
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
 	}
+	err = s.storeOf(r).TransferLeadership(r.Context(), cmd.Id)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+}
 
-	resp, err := s.httpClient.Do(r)
+// handleAssignRole handles the request to assign a role to a user.
+func (s *Service) handleAssignRole(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+	var cmd command.AssignRoleRequest
+	err = jsoniter.Unmarshal(data, &cmd)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	if len(cmd.PType) == 0 {
+		cmd.PType = "g"
+	}
+	err = s.storeOf(r).AssignRole(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
-
-	return nil
 }
 
-func (s *Service) DoRemovePolicyRequest(request *command.RemovePoliciesRequest) error {
-	b, err := jsoniter.Marshal(request)
+// handleUnassignRole handles the request to unassign a role from a user.
+func (s *Service) handleUnassignRole(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/remove", s.Addr()), bytes.NewBuffer(b))
+	var cmd command.UnassignRoleRequest
+	err = jsoniter.Unmarshal(data, &cmd)
 	if err != nil {
-		return err
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	if len(cmd.PType) == 0 {
+		cmd.PType = "g"
+	}
+	err = s.storeOf(r).UnassignRole(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
+}
 
-	resp, err := s.httpClient.Do(r)
+// handleDeleteRole handles the request to delete a role.
+func (s *Service) handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+	var cmd command.DeleteRoleRequest
+	err = jsoniter.Unmarshal(data, &cmd)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	err = s.storeOf(r).DeleteRole(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
+}
 
-	return nil
+// handleGetUsersForRole handles the request to get the users that have a role.
+func (s *Service) handleGetUsersForRole(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	role := query.Get("role")
+	if len(role) == 0 {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, "role is not provided"))
+		return
+	}
+	users, err := s.storeOf(r).GetUsersForRole(role, query["domain"]...)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, users)
 }
 
-func (s *Service) DoRemoveFilteredPolicyRequest(request *command.RemoveFilteredPolicyRequest) error {
-	b, err := jsoniter.Marshal(request)
+// handleGetRolesForUser handles the request to get the roles of a user,
+// the roles inherited through other roles are included if implicit is true.
+func (s *Service) handleGetRolesForUser(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	user := query.Get("user")
+	if len(user) == 0 {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, "user is not provided"))
+		return
+	}
+	var roles []string
+	var err error
+	if query.Get("implicit") == "true" {
+		roles, err = s.storeOf(r).GetImplicitRolesForUser(user, query["domain"]...)
+	} else {
+		roles, err = s.storeOf(r).GetRolesForUser(user, query["domain"]...)
+	}
 	if err != nil {
-		return err
+		s.writeError(w, r, err)
+		return
 	}
+	s.writeJSON(w, r, roles)
+}
 
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/remove?type=filtered", s.Addr()), bytes.NewBuffer(b))
+// writeJSON writes a JSON-encoded value as the response body.
+func (s *Service) writeJSON(w http.ResponseWriter, r *http.Request, v interface{}) {
+	b, err := jsoniter.Marshal(v)
 	if err != nil {
-		return err
+		s.writeError(w, r, NewError(ErrorCodeInternal, err.Error()))
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
 
-	resp, err := s.httpClient.Do(r)
+// handleEnforce handles the request to decide whether a subject can access an object.
+func (s *Service) handleEnforce(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+	var cmd command.EnforceRequest
+	err = jsoniter.Unmarshal(data, &cmd)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
 	}
-
-	return nil
+	rvals := make([]interface{}, len(cmd.Params))
+	for i, param := range cmd.Params {
+		rvals[i] = param
+	}
+	allowed, err := s.storeOf(r).Enforce(rvals...)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	s.writeJSON(w, r, &command.EnforceResponse{Allowed: allowed})
 }
 
-func (s *Service) DoClearPolicyRequest() error {
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/remove?type=all", s.Addr()), nil)
+// handleCreateNamespace handles the request to create a namespace.
+func (s *Service) handleCreateNamespace(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-
-	resp, err := s.httpClient.Do(r)
+	var cmd command.CreateNamespaceRequest
+	err = jsoniter.Unmarshal(data, &cmd)
 	if err != nil {
-		return err
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+	if cmd.Namespace != r.URL.Query().Get("namespace") {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, "the namespace query parameter must be the namespace of the request"))
+		return
+	}
+	err = s.storeOf(r).CreateNamespace(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
-
-	return nil
 }
 
-func (s *Service) DoUpdatePolicyRequest(request *command.UpdatePolicyRequest) error {
-	b, err := jsoniter.Marshal(request)
+// handleDeleteNamespace handles the request to delete a namespace.
+func (s *Service) handleDeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/update", s.Addr()), bytes.NewBuffer(b))
+	var cmd command.DeleteNamespaceRequest
+	err = jsoniter.Unmarshal(data, &cmd)
 	if err != nil {
-		return err
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	if cmd.Namespace != r.URL.Query().Get("namespace") {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, "the namespace query parameter must be the namespace of the request"))
+		return
 	}
+	err = s.storeOf(r).DeleteNamespace(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+}
 
-	resp, err := s.httpClient.Do(r)
+// handleFreezeNamespace handles the request to freeze or unfreeze a namespace.
+func (s *Service) handleFreezeNamespace(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+	var cmd command.FreezeNamespaceRequest
+	err = jsoniter.Unmarshal(data, &cmd)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	if cmd.Namespace != r.URL.Query().Get("namespace") {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, "the namespace query parameter must be the namespace of the request"))
+		return
+	}
+	err = s.storeOf(r).FreezeNamespace(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
+}
 
-	return nil
+// handleNamespaces handles the request to list the namespaces.
+func (s *Service) handleNamespaces(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, r, s.store.Namespaces())
 }
 
-func (s *Service) DoUpdatePoliciesRequest(request *command.UpdatePoliciesRequest) error {
-	b, err := jsoniter.Marshal(request)
+// handleExportNamespace handles the request to export the model and the rules of a namespace.
+func (s *Service) handleExportNamespace(w http.ResponseWriter, r *http.Request) {
+	export, err := s.storeOf(r).Export()
 	if err != nil {
-		return err
+		s.writeError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, export)
+}
+
+// handleSetRoute handles the request to route a namespace to a raft group.
+func (s *Service) handleSetRoute(w http.ResponseWriter, r *http.Request) {
+	sharded, ok := s.store.(ShardedStore)
+	if !ok {
+		s.writeError(w, r, errNotSharded)
+		return
 	}
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/update?type=batch", s.Addr()), bytes.NewBuffer(b))
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-
-	resp, err := s.httpClient.Do(r)
+	var cmd command.SetRouteRequest
+	err = jsoniter.Unmarshal(data, &cmd)
 	if err != nil {
-		return err
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+	err = sharded.SetRoute(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
-
-	return nil
 }
 
-func (s *Service) DoJoinNodeRequest(request *command.AddNodeRequest) error {
-	b, err := jsoniter.Marshal(request)
-	if err != nil {
-		return err
+// handleRoutingTable handles the request to get the raft group of each namespace.
+func (s *Service) handleRoutingTable(w http.ResponseWriter, r *http.Request) {
+	sharded, ok := s.store.(ShardedStore)
+	if !ok {
+		s.writeError(w, r, errNotSharded)
+		return
 	}
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/nodes/join", s.Addr()), bytes.NewBuffer(b))
+	table, err := sharded.RoutingTable()
 	if err != nil {
-		return err
+		s.writeError(w, r, err)
+		return
 	}
+	s.writeJSON(w, r, table)
+}
 
-	resp, err := s.httpClient.Do(r)
+// handleSetModel handles the request to replace the model.
+func (s *Service) handleSetModel(w http.ResponseWriter, r *http.Request) {
+	data, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return err
+		s.writeError(w, r, bodyError(err))
+		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+	var cmd command.SetModelRequest
+	err = jsoniter.Unmarshal(data, &cmd)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	err = s.storeOf(r).SetModel(r.Context(), &cmd)
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
-
-	return nil
 }
 
-func (s *Service) DoRemoveNodeRequest(request *command.RemoveNodeRequest) error {
-	b, err := jsoniter.Marshal(request)
+// handleGetModel handles the request to get the replicated model text.
+func (s *Service) handleGetModel(w http.ResponseWriter, r *http.Request) {
+	text, err := s.storeOf(r).Model()
 	if err != nil {
-		return err
+		s.writeError(w, r, err)
+		return
 	}
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/nodes/remove", s.Addr()), bytes.NewBuffer(b))
+	b, err := jsoniter.Marshal(&command.SetModelRequest{Text: text})
 	if err != nil {
-		return err
+		s.writeError(w, r, NewError(ErrorCodeInternal, err.Error()))
+		return
 	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
 
-	resp, err := s.httpClient.Do(r)
+// handleStatus handles the request to get the status of the current node.
+func (s *Service) handleStatus(w http.ResponseWriter, r *http.Request) {
+	b, err := jsoniter.Marshal(s.storeOf(r).Status())
 	if err != nil {
-		return err
+		s.writeError(w, r, NewError(ErrorCodeInternal, err.Error()))
+		return
 	}
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(b)
+}
+
+// handleRepair handles the request to force the followers to install a snapshot of the leader.
+func (s *Service) handleRepair(w http.ResponseWriter, r *http.Request) {
+	err := s.storeOf(r).Repair(r.Context())
+	if err != nil {
+		s.writeError(w, r, err)
+		return
 	}
+}
 
-	return nil
+// Addr returns the address that the Service listens on, or the address of the node that a client sends the requests to.
+func (s *Service) Addr() string {
+	if s.ln == nil {
+		return s.address
+	}
+	return s.ln.Addr().String()
 }
 
-func DoJoinNodeRequest(clusterAddress string, nodeID string, nodeAddress string, tlsConfig *tls.Config) error {
+// leaderAddr returns the HTTP address of the leader of the raft group that the requests of this Service are sent to.
+func (s *Service) leaderAddr() (string, error) {
+	var isLeader bool
+	var leaderAddr string
+	if s.store != nil {
+		group := ""
+		if s.group >= 0 {
+			group = strconv.Itoa(s.group)
+		}
+		store, err := s.resolveStore(group, s.namespace)
+		if err != nil {
+			return "", err
+		}
+		isLeader, leaderAddr = store.Leader()
+	} else {
+		status, err := s.DoStatusRequest()
+		if err != nil {
+			return "", err
+		}
+		isLeader, leaderAddr = status.Leader == status.Address, status.Leader
+	}
+
+	if isLeader {
+		return s.Addr(), nil
+	}
+	if len(leaderAddr) == 0 {
+		return "", ErrNoLeader
+	}
+	return ConvertRaftAddressToHTTPAddress(leaderAddr)
+}
+
+// WithNamespace returns a Service that sends the requests to the given namespace.
+func (s *Service) WithNamespace(namespace string) *Service {
+	c := *s
+	c.namespace = namespace
+	return &c
+}
+
+// WithGroup returns a Service that sends the requests to the given raft group.
+func (s *Service) WithGroup(group int) *Service {
+	c := *s
+	c.group = group
+	return &c
+}
+
+// WithContext returns a Service whose requests are cancelled when ctx is done.
+// A change that has reached the leader may still be applied after its request is cancelled.
+func (s *Service) WithContext(ctx context.Context) *Service {
+	c := *s
+	c.ctx = ctx
+	return &c
+}
+
+// newRequest returns a request with the context of the Service, and the request ID of the context if it has one.
+func (s *Service) newRequest(method string, url string, body io.Reader) (*http.Request, error) {
+	ctx := s.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	r, err := http.NewRequestWithContext(ctx, method, url, body)
+	if err != nil {
+		return nil, err
+	}
+	if id := RequestID(ctx); len(id) > 0 {
+		r.Header.Set(RequestIDHeader, id)
+	}
+	return r, nil
+}
+
+// url returns the URL of the path on this node, with the namespace and the raft group of the Service.
+func (s *Service) url(path string) string {
+	return s.urlAt(s.Addr(), path, nil)
+}
+
+// urlAt returns the URL of the path on the given address, with the query, the namespace and the raft group of the Service.
+func (s *Service) urlAt(address string, path string, query url.Values) string {
+	u, err := url.Parse(fmt.Sprintf("https://%s%s", address, path))
+	if err != nil {
+		return ""
+	}
+	if query == nil {
+		query = u.Query()
+	}
+	if len(s.namespace) > 0 {
+		query.Set("namespace", s.namespace)
+	}
+	if s.group >= 0 {
+		query.Set("group", strconv.Itoa(s.group))
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+func (s *Service) DoCreateNamespaceRequest(request *command.CreateNamespaceRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.WithNamespace(request.Namespace).url("/namespaces/create"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoDeleteNamespaceRequest(request *command.DeleteNamespaceRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.WithNamespace(request.Namespace).url("/namespaces/delete"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+// DoFreezeNamespaceRequest sends a request to freeze or unfreeze a namespace.
+func (s *Service) DoFreezeNamespaceRequest(request *command.FreezeNamespaceRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.WithNamespace(request.Namespace).url("/namespaces/freeze"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoExportNamespaceRequest() (*command.NamespaceExport, error) {
+	r, err := s.newRequest(http.MethodGet, s.url("/namespaces/export"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ResponseError(resp)
+	}
+
+	var export command.NamespaceExport
+	err = jsoniter.NewDecoder(resp.Body).Decode(&export)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (s *Service) DoSetRouteRequest(request *command.SetRouteRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, fmt.Sprintf("https://%s/routes/", s.Addr()), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoAddPolicyRequest(request *command.AddPoliciesRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.newRequest(http.MethodPut, s.url("/policies/add"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoRemovePolicyRequest(request *command.RemovePoliciesRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/policies/remove"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoRemoveFilteredPolicyRequest(request *command.RemoveFilteredPolicyRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	r, err := s.newRequest(http.MethodPut, s.url("/policies/remove?type=filtered"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+// DoBatchRequest sends a batch of writes to the leader, the error of each write is returned at its index as an *Error.
+// The returned error is not nil if the batch cannot be applied at all, then the errors of the writes are nil.
+func (s *Service) DoBatchRequest(request *command.BatchRequest) ([]error, error) {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := s.newRequest(http.MethodPut, s.url("/policies/batch"), bytes.NewBuffer(b))
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ResponseError(resp)
+	}
+
+	var response command.BatchResponse
+	err = jsoniter.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Results) != len(request.Items) {
+		return nil, errors.Errorf("the batch has %d writes, but %d results are returned", len(request.Items), len(response.Results))
+	}
+	errs := make([]error, len(response.Results))
+	for i, result := range response.Results {
+		if len(result.Code) > 0 {
+			errs[i] = NewError(ErrorCode(result.Code), result.Error)
+		}
+	}
+	return errs, nil
+}
+
+func (s *Service) DoClearPolicyRequest() error {
+	r, err := s.newRequest(http.MethodPut, s.url("/policies/remove?type=all"), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoUpdatePolicyRequest(request *command.UpdatePolicyRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/policies/update"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoUpdatePoliciesRequest(request *command.UpdatePoliciesRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/policies/update?type=batch"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoMovePolicyRequest(request *command.MovePolicyRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/policies/move"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoSetModelRequest(request *command.SetModelRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/model"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoAssignRoleRequest(request *command.AssignRoleRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/roles/assign"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoUnassignRoleRequest(request *command.UnassignRoleRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/roles/unassign"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoDeleteRoleRequest(request *command.DeleteRoleRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/roles/delete"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoJoinNodeRequest(request *command.AddNodeRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/nodes/join"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoRemoveNodeRequest(request *command.RemoveNodeRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/nodes/remove"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoRepairRequest() error {
+	r, err := s.newRequest(http.MethodPut, s.url("/nodes/repair"), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+func (s *Service) DoTransferLeadershipRequest(request *command.TransferLeadershipRequest) error {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return err
+	}
+	r, err := s.newRequest(http.MethodPut, s.url("/nodes/transfer"), bytes.NewBuffer(b))
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	return nil
+}
+
+// DoStatusRequest returns the status of the node that the requests are sent to.
+func (s *Service) DoStatusRequest() (*command.NodeStatus, error) {
+	r, err := s.newRequest(http.MethodGet, s.url("/status"), nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, ResponseError(resp)
+	}
+
+	var status command.NodeStatus
+	err = jsoniter.NewDecoder(resp.Body).Decode(&status)
+	if err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// DoEnforceRequest decides whether a subject can access an object on the node that the requests are sent to.
+func (s *Service) DoEnforceRequest(request *command.EnforceRequest) (bool, error) {
+	b, err := jsoniter.Marshal(request)
+	if err != nil {
+		return false, err
+	}
+	r, err := s.newRequest(http.MethodPost, s.url("/enforce"), bytes.NewBuffer(b))
+	if err != nil {
+		return false, err
+	}
+
+	resp, err := s.httpClient.Do(r)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return false, ResponseError(resp)
+	}
+
+	var response command.EnforceResponse
+	err = jsoniter.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return false, err
+	}
+	return response.Allowed, nil
+}
+
+func DoJoinNodeRequest(clusterAddress string, nodeID string, nodeAddress string, tlsConfig *tls.Config) error {
+	return doJoinNodeRequest(fmt.Sprintf("https://%s/nodes/join", clusterAddress), nodeID, nodeAddress, tlsConfig)
+}
+
+// DoJoinGroupRequest joins a node to a raft group of the cluster that is started with several raft groups.
+func DoJoinGroupRequest(clusterAddress string, group int, nodeID string, nodeAddress string, tlsConfig *tls.Config) error {
+	return doJoinNodeRequest(fmt.Sprintf("https://%s/nodes/join?group=%d", clusterAddress, group), nodeID, nodeAddress, tlsConfig)
+}
+
+func doJoinNodeRequest(url string, nodeID string, nodeAddress string, tlsConfig *tls.Config) error {
 	tr := &http2.Transport{
 		TLSClientConfig: tlsConfig,
 	}
@@ -516,7 +1493,7 @@ func DoJoinNodeRequest(clusterAddress string, nodeID string, nodeAddress string,
 		return err
 	}
 
-	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/nodes/join", clusterAddress), bytes.NewBuffer(b))
+	r, err := http.NewRequest(http.MethodPut, url, bytes.NewBuffer(b))
 	if err != nil {
 		return err
 	}
@@ -526,7 +1503,7 @@ func DoJoinNodeRequest(clusterAddress string, nodeID string, nodeAddress string,
 		return err
 	}
 	if resp.StatusCode != http.StatusOK {
-		return errors.New(http.StatusText(http.StatusServiceUnavailable))
+		return ResponseError(resp)
 	}
 
 	return nil
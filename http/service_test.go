@@ -8,9 +8,11 @@ import (
 	jsoniter "github.com/json-iterator/go"
 	"github.com/nodece/casbin-hraft-dispatcher/command"
 	"github.com/nodece/casbin-hraft-dispatcher/http/mocks"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
@@ -84,7 +86,7 @@ func TestAddPolicy(t *testing.T) {
 		Rules: []*command.StringArray{{Items: []string{"role:admin", "/", "*"}}},
 	}
 	store.EXPECT().Leader().Return(true, s.Addr())
-	store.EXPECT().AddPolicies(addPolicyRequest).Return(nil)
+	store.EXPECT().AddPolicies(gomock.Any(), addPolicyRequest).Return(nil)
 
 	b, err := jsoniter.Marshal(addPolicyRequest)
 	assert.NoError(t, err)
@@ -121,7 +123,7 @@ func TestRemovePolicy(t *testing.T) {
 		Rules: []*command.StringArray{{Items: []string{"role:admin", "/", "*"}}},
 	}
 	store.EXPECT().Leader().Return(true, s.Addr())
-	store.EXPECT().RemovePolicies(removePolicyRequest).Return(nil)
+	store.EXPECT().RemovePolicies(gomock.Any(), removePolicyRequest).Return(nil)
 
 	b, err := jsoniter.Marshal(removePolicyRequest)
 	assert.NoError(t, err)
@@ -159,7 +161,7 @@ func TestRemoveFilteredPolicy(t *testing.T) {
 		FieldValues: []string{"role:admin"},
 	}
 	store.EXPECT().Leader().Return(true, s.Addr())
-	store.EXPECT().RemoveFilteredPolicy(removeFilteredPolicyRequest).Return(nil)
+	store.EXPECT().RemoveFilteredPolicy(gomock.Any(), removeFilteredPolicyRequest).Return(nil)
 
 	b, err := jsoniter.Marshal(removeFilteredPolicyRequest)
 	assert.NoError(t, err)
@@ -197,7 +199,7 @@ func TestUpdatePolicy(t *testing.T) {
 		NewRule: []string{"role:admin", "/admin", "*"},
 	}
 	store.EXPECT().Leader().Return(true, s.Addr())
-	store.EXPECT().UpdatePolicy(updatePolicyRequest).Return(nil)
+	store.EXPECT().UpdatePolicy(gomock.Any(), updatePolicyRequest).Return(nil)
 
 	b, err := jsoniter.Marshal(updatePolicyRequest)
 	assert.NoError(t, err)
@@ -209,6 +211,442 @@ func TestUpdatePolicy(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestMovePolicy(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	movePolicyRequest := &command.MovePolicyRequest{
+		Sec:      "p",
+		PType:    "p",
+		Rule:     []string{"role:admin", "/", "*"},
+		Position: 1,
+	}
+	store.EXPECT().Leader().Return(true, s.Addr())
+	store.EXPECT().MovePolicy(gomock.Any(), movePolicyRequest).Return(nil)
+
+	b, err := jsoniter.Marshal(movePolicyRequest)
+	assert.NoError(t, err)
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/move", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestBatch(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	batchRequest := &command.BatchRequest{
+		Items: []*command.BatchItem{
+			{AddPolicies: &command.AddPoliciesRequest{Sec: "p", PType: "p", Rules: []*command.StringArray{{Items: []string{"alice", "/", "GET"}}}}},
+			{RemovePolicies: &command.RemovePoliciesRequest{Sec: "p", PType: "p2", Rules: []*command.StringArray{{Items: []string{"bob", "/", "GET"}}}}},
+		},
+	}
+	store.EXPECT().Leader().Return(true, s.Addr())
+	store.EXPECT().ApplyBatch(gomock.Any(), batchRequest).Return([]error{nil, NewError(ErrorCodeRejected, "the model does not define p2")})
+
+	errs, err := s.DoBatchRequest(batchRequest)
+	assert.NoError(t, err)
+	assert.Equal(t, []error{nil, &Error{Code: ErrorCodeRejected, Message: "the model does not define p2"}}, errs)
+
+	store.EXPECT().Leader().Return(false, "")
+	errs, err = s.DoBatchRequest(batchRequest)
+	assert.Nil(t, errs)
+	assert.Equal(t, ErrNoLeader, errors.Cause(err))
+}
+
+func TestRequestID(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	request := &command.AddPoliciesRequest{
+		Sec:   "p",
+		PType: "p",
+		Rules: []*command.StringArray{{Items: []string{"alice", "/", "GET"}}},
+	}
+	store.EXPECT().Leader().Return(true, s.Addr())
+	store.EXPECT().AddPolicies(gomock.Any(), request).DoAndReturn(func(ctx context.Context, request *command.AddPoliciesRequest) error {
+		assert.Equal(t, "add-alice", RequestID(ctx))
+		return nil
+	})
+	err = s.WithContext(WithRequestID(context.Background(), "add-alice")).DoAddPolicyRequest(request)
+	assert.NoError(t, err)
+
+	err = s.WithContext(WithRequestID(context.Background(), strings.Repeat("a", maxRequestIDLength+1))).DoAddPolicyRequest(request)
+	assert.Equal(t, ErrInvalidRequest, errors.Cause(err))
+}
+
+func TestSetModel(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	setModelRequest := &command.SetModelRequest{
+		Text: "[request_definition]\nr = sub, obj, act",
+	}
+	store.EXPECT().Leader().Return(true, s.Addr())
+	store.EXPECT().SetModel(gomock.Any(), setModelRequest).Return(nil)
+
+	b, err := jsoniter.Marshal(setModelRequest)
+	assert.NoError(t, err)
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/model", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	store.EXPECT().Model().Return(setModelRequest.Text, nil)
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/model", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var actual command.SetModelRequest
+	err = jsoniter.NewDecoder(resp.Body).Decode(&actual)
+	assert.NoError(t, err)
+	assert.Equal(t, setModelRequest.Text, actual.Text)
+}
+
+func TestRoles(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	store.EXPECT().Leader().Return(true, s.Addr()).AnyTimes()
+
+	store.EXPECT().AssignRole(gomock.Any(), &command.AssignRoleRequest{PType: "g", User: "alice", Role: "role:admin"}).Return(nil)
+	b, err := jsoniter.Marshal(&command.AssignRoleRequest{User: "alice", Role: "role:admin"})
+	assert.NoError(t, err)
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/roles/assign", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	unassignRoleRequest := &command.UnassignRoleRequest{PType: "g2", User: "alice", Role: "role:admin", Domain: []string{"domain1"}}
+	store.EXPECT().UnassignRole(gomock.Any(), unassignRoleRequest).Return(nil)
+	b, err = jsoniter.Marshal(unassignRoleRequest)
+	assert.NoError(t, err)
+	r, err = http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/roles/unassign", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err = ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	deleteRoleRequest := &command.DeleteRoleRequest{Role: "role:admin"}
+	store.EXPECT().DeleteRole(gomock.Any(), deleteRoleRequest).Return(nil)
+	b, err = jsoniter.Marshal(deleteRoleRequest)
+	assert.NoError(t, err)
+	r, err = http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/roles/delete", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err = ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var result []string
+	store.EXPECT().GetUsersForRole("role:admin", "domain1").Return([]string{"alice"}, nil)
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/roles/users?role=role:admin&domain=domain1", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = jsoniter.NewDecoder(resp.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice"}, result)
+
+	store.EXPECT().GetRolesForUser("alice").Return([]string{"role:admin"}, nil)
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/users/roles?user=alice", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = jsoniter.NewDecoder(resp.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"role:admin"}, result)
+
+	store.EXPECT().GetImplicitRolesForUser("alice").Return([]string{"role:admin", "role:root"}, nil)
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/users/roles?user=alice&implicit=true", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	err = jsoniter.NewDecoder(resp.Body).Decode(&result)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"role:admin", "role:root"}, result)
+
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/users/roles", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+// namespacedStore routes the namespaces and the groups to the given stores.
+type namespacedStore struct {
+	Store
+	namespaces map[string]Store
+	groups     []Store
+	routes     []*command.Route
+}
+
+func (s *namespacedStore) WithNamespace(namespace string) Store {
+	return s.namespaces[namespace]
+}
+
+func (s *namespacedStore) Group(id int) (Store, error) {
+	if id < 0 || id >= len(s.groups) {
+		return nil, errors.New("the group does not exist")
+	}
+	return s.groups[id], nil
+}
+
+func (s *namespacedStore) RoutingTable() (*command.RoutingTable, error) {
+	return &command.RoutingTable{Groups: int32(len(s.groups)), Routes: s.routes}, nil
+}
+
+func (s *namespacedStore) SetRoute(ctx context.Context, request *command.SetRouteRequest) error {
+	s.routes = append(s.routes, &command.Route{Namespace: request.Namespace, Group: request.Group})
+	return nil
+}
+
+func TestNamespaces(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+	tenantStore := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, &namespacedStore{Store: store, namespaces: map[string]Store{"tenant1": tenantStore}})
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	store.EXPECT().Leader().Return(true, s.Addr()).AnyTimes()
+	tenantStore.EXPECT().Leader().Return(true, s.Addr()).AnyTimes()
+
+	createNamespaceRequest := &command.CreateNamespaceRequest{Namespace: "tenant1", Model: "[request_definition]\nr = sub, obj, act"}
+	b, err := jsoniter.Marshal(createNamespaceRequest)
+	assert.NoError(t, err)
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/namespaces/create", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	tenantStore.EXPECT().CreateNamespace(gomock.Any(), createNamespaceRequest).Return(nil)
+	r, err = http.NewRequest(http.MethodPut, s.WithNamespace("tenant1").url("/namespaces/create"), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err = ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	store.EXPECT().Namespaces().Return([]string{"tenant1"})
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/namespaces", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var namespaces []string
+	err = jsoniter.NewDecoder(resp.Body).Decode(&namespaces)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant1"}, namespaces)
+
+	addPolicyRequest := &command.AddPoliciesRequest{
+		Sec:   "p",
+		PType: "p",
+		Rules: []*command.StringArray{{Items: []string{"alice", "/", "GET"}}},
+	}
+	tenantStore.EXPECT().AddPolicies(gomock.Any(), addPolicyRequest).Return(nil)
+	b, err = jsoniter.Marshal(addPolicyRequest)
+	assert.NoError(t, err)
+	r, err = http.NewRequest(http.MethodPut, s.WithNamespace("tenant1").url("/policies/add"), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err = ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	assert.Equal(t, fmt.Sprintf("https://%s/policies/remove?namespace=tenant1&type=filtered", s.Addr()), s.WithNamespace("tenant1").url("/policies/remove?type=filtered"))
+	assert.Equal(t, fmt.Sprintf("https://%s/policies/remove?type=filtered", s.Addr()), s.url("/policies/remove?type=filtered"))
+
+	tenantStore.EXPECT().Enforce("alice", "/", "GET").Return(true, nil)
+	b, err = jsoniter.Marshal(&command.EnforceRequest{Params: []string{"alice", "/", "GET"}})
+	assert.NoError(t, err)
+	resp, err = ts.Client().Post(fmt.Sprintf("https://%s/enforce?namespace=tenant1", s.Addr()), "application/json", bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var enforceResponse command.EnforceResponse
+	err = jsoniter.NewDecoder(resp.Body).Decode(&enforceResponse)
+	assert.NoError(t, err)
+	assert.True(t, enforceResponse.Allowed)
+
+	freezeNamespaceRequest := &command.FreezeNamespaceRequest{Namespace: "tenant1", Frozen: true}
+	tenantStore.EXPECT().FreezeNamespace(gomock.Any(), freezeNamespaceRequest).Return(nil)
+	b, err = jsoniter.Marshal(freezeNamespaceRequest)
+	assert.NoError(t, err)
+	r, err = http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/namespaces/freeze?namespace=tenant1", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err = ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	deleteNamespaceRequest := &command.DeleteNamespaceRequest{Namespace: "tenant1"}
+	tenantStore.EXPECT().DeleteNamespace(gomock.Any(), deleteNamespaceRequest).Return(nil)
+	b, err = jsoniter.Marshal(deleteNamespaceRequest)
+	assert.NoError(t, err)
+	r, err = http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/namespaces/delete?namespace=tenant1", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err = ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
+
+func TestGroups(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+	groupStore := mocks.NewMockStore(ctl)
+	tenantStore := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	sharded := &namespacedStore{
+		Store:      store,
+		namespaces: map[string]Store{},
+		groups:     []Store{store, &namespacedStore{Store: groupStore, namespaces: map[string]Store{"tenant1": tenantStore}}},
+	}
+	s, err := NewService("127.0.0.1:0", ts.TLS, sharded)
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	store.EXPECT().Leader().Return(true, s.Addr()).AnyTimes()
+	groupStore.EXPECT().Leader().Return(true, s.Addr()).AnyTimes()
+
+	// The requests with the group are served by the store of the group.
+	addNodeRequest := &command.AddNodeRequest{Id: "node2", Address: "127.0.0.1:6790"}
+	groupStore.EXPECT().JoinNode(gomock.Any(), "node2", "127.0.0.1:6790").Return(nil)
+	b, err := jsoniter.Marshal(addNodeRequest)
+	assert.NoError(t, err)
+	r, err := http.NewRequest(http.MethodPut, s.WithGroup(1).url("/nodes/join"), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/status?group=2", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	tenantStore.EXPECT().Leader().Return(true, s.Addr())
+	tenantStore.EXPECT().Export().Return(&command.NamespaceExport{Namespace: "tenant1", Model: "m"}, nil)
+	resp, err = ts.Client().Get(s.WithGroup(1).WithNamespace("tenant1").url("/namespaces/export"))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var export command.NamespaceExport
+	err = jsoniter.NewDecoder(resp.Body).Decode(&export)
+	assert.NoError(t, err)
+	assert.Equal(t, "m", export.Model)
+
+	b, err = jsoniter.Marshal(&command.SetRouteRequest{Namespace: "tenant1", Group: 1})
+	assert.NoError(t, err)
+	r, err = http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/routes", s.Addr()), bytes.NewBuffer(b))
+	assert.NoError(t, err)
+	resp, err = ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/routes", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var table command.RoutingTable
+	err = jsoniter.NewDecoder(resp.Body).Decode(&table)
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), table.Groups)
+	assert.Len(t, table.Routes, 1)
+	assert.Equal(t, "tenant1", table.Routes[0].Namespace)
+	assert.Equal(t, int32(1), table.Routes[0].Group)
+}
+
 func TestClearPolicy(t *testing.T) {
 	ctl := gomock.NewController(t)
 	defer ctl.Finish()
@@ -229,7 +667,7 @@ func TestClearPolicy(t *testing.T) {
 	defer s.Stop(context.Background())
 
 	store.EXPECT().Leader().Return(true, s.Addr())
-	store.EXPECT().ClearPolicy().Return(nil)
+	store.EXPECT().ClearPolicy(gomock.Any()).Return(nil)
 
 	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/remove?type=all", s.Addr()), nil)
 	assert.NoError(t, err)
@@ -239,6 +677,42 @@ func TestClearPolicy(t *testing.T) {
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
 
+func TestRemovePolicyErrors(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	// The errors of the store keep their codes, so the clients follow the leader and retry the overloaded writes.
+	notLeader := NewError(ErrorCodeNotLeader, "node is not the leader")
+	notLeader.Leader = "127.0.0.1:6791"
+	overloaded := NewError(ErrorCodeOverloaded, "1 writes are being applied")
+	for _, storeErr := range []*Error{notLeader, overloaded} {
+		store.EXPECT().Leader().Return(true, s.Addr()).Times(2)
+		store.EXPECT().ClearPolicy(gomock.Any()).Return(storeErr)
+		store.EXPECT().RemoveFilteredPolicy(gomock.Any(), gomock.Any()).Return(storeErr)
+
+		err = s.DoClearPolicyRequest()
+		assert.Equal(t, storeErr.Code, CodeOf(err))
+		assert.Equal(t, storeErr.Leader, asError(err).Leader)
+		err = s.DoRemoveFilteredPolicyRequest(&command.RemoveFilteredPolicyRequest{Sec: "p", PType: "p", FieldValues: []string{"alice"}})
+		assert.Equal(t, storeErr.Code, CodeOf(err))
+		assert.Equal(t, storeErr.Leader, asError(err).Leader)
+	}
+}
+
 func TestJoinNode(t *testing.T) {
 	ctl := gomock.NewController(t)
 	defer ctl.Finish()
@@ -263,7 +737,7 @@ func TestJoinNode(t *testing.T) {
 		Address: "10.0.7.10",
 	}
 	store.EXPECT().Leader().Return(true, s.Addr())
-	store.EXPECT().JoinNode(addNodeRequest.Id, addNodeRequest.Address).Return(nil)
+	store.EXPECT().JoinNode(gomock.Any(), addNodeRequest.Id, addNodeRequest.Address).Return(nil)
 
 	b, err := jsoniter.Marshal(addNodeRequest)
 	assert.NoError(t, err)
@@ -298,7 +772,7 @@ func TestRemoveNode(t *testing.T) {
 		Id: "test-main",
 	}
 	store.EXPECT().Leader().Return(true, s.Addr())
-	store.EXPECT().RemoveNode(removeNodeRequest.Id).Return(nil)
+	store.EXPECT().RemoveNode(gomock.Any(), removeNodeRequest.Id).Return(nil)
 
 	b, err := jsoniter.Marshal(removeNodeRequest)
 	assert.NoError(t, err)
@@ -309,3 +783,76 @@ func TestRemoveNode(t *testing.T) {
 	assert.NoError(t, err)
 	assert.Equal(t, http.StatusOK, resp.StatusCode)
 }
+
+func TestStatus(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	status := &command.NodeStatus{
+		Id:            "test-main",
+		Address:       "127.0.0.1:6790",
+		Leader:        "127.0.0.1:6790",
+		AppliedIndex:  10,
+		ChecksumIndex: 8,
+		Checksum:      []byte("checksum"),
+		Diverged:      true,
+	}
+	store.EXPECT().Status().Return(status)
+
+	r, err := http.NewRequest(http.MethodGet, fmt.Sprintf("https://%s/status", s.Addr()), nil)
+	assert.NoError(t, err)
+
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+	var actual command.NodeStatus
+	err = jsoniter.NewDecoder(resp.Body).Decode(&actual)
+	assert.NoError(t, err)
+	assert.Equal(t, status.String(), actual.String())
+}
+
+func TestRepair(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	assert.NotNil(t, s)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	store.EXPECT().Leader().Return(true, s.Addr())
+	store.EXPECT().Repair(gomock.Any()).Return(nil)
+
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/nodes/repair", s.Addr()), nil)
+	assert.NoError(t, err)
+
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+}
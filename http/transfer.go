@@ -0,0 +1,477 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+// Format is the format of a policy stream.
+type Format string
+
+const (
+	// FormatCSV is the casbin CSV policy format, such as "p, alice, data1, read".
+	FormatCSV Format = "csv"
+	// FormatJSON is a JSON array of rules, such as [{"sec":"p","pType":"p","rule":["alice","data1","read"]}].
+	FormatJSON Format = "json"
+
+	// maxImportChunkSize is the maximum size of the rules in a raft entry of an import.
+	maxImportChunkSize = 512 * 1024
+)
+
+// ParseFormat returns the Format of a name, an empty name means FormatCSV.
+func ParseFormat(name string) (Format, error) {
+	switch Format(strings.ToLower(name)) {
+	case "", FormatCSV:
+		return FormatCSV, nil
+	case FormatJSON:
+		return FormatJSON, nil
+	default:
+		return "", errors.Errorf("unsupported format %s", name)
+	}
+}
+
+// PolicyReader reads the rules from a policy stream.
+type PolicyReader interface {
+	// Read returns the next rule, it returns io.EOF at the end of the stream.
+	Read() (*command.PolicyRule, error)
+}
+
+// PolicyWriter writes the rules to a policy stream.
+type PolicyWriter interface {
+	// Write writes a rule.
+	Write(rule *command.PolicyRule) error
+	// Close completes the stream, it does not close the underlying io.Writer.
+	Close() error
+}
+
+// NewPolicyReader returns a PolicyReader of the given format.
+func NewPolicyReader(r io.Reader, format Format) (PolicyReader, error) {
+	switch format {
+	case FormatCSV:
+		reader := csv.NewReader(r)
+		reader.Comment = '#'
+		reader.FieldsPerRecord = -1
+		reader.TrimLeadingSpace = true
+		reader.LazyQuotes = true
+		return &csvPolicyReader{reader: reader}, nil
+	case FormatJSON:
+		return &jsonPolicyReader{iter: jsoniter.Parse(jsoniter.ConfigDefault, r, 4096)}, nil
+	default:
+		return nil, errors.Errorf("unsupported format %s", format)
+	}
+}
+
+// NewPolicyWriter returns a PolicyWriter of the given format.
+func NewPolicyWriter(w io.Writer, format Format) (PolicyWriter, error) {
+	switch format {
+	case FormatCSV:
+		return &csvPolicyWriter{w: bufio.NewWriter(w)}, nil
+	case FormatJSON:
+		return &jsonPolicyWriter{w: bufio.NewWriter(w)}, nil
+	default:
+		return nil, errors.Errorf("unsupported format %s", format)
+	}
+}
+
+// validateRule fills the sec of a rule by its pType like casbin does, and checks the rule is complete.
+func validateRule(rule *command.PolicyRule) error {
+	if len(rule.PType) == 0 {
+		return errors.New("the pType of a rule is empty")
+	}
+	if len(rule.Sec) == 0 {
+		rule.Sec = rule.PType[:1]
+	}
+	if len(rule.Rule) == 0 {
+		return errors.Errorf("the rule of %s is empty", rule.PType)
+	}
+	return nil
+}
+
+// csvPolicyReader reads the casbin CSV policy format.
+type csvPolicyReader struct {
+	reader *csv.Reader
+	// records is the number of records read.
+	records int
+}
+
+// Read implements the PolicyReader interface.
+func (r *csvPolicyReader) Read() (*command.PolicyRule, error) {
+	for {
+		record, err := r.reader.Read()
+		if err != nil {
+			return nil, err
+		}
+		r.records++
+		for i := range record {
+			record[i] = strings.TrimSpace(record[i])
+		}
+		if len(record) == 1 && len(record[0]) == 0 {
+			continue
+		}
+		rule := &command.PolicyRule{
+			PType: record[0],
+			Rule:  record[1:],
+		}
+		err = validateRule(rule)
+		if err != nil {
+			return nil, errors.Wrapf(err, "record %d", r.records)
+		}
+		return rule, nil
+	}
+}
+
+// csvPolicyWriter writes the casbin CSV policy format.
+type csvPolicyWriter struct {
+	w *bufio.Writer
+}
+
+// Write implements the PolicyWriter interface.
+func (w *csvPolicyWriter) Write(rule *command.PolicyRule) error {
+	_, err := w.w.WriteString(rule.PType)
+	if err != nil {
+		return err
+	}
+	for _, field := range rule.Rule {
+		_, err = w.w.WriteString(", " + quoteCSVField(field))
+		if err != nil {
+			return err
+		}
+	}
+	return w.w.WriteByte('\n')
+}
+
+// Close implements the PolicyWriter interface.
+func (w *csvPolicyWriter) Close() error {
+	return w.w.Flush()
+}
+
+// quoteCSVField quotes a field if it contains a separator, a quote or a line break.
+// The leading and trailing spaces of the fields are trimmed when read, like casbin does.
+func quoteCSVField(field string) string {
+	if !strings.ContainsAny(field, ",\"\r\n#") {
+		return field
+	}
+	return `"` + strings.ReplaceAll(field, `"`, `""`) + `"`
+}
+
+// jsonPolicyReader reads a JSON array of rules without loading the whole array.
+type jsonPolicyReader struct {
+	iter *jsoniter.Iterator
+}
+
+// Read implements the PolicyReader interface.
+func (r *jsonPolicyReader) Read() (*command.PolicyRule, error) {
+	if !r.iter.ReadArray() {
+		if r.iter.Error != nil && r.iter.Error != io.EOF {
+			return nil, r.iter.Error
+		}
+		return nil, io.EOF
+	}
+	var rule command.PolicyRule
+	r.iter.ReadVal(&rule)
+	if r.iter.Error != nil {
+		return nil, r.iter.Error
+	}
+	err := validateRule(&rule)
+	if err != nil {
+		return nil, err
+	}
+	return &rule, nil
+}
+
+// jsonPolicyWriter writes a JSON array of rules.
+type jsonPolicyWriter struct {
+	w     *bufio.Writer
+	count int
+}
+
+// Write implements the PolicyWriter interface.
+func (w *jsonPolicyWriter) Write(rule *command.PolicyRule) error {
+	b, err := jsoniter.Marshal(rule)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if w.count == 0 {
+		sep = "[\n"
+	}
+	w.count++
+	_, err = w.w.WriteString(sep)
+	if err != nil {
+		return err
+	}
+	_, err = w.w.Write(b)
+	return err
+}
+
+// Close implements the PolicyWriter interface.
+func (w *jsonPolicyWriter) Close() error {
+	end := "\n]\n"
+	if w.count == 0 {
+		end = "[]\n"
+	}
+	_, err := w.w.WriteString(end)
+	if err != nil {
+		return err
+	}
+	return w.w.Flush()
+}
+
+// newImportID returns a random ID of an import.
+func newImportID() (string, error) {
+	b := make([]byte, 16)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// ImportOptions are the options of ImportPolicies.
+type ImportOptions struct {
+	// Replace stages the chunks and then replaces all rules at once, otherwise the rules are added to the current rules.
+	Replace bool
+	// Seed records that the cluster is seeded when the import is committed, it requires Replace.
+	Seed bool
+	// Progress is called with the number of rules applied after each chunk, it can be nil.
+	Progress func(rules int)
+	// Logger logs the failures to discard the staged rules, it can be nil.
+	Logger *zap.Logger
+}
+
+// ImportPolicies reads the rules and applies them to the Store in chunks, each chunk is a raft entry.
+// The import stops when ctx is done, the chunks that have been applied are kept unless options.Replace is true.
+func ImportPolicies(ctx context.Context, store Store, reader PolicyReader, options ImportOptions) (int, error) {
+	var id string
+	if options.Replace {
+		var err error
+		id, err = newImportID()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	total := 0
+	size := 0
+	request := &command.ImportPoliciesRequest{Id: id}
+	flush := func() error {
+		if len(request.Rules) == 0 {
+			return nil
+		}
+		err := store.ImportPolicies(ctx, request)
+		if err != nil {
+			return err
+		}
+		total += len(request.Rules)
+		if options.Progress != nil {
+			options.Progress(total)
+		}
+		request = &command.ImportPoliciesRequest{Id: id}
+		size = 0
+		return nil
+	}
+
+	err := func() error {
+		for {
+			rule, err := reader.Read()
+			if err == io.EOF {
+				return flush()
+			}
+			if err != nil {
+				return err
+			}
+			ruleSize := proto.Size(rule)
+			if size+ruleSize > maxImportChunkSize {
+				err = flush()
+				if err != nil {
+					return err
+				}
+			}
+			request.Rules = append(request.Rules, rule)
+			size += ruleSize
+		}
+	}()
+	if err == nil && options.Replace {
+		err = store.CommitImport(ctx, &command.CommitImportRequest{Id: id, Seed: options.Seed})
+	}
+	if err != nil && options.Replace {
+		// The staged rules are useless once the import fails, they are discarded even if ctx is done.
+		discardErr := store.CommitImport(context.Background(), &command.CommitImportRequest{Id: id, Discard: true})
+		if discardErr != nil && options.Logger != nil {
+			options.Logger.Error("failed to discard the staged rules of an import", zap.String("id", id), zap.Error(discardErr))
+		}
+	}
+	return total, err
+}
+
+// handleImport handles the request to import a policy stream.
+// The progress is streamed as newline-delimited JSON of command.ImportProgress, the last line is done or has an error.
+func (s *Service) handleImport(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	format, err := ParseFormat(query.Get("format"))
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	reader, err := NewPolicyReader(r.Body, format)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	writeProgress := func(progress *command.ImportProgress) {
+		b, err := jsoniter.Marshal(progress)
+		if err != nil {
+			s.logger.Error("failed to encode the progress of an import", zap.Error(err))
+			return
+		}
+		_, _ = w.Write(append(b, '\n'))
+		if flusher, ok := w.(http.Flusher); ok {
+			flusher.Flush()
+		}
+	}
+
+	rules, err := ImportPolicies(r.Context(), s.storeOf(r), reader, ImportOptions{
+		Replace: query.Get("replace") == "true",
+		Progress: func(rules int) {
+			writeProgress(&command.ImportProgress{Rules: int64(rules)})
+		},
+		Logger: s.logger,
+	})
+	if err != nil {
+		s.logger.Error("failed to import the policies", zap.Int("rules", rules), zap.Error(err))
+		writeProgress(&command.ImportProgress{Rules: int64(rules), Error: err.Error(), Code: string(CodeOf(err))})
+		return
+	}
+	writeProgress(&command.ImportProgress{Rules: int64(rules), Done: true})
+}
+
+// handleExport handles the request to export the rules as a policy stream.
+func (s *Service) handleExport(w http.ResponseWriter, r *http.Request) {
+	format, err := ParseFormat(r.URL.Query().Get("format"))
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	export, err := s.storeOf(r).Export()
+	if err != nil {
+		s.writeError(w, r, err)
+		return
+	}
+
+	if format == FormatJSON {
+		w.Header().Set("Content-Type", "application/json")
+	} else {
+		w.Header().Set("Content-Type", "text/csv")
+	}
+	writer, err := NewPolicyWriter(w, format)
+	if err != nil {
+		s.writeError(w, r, NewError(ErrorCodeInvalidRequest, err.Error()))
+		return
+	}
+	for _, rule := range export.Rules {
+		err = writer.Write(rule)
+		if err != nil {
+			s.logger.Error("failed to write the exported rules", zap.Error(err))
+			return
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		s.logger.Error("failed to write the exported rules", zap.Error(err))
+	}
+}
+
+// DoImportRequest streams a policy stream to the leader, which imports it in chunks.
+// The progress is called with the number of rules applied after each chunk.
+// It returns the number of rules applied.
+func (s *Service) DoImportRequest(reader io.Reader, format Format, replace bool, progress func(rules int)) (int, error) {
+	// The body is streamed, so it cannot be sent again when redirected, the leader is resolved first.
+	address, err := s.leaderAddr()
+	if err != nil {
+		return 0, err
+	}
+
+	query := url.Values{}
+	query.Set("format", string(format))
+	if replace {
+		query.Set("replace", "true")
+	}
+	r, err := s.newRequest(http.MethodPut, s.urlAt(address, "/policies/import", query), reader)
+	if err != nil {
+		return 0, err
+	}
+
+	// An import may take longer than the timeout of the other requests.
+	client := *s.httpClient
+	client.Timeout = 0
+	resp, err := client.Do(r)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return 0, ResponseError(resp)
+	}
+
+	rules := 0
+	decoder := jsoniter.NewDecoder(resp.Body)
+	for {
+		var p command.ImportProgress
+		err = decoder.Decode(&p)
+		if err == io.EOF {
+			return rules, errors.New("the import is interrupted")
+		}
+		if err != nil {
+			return rules, err
+		}
+		rules = int(p.Rules)
+		if len(p.Error) > 0 {
+			return rules, NewError(ErrorCode(p.Code), p.Error)
+		}
+		if p.Done {
+			return rules, nil
+		}
+		if progress != nil {
+			progress(rules)
+		}
+	}
+}
+
+// DoExportRequest writes the rules of the node that the requests are sent to as a policy stream.
+func (s *Service) DoExportRequest(writer io.Writer, format Format) error {
+	query := url.Values{}
+	query.Set("format", string(format))
+	r, err := s.newRequest(http.MethodGet, s.urlAt(s.Addr(), "/policies/export", query), nil)
+	if err != nil {
+		return err
+	}
+
+	client := *s.httpClient
+	client.Timeout = 0
+	resp, err := client.Do(r)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ResponseError(resp)
+	}
+
+	_, err = io.Copy(writer, resp.Body)
+	return err
+}
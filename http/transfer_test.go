@@ -0,0 +1,206 @@
+package http
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http/mocks"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPolicyReaderWriter(t *testing.T) {
+	rules := []*command.PolicyRule{
+		{Sec: "p", PType: "p", Rule: []string{"alice", "data1", "read"}},
+		{Sec: "p", PType: "p", Rule: []string{"bob", "keyMatch(\"/a, b\")", "write"}},
+		{Sec: "g", PType: "g2", Rule: []string{"alice", "admin", "domain1"}},
+	}
+
+	for _, format := range []Format{FormatCSV, FormatJSON} {
+		var buf bytes.Buffer
+		w, err := NewPolicyWriter(&buf, format)
+		assert.NoError(t, err)
+		for _, rule := range rules {
+			assert.NoError(t, w.Write(rule))
+		}
+		assert.NoError(t, w.Close())
+
+		r, err := NewPolicyReader(&buf, format)
+		assert.NoError(t, err)
+		var actual []*command.PolicyRule
+		for {
+			rule, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			assert.NoError(t, err)
+			actual = append(actual, rule)
+		}
+		assert.Len(t, actual, len(rules))
+		for i := range rules {
+			assert.Equal(t, rules[i].Sec, actual[i].Sec)
+			assert.Equal(t, rules[i].PType, actual[i].PType)
+			assert.Equal(t, rules[i].Rule, actual[i].Rule)
+		}
+	}
+
+	r, err := NewPolicyReader(strings.NewReader("# comment\n\np, alice, data1, read\n  g,alice , admin\n"), FormatCSV)
+	assert.NoError(t, err)
+	rule, err := r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"alice", "data1", "read"}, rule.Rule)
+	rule, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "g", rule.Sec)
+	assert.Equal(t, []string{"alice", "admin"}, rule.Rule)
+	_, err = r.Read()
+	assert.Equal(t, io.EOF, err)
+
+	r, err = NewPolicyReader(strings.NewReader("p\n"), FormatCSV)
+	assert.NoError(t, err)
+	_, err = r.Read()
+	assert.Error(t, err)
+
+	r, err = NewPolicyReader(strings.NewReader(`[{"pType":"p","rule":["alice"]}, 1]`), FormatJSON)
+	assert.NoError(t, err)
+	rule, err = r.Read()
+	assert.NoError(t, err)
+	assert.Equal(t, "p", rule.Sec)
+	_, err = r.Read()
+	assert.Error(t, err)
+
+	_, err = ParseFormat("xml")
+	assert.Error(t, err)
+}
+
+func TestImport(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+	s.httpClient = ts.Client()
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	store.EXPECT().Leader().Return(true, s.Addr()).AnyTimes()
+
+	var imported []*command.PolicyRule
+	store.EXPECT().ImportPolicies(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, request *command.ImportPoliciesRequest) error {
+		assert.Empty(t, request.Id)
+		imported = append(imported, request.Rules...)
+		return nil
+	})
+	var progress []int
+	rules, err := s.DoImportRequest(strings.NewReader("p, alice, data1, read\ng, alice, admin\n"), FormatCSV, false, func(rules int) {
+		progress = append(progress, rules)
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, rules)
+	assert.Equal(t, []int{2}, progress)
+	assert.Len(t, imported, 2)
+	assert.Equal(t, "g", imported[1].Sec)
+
+	// The staged rules are discarded if the import fails.
+	gomock.InOrder(
+		store.EXPECT().ImportPolicies(gomock.Any(), gomock.Any()).Return(nil),
+		store.EXPECT().CommitImport(gomock.Any(), gomock.Any()).Return(errors.New("the model does not define p2")),
+		store.EXPECT().CommitImport(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, request *command.CommitImportRequest) error {
+			assert.True(t, request.Discard)
+			return nil
+		}),
+	)
+	_, err = s.DoImportRequest(strings.NewReader(`[{"pType":"p2","rule":["alice"]}]`), FormatJSON, true, nil)
+	assert.EqualError(t, err, "the model does not define p2")
+
+	// A large import is split into several chunks.
+	var buf bytes.Buffer
+	for i := 0; i < 20000; i++ {
+		fmt.Fprintf(&buf, "p, user%d, /data/%d/%s, read\n", i, i, strings.Repeat("x", 32))
+	}
+	var id string
+	chunks := 0
+	store.EXPECT().ImportPolicies(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, request *command.ImportPoliciesRequest) error {
+		assert.NotEmpty(t, request.Id)
+		id = request.Id
+		chunks++
+		return nil
+	}).MinTimes(2)
+	store.EXPECT().CommitImport(gomock.Any(), gomock.Any()).DoAndReturn(func(ctx context.Context, request *command.CommitImportRequest) error {
+		assert.Equal(t, id, request.Id)
+		assert.False(t, request.Discard)
+		return nil
+	})
+	rules, err = s.DoImportRequest(&buf, FormatCSV, true, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 20000, rules)
+	assert.True(t, chunks > 1)
+
+	r, err := http.NewRequest(http.MethodPut, fmt.Sprintf("https://%s/policies/import?format=xml", s.Addr()), strings.NewReader(""))
+	assert.NoError(t, err)
+	resp, err := ts.Client().Do(r)
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
+func TestExport(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	store := mocks.NewMockStore(ctl)
+
+	ts := httptest.NewUnstartedServer(nil)
+	ts.EnableHTTP2 = true
+	ts.StartTLS()
+	defer ts.Close()
+
+	s, err := NewService("127.0.0.1:0", ts.TLS, store)
+	assert.NoError(t, err)
+
+	err = s.Start()
+	assert.NoError(t, err)
+	defer s.Stop(context.Background())
+
+	export := &command.NamespaceExport{
+		Rules: []*command.PolicyRule{
+			{Sec: "p", PType: "p", Rule: []string{"alice", "data1", "read"}},
+			{Sec: "g", PType: "g", Rule: []string{"alice", "admin"}},
+		},
+	}
+	store.EXPECT().Export().Return(export, nil).Times(2)
+
+	resp, err := ts.Client().Get(fmt.Sprintf("https://%s/policies/export?format=csv", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	b, err := ioutil.ReadAll(resp.Body)
+	assert.NoError(t, err)
+	assert.Equal(t, "p, alice, data1, read\ng, alice, admin\n", string(b))
+
+	resp, err = ts.Client().Get(fmt.Sprintf("https://%s/policies/export?format=json", s.Addr()))
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	var rules []*command.PolicyRule
+	err = jsoniter.NewDecoder(resp.Body).Decode(&rules)
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+	assert.Equal(t, []string{"alice", "admin"}, rules[1].Rule)
+}
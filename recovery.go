@@ -0,0 +1,78 @@
+package hraftdispatcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/store"
+	"github.com/pkg/errors"
+)
+
+// groupDataDir returns the data directory of a raft group, the first group uses the data directory itself.
+func groupDataDir(dataDir string, group int) string {
+	if group == 0 {
+		return dataDir
+	}
+	return filepath.Join(dataDir, fmt.Sprintf("group-%d", group))
+}
+
+// RecoverCluster prepares a stopped node to replace the configuration of the cluster with the servers when it starts
+// again, it is used when the quorum of the cluster is permanently lost. The node keeps its policies, the logs that are
+// not committed by the lost quorum may be applied. It must be called for each server with the same servers before
+// they are started, the configuration of every raft group is replaced. The servers share the raft listen address.
+//
+// It writes a raft peers.json to the data directory of each raft group, see store.WritePeers.
+func RecoverCluster(config *Config, servers []raft.Server) error {
+	if config == nil {
+		return errors.New("config is not provided")
+	}
+	if len(config.DataDir) == 0 {
+		return errors.New("DataDir is not provided in config")
+	}
+	if len(servers) == 0 {
+		return errors.New("servers are not provided")
+	}
+
+	groups := config.RaftGroups
+	if groups <= 0 {
+		groups = 1
+	}
+	for i := 0; i < groups; i++ {
+		dir := groupDataDir(config.DataDir, i)
+		// A node without data cannot be recovered, it is probably the wrong data directory.
+		_, err := os.Stat(filepath.Join(dir, "raft.db"))
+		if err != nil {
+			return errors.Wrapf(err, "the raft group %d has no data", i)
+		}
+		err = store.WritePeers(dir, servers)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ForceNewCluster prepares a stopped node to start as a new single-node cluster with its policies, it is used to bring
+// the service back from a surviving node when the quorum is permanently lost. The other nodes can join it again
+// after their data is removed. See RecoverCluster.
+func ForceNewCluster(config *Config) error {
+	if config == nil {
+		return errors.New("config is not provided")
+	}
+	if len(config.RaftListenAddress) == 0 {
+		return errors.New("RaftListenAddress is not provided in config")
+	}
+	serverID := config.ServerID
+	if len(serverID) == 0 {
+		serverID = config.RaftListenAddress
+	}
+	return RecoverCluster(config, []raft.Server{
+		{
+			Suffrage: raft.Voter,
+			ID:       raft.ServerID(serverID),
+			Address:  raft.ServerAddress(config.RaftListenAddress),
+		},
+	})
+}
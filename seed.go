@@ -0,0 +1,94 @@
+package hraftdispatcher
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/nodece/casbin-hraft-dispatcher/store"
+	"go.uber.org/zap"
+)
+
+// seedPendingFileName is the file in the data directory that marks a bootstrapped cluster
+// whose seeding has not been recorded yet, see Config.InitialAdapter.
+const seedPendingFileName = "seed.pending"
+
+// seedCluster seeds a bootstrapped cluster with the policies of config.InitialAdapter, unless the cluster has recorded
+// that it is seeded. The pending file is removed once the seeding is recorded, so a node that stopped before that
+// seeds the cluster on its next start.
+func seedCluster(s *store.Store, config *Config, logger *zap.Logger) error {
+	pendingPath := filepath.Join(config.DataDir, seedPendingFileName)
+	if _, err := os.Stat(pendingPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	err := s.WaitLeader()
+	if err != nil {
+		return err
+	}
+	seeded, err := s.Seeded()
+	if err != nil {
+		return err
+	}
+	if !seeded {
+		rules, err := seedPolicies(s, config.Enforcer.GetModel(), config.InitialAdapter, logger)
+		if err != nil {
+			return err
+		}
+		logger.Info("seeded the policies from the initial adapter", zap.Int("rules", rules))
+	}
+	return os.Remove(pendingPath)
+}
+
+// seedPolicies loads the policies from the adapter with the policy definitions of the model,
+// and replicates them as the rules of the default namespace. The cluster records that it is seeded
+// when the rules are committed.
+func seedPolicies(store http.Store, m model.Model, adapter persist.Adapter, logger *zap.Logger) (int, error) {
+	// The policies are loaded into an empty copy of the policy definitions, the enforcer is updated by replication only.
+	seed := model.NewModel()
+	for _, sec := range []string{"p", "g"} {
+		for key, ast := range m[sec] {
+			seed.AddDef(sec, key, ast.Value)
+		}
+	}
+	err := adapter.LoadPolicy(seed)
+	if err != nil {
+		return 0, err
+	}
+
+	reader := &rulesReader{}
+	for _, sec := range []string{"p", "g"} {
+		var keys []string
+		for key := range seed[sec] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			for _, rule := range seed[sec][key].Policy {
+				reader.rules = append(reader.rules, &command.PolicyRule{Sec: sec, PType: key, Rule: rule})
+			}
+		}
+	}
+	return http.ImportPolicies(context.Background(), store, reader, http.ImportOptions{Replace: true, Seed: true, Logger: logger})
+}
+
+// rulesReader implements the http.PolicyReader interface for a set of rules.
+type rulesReader struct {
+	rules []*command.PolicyRule
+}
+
+// Read implements the http.PolicyReader interface.
+func (r *rulesReader) Read() (*command.PolicyRule, error) {
+	if len(r.rules) == 0 {
+		return nil, io.EOF
+	}
+	rule := r.rules[0]
+	r.rules = r.rules[1:]
+	return rule, nil
+}
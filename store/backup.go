@@ -0,0 +1,242 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// backupMagic starts each section of a backup.
+var backupMagic = []byte("casbin-hraft-backup\n")
+
+// A backup is a sequence of sections, one for each raft group. A section is laid out as follows:
+//
+//	backupMagic
+//	<varint size of the header> <command.BackupHeader>
+//	<snapshot data of header.Size bytes>
+//	<SHA-256 checksum of the snapshot data>
+
+// BackupSection is the snapshot of a raft group read from a backup.
+// The snapshot data is verified and held in a temporary file until the section is closed.
+type BackupSection struct {
+	Header   *command.BackupHeader
+	data     *os.File
+	checksum []byte
+}
+
+// encodeBackupHeader encodes the beginning of a section.
+func encodeBackupHeader(header *command.BackupHeader) ([]byte, error) {
+	b, err := proto.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	buf := append([]byte{}, backupMagic...)
+	buf = protowire.AppendVarint(buf, uint64(len(b)))
+	return append(buf, b...), nil
+}
+
+// writeBackupSection writes a section with the snapshot data of header.Size bytes.
+func writeBackupSection(w io.Writer, header *command.BackupHeader, data io.Reader) error {
+	b, err := encodeBackupHeader(header)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(b)
+	if err != nil {
+		return err
+	}
+	hash := sha256.New()
+	_, err = io.CopyN(io.MultiWriter(w, hash), data, header.Size)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(hash.Sum(nil))
+	return err
+}
+
+// ReadBackupSection reads a section of a backup, it returns io.EOF if there are no more sections.
+// The reader is not read beyond the section.
+func ReadBackupSection(r io.Reader) (*BackupSection, error) {
+	magic := make([]byte, len(backupMagic))
+	_, err := io.ReadFull(r, magic)
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil || !bytes.Equal(magic, backupMagic) {
+		return nil, errors.New("the stream is not a backup")
+	}
+
+	size, err := binary.ReadUvarint(byteReader{r})
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the backup header")
+	}
+	b := make([]byte, size)
+	_, err = io.ReadFull(r, b)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to read the backup header")
+	}
+	var header command.BackupHeader
+	err = proto.Unmarshal(b, &header)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode the backup header")
+	}
+
+	f, err := ioutil.TempFile("", "casbin-hraft-backup-")
+	if err != nil {
+		return nil, err
+	}
+	section := &BackupSection{Header: &header, data: f}
+	err = func() error {
+		hash := sha256.New()
+		_, err := io.CopyN(io.MultiWriter(f, hash), r, header.Size)
+		if err != nil {
+			return errors.Wrap(err, "failed to read the snapshot of the backup")
+		}
+		section.checksum = make([]byte, sha256.Size)
+		_, err = io.ReadFull(r, section.checksum)
+		if err != nil {
+			return errors.Wrap(err, "failed to read the checksum of the backup")
+		}
+		if !bytes.Equal(section.checksum, hash.Sum(nil)) {
+			return errors.New("the checksum of the backup does not match")
+		}
+		_, err = f.Seek(0, io.SeekStart)
+		return err
+	}()
+	if err != nil {
+		section.Close()
+		return nil, err
+	}
+	return section, nil
+}
+
+// Reader returns the encoded section, which can be restored by Store.Restore.
+func (b *BackupSection) Reader() (io.Reader, error) {
+	header, err := encodeBackupHeader(b.Header)
+	if err != nil {
+		return nil, err
+	}
+	_, err = b.data.Seek(0, io.SeekStart)
+	if err != nil {
+		return nil, err
+	}
+	return io.MultiReader(bytes.NewReader(header), b.data, bytes.NewReader(b.checksum)), nil
+}
+
+// Close removes the temporary file of the section.
+func (b *BackupSection) Close() error {
+	b.data.Close()
+	return os.Remove(b.data.Name())
+}
+
+// byteReader reads a byte at a time, so that the reader is not read beyond a section.
+type byteReader struct {
+	io.Reader
+}
+
+func (r byteReader) ReadByte() (byte, error) {
+	b := make([]byte, 1)
+	_, err := io.ReadFull(r.Reader, b)
+	return b[0], err
+}
+
+// Backup takes a snapshot of the current node and writes it to the writer with its index, term and
+// configuration. The backup can be restored by Restore, or used to recover a cluster.
+func (s *Store) Backup(w io.Writer) error {
+	return s.backup(w, 0, 1)
+}
+
+// backup writes a snapshot of the current node as the section of the given raft group.
+// If nothing has been applied since the latest snapshot, the latest snapshot is written.
+func (s *Store) backup(w io.Writer, group int, groups int) error {
+	err := s.raft.Snapshot().Error()
+	if err != nil && err != raft.ErrNothingNewToSnapshot {
+		s.logger.Error("failed to take a snapshot", zap.Error(err))
+		return err
+	}
+	meta, rc, err := s.openLatestSnapshot()
+	if err != nil {
+		s.logger.Error("failed to open the snapshot", zap.Error(err))
+		return err
+	}
+	defer rc.Close()
+
+	header := &command.BackupHeader{
+		Index:              meta.Index,
+		Term:               meta.Term,
+		ConfigurationIndex: meta.ConfigurationIndex,
+		Size:               meta.Size,
+		Group:              int32(group),
+		Groups:             int32(groups),
+		ServerId:           s.serverID,
+		CreatedAt:          time.Now().Unix(),
+	}
+	for _, server := range meta.Configuration.Servers {
+		header.Configuration = append(header.Configuration, &command.BackupServer{
+			Id:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+		})
+	}
+	return writeBackupSection(w, header, rc)
+}
+
+// openLatestSnapshot opens the latest snapshot of the current node.
+func (s *Store) openLatestSnapshot() (*raft.SnapshotMeta, io.ReadCloser, error) {
+	snapshots, err := s.snapshotStore.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(snapshots) == 0 {
+		return nil, nil, errors.New("no snapshot is available")
+	}
+	return s.snapshotStore.Open(snapshots[0].ID)
+}
+
+// Restore replaces the state of the cluster with a section of a backup, the current node must be the leader.
+// The followers install the restored state as a snapshot, the configuration of the cluster is kept.
+func (s *Store) Restore(ctx context.Context, r io.Reader) error {
+	section, err := ReadBackupSection(r)
+	if err != nil {
+		return err
+	}
+	defer section.Close()
+	return s.restore(ctx, section)
+}
+
+// restore restores a section of a backup.
+func (s *Store) restore(ctx context.Context, section *BackupSection) error {
+	if s.raft.State() != raft.Leader {
+		return raftError(raft.ErrNotLeader)
+	}
+	timeout, err := applyTimeout(ctx)
+	if err != nil {
+		return err
+	}
+
+	meta := &raft.SnapshotMeta{
+		Version: raft.SnapshotVersionMax,
+		Index:   section.Header.Index,
+		Term:    section.Header.Term,
+		Size:    section.Header.Size,
+	}
+	err = s.raft.Restore(meta, section.data, timeout)
+	if err != nil {
+		s.logger.Error("failed to restore the backup", zap.Error(err), zap.Uint64("index", section.Header.Index))
+		return raftError(err)
+	}
+	s.logger.Info("restored the backup", zap.Uint64("index", section.Header.Index), zap.Uint64("term", section.Header.Term))
+	return nil
+}
@@ -0,0 +1,169 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupSection(t *testing.T) {
+	var buf bytes.Buffer
+	for i := 0; i < 2; i++ {
+		data := strings.Repeat("x", i*100)
+		err := writeBackupSection(&buf, &command.BackupHeader{
+			Index:  uint64(10 + i),
+			Size:   int64(len(data)),
+			Group:  int32(i),
+			Groups: 2,
+			Configuration: []*command.BackupServer{
+				{Id: "node", Address: "127.0.0.1:6790", Suffrage: raft.Voter.String()},
+			},
+		}, strings.NewReader(data))
+		assert.NoError(t, err)
+	}
+	backup := buf.Bytes()
+
+	// The sections are read one by one from the stream.
+	r := bytes.NewReader(backup)
+	for i := 0; i < 2; i++ {
+		section, err := ReadBackupSection(r)
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(10+i), section.Header.Index)
+		assert.Equal(t, int32(i), section.Header.Group)
+		assert.Equal(t, "node", section.Header.Configuration[0].Id)
+
+		// The encoded section is the same as the original one.
+		reader, err := section.Reader()
+		assert.NoError(t, err)
+		b, err := ioutil.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.True(t, bytes.HasPrefix(backup, b) || bytes.HasSuffix(backup, b))
+		assert.NoError(t, section.Close())
+	}
+	_, err := ReadBackupSection(r)
+	assert.Equal(t, io.EOF, err)
+
+	// The second section holds 100 bytes of data followed by the checksum.
+	second := bytes.LastIndex(backup, backupMagic)
+	corrupted := append([]byte{}, backup[second:]...)
+	corrupted[len(corrupted)-sha256.Size-1] ^= 0xff
+	_, err = ReadBackupSection(bytes.NewReader(corrupted))
+	assert.EqualError(t, err, "the checksum of the backup does not match")
+
+	_, err = ReadBackupSection(bytes.NewReader(backup[second : len(backup)-1]))
+	assert.Error(t, err)
+
+	_, err = ReadBackupSection(strings.NewReader("p, alice, data1, read\n"))
+	assert.EqualError(t, err, "the stream is not a backup")
+}
+
+func TestStore_BackupRestore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	e, err := casbin.NewDistributedEnforcer(m)
+	assert.NoError(t, err)
+
+	s, err := NewStore(&Config{
+		ID:  "node",
+		Dir: dir,
+		NetworkTransportConfig: &raft.NetworkTransportConfig{
+			Stream:  newTCPStreamLayer(t),
+			MaxPool: 5,
+			Timeout: 10 * time.Second,
+		},
+		Enforcer: e,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start(true))
+	defer s.Stop()
+	assert.NoError(t, s.WaitLeader())
+
+	addPolicy := func(rule ...string) {
+		err := s.AddPolicies(context.Background(), &command.AddPoliciesRequest{
+			Sec:   "p",
+			PType: "p",
+			Rules: []*command.StringArray{{Items: rule}},
+		})
+		assert.NoError(t, err)
+	}
+
+	addPolicy("alice", "data1", "read")
+	var buf bytes.Buffer
+	err = s.Backup(&buf)
+	assert.NoError(t, err)
+
+	section, err := ReadBackupSection(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.Equal(t, "node", section.Header.ServerId)
+	assert.Equal(t, int32(1), section.Header.Groups)
+	assert.True(t, section.Header.Index > 0)
+	assert.Len(t, section.Header.Configuration, 1)
+	assert.Equal(t, raft.Voter.String(), section.Header.Configuration[0].Suffrage)
+	assert.NoError(t, section.Close())
+
+	// Without a new log since the latest snapshot, the latest snapshot is backed up.
+	var again bytes.Buffer
+	err = s.Backup(&again)
+	assert.NoError(t, err)
+	section, err = ReadBackupSection(bytes.NewReader(again.Bytes()))
+	assert.NoError(t, err)
+	assert.NoError(t, section.Close())
+	index := section.Header.Index
+	section, err = ReadBackupSection(bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	assert.NoError(t, section.Close())
+	assert.Equal(t, section.Header.Index, index)
+
+	addPolicy("bob", "data2", "write")
+	ok, err := e.Enforce("bob", "data2", "write")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	err = s.Restore(context.Background(), bytes.NewReader(buf.Bytes()))
+	assert.NoError(t, err)
+	ok, err = e.Enforce("bob", "data2", "write")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = e.Enforce("alice", "data1", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// The restored state is kept by the later commands and snapshots.
+	addPolicy("carol", "data3", "read")
+	assert.NoError(t, s.Backup(ioutil.Discard))
+	ok, err = e.Enforce("carol", "data3", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// Stop can be called again after the store is stopped.
+	assert.NoError(t, s.Stop())
+	assert.NoError(t, s.Stop())
+}
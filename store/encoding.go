@@ -0,0 +1,362 @@
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"strconv"
+
+	jsoniter "github.com/json-iterator/go"
+	"github.com/pkg/errors"
+	bolt "go.etcd.io/bbolt"
+)
+
+// The layout of the database:
+//
+//	meta/
+//	  version -> layoutVersion
+//	  model -> <model text>
+//	  seeded -> 1, if the cluster is seeded with the policies of an initial adapter
+//	  sink_cursor -> <index of the last log mirrored by the sink of the leader>
+//	policy_rules/
+//	  <sec>/
+//	    <pType>/
+//	      rules/
+//	        <encoded rule> -> <sequence>
+//	      order/
+//	        <sequence> -> <encoded rule>
+//	namespaces/
+//	  <namespace>/
+//	    meta/
+//	      model -> <model text>
+//	      frozen -> 1, if the namespace is being moved
+//	    policy_rules/
+//	      ...
+//	routes/
+//	  <namespace> -> <raft group>
+//	imports/
+//	  <import id>/
+//	    term -> <raft term of the first chunk>
+//	    <sequence> -> <encoded sec, pType and rule>
+//	requests/
+//	  <request id> -> <RequestRecord>
+//	request_index/
+//	  <log index> -> <request id>
+//
+// The default namespace uses the top-level buckets, each other namespace has its own
+// meta, policy_rules and imports buckets with the same layout.
+// The imports bucket stages the rules of an import that replaces all rules, until it is committed,
+// the raft term of its first chunk tells the imports left behind by a previous leader.
+// The requests bucket holds the results of the recent commands with a request ID of all namespaces,
+// the request_index bucket orders them by their log index, so the old ones are evicted first.
+// A rule is encoded as a list of fields, each field is prefixed by its length in uvarint.
+// Because every field is self-delimited, the encoded leading fields of a rule are a prefix
+// of the encoded rule, which allows prefix scans by the leading fields.
+// A sequence is encoded as a big-endian uint64, so the order bucket keeps the rules in insertion order.
+const (
+	// legacyLayoutVersion is the layout which uses the JSON-encoded rule as the key in the policy bucket.
+	legacyLayoutVersion uint64 = 1
+	// layoutVersion is the current layout of the database.
+	layoutVersion uint64 = 2
+)
+
+var (
+	metaBucketName         = []byte("meta")
+	versionKey             = []byte("version")
+	modelKey               = []byte("model")
+	frozenKey              = []byte("frozen")
+	seededKey              = []byte("seeded")
+	sinkCursorMetaKey      = []byte("sink_cursor")
+	rulesBucketName        = []byte("rules")
+	orderBucketName        = []byte("order")
+	namespacesBucketName   = []byte("namespaces")
+	routesBucketName       = []byte("routes")
+	importsBucketName      = []byte("imports")
+	importTermKey          = []byte("term")
+	requestsBucketName     = []byte("requests")
+	requestIndexBucketName = []byte("request_index")
+
+	errInvalidRuleKey = errors.New("invalid rule key")
+)
+
+// encodeRule encodes the fields of a rule.
+func encodeRule(rule []string) []byte {
+	size := 0
+	for _, field := range rule {
+		size += binary.MaxVarintLen64 + len(field)
+	}
+
+	buf := make([]byte, 0, size)
+	var l [binary.MaxVarintLen64]byte
+	for _, field := range rule {
+		n := binary.PutUvarint(l[:], uint64(len(field)))
+		buf = append(buf, l[:n]...)
+		buf = append(buf, field...)
+	}
+	return buf
+}
+
+// decodeRule decodes the fields of a rule.
+func decodeRule(b []byte) ([]string, error) {
+	var rule []string
+	for len(b) > 0 {
+		size, n := binary.Uvarint(b)
+		if n <= 0 || uint64(len(b)-n) < size {
+			return nil, errInvalidRuleKey
+		}
+		b = b[n:]
+		rule = append(rule, string(b[:size]))
+		b = b[size:]
+	}
+	return rule, nil
+}
+
+// encodeSequence encodes a sequence.
+func encodeSequence(seq uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, seq)
+	return b
+}
+
+// decodeSequence decodes a sequence.
+func decodeSequence(b []byte) uint64 {
+	if len(b) != 8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// readLayoutVersion returns the layout version of the database.
+// A database without the version is a legacy database.
+func readLayoutVersion(tx *bolt.Tx) uint64 {
+	bkt := tx.Bucket(metaBucketName)
+	if bkt == nil {
+		return legacyLayoutVersion
+	}
+	v := bkt.Get(versionKey)
+	if v == nil {
+		return legacyLayoutVersion
+	}
+	return decodeSequence(v)
+}
+
+// writeLayoutVersion writes the layout version of the database.
+func writeLayoutVersion(tx *bolt.Tx, version uint64) error {
+	bkt, err := tx.CreateBucketIfNotExists(metaBucketName)
+	if err != nil {
+		return err
+	}
+	return bkt.Put(versionKey, encodeSequence(version))
+}
+
+// migrateLegacyLayout moves the JSON-encoded rules in the policy bucket to the nested buckets.
+// The sequence of each rule is kept.
+func migrateLegacyLayout(tx *bolt.Tx) error {
+	root := tx.Bucket(policyBucketName)
+
+	type legacyRule struct {
+		key   []byte
+		rule  Rule
+		value uint64
+	}
+	var rules []legacyRule
+	err := root.ForEach(func(k, v []byte) error {
+		// Skip the nested buckets.
+		if v == nil {
+			return nil
+		}
+		var rule Rule
+		err := jsoniter.Unmarshal(k, &rule)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode the legacy rule %s", k)
+		}
+		value, err := strconv.ParseUint(string(v), 10, 64)
+		if err != nil {
+			return errors.Wrapf(err, "failed to decode the sequence of legacy rule %s", k)
+		}
+		rules = append(rules, legacyRule{key: append([]byte(nil), k...), rule: rule, value: value})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, item := range rules {
+		err := root.Delete(item.key)
+		if err != nil {
+			return err
+		}
+		bkt, err := createRuleBucket(root, item.rule.Sec, item.rule.PType)
+		if err != nil {
+			return err
+		}
+		err = bkt.put(encodeRule(item.rule.Rule), item.value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writeLayoutVersion(tx, layoutVersion)
+}
+
+// ruleBuckets holds the buckets of the rules of a sec and pType.
+type ruleBuckets struct {
+	// rules maps an encoded rule to its sequence.
+	rules *bolt.Bucket
+	// order maps a sequence to its encoded rule.
+	order *bolt.Bucket
+}
+
+// sequence returns the sequence of the encoded rule.
+func (b *ruleBuckets) sequence(key []byte) (uint64, bool) {
+	v := b.rules.Get(key)
+	if v == nil {
+		return 0, false
+	}
+	return decodeSequence(v), true
+}
+
+// put puts the encoded rule with the given sequence.
+func (b *ruleBuckets) put(key []byte, seq uint64) error {
+	err := b.rules.Put(key, encodeSequence(seq))
+	if err != nil {
+		return err
+	}
+	return b.order.Put(encodeSequence(seq), key)
+}
+
+// delete deletes the encoded rule.
+func (b *ruleBuckets) delete(key []byte) error {
+	seq, ok := b.sequence(key)
+	if !ok {
+		return nil
+	}
+	err := b.rules.Delete(key)
+	if err != nil {
+		return err
+	}
+	return b.order.Delete(encodeSequence(seq))
+}
+
+// ruleBucket returns the buckets under the policy bucket that hold the rules of the given sec and pType.
+// It returns nil if the buckets do not exist.
+func ruleBucket(root *bolt.Bucket, sec, pType string) *ruleBuckets {
+	secBkt := root.Bucket([]byte(sec))
+	if secBkt == nil {
+		return nil
+	}
+	bkt := secBkt.Bucket([]byte(pType))
+	if bkt == nil {
+		return nil
+	}
+	return &ruleBuckets{
+		rules: bkt.Bucket(rulesBucketName),
+		order: bkt.Bucket(orderBucketName),
+	}
+}
+
+// createRuleBucket creates the buckets under the policy bucket that hold the rules of the given sec and pType
+// if they do not exist.
+func createRuleBucket(root *bolt.Bucket, sec, pType string) (*ruleBuckets, error) {
+	secBkt, err := root.CreateBucketIfNotExists([]byte(sec))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s bucket", sec)
+	}
+	bkt, err := secBkt.CreateBucketIfNotExists([]byte(pType))
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s bucket", pType)
+	}
+	rules, err := bkt.CreateBucketIfNotExists(rulesBucketName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s bucket", rulesBucketName)
+	}
+	order, err := bkt.CreateBucketIfNotExists(orderBucketName)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to create %s bucket", orderBucketName)
+	}
+	return &ruleBuckets{rules: rules, order: order}, nil
+}
+
+// forEachRule calls fn for each rule under the policy bucket.
+// The rules of each sec and pType are visited in insertion order.
+func forEachRule(root *bolt.Bucket, fn func(sec, pType string, seq uint64, k []byte) error) error {
+	return root.ForEach(func(sec, v []byte) error {
+		secBkt := root.Bucket(sec)
+		if secBkt == nil {
+			return nil
+		}
+		return secBkt.ForEach(func(pType, v []byte) error {
+			bkt := ruleBucket(root, string(sec), string(pType))
+			if bkt == nil || bkt.order == nil {
+				return nil
+			}
+			return bkt.order.ForEach(func(seq, k []byte) error {
+				return fn(string(sec), string(pType), decodeSequence(seq), k)
+			})
+		})
+	})
+}
+
+// forEachFilteredRule calls fn for each rule in the buckets that matches a pattern.
+// An empty field value matches any value. The rules are visited in insertion order.
+func forEachFilteredRule(bkt *ruleBuckets, fieldIndex int, fieldValues []string, fn func(k []byte, rule []string) error) error {
+	if len(fieldValues) == 0 {
+		return nil
+	}
+
+	type matchedRule struct {
+		seq  uint64
+		key  []byte
+		rule []string
+	}
+	var matched []matchedRule
+
+	// The leading non-empty values starting at the first field form a key prefix.
+	var prefix []byte
+	if fieldIndex == 0 {
+		var fields []string
+		for _, value := range fieldValues {
+			if value == "" {
+				break
+			}
+			fields = append(fields, value)
+		}
+		prefix = encodeRule(fields)
+	}
+
+	c := bkt.rules.Cursor()
+	for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); k, v = c.Next() {
+		rule, err := decodeRule(k)
+		if err != nil {
+			return err
+		}
+		if !matchRule(rule, fieldIndex, fieldValues) {
+			continue
+		}
+		matched = append(matched, matchedRule{seq: decodeSequence(v), key: k, rule: rule})
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		return matched[i].seq < matched[j].seq
+	})
+	for _, item := range matched {
+		err := fn(item.key, item.rule)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// matchRule checks whether the rule matches a pattern.
+func matchRule(rule []string, fieldIndex int, fieldValues []string) bool {
+	for i, value := range fieldValues {
+		if value == "" {
+			continue
+		}
+		if fieldIndex+i >= len(rule) || rule[fieldIndex+i] != value {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,90 @@
+package store
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/golang/mock/gomock"
+	jsoniter "github.com/json-iterator/go"
+	"github.com/nodece/casbin-hraft-dispatcher/store/mocks"
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestEncodeRule(t *testing.T) {
+	rules := [][]string{
+		{"role:admin", "/", "*"},
+		{"", "/", ""},
+		{"alice"},
+	}
+	for _, rule := range rules {
+		actual, err := decodeRule(encodeRule(rule))
+		assert.NoError(t, err)
+		assert.Equal(t, rule, actual)
+	}
+
+	assert.True(t, bytes.HasPrefix(encodeRule([]string{"role:admin", "/", "*"}), encodeRule([]string{"role:admin", "/"})))
+	assert.False(t, bytes.HasPrefix(encodeRule([]string{"role:administrator", "/", "*"}), encodeRule([]string{"role:admin"})))
+
+	_, err := decodeRule([]byte{10, 'a'})
+	assert.Equal(t, errInvalidRuleKey, err)
+}
+
+func TestPolicyOperator_MigrateLegacyLayout(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(filepath.Join(dir, databaseFilename), 0666, nil)
+	assert.NoError(t, err)
+	err = db.Update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucket(policyBucketName)
+		if err != nil {
+			return err
+		}
+		for i, rule := range []Rule{
+			{Sec: "p", PType: "p", Rule: []string{"role:admin", "/", "*"}},
+			{Sec: "g", PType: "g", Rule: []string{"alice", "role:admin"}},
+		} {
+			key, err := jsoniter.Marshal(rule)
+			if err != nil {
+				return err
+			}
+			err = bkt.Put(key, []byte{byte('1' + i)})
+			if err != nil {
+				return err
+			}
+		}
+		return bkt.SetSequence(2)
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, db.Close())
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	rules, err := p.readRules()
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []Rule{
+		{Sec: "p", PType: "p", Rule: []string{"role:admin", "/", "*"}},
+		{Sec: "g", PType: "g", Rule: []string{"alice", "role:admin"}},
+	}, rules)
+
+	err = p.db.View(func(tx *bolt.Tx) error {
+		assert.Equal(t, layoutVersion, readLayoutVersion(tx))
+		assert.Equal(t, uint64(2), tx.Bucket(policyBucketName).Sequence())
+		seq, ok := ruleBucket(tx.Bucket(policyBucketName), "p", "p").sequence(encodeRule([]string{"role:admin", "/", "*"}))
+		assert.True(t, ok)
+		assert.Equal(t, uint64(1), seq)
+		return nil
+	})
+	assert.NoError(t, err)
+}
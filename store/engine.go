@@ -3,44 +3,90 @@ package store
 import (
 	"bytes"
 	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"hash"
 	"io"
 	"io/ioutil"
 	"path/filepath"
-	"strconv"
+	"sort"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/casbin/casbin/v2"
-	jsoniter "github.com/json-iterator/go"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
 	"github.com/pkg/errors"
 	bolt "go.etcd.io/bbolt"
 	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
 	databaseFilename = "casbin.db"
+	ruleKeySeparator = "\x00"
+	// requestRetention is the number of log entries that the result of a command with a request ID is kept for,
+	// a retry that comes later is applied again.
+	requestRetention = 10000
 )
 
 var (
 	policyBucketName = []byte("policy_rules")
+
+	errRuleNotFound      = errors.New("the rule does not exist")
+	errNamespaceNotFound = errors.New("the namespace does not exist")
+	errNamespaceExists   = errors.New("the namespace already exists with a different model")
+	errNamespaceFrozen   = errors.New("the namespace is being moved to another raft group")
 )
 
+// bucketContainer is implemented by bolt.Tx and bolt.Bucket,
+// it holds the buckets of a namespace.
+type bucketContainer interface {
+	Bucket(name []byte) *bolt.Bucket
+	CreateBucket(name []byte) (*bolt.Bucket, error)
+	CreateBucketIfNotExists(name []byte) (*bolt.Bucket, error)
+	DeleteBucket(name []byte) error
+}
+
 // PolicyOperator is used to update policies and provide persistence.
+// A PolicyOperator serves a namespace, the operators of all namespaces share the database and the lock.
 type PolicyOperator struct {
 	enforcer casbin.IDistributedEnforcer
 	db       *bolt.DB
-	l        *sync.Mutex
+	l        *sync.RWMutex
 	logger   *zap.Logger
+
+	// namespace is empty for the default namespace.
+	namespace string
+	// namespaces holds the operators of all namespaces.
+	namespaces map[string]*PolicyOperator
+	// onModelChange is called after a model is set on enforcer, it is nil if no call is needed.
+	onModelChange func(e casbin.IDistributedEnforcer)
+	// staged is the record of the request that is being applied, it is shared by the operators of all namespaces.
+	staged *stagedRequest
+}
+
+// stagedRequest is the record of the command with a request ID that is being applied,
+// it is written in the transaction of the changes of the command, see update.
+type stagedRequest struct {
+	id     string
+	record *command.RequestRecord
+	// written reports whether the record has been committed with the changes of the command.
+	written bool
 }
 
 // NewPolicyOperator returns a PolicyOperator.
 func NewPolicyOperator(path string, e casbin.IDistributedEnforcer) (*PolicyOperator, error) {
 	p := &PolicyOperator{
-		enforcer: e,
-		l:        &sync.Mutex{},
-		logger:   zap.NewExample(),
+		enforcer:   e,
+		l:          &sync.RWMutex{},
+		logger:     zap.NewExample(),
+		namespaces: make(map[string]*PolicyOperator),
+		staged:     &stagedRequest{},
 	}
+	p.namespaces[""] = p
 	dbPath := filepath.Join(path, databaseFilename)
 	if err := p.openDBFile(dbPath); err != nil {
 		return nil, errors.Wrapf(err, "failed to open bolt file")
@@ -62,7 +108,52 @@ func (p *PolicyOperator) openDBFile(dbPath string) error {
 
 	p.db = boltDB
 
-	return p.createBucket(policyBucketName)
+	err = p.createBucket(policyBucketName)
+	if err != nil {
+		return err
+	}
+	err = p.createBucket(namespacesBucketName)
+	if err != nil {
+		return err
+	}
+	err = p.createBucket(routesBucketName)
+	if err != nil {
+		return err
+	}
+	err = p.createBucket(requestsBucketName)
+	if err != nil {
+		return err
+	}
+	err = p.createBucket(requestIndexBucketName)
+	if err != nil {
+		return err
+	}
+
+	return p.migrate()
+}
+
+// Close closes the database.
+func (p *PolicyOperator) Close() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	return p.db.Close()
+}
+
+// migrate upgrades the layout of the database to the current version.
+func (p *PolicyOperator) migrate() error {
+	return p.db.Update(func(tx *bolt.Tx) error {
+		version := readLayoutVersion(tx)
+		switch version {
+		case layoutVersion:
+			return nil
+		case legacyLayoutVersion:
+			p.logger.Info("migrating the database from the legacy layout", zap.Uint64("version", layoutVersion))
+			return migrateLegacyLayout(tx)
+		default:
+			return errors.Errorf("unsupported database layout version %d", version)
+		}
+	})
 }
 
 // Restore is used to restore a database from io.ReadCloser.
@@ -112,8 +203,8 @@ func (p *PolicyOperator) Restore(rc io.ReadCloser) error {
 
 // Backup writes the database to bytes with gzip.
 func (p *PolicyOperator) Backup() ([]byte, error) {
-	p.l.Lock()
-	defer p.l.Unlock()
+	p.l.RLock()
+	defer p.l.RUnlock()
 
 	writer := new(bytes.Buffer)
 	gz, err := gzip.NewWriterLevel(writer, gzip.BestCompression)
@@ -136,6 +227,64 @@ func (p *PolicyOperator) Backup() ([]byte, error) {
 	return writer.Bytes(), nil
 }
 
+// Checksum returns a SHA-256 checksum of the rules stored in database.
+// The rules are visited in a fixed order, so the result is deterministic for the same content.
+func (p *PolicyOperator) Checksum() ([]byte, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	h := sha256.New()
+	err := p.db.View(func(tx *bolt.Tx) error {
+		err := writeChecksum(h, tx)
+		if err != nil {
+			return err
+		}
+		// The namespaces are visited in the order of their names.
+		err = tx.Bucket(namespacesBucketName).ForEach(func(name, v []byte) error {
+			writeChecksumPart(h, name)
+			return writeChecksum(h, tx.Bucket(namespacesBucketName).Bucket(name))
+		})
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(routesBucketName).ForEach(func(name, group []byte) error {
+			writeChecksumPart(h, name)
+			writeChecksumPart(h, group)
+			return nil
+		})
+	})
+	if err != nil {
+		p.logger.Error("failed to calculate the checksum", zap.Error(err))
+		return nil, err
+	}
+
+	return h.Sum(nil), nil
+}
+
+// writeChecksum writes the model and the rules of a namespace to the hash.
+func writeChecksum(h hash.Hash, c bucketContainer) error {
+	var text []byte
+	if bkt := c.Bucket(metaBucketName); bkt != nil {
+		text = bkt.Get(modelKey)
+	}
+	writeChecksumPart(h, text)
+	return forEachRule(c.Bucket(policyBucketName), func(sec, pType string, seq uint64, k []byte) error {
+		for _, b := range [][]byte{[]byte(sec), []byte(pType), encodeSequence(seq), k} {
+			writeChecksumPart(h, b)
+		}
+		return nil
+	})
+}
+
+// writeChecksumPart writes a part of the state to the hash, prefixed by its length,
+// so the boundaries of the parts are part of the checksum.
+func writeChecksumPart(h hash.Hash, b []byte) {
+	var size [8]byte
+	binary.BigEndian.PutUint64(size[:], uint64(len(b)))
+	h.Write(size[:])
+	h.Write(b)
+}
+
 // createBucket creates a bucket with the given name.
 func (p *PolicyOperator) createBucket(name []byte) error {
 	return p.db.Update(func(tx *bolt.Tx) error {
@@ -147,242 +296,1079 @@ func (p *PolicyOperator) createBucket(name []byte) error {
 	})
 }
 
-// LoadPolicy clears the policies held by enforcer, and loads policy from database.
-func (p *PolicyOperator) LoadPolicy() error {
+// container returns the bucket container of the namespace.
+func (p *PolicyOperator) container(tx *bolt.Tx) bucketContainer {
+	if len(p.namespace) == 0 {
+		return tx
+	}
+	return tx.Bucket(namespacesBucketName).Bucket([]byte(p.namespace))
+}
+
+// policyBucket returns the policy bucket of the namespace.
+func (p *PolicyOperator) policyBucket(tx *bolt.Tx) *bolt.Bucket {
+	return p.container(tx).Bucket(policyBucketName)
+}
+
+// newNamespaceOperator returns an operator of the namespace that shares the database and the lock.
+func (p *PolicyOperator) newNamespaceOperator(name string, e casbin.IDistributedEnforcer) *PolicyOperator {
+	return &PolicyOperator{
+		enforcer:   e,
+		db:         p.db,
+		l:          p.l,
+		logger:     p.logger.With(zap.String("namespace", name)),
+		namespace:  name,
+		namespaces: p.namespaces,
+		staged:     p.staged,
+	}
+}
+
+// Namespace returns the operator of the namespace, an empty name means the default namespace.
+func (p *PolicyOperator) Namespace(name string) (*PolicyOperator, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	op, ok := p.namespaces[name]
+	if !ok {
+		return nil, errors.Wrapf(errNamespaceNotFound, "namespace %s", name)
+	}
+	return op, nil
+}
+
+// Namespaces returns the names of the namespaces except the default namespace in order.
+func (p *PolicyOperator) Namespaces() []string {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	var names []string
+	for name := range p.namespaces {
+		if len(name) > 0 {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// CreateNamespace creates a namespace with its own model, enforcer and buckets.
+// Creating an existing namespace with the same model does nothing.
+func (p *PolicyOperator) CreateNamespace(name, text string) error {
+	if len(name) == 0 {
+		return errors.New("namespace cannot be empty")
+	}
+	m, err := model.NewModelFromString(text)
+	if err != nil {
+		return errors.Wrap(err, "invalid model")
+	}
+
 	p.l.Lock()
 	defer p.l.Unlock()
 
-	err := p.enforcer.ClearPolicySelf(nil)
+	if op, ok := p.namespaces[name]; ok {
+		current, err := op.readModel()
+		if err != nil {
+			return err
+		}
+		if current != text {
+			return errors.Wrapf(errNamespaceExists, "namespace %s", name)
+		}
+		return nil
+	}
+
+	e, err := casbin.NewDistributedEnforcer(m)
 	if err != nil {
-		p.logger.Error("failed to call loadPolicy", zap.Error(err))
 		return err
 	}
 
-	err = p.db.View(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(policyBucketName)
-		err := bkt.ForEach(func(k, v []byte) error {
-			var rule Rule
-			err := jsoniter.Unmarshal(k, &rule)
-			if err != nil {
-				return err
-			}
-
-			_, err = p.enforcer.AddPoliciesSelf(nil, rule.Sec, rule.PType, [][]string{rule.Rule})
+	err = p.update(func(tx *bolt.Tx) error {
+		bkt, err := tx.Bucket(namespacesBucketName).CreateBucket([]byte(name))
+		if err != nil {
 			return err
-		})
-		return err
+		}
+		_, err = bkt.CreateBucket(policyBucketName)
+		if err != nil {
+			return err
+		}
+		meta, err := bkt.CreateBucket(metaBucketName)
+		if err != nil {
+			return err
+		}
+		return meta.Put(modelKey, []byte(text))
 	})
 	if err != nil {
 		p.logger.Error("failed to persist to database", zap.Error(err))
+		return err
 	}
 
-	return err
+	p.namespaces[name] = p.newNamespaceOperator(name, e)
+	return nil
 }
 
-// AddPolicies adds a set of rules.
-func (p *PolicyOperator) AddPolicies(sec, pType string, rules [][]string) error {
+// DeleteNamespace deletes a namespace with its enforcer and buckets.
+// Deleting a namespace that does not exist does nothing.
+func (p *PolicyOperator) DeleteNamespace(name string) error {
+	if len(name) == 0 {
+		return errors.New("the default namespace cannot be deleted")
+	}
+
 	p.l.Lock()
 	defer p.l.Unlock()
 
-	effected, err := p.enforcer.AddPoliciesSelf(nil, sec, pType, rules)
+	err := p.update(func(tx *bolt.Tx) error {
+		err := tx.Bucket(namespacesBucketName).DeleteBucket([]byte(name))
+		if err == bolt.ErrBucketNotFound {
+			return nil
+		}
+		return err
+	})
 	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
 		return err
 	}
-	if len(effected) == 0 {
-		return nil
-	}
 
-	err = p.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(policyBucketName)
-		for _, item := range rules {
-			key, err := newRuleBytes(sec, pType, item)
-			if err != nil {
-				return err
-			}
+	delete(p.namespaces, name)
+	return nil
+}
 
-			value, err := bkt.NextSequence()
-			if err != nil {
-				return err
-			}
+// FreezeNamespace freezes or unfreezes a namespace, the commands to a frozen namespace are rejected by FSM,
+// so it is not changed while it is moved to another raft group.
+func (p *PolicyOperator) FreezeNamespace(name string, frozen bool) error {
+	if len(name) == 0 {
+		return errors.New("the default namespace cannot be frozen")
+	}
 
-			err = bkt.Put(key, []byte(strconv.FormatUint(value, 10)))
-			if err != nil {
-				return err
-			}
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	op, ok := p.namespaces[name]
+	if !ok {
+		return errors.Wrapf(errNamespaceNotFound, "namespace %s", name)
+	}
+	err := p.update(func(tx *bolt.Tx) error {
+		bkt, err := op.container(tx).CreateBucketIfNotExists(metaBucketName)
+		if err != nil {
+			return err
 		}
-		return nil
+		if frozen {
+			return bkt.Put(frozenKey, []byte{1})
+		}
+		return bkt.Delete(frozenKey)
 	})
 	if err != nil {
 		p.logger.Error("failed to persist to database", zap.Error(err))
 	}
-
 	return err
 }
 
-// RemovePolicies removes a set of rules.
-func (p *PolicyOperator) RemovePolicies(sec, pType string, rules [][]string) error {
-	p.l.Lock()
-	defer p.l.Unlock()
-
-	effected, err := p.enforcer.RemovePoliciesSelf(nil, sec, pType, rules)
-	if err != nil {
-		p.logger.Error("failed to call RemovePolicySelf", zap.Error(err))
-		return err
-	}
-	if len(effected) == 0 {
-		return nil
+// Frozen returns whether the namespace is frozen, the default namespace is never frozen.
+func (p *PolicyOperator) Frozen() (bool, error) {
+	if len(p.namespace) == 0 {
+		return false, nil
 	}
 
-	err = p.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(policyBucketName)
-		for _, item := range rules {
-			key, err := newRuleBytes(sec, pType, item)
-			if err != nil {
-				return err
-			}
+	p.l.RLock()
+	defer p.l.RUnlock()
 
-			err = bkt.Delete(key)
-			if err != nil {
-				return err
-			}
-		}
+	var frozen bool
+	err := p.db.View(func(tx *bolt.Tx) error {
+		bkt := p.container(tx).Bucket(metaBucketName)
+		frozen = bkt != nil && bkt.Get(frozenKey) != nil
 		return nil
 	})
+	return frozen, err
+}
 
-	return err
+// Seeded returns whether the namespace has been seeded by an import, see CommitImport.
+func (p *PolicyOperator) Seeded() (bool, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	var seeded bool
+	err := p.db.View(func(tx *bolt.Tx) error {
+		bkt := p.container(tx).Bucket(metaBucketName)
+		seeded = bkt != nil && bkt.Get(seededKey) != nil
+		return nil
+	})
+	return seeded, err
 }
 
-// RemoveFilteredPolicy removes a set of rules that match a pattern.
-func (p *PolicyOperator) RemoveFilteredPolicy(sec string, pType string, fieldIndex int, fieldValues ...string) error {
+// SetSinkCursor records the index of the last log mirrored by the sink, a smaller index than the recorded one is ignored.
+func (p *PolicyOperator) SetSinkCursor(index uint64) error {
 	p.l.Lock()
 	defer p.l.Unlock()
 
-	effected, err := p.enforcer.RemoveFilteredPolicySelf(nil, sec, pType, fieldIndex, fieldValues...)
+	err := p.update(func(tx *bolt.Tx) error {
+		bkt, err := tx.CreateBucketIfNotExists(metaBucketName)
+		if err != nil {
+			return err
+		}
+		if b := bkt.Get(sinkCursorMetaKey); b != nil && decodeSequence(b) >= index {
+			return nil
+		}
+		return bkt.Put(sinkCursorMetaKey, encodeSequence(index))
+	})
 	if err != nil {
-		p.logger.Error("failed to call RemoveFilteredPolicySelf", zap.Error(err))
-		return err
-	}
-	if len(effected) == 0 {
-		return nil
+		p.logger.Error("failed to persist to database", zap.Error(err))
 	}
+	return err
+}
 
-	err = p.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(policyBucketName)
-		for _, item := range effected {
-			key, err := newRuleBytes(sec, pType, item)
-			if err != nil {
-				return err
-			}
+// SinkCursor returns the index of the last log mirrored by the sink, see SetSinkCursor.
+func (p *PolicyOperator) SinkCursor() (uint64, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
 
-			err = bkt.Delete(key)
-			if err != nil {
-				return err
+	var index uint64
+	err := p.db.View(func(tx *bolt.Tx) error {
+		if bkt := tx.Bucket(metaBucketName); bkt != nil {
+			if b := bkt.Get(sinkCursorMetaKey); b != nil {
+				index = decodeSequence(b)
 			}
 		}
 		return nil
 	})
-	if err != nil {
-		p.logger.Error("failed to persist to database", zap.Error(err))
-	}
-
-	return err
+	return index, err
 }
 
-//UpdatePolicy replaces an existing rule.
-func (p *PolicyOperator) UpdatePolicy(sec, pType string, oldRule, newRule []string) error {
+// LoadNamespaces rebuilds the enforcers of the namespaces from database.
+func (p *PolicyOperator) LoadNamespaces() error {
 	p.l.Lock()
 	defer p.l.Unlock()
 
-	effected, err := p.enforcer.UpdatePolicySelf(nil, sec, pType, oldRule, newRule)
+	var names []string
+	models := make(map[string]string)
+	err := p.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(namespacesBucketName).ForEach(func(name, v []byte) error {
+			names = append(names, string(name))
+			if meta := tx.Bucket(namespacesBucketName).Bucket(name).Bucket(metaBucketName); meta != nil {
+				models[string(name)] = string(meta.Get(modelKey))
+			}
+			return nil
+		})
+	})
 	if err != nil {
-		p.logger.Error("failed to call UpdatePolicySelf", zap.Error(err))
+		p.logger.Error("failed to read namespaces from database", zap.Error(err))
 		return err
 	}
-	if effected == false {
-		return nil
-	}
 
-	err = p.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(policyBucketName)
-
-		newKey, err := newRuleBytes(sec, pType, newRule)
+	for name := range p.namespaces {
+		if len(name) > 0 {
+			delete(p.namespaces, name)
+		}
+	}
+	for _, name := range names {
+		m, err := model.NewModelFromString(models[name])
 		if err != nil {
-			return err
+			return errors.Wrapf(err, "failed to parse the model of namespace %s", name)
 		}
-		value, err := bkt.NextSequence()
+		e, err := casbin.NewDistributedEnforcer(m)
 		if err != nil {
 			return err
 		}
-
-		err = bkt.Put(newKey, []byte(strconv.FormatUint(value, 10)))
+		op := p.newNamespaceOperator(name, e)
+		rules, err := op.readRules()
 		if err != nil {
 			return err
 		}
-
-		oldKey, err := newRuleBytes(sec, pType, oldRule)
+		err = op.loadPolicy(rules)
 		if err != nil {
 			return err
 		}
-		return bkt.Delete(oldKey)
-	})
-	if err != nil {
-		p.logger.Error("failed to persist to database", zap.Error(err))
+		p.namespaces[name] = op
 	}
-
-	return err
+	return nil
 }
 
-//UpdatePolicies replaces a set of existing rule.
-func (p *PolicyOperator) UpdatePolicies(sec, pType string, oldRules, newRules [][]string) error {
+// SetRoute routes a namespace to a raft group, it overrides the group given by the hash of the namespace.
+func (p *PolicyOperator) SetRoute(namespace string, group int) error {
+	if group < 0 {
+		return errors.New("group cannot be negative")
+	}
+
 	p.l.Lock()
 	defer p.l.Unlock()
 
-	effected, err := p.enforcer.UpdatePoliciesSelf(nil, sec, pType, oldRules, newRules)
+	err := p.update(func(tx *bolt.Tx) error {
+		return tx.Bucket(routesBucketName).Put([]byte(namespace), encodeSequence(uint64(group)))
+	})
 	if err != nil {
-		p.logger.Error("failed to call UpdatePoliciesSelf", zap.Error(err))
-		return err
-	}
-	if effected == false {
-		return nil
+		p.logger.Error("failed to persist to database", zap.Error(err))
 	}
+	return err
+}
 
-	err = p.db.Update(func(tx *bolt.Tx) error {
-		bkt := tx.Bucket(policyBucketName)
+// Routes returns the routes that are set by SetRoute.
+func (p *PolicyOperator) Routes() (map[string]int, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
 
-		for _, newRule := range newRules {
-			newKey, err := newRuleBytes(sec, pType, newRule)
-			if err != nil {
-				return err
-			}
-			value, err := bkt.NextSequence()
-			if err != nil {
-				return err
-			}
+	routes := make(map[string]int)
+	err := p.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(routesBucketName).ForEach(func(name, group []byte) error {
+			routes[string(name)] = int(decodeSequence(group))
+			return nil
+		})
+	})
+	return routes, err
+}
 
-			err = bkt.Put(newKey, []byte(strconv.FormatUint(value, 10)))
-			if err != nil {
-				return err
-			}
-		}
+// Request returns the recorded result of the command with the request ID, it is nil if none is recorded.
+func (p *PolicyOperator) Request(id string) (*command.RequestRecord, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
 
-		for _, oldRule := range oldRules {
-			oldKey, err := newRuleBytes(sec, pType, oldRule)
-			if err != nil {
-				return err
-			}
-			return bkt.Delete(oldKey)
+	var record *command.RequestRecord
+	err := p.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket(requestsBucketName).Get([]byte(id))
+		if b == nil {
+			return nil
 		}
-		return nil
+		record = &command.RequestRecord{}
+		return proto.Unmarshal(b, record)
+	})
+	return record, err
+}
+
+// RecordRequest records the result of the command with the request ID, the results of the commands that are
+// requestRetention log entries older than it are evicted.
+func (p *PolicyOperator) RecordRequest(id string, record *command.RequestRecord) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		return putRequest(tx, id, record)
 	})
 	if err != nil {
 		p.logger.Error("failed to persist to database", zap.Error(err))
 	}
-
-	return nil
+	return err
 }
 
-// ClearPolicy clears all rules.
-func (p *PolicyOperator) ClearPolicy() error {
+// StageRequest stages the record of the command with the request ID that is applied next,
+// it is written by the first transaction that changes the database, so that the changes and the record are committed
+// together. UnstageRequest must be called after the command is applied.
+func (p *PolicyOperator) StageRequest(id string, record *command.RequestRecord) {
+	p.l.Lock()
+	defer p.l.Unlock()
+	*p.staged = stagedRequest{id: id, record: record}
+}
+
+// UnstageRequest drops the staged record, and reports whether it has been committed with the changes of the command.
+func (p *PolicyOperator) UnstageRequest() bool {
+	p.l.Lock()
+	defer p.l.Unlock()
+	written := p.staged.written
+	*p.staged = stagedRequest{}
+	return written
+}
+
+// update runs fn in a read-write transaction, which also writes the staged record of a request if it is not written yet.
+func (p *PolicyOperator) update(fn func(tx *bolt.Tx) error) error {
+	staged := p.staged
+	writes := len(staged.id) > 0 && !staged.written
+	err := p.db.Update(func(tx *bolt.Tx) error {
+		err := fn(tx)
+		if err != nil || !writes {
+			return err
+		}
+		return putRequest(tx, staged.id, staged.record)
+	})
+	if err == nil && writes {
+		staged.written = true
+	}
+	return err
+}
+
+// putRequest writes the record of a request, and evicts the records that are requestRetention log entries older than it.
+func putRequest(tx *bolt.Tx, id string, record *command.RequestRecord) error {
+	b, err := proto.Marshal(record)
+	if err != nil {
+		return err
+	}
+	requests := tx.Bucket(requestsBucketName)
+	index := tx.Bucket(requestIndexBucketName)
+	if record.Index > requestRetention {
+		c := index.Cursor()
+		for k, v := c.First(); k != nil && decodeSequence(k) <= record.Index-requestRetention; k, v = c.First() {
+			err := requests.Delete(v)
+			if err != nil {
+				return err
+			}
+			err = c.Delete()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	err = index.Put(encodeSequence(record.Index), []byte(id))
+	if err != nil {
+		return err
+	}
+	return requests.Put([]byte(id), b)
+}
+
+// Enforce decides whether a subject can access an object with the enforcer of the namespace.
+func (p *PolicyOperator) Enforce(rvals ...interface{}) (bool, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	return p.enforcer.Enforce(rvals...)
+}
+
+// LoadPolicy clears the policies held by enforcer, and loads policy from database.
+// Before reloading, it compares the enforcer with the database and reports any difference.
+func (p *PolicyOperator) LoadPolicy() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	rules, err := p.readRules()
+	if err != nil {
+		p.logger.Error("failed to read rules from database", zap.Error(err))
+		return err
+	}
+
+	diff := p.diff(rules)
+	if !diff.IsEmpty() {
+		p.logger.Warn("the enforcer is inconsistent with the database",
+			zap.Int("missing", len(diff.Missing)),
+			zap.Int("unexpected", len(diff.Unexpected)),
+			zap.Any("diff", diff))
+	}
+
+	return p.loadPolicy(rules)
+}
+
+// loadPolicy clears the policies held by enforcer, and loads the given rules.
+// The role managers are cleared and the role links are rebuilt from the loaded grouping rules,
+// so a link of a grouping rule that is not loaded does not survive the reload.
+func (p *PolicyOperator) loadPolicy(rules []Rule) error {
+	err := p.enforcer.ClearPolicySelf(nil)
+	if err != nil {
+		p.logger.Error("failed to call loadPolicy", zap.Error(err))
+		return err
+	}
+
+	for _, rule := range rules {
+		_, err = p.enforcer.AddPoliciesSelf(nil, rule.Sec, rule.PType, [][]string{rule.Rule})
+		if err != nil {
+			p.logger.Error("failed to load policy from database", zap.Error(err))
+			return err
+		}
+	}
+
+	err = p.enforcer.BuildRoleLinks()
+	if err != nil {
+		p.logger.Error("failed to build the role links", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// LoadModel installs the model stored in database on enforcer.
+// If no model is stored, the model of enforcer is kept.
+func (p *PolicyOperator) LoadModel() error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	text, err := p.readModel()
+	if err != nil {
+		p.logger.Error("failed to read the model from database", zap.Error(err))
+		return err
+	}
+	if len(text) == 0 {
+		return nil
+	}
+
+	m, err := model.NewModelFromString(text)
+	if err != nil {
+		p.logger.Error("failed to parse the model stored in database", zap.Error(err))
+		return err
+	}
+	p.installModel(m)
+	return nil
+}
+
+// installModel sets the model on enforcer. casbin resets the role managers, the watcher, the effector and the flags
+// of an enforcer when its model is set, so the model is only set if it differs from the model of enforcer,
+// the role manager of g is kept, and onModelChange is called to set the others again.
+func (p *PolicyOperator) installModel(m model.Model) {
+	if sameModel(p.enforcer.GetModel(), m) {
+		return
+	}
+
+	rm := p.enforcer.GetRoleManager()
+	p.enforcer.SetModel(m)
+	if _, ok := m["g"]["g"]; ok && rm != nil {
+		p.enforcer.SetRoleManager(rm)
+	}
+	if p.onModelChange != nil {
+		p.onModelChange(p.enforcer)
+	}
+}
+
+// sameModel returns whether two models have the same definitions.
+func sameModel(a, b model.Model) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for sec, asts := range a {
+		other, ok := b[sec]
+		if !ok || len(asts) != len(other) {
+			return false
+		}
+		for key, ast := range asts {
+			o, ok := other[key]
+			if !ok || o.Value != ast.Value {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// SetModel validates the model text, persists it and installs it on enforcer.
+// The policies are reloaded from database into the new model.
+func (p *PolicyOperator) SetModel(text string) error {
+	m, err := model.NewModelFromString(text)
+	if err != nil {
+		return errors.Wrap(err, "invalid model")
+	}
+
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	rules, err := p.readRules()
+	if err != nil {
+		p.logger.Error("failed to read rules from database", zap.Error(err))
+		return err
+	}
+	for _, rule := range rules {
+		if _, ok := m[rule.Sec][rule.PType]; !ok {
+			return errors.Errorf("the model does not define %s of the stored rules in section %s", rule.PType, rule.Sec)
+		}
+	}
+
+	err = p.update(func(tx *bolt.Tx) error {
+		bkt, err := p.container(tx).CreateBucketIfNotExists(metaBucketName)
+		if err != nil {
+			return err
+		}
+		return bkt.Put(modelKey, []byte(text))
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+		return err
+	}
+
+	p.installModel(m)
+	return p.loadPolicy(rules)
+}
+
+// Model returns the model text stored in database.
+// It returns an empty string if no model is stored.
+func (p *PolicyOperator) Model() (string, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	return p.readModel()
+}
+
+// readModel reads the model text from database.
+func (p *PolicyOperator) readModel() (string, error) {
+	var text string
+	err := p.db.View(func(tx *bolt.Tx) error {
+		bkt := p.container(tx).Bucket(metaBucketName)
+		if bkt == nil {
+			return nil
+		}
+		text = string(bkt.Get(modelKey))
+		return nil
+	})
+	return text, err
+}
+
+// Export returns the model text and the rules of the namespace in insertion order.
+func (p *PolicyOperator) Export() (string, []Rule, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	text, err := p.readModel()
+	if err != nil {
+		return "", nil, err
+	}
+	rules, err := p.readRules()
+	if err != nil {
+		return "", nil, err
+	}
+	return text, rules, nil
+}
+
+// CheckConsistency compares the policies held by enforcer with the policies stored in database.
+func (p *PolicyOperator) CheckConsistency() (*PolicyDiff, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	rules, err := p.readRules()
+	if err != nil {
+		p.logger.Error("failed to read rules from database", zap.Error(err))
+		return nil, err
+	}
+	return p.diff(rules), nil
+}
+
+// readRules reads all rules from database in insertion order.
+func (p *PolicyOperator) readRules() ([]Rule, error) {
+	var rules []Rule
+	err := p.db.View(func(tx *bolt.Tx) error {
+		return forEachRule(p.policyBucket(tx), func(sec, pType string, seq uint64, k []byte) error {
+			rule, err := decodeRule(k)
+			if err != nil {
+				return err
+			}
+			rules = append(rules, Rule{Sec: sec, PType: pType, Rule: rule})
+			return nil
+		})
+	})
+	return rules, err
+}
+
+// GetFilteredPolicy returns the rules that match a pattern from database.
+// If the pattern starts at the first field, only the rules with the matching prefix are visited.
+func (p *PolicyOperator) GetFilteredPolicy(sec string, pType string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	var rules [][]string
+	err := p.db.View(func(tx *bolt.Tx) error {
+		bkt := ruleBucket(p.policyBucket(tx), sec, pType)
+		if bkt == nil {
+			return nil
+		}
+		return forEachFilteredRule(bkt, fieldIndex, fieldValues, func(k []byte, rule []string) error {
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	if err != nil {
+		p.logger.Error("failed to read rules from database", zap.Error(err))
+	}
+	return rules, err
+}
+
+// diff compares the given rules with the policies held by enforcer.
+func (p *PolicyOperator) diff(rules []Rule) *PolicyDiff {
+	diff := &PolicyDiff{}
+
+	stored := make(map[string]struct{}, len(rules))
+	for _, rule := range rules {
+		stored[rule.key()] = struct{}{}
+	}
+
+	held := make(map[string]struct{})
+	m := p.enforcer.GetModel()
+	for _, sec := range []string{"p", "g"} {
+		for pType, ast := range m[sec] {
+			for _, item := range ast.Policy {
+				rule := Rule{Sec: sec, PType: pType, Rule: item}
+				held[rule.key()] = struct{}{}
+				if _, ok := stored[rule.key()]; !ok {
+					diff.Unexpected = append(diff.Unexpected, rule)
+				}
+			}
+		}
+	}
+
+	for _, rule := range rules {
+		if _, ok := held[rule.key()]; !ok {
+			diff.Missing = append(diff.Missing, rule)
+		}
+	}
+
+	return diff
+}
+
+// AddPolicies adds a set of rules.
+func (p *PolicyOperator) AddPolicies(sec, pType string, rules [][]string) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	effected, err := p.enforcer.AddPoliciesSelf(nil, sec, pType, rules)
+	if err != nil {
+		return err
+	}
+	if len(effected) == 0 {
+		return nil
+	}
+
+	err = p.update(func(tx *bolt.Tx) error {
+		bkt, err := createRuleBucket(p.policyBucket(tx), sec, pType)
+		if err != nil {
+			return err
+		}
+		for _, item := range effected {
+			value, err := p.policyBucket(tx).NextSequence()
+			if err != nil {
+				return err
+			}
+
+			err = bkt.put(encodeRule(item), value)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+	}
+
+	return err
+}
+
+// RemovePolicies removes a set of rules.
+func (p *PolicyOperator) RemovePolicies(sec, pType string, rules [][]string) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	effected, err := p.enforcer.RemovePoliciesSelf(nil, sec, pType, rules)
+	if err != nil {
+		p.logger.Error("failed to call RemovePolicySelf", zap.Error(err))
+		return err
+	}
+	if len(effected) == 0 {
+		return nil
+	}
+
+	err = p.update(func(tx *bolt.Tx) error {
+		bkt := ruleBucket(p.policyBucket(tx), sec, pType)
+		if bkt == nil {
+			return nil
+		}
+		for _, item := range effected {
+			err := bkt.delete(encodeRule(item))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+	}
+
+	return err
+}
+
+// RemoveFilteredPolicy removes a set of rules that match a pattern.
+func (p *PolicyOperator) RemoveFilteredPolicy(sec string, pType string, fieldIndex int, fieldValues ...string) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	return p.removeFilteredPolicy(sec, pType, fieldIndex, fieldValues...)
+}
+
+// removeFilteredPolicy removes a set of rules that match a pattern, the caller must hold the lock.
+func (p *PolicyOperator) removeFilteredPolicy(sec string, pType string, fieldIndex int, fieldValues ...string) error {
+	effected, err := p.enforcer.RemoveFilteredPolicySelf(nil, sec, pType, fieldIndex, fieldValues...)
+	if err != nil {
+		p.logger.Error("failed to call RemoveFilteredPolicySelf", zap.Error(err))
+		return err
+	}
+	if len(effected) == 0 {
+		return nil
+	}
+
+	err = p.update(func(tx *bolt.Tx) error {
+		bkt := ruleBucket(p.policyBucket(tx), sec, pType)
+		if bkt == nil {
+			return nil
+		}
+		var keys [][]byte
+		err := forEachFilteredRule(bkt, fieldIndex, fieldValues, func(k []byte, rule []string) error {
+			keys = append(keys, append([]byte(nil), k...))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, key := range keys {
+			err := bkt.delete(key)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+	}
+
+	return err
+}
+
+// AssignRole assigns a role to a user by adding a grouping rule of the given pType.
+func (p *PolicyOperator) AssignRole(pType, user, role string, domain ...string) error {
+	rule := append([]string{user, role}, domain...)
+	return p.AddPolicies("g", pType, [][]string{rule})
+}
+
+// UnassignRole unassigns a role from a user by removing a grouping rule of the given pType.
+func (p *PolicyOperator) UnassignRole(pType, user, role string, domain ...string) error {
+	rule := append([]string{user, role}, domain...)
+	return p.RemovePolicies("g", pType, [][]string{rule})
+}
+
+// DeleteRole deletes a role in all domains, including the grouping rules that assign the role
+// or let the role inherit other roles, and the policy rules whose subject is the role.
+func (p *PolicyOperator) DeleteRole(role string) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	gTypes, err := p.readPTypes("g")
+	if err != nil {
+		p.logger.Error("failed to read the grouping types from database", zap.Error(err))
+		return err
+	}
+	pTypes, err := p.readPTypes("p")
+	if err != nil {
+		p.logger.Error("failed to read the policy types from database", zap.Error(err))
+		return err
+	}
+
+	for _, pType := range gTypes {
+		err = p.removeFilteredPolicy("g", pType, 1, role)
+		if err != nil {
+			return err
+		}
+		err = p.removeFilteredPolicy("g", pType, 0, role)
+		if err != nil {
+			return err
+		}
+	}
+	for _, pType := range pTypes {
+		err = p.removeFilteredPolicy("p", pType, 0, role)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GetUsersForRole returns the users that have the role directly.
+func (p *PolicyOperator) GetUsersForRole(role string, domain ...string) ([]string, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	return p.enforcer.GetUsersForRole(role, domain...)
+}
+
+// GetRolesForUser returns the roles that the user has directly.
+func (p *PolicyOperator) GetRolesForUser(user string, domain ...string) ([]string, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	return p.enforcer.GetRolesForUser(user, domain...)
+}
+
+// GetImplicitRolesForUser returns the roles that the user has directly or through role inheritance.
+func (p *PolicyOperator) GetImplicitRolesForUser(user string, domain ...string) ([]string, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	return p.enforcer.GetImplicitRolesForUser(user, domain...)
+}
+
+// readPTypes reads the pTypes of the given sec that have rules stored in database.
+func (p *PolicyOperator) readPTypes(sec string) ([]string, error) {
+	var pTypes []string
+	err := p.db.View(func(tx *bolt.Tx) error {
+		secBkt := p.policyBucket(tx).Bucket([]byte(sec))
+		if secBkt == nil {
+			return nil
+		}
+		return secBkt.ForEach(func(k, v []byte) error {
+			if v == nil {
+				pTypes = append(pTypes, string(k))
+			}
+			return nil
+		})
+	})
+	return pTypes, err
+}
+
+//UpdatePolicy replaces an existing rule.
+func (p *PolicyOperator) UpdatePolicy(sec, pType string, oldRule, newRule []string) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	effected, err := p.enforcer.UpdatePolicySelf(nil, sec, pType, oldRule, newRule)
+	if err != nil {
+		p.logger.Error("failed to call UpdatePolicySelf", zap.Error(err))
+		return err
+	}
+	if effected == false {
+		return nil
+	}
+
+	err = p.update(func(tx *bolt.Tx) error {
+		bkt, err := createRuleBucket(p.policyBucket(tx), sec, pType)
+		if err != nil {
+			return err
+		}
+
+		return replaceRule(p.policyBucket(tx), bkt, encodeRule(oldRule), encodeRule(newRule))
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+	}
+
+	return err
+}
+
+//UpdatePolicies replaces a set of existing rule.
+func (p *PolicyOperator) UpdatePolicies(sec, pType string, oldRules, newRules [][]string) error {
+	if len(oldRules) != len(newRules) {
+		return errors.New("the number of old rules and new rules must be the same")
+	}
+
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	effected, err := p.enforcer.UpdatePoliciesSelf(nil, sec, pType, oldRules, newRules)
+	if err != nil {
+		p.logger.Error("failed to call UpdatePoliciesSelf", zap.Error(err))
+		return err
+	}
+	if effected == false {
+		return nil
+	}
+
+	err = p.update(func(tx *bolt.Tx) error {
+		bkt, err := createRuleBucket(p.policyBucket(tx), sec, pType)
+		if err != nil {
+			return err
+		}
+
+		for i, oldRule := range oldRules {
+			err := replaceRule(p.policyBucket(tx), bkt, encodeRule(oldRule), encodeRule(newRules[i]))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+	}
+
+	return err
+}
+
+// MovePolicy moves a rule to the given position among the rules of the same sec and pType.
+// The position is zero-based, a position beyond the last rule moves the rule to the end.
+func (p *PolicyOperator) MovePolicy(sec, pType string, rule []string, position int) error {
+	if position < 0 {
+		return errors.New("position cannot be negative")
+	}
+
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	// tail is the moved rule and the rules after it in the new order.
+	var tail [][]string
+	err := p.update(func(tx *bolt.Tx) error {
+		bkt := ruleBucket(p.policyBucket(tx), sec, pType)
+		if bkt == nil {
+			return errRuleNotFound
+		}
+		key := encodeRule(rule)
+		if _, ok := bkt.sequence(key); !ok {
+			return errRuleNotFound
+		}
+
+		// The sequences of the rules are reassigned in the new order,
+		// so the set of sequences is kept and only the moved range is rewritten.
+		var seqs []uint64
+		var keys, ordered [][]byte
+		err := bkt.order.ForEach(func(seq, k []byte) error {
+			seqs = append(seqs, decodeSequence(seq))
+			keys = append(keys, append([]byte(nil), k...))
+			if !bytes.Equal(k, key) {
+				ordered = append(ordered, keys[len(keys)-1])
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		if position > len(ordered) {
+			position = len(ordered)
+		}
+		ordered = append(ordered[:position], append([][]byte{key}, ordered[position:]...)...)
+
+		for i, k := range ordered {
+			if i >= position {
+				item, err := decodeRule(k)
+				if err != nil {
+					return err
+				}
+				tail = append(tail, item)
+			}
+			if bytes.Equal(keys[i], k) {
+				continue
+			}
+			err := bkt.put(k, seqs[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+		return err
+	}
+
+	return p.movePolicySelf(sec, pType, tail)
+}
+
+// movePolicySelf moves a rule in the policy held by enforcer, the enforcer only appends the rules,
+// so the moved rule and the rules after it are removed and added back in the new order.
+func (p *PolicyOperator) movePolicySelf(sec, pType string, tail [][]string) error {
+	_, err := p.enforcer.RemovePoliciesSelf(nil, sec, pType, tail)
+	if err != nil {
+		p.logger.Error("failed to call RemovePoliciesSelf", zap.Error(err))
+		return err
+	}
+	_, err = p.enforcer.AddPoliciesSelf(nil, sec, pType, tail)
+	if err != nil {
+		p.logger.Error("failed to call AddPoliciesSelf", zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// replaceRule replaces the old rule with the new rule, the new rule takes the position of the old rule.
+// If the old rule does not exist, the new rule is appended.
+func replaceRule(root *bolt.Bucket, bkt *ruleBuckets, oldKey, newKey []byte) error {
+	seq, ok := bkt.sequence(oldKey)
+	if !ok {
+		value, err := root.NextSequence()
+		if err != nil {
+			return err
+		}
+		seq = value
+	}
+
+	err := bkt.delete(oldKey)
+	if err != nil {
+		return err
+	}
+	return bkt.put(newKey, seq)
+}
+
+// ClearPolicy clears all rules.
+func (p *PolicyOperator) ClearPolicy() error {
 	p.l.Lock()
 	defer p.l.Unlock()
 
@@ -392,15 +1378,75 @@ func (p *PolicyOperator) ClearPolicy() error {
 		return err
 	}
 
-	err = p.db.Update(func(tx *bolt.Tx) error {
-		err := tx.DeleteBucket(policyBucketName)
+	err = p.update(func(tx *bolt.Tx) error {
+		c := p.container(tx)
+		err := c.DeleteBucket(policyBucketName)
+		if err != nil {
+			return err
+		}
+		_, err = c.CreateBucket(policyBucketName)
 		if err != nil {
 			return err
 		}
-		_, err = tx.CreateBucket(policyBucketName)
+		return nil
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+	}
+
+	return err
+}
+
+// ImportPolicies imports a chunk of rules in the given raft term.
+// If id is empty, the rules are added to the current rules. Otherwise the rules are staged
+// until the import is committed by CommitImport, which replaces all rules at once.
+func (p *PolicyOperator) ImportPolicies(id string, term uint64, rules []Rule) error {
+	if len(id) == 0 {
+		// The consecutive rules of the same sec and pType are added at once.
+		for i := 0; i < len(rules); {
+			var batch [][]string
+			j := i
+			for ; j < len(rules) && rules[j].Sec == rules[i].Sec && rules[j].PType == rules[i].PType; j++ {
+				batch = append(batch, rules[j].Rule)
+			}
+			err := p.AddPolicies(rules[i].Sec, rules[i].PType, batch)
+			if err != nil {
+				return err
+			}
+			i = j
+		}
+		return nil
+	}
+
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	err := p.update(func(tx *bolt.Tx) error {
+		imports, err := p.container(tx).CreateBucketIfNotExists(importsBucketName)
 		if err != nil {
 			return err
 		}
+		bkt := imports.Bucket([]byte(id))
+		if bkt == nil {
+			bkt, err = imports.CreateBucket([]byte(id))
+			if err != nil {
+				return err
+			}
+			err = bkt.Put(importTermKey, encodeSequence(term))
+			if err != nil {
+				return err
+			}
+		}
+		for _, rule := range rules {
+			seq, err := bkt.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = bkt.Put(encodeSequence(seq), encodeRule(append([]string{rule.Sec, rule.PType}, rule.Rule...)))
+			if err != nil {
+				return err
+			}
+		}
 		return nil
 	})
 	if err != nil {
@@ -410,22 +1456,211 @@ func (p *PolicyOperator) ClearPolicy() error {
 	return err
 }
 
+// CommitImport replaces all rules with the staged rules of an import in a transaction, and reloads the enforcer.
+// The duplicated rules are imported once. If discard is true, the staged rules are dropped instead.
+// If seed is true, the namespace is marked as seeded in the same transaction, see Seeded.
+func (p *PolicyOperator) CommitImport(id string, discard, seed bool) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	if discard {
+		err := p.update(func(tx *bolt.Tx) error {
+			return deleteImport(p.container(tx), id)
+		})
+		if err != nil {
+			p.logger.Error("failed to persist to database", zap.Error(err))
+		}
+		return err
+	}
+
+	var rules []Rule
+	err := p.db.View(func(tx *bolt.Tx) error {
+		imports := p.container(tx).Bucket(importsBucketName)
+		if imports == nil {
+			return nil
+		}
+		bkt := imports.Bucket([]byte(id))
+		if bkt == nil {
+			return nil
+		}
+		seen := make(map[string]struct{})
+		return bkt.ForEach(func(k, v []byte) error {
+			if bytes.Equal(k, importTermKey) {
+				return nil
+			}
+			fields, err := decodeRule(v)
+			if err != nil {
+				return err
+			}
+			if len(fields) < 2 {
+				return errInvalidRuleKey
+			}
+			rule := Rule{Sec: fields[0], PType: fields[1], Rule: fields[2:]}
+			if _, ok := seen[rule.key()]; ok {
+				return nil
+			}
+			seen[rule.key()] = struct{}{}
+			rules = append(rules, rule)
+			return nil
+		})
+	})
+	if err != nil {
+		p.logger.Error("failed to read the imported rules from database", zap.Error(err))
+		return err
+	}
+
+	m := p.enforcer.GetModel()
+	for _, rule := range rules {
+		if _, ok := m[rule.Sec][rule.PType]; !ok {
+			return errors.Errorf("the model does not define %s of the imported rules in section %s", rule.PType, rule.Sec)
+		}
+	}
+
+	err = p.update(func(tx *bolt.Tx) error {
+		c := p.container(tx)
+		err := c.DeleteBucket(policyBucketName)
+		if err != nil {
+			return err
+		}
+		root, err := c.CreateBucket(policyBucketName)
+		if err != nil {
+			return err
+		}
+		for _, rule := range rules {
+			bkt, err := createRuleBucket(root, rule.Sec, rule.PType)
+			if err != nil {
+				return err
+			}
+			seq, err := root.NextSequence()
+			if err != nil {
+				return err
+			}
+			err = bkt.put(encodeRule(rule.Rule), seq)
+			if err != nil {
+				return err
+			}
+		}
+		if seed {
+			meta, err := c.CreateBucketIfNotExists(metaBucketName)
+			if err != nil {
+				return err
+			}
+			err = meta.Put(seededKey, []byte{1})
+			if err != nil {
+				return err
+			}
+		}
+		return deleteImport(c, id)
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+		return err
+	}
+
+	return p.loadPolicy(rules)
+}
+
+// deleteImport deletes the staged rules of an import.
+func deleteImport(c bucketContainer, id string) error {
+	imports := c.Bucket(importsBucketName)
+	if imports == nil || imports.Bucket([]byte(id)) == nil {
+		return nil
+	}
+	return imports.DeleteBucket([]byte(id))
+}
+
+// DiscardImports discards the staged rules of the imports of all namespaces that were started before the raft term.
+func (p *PolicyOperator) DiscardImports(term uint64) error {
+	p.l.Lock()
+	defer p.l.Unlock()
+
+	err := p.update(func(tx *bolt.Tx) error {
+		return forEachNamespaceContainer(tx, func(c bucketContainer) error {
+			for _, id := range staleImports(c, term) {
+				err := c.Bucket(importsBucketName).DeleteBucket(id)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		p.logger.Error("failed to persist to database", zap.Error(err))
+	}
+	return err
+}
+
+// HasStaleImports returns whether a namespace has an import that was started before the raft term.
+func (p *PolicyOperator) HasStaleImports(term uint64) (bool, error) {
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	var stale bool
+	err := p.db.View(func(tx *bolt.Tx) error {
+		return forEachNamespaceContainer(tx, func(c bucketContainer) error {
+			stale = stale || len(staleImports(c, term)) > 0
+			return nil
+		})
+	})
+	return stale, err
+}
+
+// staleImports returns the IDs of the imports of a namespace that were started before the raft term.
+func staleImports(c bucketContainer, term uint64) [][]byte {
+	imports := c.Bucket(importsBucketName)
+	if imports == nil {
+		return nil
+	}
+	var ids [][]byte
+	_ = imports.ForEach(func(k, v []byte) error {
+		bkt := imports.Bucket(k)
+		if bkt == nil {
+			return nil
+		}
+		if b := bkt.Get(importTermKey); b == nil || decodeSequence(b) < term {
+			ids = append(ids, append([]byte(nil), k...))
+		}
+		return nil
+	})
+	return ids
+}
+
+// forEachNamespaceContainer calls fn with the bucket container of every namespace, the default namespace first.
+func forEachNamespaceContainer(tx *bolt.Tx, fn func(c bucketContainer) error) error {
+	err := fn(tx)
+	if err != nil {
+		return err
+	}
+	namespaces := tx.Bucket(namespacesBucketName)
+	if namespaces == nil {
+		return nil
+	}
+	return namespaces.ForEach(func(name, v []byte) error {
+		return fn(namespaces.Bucket(name))
+	})
+}
+
 type Rule struct {
 	Sec   string   `json:"sec"`
 	PType string   `json:"p_type"`
 	Rule  []string `json:"rule"`
 }
 
-func newRuleBytes(sec, pType string, rule []string) ([]byte, error) {
-	r := Rule{
-		Sec:   sec,
-		PType: pType,
-		Rule:  rule,
-	}
+// key returns a string that identifies the rule.
+func (r Rule) key() string {
+	return strings.Join(append([]string{r.Sec, r.PType}, r.Rule...), ruleKeySeparator)
+}
 
-	key, err := jsoniter.Marshal(r)
-	if err != nil {
-		return nil, err
-	}
-	return key, nil
+// PolicyDiff describes the difference between the enforcer and the database.
+type PolicyDiff struct {
+	// Missing holds the rules that are stored in database but not held by enforcer.
+	Missing []Rule `json:"missing"`
+	// Unexpected holds the rules that are held by enforcer but not stored in database.
+	Unexpected []Rule `json:"unexpected"`
+}
+
+// IsEmpty checks whether there is no difference.
+func (d *PolicyDiff) IsEmpty() bool {
+	return len(d.Missing) == 0 && len(d.Unexpected) == 0
 }
@@ -6,10 +6,16 @@ import (
 	"os"
 	"path"
 	"testing"
+	"time"
 
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	defaultrolemanager "github.com/casbin/casbin/v2/rbac/default-role-manager"
 	"github.com/golang/mock/gomock"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
 	"github.com/nodece/casbin-hraft-dispatcher/store/mocks"
 	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
 )
 
 //go:generate mockgen -destination ./mocks/mock_distributed_enforcer.go -package mocks github.com/casbin/casbin/v2 IDistributedEnforcer
@@ -103,7 +109,9 @@ func TestPolicyOperator_LoadPolicy(t *testing.T) {
 	err = p.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}, {"role:user", "/", "GET"}})
 	assert.NoError(t, err)
 
+	e.EXPECT().GetModel().Return(model.Model{})
 	e.EXPECT().ClearPolicySelf(nil)
+	e.EXPECT().BuildRoleLinks()
 	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/", "*"}})
 	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:user", "/", "GET"}})
 	err = p.LoadPolicy()
@@ -134,9 +142,818 @@ func TestPolicyOperator_Backup_Restore(t *testing.T) {
 	err = p.Restore(ioutil.NopCloser(bytes.NewBuffer(b)))
 	assert.NoError(t, err)
 
+	e.EXPECT().GetModel().Return(model.Model{})
 	e.EXPECT().ClearPolicySelf(nil)
+	e.EXPECT().BuildRoleLinks()
 	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/", "*"}})
 	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:user", "/", "GET"}})
 	err = p.LoadPolicy()
 	assert.NoError(t, err)
 }
+
+func TestPolicyOperator_Restore_RemovedRole(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	e, err := casbin.NewDistributedEnforcer(m)
+	assert.NoError(t, err)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	err = p.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}})
+	assert.NoError(t, err)
+	b, err := p.Backup()
+	assert.NoError(t, err)
+
+	err = p.AssignRole("g", "alice", "role:admin")
+	assert.NoError(t, err)
+	ok, err := e.Enforce("alice", "/", "*")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	// The backup does not assign the role, alice loses it and its permissions.
+	err = p.Restore(ioutil.NopCloser(bytes.NewBuffer(b)))
+	assert.NoError(t, err)
+	err = p.LoadPolicy()
+	assert.NoError(t, err)
+
+	assert.Empty(t, e.GetGroupingPolicy())
+	roles, err := e.GetRolesForUser("alice")
+	assert.NoError(t, err)
+	assert.Empty(t, roles)
+	ok, err = e.Enforce("alice", "/", "*")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestPolicyOperator_ConcurrentEnforce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	e, err := casbin.NewDistributedEnforcer(m)
+	assert.NoError(t, err)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+	err = p.AddPolicies("p", "p", [][]string{{"alice", "/", "GET"}})
+	assert.NoError(t, err)
+
+	// Enforce does not wait for the other reads.
+	p.l.RLock()
+	defer p.l.RUnlock()
+	done := make(chan bool)
+	go func() {
+		ok, _ := p.Enforce("alice", "/", "GET")
+		done <- ok
+	}()
+	select {
+	case ok := <-done:
+		assert.True(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("Enforce waits for a read")
+	}
+}
+
+func TestPolicyOperator_CheckConsistency(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/", "*"}, {"role:user", "/", "GET"}}).Return([][]string{{"role:admin", "/", "*"}, {"role:user", "/", "GET"}}, nil)
+	err = p.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}, {"role:user", "/", "GET"}})
+	assert.NoError(t, err)
+
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	m.AddPolicy("p", "p", []string{"role:admin", "/", "*"})
+	m.AddPolicy("p", "p", []string{"role:guest", "/", "GET"})
+
+	e.EXPECT().GetModel().Return(m)
+	diff, err := p.CheckConsistency()
+	assert.NoError(t, err)
+	assert.False(t, diff.IsEmpty())
+	assert.Equal(t, []Rule{{Sec: "p", PType: "p", Rule: []string{"role:user", "/", "GET"}}}, diff.Missing)
+	assert.Equal(t, []Rule{{Sec: "p", PType: "p", Rule: []string{"role:guest", "/", "GET"}}}, diff.Unexpected)
+}
+
+func TestPolicyOperator_Checksum(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p1, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	dir2, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir2)
+
+	p2, err := NewPolicyOperator(dir2, e)
+	assert.NoError(t, err)
+
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/", "*"}}).Return([][]string{{"role:admin", "/", "*"}}, nil).Times(2)
+	assert.NoError(t, p1.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}}))
+	assert.NoError(t, p2.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}}))
+
+	c1, err := p1.Checksum()
+	assert.NoError(t, err)
+	c2, err := p2.Checksum()
+	assert.NoError(t, err)
+	assert.Equal(t, c1, c2)
+
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:user", "/", "GET"}}).Return([][]string{{"role:user", "/", "GET"}}, nil)
+	assert.NoError(t, p2.AddPolicies("p", "p", [][]string{{"role:user", "/", "GET"}}))
+
+	c2, err = p2.Checksum()
+	assert.NoError(t, err)
+	assert.NotEqual(t, c1, c2)
+}
+
+func TestPolicyOperator_GetFilteredPolicy(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	rules := [][]string{{"role:admin", "/", "*"}, {"role:admin", "/admin", "GET"}, {"role:user", "/", "GET"}}
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", rules).Return(rules, nil)
+	err = p.AddPolicies("p", "p", rules)
+	assert.NoError(t, err)
+
+	actual, err := p.GetFilteredPolicy("p", "p", 0, "role:admin")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{{"role:admin", "/", "*"}, {"role:admin", "/admin", "GET"}}, actual)
+
+	actual, err = p.GetFilteredPolicy("p", "p", 1, "/", "GET")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"role:user", "/", "GET"}}, actual)
+
+	actual, err = p.GetFilteredPolicy("p", "p", 0, "", "/")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{{"role:admin", "/", "*"}, {"role:user", "/", "GET"}}, actual)
+
+	actual, err = p.GetFilteredPolicy("g", "g", 0, "alice")
+	assert.NoError(t, err)
+	assert.Empty(t, actual)
+
+	e.EXPECT().RemoveFilteredPolicySelf(nil, "p", "p", 0, "role:admin").Return([][]string{{"role:admin", "/", "*"}, {"role:admin", "/admin", "GET"}}, nil)
+	err = p.RemoveFilteredPolicy("p", "p", 0, "role:admin")
+	assert.NoError(t, err)
+
+	actual, err = p.GetFilteredPolicy("p", "p", 0, "")
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"role:user", "/", "GET"}}, actual)
+}
+
+func TestPolicyOperator_InsertionOrder(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	rules := [][]string{{"role:user", "/", "GET"}, {"role:admin", "/", "*"}, {"role:guest", "/", "GET"}}
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", rules).Return(rules, nil)
+	err = p.AddPolicies("p", "p", rules)
+	assert.NoError(t, err)
+
+	e.EXPECT().UpdatePolicySelf(nil, "p", "p", []string{"role:admin", "/", "*"}, []string{"role:admin", "/admin", "*"}).Return(true, nil)
+	err = p.UpdatePolicy("p", "p", []string{"role:admin", "/", "*"}, []string{"role:admin", "/admin", "*"})
+	assert.NoError(t, err)
+
+	e.EXPECT().UpdatePoliciesSelf(nil, "p", "p", [][]string{{"role:user", "/", "GET"}}, [][]string{{"role:user", "/", "POST"}}).Return(true, nil)
+	err = p.UpdatePolicies("p", "p", [][]string{{"role:user", "/", "GET"}}, [][]string{{"role:user", "/", "POST"}})
+	assert.NoError(t, err)
+
+	e.EXPECT().GetModel().Return(model.Model{})
+	gomock.InOrder(
+		e.EXPECT().ClearPolicySelf(nil),
+		e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:user", "/", "POST"}}),
+		e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/admin", "*"}}),
+		e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:guest", "/", "GET"}}),
+		e.EXPECT().BuildRoleLinks(),
+	)
+	err = p.LoadPolicy()
+	assert.NoError(t, err)
+}
+
+func TestPolicyOperator_MovePolicy(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act, eft
+
+[policy_effect]
+e = priority(p_eft) || deny
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+
+	addPolicies := func(shouldPersist func() bool, sec string, pType string, rules [][]string) ([][]string, error) {
+		return m.AddPoliciesWithAffected(sec, pType, rules), nil
+	}
+	removePolicies := func(shouldPersist func() bool, sec string, pType string, rules [][]string) ([][]string, error) {
+		return m.RemovePoliciesWithEffected(sec, pType, rules), nil
+	}
+
+	rules := [][]string{{"alice", "/", "GET", "allow"}, {"bob", "/", "GET", "allow"}, {"alice", "/", "GET", "deny"}}
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", rules).DoAndReturn(addPolicies)
+	err = p.AddPolicies("p", "p", rules)
+	assert.NoError(t, err)
+
+	// The moved rule and the rules after it are removed and added back in the new order.
+	expected := [][]string{{"alice", "/", "GET", "deny"}, {"alice", "/", "GET", "allow"}, {"bob", "/", "GET", "allow"}}
+	gomock.InOrder(
+		e.EXPECT().RemovePoliciesSelf(nil, "p", "p", expected).DoAndReturn(removePolicies),
+		e.EXPECT().AddPoliciesSelf(nil, "p", "p", expected).DoAndReturn(addPolicies),
+	)
+	err = p.MovePolicy("p", "p", []string{"alice", "/", "GET", "deny"}, 0)
+	assert.NoError(t, err)
+
+	assert.Equal(t, expected, m.GetPolicy("p", "p"))
+	assert.True(t, m.HasPolicy("p", "p", []string{"bob", "/", "GET", "allow"}))
+	assert.Equal(t, 2, m["p"]["p"].PolicyMap["bob,/,GET,allow"])
+
+	rules2, err := p.readRules()
+	assert.NoError(t, err)
+	var actual [][]string
+	for _, rule := range rules2 {
+		actual = append(actual, rule.Rule)
+	}
+	assert.Equal(t, expected, actual)
+
+	gomock.InOrder(
+		e.EXPECT().RemovePoliciesSelf(nil, "p", "p", [][]string{{"alice", "/", "GET", "deny"}}).DoAndReturn(removePolicies),
+		e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"alice", "/", "GET", "deny"}}).DoAndReturn(addPolicies),
+	)
+	err = p.MovePolicy("p", "p", []string{"alice", "/", "GET", "deny"}, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{{"alice", "/", "GET", "allow"}, {"bob", "/", "GET", "allow"}, {"alice", "/", "GET", "deny"}}, m.GetPolicy("p", "p"))
+
+	err = p.MovePolicy("p", "p", []string{"carol", "/", "GET", "deny"}, 0)
+	assert.Equal(t, errRuleNotFound, err)
+
+	err = p.MovePolicy("p", "p", []string{"alice", "/", "GET", "deny"}, -1)
+	assert.Error(t, err)
+}
+
+func TestPolicyOperator_SetModel(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	text, err := p.Model()
+	assert.NoError(t, err)
+	assert.Empty(t, text)
+
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/", "*"}}).Return([][]string{{"role:admin", "/", "*"}}, nil)
+	err = p.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}})
+	assert.NoError(t, err)
+
+	err = p.SetModel("[request_definition]")
+	assert.Error(t, err)
+
+	err = p.SetModel(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p2 = sub, obj, act
+
+[policy_effect]
+e = some(where (p2.eft == allow))
+
+[matchers]
+m = r.sub == p2.sub && r.obj == p2.obj && r.act == p2.act
+`)
+	assert.Error(t, err)
+
+	modelText := `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`
+	changes := 0
+	p.onModelChange = func(enforcer casbin.IDistributedEnforcer) {
+		assert.Equal(t, e, enforcer)
+		changes++
+	}
+	gomock.InOrder(
+		e.EXPECT().GetModel().Return(model.Model{}),
+		e.EXPECT().GetRoleManager(),
+		e.EXPECT().SetModel(gomock.Any()),
+		e.EXPECT().ClearPolicySelf(nil),
+		e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/", "*"}}),
+		e.EXPECT().BuildRoleLinks(),
+	)
+	err = p.SetModel(modelText)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changes)
+
+	text, err = p.Model()
+	assert.NoError(t, err)
+	assert.Equal(t, modelText, text)
+
+	// The model of enforcer is kept if it is the same as the stored model.
+	m, err := model.NewModelFromString(modelText)
+	assert.NoError(t, err)
+	e.EXPECT().GetModel().Return(m)
+	err = p.LoadModel()
+	assert.NoError(t, err)
+	assert.Equal(t, 1, changes)
+}
+
+func TestPolicyOperator_SetModel_RoleManager(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	e, err := casbin.NewDistributedEnforcer(m)
+	assert.NoError(t, err)
+	rm := defaultrolemanager.NewRoleManager(10)
+	e.SetRoleManager(rm)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	err = p.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}})
+	assert.NoError(t, err)
+	err = p.AssignRole("g", "alice", "role:admin")
+	assert.NoError(t, err)
+
+	err = p.SetModel(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && keyMatch(r.obj, p.obj) && r.act == p.act
+`)
+	assert.NoError(t, err)
+
+	// The role manager of the enforcer is kept and rebuilt with the stored roles.
+	assert.Equal(t, rm, e.GetRoleManager())
+	ok, err := e.Enforce("alice", "/", "*")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
+
+func TestPolicyOperator_DeleteRole(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	e.EXPECT().AddPoliciesSelf(nil, gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(shouldPersist func() bool, sec string, pType string, rules [][]string) ([][]string, error) {
+		return m.AddPoliciesWithAffected(sec, pType, rules), nil
+	}).AnyTimes()
+	e.EXPECT().RemoveFilteredPolicySelf(nil, gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).DoAndReturn(func(shouldPersist func() bool, sec string, pType string, fieldIndex int, fieldValues ...string) ([][]string, error) {
+		_, effected := m.RemoveFilteredPolicy(sec, pType, fieldIndex, fieldValues...)
+		return effected, nil
+	}).Times(3)
+
+	err = p.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}, {"role:user", "/", "GET"}})
+	assert.NoError(t, err)
+	err = p.AssignRole("g", "alice", "role:admin")
+	assert.NoError(t, err)
+	err = p.AssignRole("g", "bob", "role:user")
+	assert.NoError(t, err)
+	err = p.AssignRole("g", "role:admin", "role:user")
+	assert.NoError(t, err)
+
+	err = p.DeleteRole("role:admin")
+	assert.NoError(t, err)
+
+	rules, err := p.readRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []Rule{
+		{Sec: "g", PType: "g", Rule: []string{"bob", "role:user"}},
+		{Sec: "p", PType: "p", Rule: []string{"role:user", "/", "GET"}},
+	}, rules)
+	assert.Equal(t, [][]string{{"role:user", "/", "GET"}}, m.GetPolicy("p", "p"))
+	assert.Equal(t, [][]string{{"bob", "role:user"}}, m.GetPolicy("g", "g"))
+}
+
+func TestPolicyOperator_Namespace(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	modelText := `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`
+	_, err = p.Namespace("tenant1")
+	assert.Error(t, err)
+	err = p.CreateNamespace("", modelText)
+	assert.Error(t, err)
+	err = p.CreateNamespace("tenant1", "[request_definition]")
+	assert.Error(t, err)
+
+	checksum, err := p.Checksum()
+	assert.NoError(t, err)
+
+	err = p.CreateNamespace("tenant1", modelText)
+	assert.NoError(t, err)
+	err = p.CreateNamespace("tenant1", modelText)
+	assert.NoError(t, err)
+	err = p.CreateNamespace("tenant2", modelText)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant1", "tenant2"}, p.Namespaces())
+
+	tenant1, err := p.Namespace("tenant1")
+	assert.NoError(t, err)
+	err = tenant1.AddPolicies("p", "p", [][]string{{"alice", "/", "GET"}})
+	assert.NoError(t, err)
+
+	ok, err := tenant1.Enforce("alice", "/", "GET")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	tenant2, err := p.Namespace("tenant2")
+	assert.NoError(t, err)
+	ok, err = tenant2.Enforce("alice", "/", "GET")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	rules, err := p.readRules()
+	assert.NoError(t, err)
+	assert.Empty(t, rules)
+
+	newChecksum, err := p.Checksum()
+	assert.NoError(t, err)
+	assert.NotEqual(t, checksum, newChecksum)
+
+	// The namespaces are rebuilt from database.
+	err = p.LoadNamespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant1", "tenant2"}, p.Namespaces())
+	tenant1, err = p.Namespace("tenant1")
+	assert.NoError(t, err)
+	ok, err = tenant1.Enforce("alice", "/", "GET")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	err = p.DeleteNamespace("tenant1")
+	assert.NoError(t, err)
+	err = p.DeleteNamespace("tenant1")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant2"}, p.Namespaces())
+	_, err = p.Namespace("tenant1")
+	assert.Error(t, err)
+
+	err = p.LoadNamespaces()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"tenant2"}, p.Namespaces())
+}
+
+func TestPolicyOperator_Import(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	err = p.CreateNamespace("tenant1", `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	tenant1, err := p.Namespace("tenant1")
+	assert.NoError(t, err)
+
+	err = tenant1.AddPolicies("p", "p", [][]string{{"bob", "/", "GET"}})
+	assert.NoError(t, err)
+
+	// The rules without an import ID are added to the current rules.
+	err = tenant1.ImportPolicies("", 1, []Rule{{Sec: "p", PType: "p", Rule: []string{"alice", "/", "GET"}}})
+	assert.NoError(t, err)
+	rules, err := tenant1.readRules()
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	err = tenant1.ImportPolicies("import1", 1, []Rule{
+		{Sec: "p", PType: "p", Rule: []string{"carol", "/", "GET"}},
+		{Sec: "p", PType: "p", Rule: []string{"carol", "/", "GET"}},
+	})
+	assert.NoError(t, err)
+	err = tenant1.ImportPolicies("import1", 1, []Rule{{Sec: "p", PType: "p", Rule: []string{"alice", "/", "POST"}}})
+	assert.NoError(t, err)
+	err = tenant1.ImportPolicies("import2", 1, []Rule{{Sec: "p", PType: "p2", Rule: []string{"alice", "/", "GET"}}})
+	assert.NoError(t, err)
+
+	// The staged rules are not visible until the import is committed.
+	rules, err = tenant1.readRules()
+	assert.NoError(t, err)
+	assert.Len(t, rules, 2)
+
+	err = tenant1.CommitImport("import2", false, false)
+	assert.Error(t, err)
+	err = tenant1.CommitImport("import2", true, false)
+	assert.NoError(t, err)
+
+	err = tenant1.CommitImport("import1", false, false)
+	assert.NoError(t, err)
+	rules, err = tenant1.readRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []Rule{
+		{Sec: "p", PType: "p", Rule: []string{"carol", "/", "GET"}},
+		{Sec: "p", PType: "p", Rule: []string{"alice", "/", "POST"}},
+	}, rules)
+
+	ok, err := tenant1.Enforce("bob", "/", "GET")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = tenant1.Enforce("carol", "/", "GET")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	err = p.db.View(func(tx *bolt.Tx) error {
+		imports := tenant1.container(tx).Bucket(importsBucketName)
+		assert.NotNil(t, imports)
+		assert.Nil(t, imports.Bucket([]byte("import1")))
+		assert.Nil(t, imports.Bucket([]byte("import2")))
+		return nil
+	})
+	assert.NoError(t, err)
+
+	// The imports of the earlier terms are discarded, the others are kept.
+	err = tenant1.ImportPolicies("import3", 1, []Rule{{Sec: "p", PType: "p", Rule: []string{"dave", "/", "GET"}}})
+	assert.NoError(t, err)
+	err = tenant1.ImportPolicies("import4", 2, []Rule{{Sec: "p", PType: "p", Rule: []string{"erin", "/", "GET"}}})
+	assert.NoError(t, err)
+	err = tenant1.ImportPolicies("import3", 2, []Rule{{Sec: "p", PType: "p", Rule: []string{"frank", "/", "GET"}}})
+	assert.NoError(t, err)
+	stale, err := p.HasStaleImports(1)
+	assert.NoError(t, err)
+	assert.False(t, stale)
+	stale, err = p.HasStaleImports(2)
+	assert.NoError(t, err)
+	assert.True(t, stale)
+	err = p.DiscardImports(2)
+	assert.NoError(t, err)
+	stale, err = p.HasStaleImports(2)
+	assert.NoError(t, err)
+	assert.False(t, stale)
+	err = p.db.View(func(tx *bolt.Tx) error {
+		imports := tenant1.container(tx).Bucket(importsBucketName)
+		assert.Nil(t, imports.Bucket([]byte("import3")))
+		assert.NotNil(t, imports.Bucket([]byte("import4")))
+		return nil
+	})
+	assert.NoError(t, err)
+	seeded, err := tenant1.Seeded()
+	assert.NoError(t, err)
+	assert.False(t, seeded)
+	err = tenant1.CommitImport("import4", false, true)
+	assert.NoError(t, err)
+	seeded, err = tenant1.Seeded()
+	assert.NoError(t, err)
+	assert.True(t, seeded)
+	rules, err = tenant1.readRules()
+	assert.NoError(t, err)
+	assert.Equal(t, []Rule{{Sec: "p", PType: "p", Rule: []string{"erin", "/", "GET"}}}, rules)
+}
+
+func TestPolicyOperator_RecordRequest(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	p, err := NewPolicyOperator(dir, e)
+	assert.NoError(t, err)
+
+	record, err := p.Request("first")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+
+	err = p.RecordRequest("first", &command.RequestRecord{Index: 1})
+	assert.NoError(t, err)
+	err = p.RecordRequest("second", &command.RequestRecord{Index: 2, Code: "rejected", Error: "the rule does not exist"})
+	assert.NoError(t, err)
+
+	record, err = p.Request("second")
+	assert.NoError(t, err)
+	assert.Equal(t, uint64(2), record.Index)
+	assert.Equal(t, "rejected", record.Code)
+	assert.Equal(t, "the rule does not exist", record.Error)
+
+	// the record of the first request is evicted once it is requestRetention entries old.
+	err = p.RecordRequest("third", &command.RequestRecord{Index: requestRetention + 1})
+	assert.NoError(t, err)
+	record, err = p.Request("first")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+	record, err = p.Request("second")
+	assert.NoError(t, err)
+	assert.NotNil(t, record)
+
+	// A staged record is committed with the changes of the command.
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"alice", "/", "GET"}}).Return([][]string{{"alice", "/", "GET"}}, nil)
+	p.StageRequest("fourth", &command.RequestRecord{Index: requestRetention + 2, Hash: []byte("hash")})
+	err = p.AddPolicies("p", "p", [][]string{{"alice", "/", "GET"}})
+	assert.NoError(t, err)
+	assert.True(t, p.UnstageRequest())
+	record, err = p.Request("fourth")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("hash"), record.Hash)
+
+	// A command that does not change the database does not write the staged record.
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"alice", "/", "GET"}}).Return(nil, nil)
+	p.StageRequest("fifth", &command.RequestRecord{Index: requestRetention + 3})
+	err = p.AddPolicies("p", "p", [][]string{{"alice", "/", "GET"}})
+	assert.NoError(t, err)
+	assert.False(t, p.UnstageRequest())
+	record, err = p.Request("fifth")
+	assert.NoError(t, err)
+	assert.Nil(t, record)
+}
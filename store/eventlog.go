@@ -0,0 +1,704 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// EventLogFormat is the encoding of the events in the segment files.
+type EventLogFormat string
+
+const (
+	// EventLogJSON writes an event as a JSON object per line.
+	EventLogJSON EventLogFormat = "json"
+	// EventLogProtobuf writes an event as a protobuf message prefixed with its size as a varint.
+	EventLogProtobuf EventLogFormat = "protobuf"
+)
+
+const (
+	defaultEventLogSegmentSize = 64 * 1024 * 1024
+
+	eventLogJSONExt     = ".ndjson"
+	eventLogProtobufExt = ".pb"
+	eventLogGzipExt     = ".gz"
+)
+
+// EventLogConfig configures the event log, see EventLog.
+type EventLogConfig struct {
+	// Dir holds the segment files.
+	Dir string
+	// Format is the encoding of the events, the default is EventLogJSON.
+	Format EventLogFormat
+	// SegmentSize is the size in bytes at which the current segment is sealed and a new one is started,
+	// the default is 64MB.
+	SegmentSize int64
+	// SegmentAge is the age at which the current segment is sealed, zero disables it.
+	SegmentAge time.Duration
+	// MaxSegments is the number of sealed segments retained, the oldest are deleted. Zero retains all.
+	MaxSegments int
+	// MaxAge is the age at which a sealed segment is deleted, zero disables it.
+	MaxAge time.Duration
+	// Compress compresses the sealed segments by gzip.
+	Compress bool
+}
+
+// segmentFile is a segment of the event log.
+type segmentFile struct {
+	// first is the index of the first event of the segment.
+	first      uint64
+	path       string
+	format     EventLogFormat
+	compressed bool
+}
+
+// segmentName returns the file name of a segment.
+func segmentName(first uint64, format EventLogFormat) string {
+	ext := eventLogJSONExt
+	if format == EventLogProtobuf {
+		ext = eventLogProtobufExt
+	}
+	return fmt.Sprintf("%020d%s", first, ext)
+}
+
+// parseSegmentName parses the file name of a segment.
+func parseSegmentName(name string) (segmentFile, bool) {
+	var segment segmentFile
+	if strings.HasSuffix(name, eventLogGzipExt) {
+		segment.compressed = true
+		name = strings.TrimSuffix(name, eventLogGzipExt)
+	}
+	ext := filepath.Ext(name)
+	switch ext {
+	case eventLogJSONExt:
+		segment.format = EventLogJSON
+	case eventLogProtobufExt:
+		segment.format = EventLogProtobuf
+	default:
+		return segment, false
+	}
+	first, err := strconv.ParseUint(strings.TrimSuffix(name, ext), 10, 64)
+	if err != nil {
+		return segment, false
+	}
+	segment.first = first
+	return segment, true
+}
+
+// listSegments returns the segments of the directory ordered by their first index.
+func listSegments(dir string) ([]segmentFile, error) {
+	infos, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	segments := make(map[uint64]segmentFile)
+	for _, info := range infos {
+		segment, ok := parseSegmentName(info.Name())
+		if !ok || info.IsDir() {
+			continue
+		}
+		segment.path = filepath.Join(dir, info.Name())
+		// The uncompressed segment is complete until it is removed after the compression.
+		if existing, ok := segments[segment.first]; ok && !existing.compressed {
+			continue
+		}
+		segments[segment.first] = segment
+	}
+
+	var result []segmentFile
+	for _, segment := range segments {
+		result = append(result, segment)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].first < result[j].first
+	})
+	return result, nil
+}
+
+// encodeEvent encodes an event as a record of the segment.
+func encodeEvent(format EventLogFormat, event *command.Event) ([]byte, error) {
+	if format == EventLogProtobuf {
+		b, err := proto.Marshal(event)
+		if err != nil {
+			return nil, err
+		}
+		return append(protowire.AppendVarint(nil, uint64(len(b))), b...), nil
+	}
+	b, err := protojson.Marshal(event)
+	if err != nil {
+		return nil, err
+	}
+	return append(b, '\n'), nil
+}
+
+// decodeEvent reads a record of the segment, it returns the event and the size of the record.
+// io.EOF is returned if there is no complete record.
+func decodeEvent(format EventLogFormat, r *bufio.Reader) (*command.Event, int64, error) {
+	var data []byte
+	var size int64
+	if format == EventLogProtobuf {
+		n, err := binary.ReadUvarint(r)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, io.EOF
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		data = make([]byte, n)
+		_, err = io.ReadFull(r, data)
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return nil, 0, io.EOF
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size = int64(protowire.SizeVarint(n)) + int64(n)
+	} else {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil, 0, io.EOF
+		}
+		if err != nil {
+			return nil, 0, err
+		}
+		size = int64(len(line))
+		data = bytes.TrimSpace(line)
+	}
+
+	var event command.Event
+	var err error
+	if format == EventLogProtobuf {
+		err = proto.Unmarshal(data, &event)
+	} else {
+		err = protojson.Unmarshal(data, &event)
+	}
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "failed to decode the event")
+	}
+	return &event, size, nil
+}
+
+// openSegment opens a segment for reading.
+func openSegment(segment segmentFile) (*os.File, *bufio.Reader, error) {
+	f, err := os.Open(segment.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	if !segment.compressed {
+		return f, bufio.NewReader(f), nil
+	}
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, bufio.NewReader(gz), nil
+}
+
+// EventLog writes the commands applied by FSM to rotating segment files, so that the batch jobs can tail
+// the changes of the policy. It is a change-data-capture of the state, every node writes its own event log.
+//
+// An event holds the index and the term of the log and the command. The segments are named by the index of
+// their first event, the current segment is sealed when it reaches EventLogConfig.SegmentSize or
+// EventLogConfig.SegmentAge, then it is compressed and the retention is applied in the background.
+// When a snapshot is installed, the commands before it are not known, an event whose Restored is true is written
+// at the index of the snapshot, the consumers have to read the whole policy again. When an event cannot be written,
+// the next event that is written has Gap set to true, the consumers have to read the whole policy again as well.
+type EventLog struct {
+	config    EventLogConfig
+	snapshots raft.SnapshotStore
+
+	l       sync.Mutex
+	file    *os.File
+	writer  *bufio.Writer
+	segment segmentFile
+	size    int64
+	created time.Time
+	last    uint64
+	// gap means that an event could not be written, the next event written is marked as a gap.
+	gap bool
+
+	// sealL serializes the compression and the retention of the sealed segments.
+	sealL sync.Mutex
+	wg    sync.WaitGroup
+
+	logger *zap.Logger
+}
+
+// newEventLog opens the event log of the directory, the current segment is appended if it has the same format.
+func newEventLog(config EventLogConfig, snapshots raft.SnapshotStore) (*EventLog, error) {
+	if len(config.Format) == 0 {
+		config.Format = EventLogJSON
+	}
+	if config.Format != EventLogJSON && config.Format != EventLogProtobuf {
+		return nil, errors.Errorf("unknown event log format %s", config.Format)
+	}
+	if config.SegmentSize <= 0 {
+		config.SegmentSize = defaultEventLogSegmentSize
+	}
+	err := os.MkdirAll(config.Dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	e := &EventLog{
+		config:    config,
+		snapshots: snapshots,
+		logger:    zap.NewExample(),
+	}
+
+	segments, err := listSegments(config.Dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(segments) == 0 {
+		return e, nil
+	}
+	segment := segments[len(segments)-1]
+	last, offset, err := scanSegment(segment)
+	if err != nil {
+		return nil, err
+	}
+	e.last = last
+	if segment.compressed || segment.format != config.Format {
+		e.seal(segment)
+		return e, nil
+	}
+
+	// A partial record is left if the node crashed while writing it.
+	f, err := os.OpenFile(segment.path, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = f.Truncate(offset)
+	if err == nil {
+		_, err = f.Seek(offset, io.SeekStart)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	e.file = f
+	e.writer = bufio.NewWriter(f)
+	e.segment = segment
+	e.size = offset
+	e.created = time.Now()
+	return e, nil
+}
+
+// scanSegment returns the index of the last event of a segment and the size of its complete records.
+func scanSegment(segment segmentFile) (uint64, int64, error) {
+	f, r, err := openSegment(segment)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	var last uint64
+	var offset int64
+	for {
+		event, size, err := decodeEvent(segment.format, r)
+		if err != nil {
+			// The records after a corrupted one are dropped.
+			return last, offset, nil
+		}
+		last = event.Index
+		offset += size
+	}
+}
+
+// LastIndex returns the index of the last event.
+func (e *EventLog) LastIndex() uint64 {
+	e.l.Lock()
+	defer e.l.Unlock()
+	return e.last
+}
+
+// applied implements the applyObserver interface.
+func (e *EventLog) applied(log *raft.Log, cmd *command.Command) {
+	e.write(&command.Event{
+		Index:   log.Index,
+		Term:    log.Term,
+		Command: cmd,
+	})
+}
+
+// restored implements the applyObserver interface.
+func (e *EventLog) restored() {
+	snapshots, err := e.snapshots.List()
+	if err != nil || len(snapshots) == 0 {
+		return
+	}
+	e.write(&command.Event{
+		Index:    snapshots[0].Index,
+		Term:     snapshots[0].Term,
+		Restored: true,
+	})
+}
+
+// write appends an event, the events that are already written are skipped, such as the logs
+// applied again after a restart. The failures cannot fail FSM, they are logged and the next event
+// written is marked as a gap, so the consumers can detect the missing events.
+func (e *EventLog) write(event *command.Event) {
+	e.l.Lock()
+	defer e.l.Unlock()
+
+	if event.Index <= e.last {
+		return
+	}
+	event.Gap = e.gap
+	record, err := encodeEvent(e.config.Format, event)
+	if err != nil {
+		e.logger.Error("failed to encode the event", zap.Uint64("index", event.Index), zap.Error(err))
+		e.gap = true
+		return
+	}
+
+	if e.file != nil && (e.size >= e.config.SegmentSize || (e.config.SegmentAge > 0 && time.Since(e.created) >= e.config.SegmentAge)) {
+		err = e.rotate()
+		if err != nil {
+			e.logger.Error("failed to seal the segment of the event log", zap.String("path", e.segment.path), zap.Error(err))
+		}
+	}
+	if e.file == nil {
+		err = e.create(event.Index)
+		if err != nil {
+			e.logger.Error("failed to create a segment of the event log", zap.Uint64("index", event.Index), zap.Error(err))
+			e.gap = true
+			return
+		}
+	}
+
+	_, err = e.writer.Write(record)
+	if err == nil {
+		// The readers tail the current segment.
+		err = e.writer.Flush()
+	}
+	if err != nil {
+		e.logger.Error("failed to write the event", zap.String("path", e.segment.path), zap.Uint64("index", event.Index), zap.Error(err))
+		e.discard()
+		e.gap = true
+		return
+	}
+	e.size += int64(len(record))
+	e.last = event.Index
+	e.gap = false
+}
+
+// discard seals the current segment after a failed write, the writer keeps failing once a write fails.
+// The partial record is truncated if possible, the readers skip it anyway once the segment is sealed.
+// The next event starts a new segment. The caller must hold the lock.
+func (e *EventLog) discard() {
+	_ = e.file.Truncate(e.size)
+	_ = e.file.Close()
+	e.file = nil
+	e.writer = nil
+	e.seal(e.segment)
+}
+
+// create starts a new segment at the index, the caller must hold the lock.
+func (e *EventLog) create(first uint64) error {
+	segment := segmentFile{
+		first:  first,
+		path:   filepath.Join(e.config.Dir, segmentName(first, e.config.Format)),
+		format: e.config.Format,
+	}
+	f, err := os.OpenFile(segment.path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	e.file = f
+	e.writer = bufio.NewWriter(f)
+	e.segment = segment
+	e.size = 0
+	e.created = time.Now()
+	return nil
+}
+
+// rotate closes the current segment and seals it, the caller must hold the lock.
+func (e *EventLog) rotate() error {
+	err := e.closeFile()
+	if err != nil {
+		return err
+	}
+	e.seal(e.segment)
+	return nil
+}
+
+// closeFile flushes and closes the current segment, the caller must hold the lock.
+func (e *EventLog) closeFile() error {
+	var result error
+	err := e.writer.Flush()
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	err = e.file.Sync()
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	err = e.file.Close()
+	if err != nil {
+		result = multierror.Append(result, err)
+	}
+	e.file = nil
+	e.writer = nil
+	return result
+}
+
+// seal compresses a sealed segment and applies the retention in the background.
+func (e *EventLog) seal(segment segmentFile) {
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.sealL.Lock()
+		defer e.sealL.Unlock()
+
+		if e.config.Compress && !segment.compressed {
+			err := compressSegment(segment)
+			if err != nil {
+				e.logger.Error("failed to compress the segment of the event log", zap.String("path", segment.path), zap.Error(err))
+			}
+		}
+		err := e.retain()
+		if err != nil {
+			e.logger.Error("failed to delete the expired segments of the event log", zap.Error(err))
+		}
+	}()
+}
+
+// compressSegment replaces a segment with its gzip file.
+func compressSegment(segment segmentFile) error {
+	src, err := os.Open(segment.path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	path := segment.path + eventLogGzipExt
+	tmp := path + ".tmp"
+	dst, err := os.OpenFile(tmp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp)
+	defer dst.Close()
+
+	gz := gzip.NewWriter(dst)
+	_, err = io.Copy(gz, src)
+	if err == nil {
+		err = gz.Close()
+	}
+	if err == nil {
+		err = dst.Sync()
+	}
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return err
+	}
+	return os.Remove(segment.path)
+}
+
+// retain deletes the sealed segments exceeding EventLogConfig.MaxSegments or EventLogConfig.MaxAge.
+func (e *EventLog) retain() error {
+	if e.config.MaxSegments <= 0 && e.config.MaxAge <= 0 {
+		return nil
+	}
+	segments, err := listSegments(e.config.Dir)
+	if err != nil {
+		return err
+	}
+
+	if len(segments) == 0 {
+		return nil
+	}
+	// The newest segment is the current one, it is appended again after a restart.
+	sealed := segments[:len(segments)-1]
+
+	var result error
+	for i, segment := range sealed {
+		expired := e.config.MaxSegments > 0 && len(sealed)-i > e.config.MaxSegments
+		if !expired && e.config.MaxAge > 0 {
+			info, err := os.Stat(segment.path)
+			expired = err == nil && time.Since(info.ModTime()) >= e.config.MaxAge
+		}
+		if !expired {
+			continue
+		}
+		err = os.Remove(segment.path)
+		if err != nil && !os.IsNotExist(err) {
+			result = multierror.Append(result, err)
+		}
+	}
+	return result
+}
+
+// Close closes the current segment and waits for the sealed segments to be compressed.
+func (e *EventLog) Close() error {
+	e.l.Lock()
+	var err error
+	if e.file != nil {
+		err = e.closeFile()
+	}
+	e.l.Unlock()
+	e.wg.Wait()
+	return err
+}
+
+// EventLogReader reads the events of an event log in order, it tails the current segment,
+// Next returns io.EOF when all the written events are read and the later events can be read by calling Next again.
+// To resume reading, for example after a restart of a batch job, open a reader from the index of the last event read plus one.
+// The segments deleted by the retention are skipped.
+type EventLogReader struct {
+	dir  string
+	next uint64
+
+	segment segmentFile
+	file    *os.File
+	reader  *bufio.Reader
+	offset  int64
+	// sealed means that the current segment is not written any more.
+	sealed bool
+	// done means that the current segment is read to the end.
+	done bool
+}
+
+// NewEventLogReader returns a reader of the event log in the directory, starting from the event at the index or after it.
+func NewEventLogReader(dir string, index uint64) *EventLogReader {
+	return &EventLogReader{
+		dir:  dir,
+		next: index,
+	}
+}
+
+// Next returns the next event.
+func (r *EventLogReader) Next() (*command.Event, error) {
+	for {
+		if r.file == nil {
+			ok, err := r.open()
+			if err != nil || !ok {
+				return nil, err
+			}
+		}
+
+		event, size, err := decodeEvent(r.segment.format, r.reader)
+		if err == io.EOF {
+			err = r.advance()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		r.offset += size
+		if event.Index < r.next {
+			continue
+		}
+		r.next = event.Index + 1
+		return event, nil
+	}
+}
+
+// open opens the segment holding the next event, it returns false if there is no such segment yet.
+func (r *EventLogReader) open() (bool, error) {
+	segments, err := listSegments(r.dir)
+	if err != nil {
+		return false, err
+	}
+
+	var segment *segmentFile
+	for i := range segments {
+		if r.done && segments[i].first <= r.segment.first {
+			continue
+		}
+		// The last segment starting at or before the next index holds it.
+		if segment == nil || segments[i].first <= r.next {
+			segment = &segments[i]
+		}
+		if segments[i].first > r.next {
+			break
+		}
+	}
+	if segment == nil {
+		return false, io.EOF
+	}
+
+	f, reader, err := openSegment(*segment)
+	if err != nil {
+		return false, err
+	}
+	r.segment = *segment
+	r.file = f
+	r.reader = reader
+	r.offset = 0
+	r.sealed = false
+	r.done = false
+	return true, nil
+}
+
+// advance handles the end of the current segment. A sealed segment is closed so that the next one is opened,
+// otherwise the current segment is being written, the partial record is read again later.
+func (r *EventLogReader) advance() error {
+	if r.segment.compressed || r.sealed {
+		r.closeFile()
+		return nil
+	}
+
+	segments, err := listSegments(r.dir)
+	if err != nil {
+		return err
+	}
+	_, err = r.file.Seek(r.offset, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	r.reader.Reset(r.file)
+	// The segment is complete once the next one is created, it is read to the end again.
+	if len(segments) > 0 && segments[len(segments)-1].first > r.segment.first {
+		r.sealed = true
+		return nil
+	}
+	return io.EOF
+}
+
+// closeFile closes the current segment, the next one is opened by Next.
+func (r *EventLogReader) closeFile() {
+	r.file.Close()
+	r.file = nil
+	r.reader = nil
+	r.done = true
+}
+
+// Close closes the reader.
+func (r *EventLogReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
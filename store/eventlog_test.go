@@ -0,0 +1,196 @@
+package store
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func writeEvents(e *EventLog, from, to uint64) {
+	for i := from; i <= to; i++ {
+		e.applied(&raft.Log{Index: i, Term: 2}, &command.Command{
+			Type:      command.Command_COMMAND_TYPE_ADD_POLICIES,
+			Data:      []byte("data"),
+			Namespace: "tenant",
+		})
+	}
+}
+
+func readEvents(t *testing.T, r *EventLogReader) []uint64 {
+	var indexes []uint64
+	for {
+		event, err := r.Next()
+		if err == io.EOF {
+			return indexes
+		}
+		if !assert.NoError(t, err) {
+			return indexes
+		}
+		indexes = append(indexes, event.Index)
+	}
+}
+
+func indexRange(from, to uint64) []uint64 {
+	var indexes []uint64
+	for i := from; i <= to; i++ {
+		indexes = append(indexes, i)
+	}
+	return indexes
+}
+
+func TestEventLog(t *testing.T) {
+	for _, format := range []EventLogFormat{EventLogJSON, EventLogProtobuf} {
+		t.Run(string(format), func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "casbin-hraft-events-")
+			assert.NoError(t, err)
+			defer os.RemoveAll(dir)
+
+			config := EventLogConfig{
+				Dir:         dir,
+				Format:      format,
+				SegmentSize: 256,
+				Compress:    true,
+			}
+			snapshots := raft.NewInmemSnapshotStore()
+			e, err := newEventLog(config, snapshots)
+			assert.NoError(t, err)
+
+			r := NewEventLogReader(dir, 0)
+			defer r.Close()
+			_, err = r.Next()
+			assert.Equal(t, io.EOF, err)
+
+			// The reader tails the segments while they are rotated and compressed.
+			writeEvents(e, 1, 10)
+			assert.Equal(t, indexRange(1, 10), readEvents(t, r))
+			writeEvents(e, 11, 30)
+			assert.Equal(t, indexRange(11, 30), readEvents(t, r))
+			assert.NoError(t, e.Close())
+
+			segments, err := listSegments(dir)
+			assert.NoError(t, err)
+			assert.True(t, len(segments) > 2)
+			for _, segment := range segments[:len(segments)-1] {
+				assert.True(t, segment.compressed)
+			}
+			assert.False(t, segments[len(segments)-1].compressed)
+
+			// A partial record is ignored by the reader and truncated when the event log is opened again.
+			f, err := os.OpenFile(segments[len(segments)-1].path, os.O_APPEND|os.O_WRONLY, 0644)
+			assert.NoError(t, err)
+			_, err = f.Write([]byte{0x7f, '{'})
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+			_, err = r.Next()
+			assert.Equal(t, io.EOF, err)
+
+			// The events written before the restart are skipped.
+			e, err = newEventLog(config, snapshots)
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(30), e.LastIndex())
+			writeEvents(e, 25, 32)
+			assert.Equal(t, indexRange(31, 32), readEvents(t, r))
+
+			// An installed snapshot is recorded as a gap.
+			sink, err := snapshots.Create(raft.SnapshotVersionMax, 40, 3, raft.Configuration{}, 1, nil)
+			assert.NoError(t, err)
+			assert.NoError(t, sink.Close())
+			e.restored()
+			event, err := r.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(40), event.Index)
+			assert.Equal(t, uint64(3), event.Term)
+			assert.True(t, event.Restored)
+			assert.Nil(t, event.Command)
+			assert.NoError(t, e.Close())
+
+			// A reader resumes from an index.
+			resumed := NewEventLogReader(dir, 17)
+			defer resumed.Close()
+			event, err = resumed.Next()
+			assert.NoError(t, err)
+			assert.Equal(t, uint64(17), event.Index)
+			assert.Equal(t, uint64(2), event.Term)
+			assert.Equal(t, "tenant", event.Command.Namespace)
+			assert.Equal(t, []byte("data"), event.Command.Data)
+			assert.Equal(t, append(indexRange(18, 32), 40), readEvents(t, resumed))
+		})
+	}
+}
+
+func TestEventLog_Retention(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-events-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e, err := newEventLog(EventLogConfig{
+		Dir:         dir,
+		Format:      EventLogProtobuf,
+		SegmentSize: 64,
+		MaxSegments: 2,
+	}, raft.NewInmemSnapshotStore())
+	assert.NoError(t, err)
+	writeEvents(e, 1, 50)
+	assert.NoError(t, e.Close())
+
+	segments, err := listSegments(dir)
+	assert.NoError(t, err)
+	assert.Len(t, segments, 3)
+	assert.Equal(t, filepath.Join(dir, segmentName(segments[0].first, EventLogProtobuf)), segments[0].path)
+
+	// The deleted segments are skipped.
+	r := NewEventLogReader(dir, 0)
+	defer r.Close()
+	indexes := readEvents(t, r)
+	assert.Equal(t, segments[0].first, indexes[0])
+	assert.Equal(t, uint64(50), indexes[len(indexes)-1])
+
+	_, err = newEventLog(EventLogConfig{Dir: dir, Format: "xml"}, raft.NewInmemSnapshotStore())
+	assert.Error(t, err)
+}
+
+func TestEventLog_Gap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-events-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e, err := newEventLog(EventLogConfig{Dir: dir}, raft.NewInmemSnapshotStore())
+	assert.NoError(t, err)
+	writeEvents(e, 1, 2)
+
+	// The events cannot be written once the segment is closed underneath.
+	e.l.Lock()
+	assert.NoError(t, e.file.Close())
+	e.l.Unlock()
+	writeEvents(e, 3, 3)
+	writeEvents(e, 4, 5)
+	assert.Equal(t, uint64(5), e.LastIndex())
+	assert.NoError(t, e.Close())
+
+	// The event after the missing one is marked as a gap.
+	r := NewEventLogReader(dir, 0)
+	defer r.Close()
+	var gaps []uint64
+	var indexes []uint64
+	for {
+		event, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			break
+		}
+		indexes = append(indexes, event.Index)
+		if event.Gap {
+			gaps = append(gaps, event.Index)
+		}
+	}
+	assert.Equal(t, []uint64{1, 2, 4, 5}, indexes)
+	assert.Equal(t, []uint64{4}, gaps)
+}
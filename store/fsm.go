@@ -1,9 +1,15 @@
 package store
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
+	"sync"
 
 	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
 	"google.golang.org/protobuf/proto"
 
 	"io"
@@ -13,34 +19,197 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	// checksumHistorySize is the number of checksums kept for verification.
+	checksumHistorySize = 16
+)
+
 // FSM is state storage.
 type FSM struct {
 	logger         *zap.Logger
 	policyOperator *PolicyOperator
+
+	l         sync.RWMutex
+	checksums []checksumRecord
+	diverged  bool
+
+	// stateL is held by Apply and Restore, it allows reading the state together with the index of the last applied log.
+	stateL sync.RWMutex
+	// observers are notified of the commands applied and the snapshots restored.
+	observers []applyObserver
+}
+
+// applyObserver is notified of the changes of the state of FSM, the calls are made under the state lock.
+type applyObserver interface {
+	// applied is called after a command of the log is successfully applied.
+	applied(log *raft.Log, cmd *command.Command)
+	// restored is called after the state is restored from a snapshot.
+	restored()
+}
+
+// checksumRecord holds a checksum of the state at a log index.
+type checksumRecord struct {
+	index    uint64
+	checksum []byte
 }
 
 // NewFSM returns a FSM.
 func NewFSM(path string, enforcer casbin.IDistributedEnforcer) (*FSM, error) {
+	return newFSM(path, enforcer, nil)
+}
+
+// newFSM returns a FSM, onModelChange is called after a replicated model is set on enforcer.
+func newFSM(path string, enforcer casbin.IDistributedEnforcer, onModelChange func(e casbin.IDistributedEnforcer)) (*FSM, error) {
 	p, err := NewPolicyOperator(path, enforcer)
 	if err != nil {
 		return nil, err
 	}
+	p.onModelChange = onModelChange
 
 	f := &FSM{
 		logger:         zap.NewExample(),
 		policyOperator: p,
 	}
-	return f, err
+
+	// The database outlives the process, so the enforcer is rebuilt from it
+	// instead of relying on the log replay only.
+	err = p.LoadModel()
+	if err != nil {
+		f.logger.Error("failed to load model from database", zap.Error(err))
+		return nil, err
+	}
+	err = p.LoadPolicy()
+	if err != nil {
+		f.logger.Error("failed to load policy from database", zap.Error(err))
+		return nil, err
+	}
+	err = p.LoadNamespaces()
+	if err != nil {
+		f.logger.Error("failed to load namespaces from database", zap.Error(err))
+		return nil, err
+	}
+
+	return f, nil
 }
 
 // Apply applies log from raft.
 func (f *FSM) Apply(log *raft.Log) interface{} {
+	f.stateL.Lock()
+	defer f.stateL.Unlock()
+
 	var cmd command.Command
 	err := proto.Unmarshal(log.Data, &cmd)
 	if err != nil {
 		f.logger.Error("cannot to unmarshal the command", zap.Error(err), zap.ByteString("command", log.Data))
 		return err
 	}
+	var hash []byte
+	if len(cmd.RequestId) > 0 {
+		hash = commandHash(&cmd)
+		record, err := f.policyOperator.Request(cmd.RequestId)
+		if err != nil {
+			f.logger.Error("cannot to read the result of the request", zap.Error(err), zap.String("id", cmd.RequestId))
+			return err
+		}
+		// A retry returns the result of the first command, the log of the first command itself is applied again
+		// when the logs after the last snapshot are replayed. The records written before the hash was added have none.
+		if record != nil && record.Index != log.Index {
+			if len(record.Hash) > 0 && !bytes.Equal(record.Hash, hash) {
+				return http.NewError(http.ErrorCodeConflict, fmt.Sprintf("the request ID %s is already used by a different command", cmd.RequestId))
+			}
+			return recordedResult(record)
+		}
+		f.policyOperator.StageRequest(cmd.RequestId, &command.RequestRecord{Index: log.Index, Hash: hash})
+	}
+	resp := f.apply(log, &cmd)
+	if len(cmd.RequestId) > 0 {
+		resp = f.recordRequest(log.Index, cmd.RequestId, hash, resp)
+	}
+	if err, ok := resp.(error); !ok || err == nil {
+		for _, observer := range f.observers {
+			observer.applied(log, &cmd)
+		}
+	}
+	return resp
+}
+
+// recordRequest records the response of a command with a request ID, so that its retries return the same result,
+// and returns the response of the apply.
+// The record of an applied command is committed with its changes, see PolicyOperator.StageRequest. The record of a
+// failed command, or of a command that does not change the database, is written on its own, if that write fails
+// the apply of a command that did not fail fails too, so that a retry applies the command again.
+func (f *FSM) recordRequest(index uint64, id string, hash []byte, resp interface{}) interface{} {
+	written := f.policyOperator.UnstageRequest()
+	failed, ok := resp.(error)
+	if written && (!ok || failed == nil) {
+		return resp
+	}
+
+	record := &command.RequestRecord{Index: index, Hash: hash}
+	if ok && failed != nil {
+		err := fsmError(failed)
+		record.Code = string(http.CodeOf(err))
+		record.Error = err.Error()
+	}
+	err := f.policyOperator.RecordRequest(id, record)
+	if err != nil {
+		f.logger.Error("failed to record the result of the request", zap.Error(err), zap.String("id", id), zap.Uint64("index", index))
+		if !ok || failed == nil {
+			return err
+		}
+	}
+	return resp
+}
+
+// commandHash returns the SHA-256 of the type, the namespace and the data of a command, which tells whether
+// two commands with the same request ID are the same write.
+func commandHash(cmd *command.Command) []byte {
+	h := sha256.New()
+	var b [binary.MaxVarintLen64]byte
+	h.Write(b[:binary.PutUvarint(b[:], uint64(cmd.Type))])
+	h.Write(b[:binary.PutUvarint(b[:], uint64(len(cmd.Namespace)))])
+	h.Write([]byte(cmd.Namespace))
+	h.Write(cmd.Data)
+	return h.Sum(nil)
+}
+
+// recordedResult returns the response of the recorded result of a command.
+func recordedResult(record *command.RequestRecord) interface{} {
+	if len(record.Code) == 0 {
+		return nil
+	}
+	return http.NewError(http.ErrorCode(record.Code), record.Error)
+}
+
+// addObserver adds an observer of the changes of the state, it can be called while raft is running.
+func (f *FSM) addObserver(observer applyObserver) {
+	f.stateL.Lock()
+	defer f.stateL.Unlock()
+	f.observers = append(f.observers, observer)
+}
+
+// View calls fn with the state locked against Apply and Restore.
+func (f *FSM) View(fn func() error) error {
+	f.stateL.RLock()
+	defer f.stateL.RUnlock()
+	return fn()
+}
+
+// apply applies a command of the log.
+func (f *FSM) apply(log *raft.Log, cmd *command.Command) interface{} {
+	operator, err := f.policyOperator.Namespace(cmd.Namespace)
+	if err != nil {
+		f.logger.Error("cannot to find the namespace of the command", zap.Error(err), zap.String("namespace", cmd.Namespace))
+		return err
+	}
+	frozen, err := operator.Frozen()
+	if err != nil {
+		f.logger.Error("cannot to read whether the namespace is frozen", zap.Error(err), zap.String("namespace", cmd.Namespace))
+		return err
+	}
+	if frozen {
+		return errors.Wrapf(errNamespaceFrozen, "namespace %s", cmd.Namespace)
+	}
 	switch cmd.Type {
 	case command.Command_COMMAND_TYPE_ADD_POLICIES:
 		var request command.AddPoliciesRequest
@@ -53,7 +222,7 @@ func (f *FSM) Apply(log *raft.Log) interface{} {
 		for _, rule := range request.Rules {
 			rules = append(rules, rule.GetItems())
 		}
-		err = f.policyOperator.AddPolicies(request.Sec, request.PType, rules)
+		err = operator.AddPolicies(request.Sec, request.PType, rules)
 		if err != nil {
 			f.logger.Error("apply the add policies request failed", zap.Error(err), zap.String("request", request.String()))
 		}
@@ -69,7 +238,7 @@ func (f *FSM) Apply(log *raft.Log) interface{} {
 		for _, rule := range request.Rules {
 			rules = append(rules, rule.GetItems())
 		}
-		err = f.policyOperator.RemovePolicies(request.Sec, request.PType, rules)
+		err = operator.RemovePolicies(request.Sec, request.PType, rules)
 		if err != nil {
 			f.logger.Error("apply the remove policies request failed", zap.Error(err), zap.String("request", request.String()))
 		}
@@ -81,7 +250,7 @@ func (f *FSM) Apply(log *raft.Log) interface{} {
 			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
 			return err
 		}
-		err = f.policyOperator.RemoveFilteredPolicy(request.Sec, request.PType, int(request.FieldIndex), request.FieldValues...)
+		err = operator.RemoveFilteredPolicy(request.Sec, request.PType, int(request.FieldIndex), request.FieldValues...)
 		if err != nil {
 			f.logger.Error("apply the remove filtered policy request failed", zap.Error(err), zap.String("request", request.String()))
 		}
@@ -93,7 +262,7 @@ func (f *FSM) Apply(log *raft.Log) interface{} {
 			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
 			return err
 		}
-		err = f.policyOperator.UpdatePolicy(request.Sec, request.PType, request.OldRule, request.NewRule)
+		err = operator.UpdatePolicy(request.Sec, request.PType, request.OldRule, request.NewRule)
 		if err != nil {
 			f.logger.Error("apply the update policy request failed", zap.Error(err), zap.String("request", request.String()))
 		}
@@ -114,17 +283,189 @@ func (f *FSM) Apply(log *raft.Log) interface{} {
 			newRules = append(newRules, rule.GetItems())
 		}
 
-		err = f.policyOperator.UpdatePolicies(request.Sec, request.PType, oldRules, newRules)
+		err = operator.UpdatePolicies(request.Sec, request.PType, oldRules, newRules)
 		if err != nil {
 			f.logger.Error("apply the update policies request failed", zap.Error(err), zap.String("request", request.String()))
 		}
 		return err
+	case command.Command_COMMAND_TYPE_MOVE_POLICY:
+		var request command.MovePolicyRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = operator.MovePolicy(request.Sec, request.PType, request.Rule, int(request.Position))
+		if err != nil {
+			f.logger.Error("apply the move policy request failed", zap.Error(err), zap.String("request", request.String()))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_SET_MODEL:
+		var request command.SetModelRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = operator.SetModel(request.Text)
+		if err != nil {
+			f.logger.Error("apply the set model request failed", zap.Error(err))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_ASSIGN_ROLE:
+		var request command.AssignRoleRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = operator.AssignRole(request.PType, request.User, request.Role, request.Domain...)
+		if err != nil {
+			f.logger.Error("apply the assign role request failed", zap.Error(err), zap.String("request", request.String()))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_UNASSIGN_ROLE:
+		var request command.UnassignRoleRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = operator.UnassignRole(request.PType, request.User, request.Role, request.Domain...)
+		if err != nil {
+			f.logger.Error("apply the unassign role request failed", zap.Error(err), zap.String("request", request.String()))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_DELETE_ROLE:
+		var request command.DeleteRoleRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = operator.DeleteRole(request.Role)
+		if err != nil {
+			f.logger.Error("apply the delete role request failed", zap.Error(err), zap.String("request", request.String()))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_CREATE_NAMESPACE:
+		var request command.CreateNamespaceRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = f.policyOperator.CreateNamespace(request.Namespace, request.Model)
+		if err != nil {
+			f.logger.Error("apply the create namespace request failed", zap.Error(err), zap.String("namespace", request.Namespace))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_DELETE_NAMESPACE:
+		var request command.DeleteNamespaceRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = f.policyOperator.DeleteNamespace(request.Namespace)
+		if err != nil {
+			f.logger.Error("apply the delete namespace request failed", zap.Error(err), zap.String("namespace", request.Namespace))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_FREEZE_NAMESPACE:
+		var request command.FreezeNamespaceRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = f.policyOperator.FreezeNamespace(request.Namespace, request.Frozen)
+		if err != nil {
+			f.logger.Error("apply the freeze namespace request failed", zap.Error(err), zap.String("request", request.String()))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_SET_ROUTE:
+		var request command.SetRouteRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = f.policyOperator.SetRoute(request.Namespace, int(request.Group))
+		if err != nil {
+			f.logger.Error("apply the set route request failed", zap.Error(err), zap.String("request", request.String()))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_IMPORT_POLICIES:
+		var request command.ImportPoliciesRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		var rules []Rule
+		for _, rule := range request.Rules {
+			rules = append(rules, Rule{Sec: rule.Sec, PType: rule.PType, Rule: rule.Rule})
+		}
+		err = operator.ImportPolicies(request.Id, log.Term, rules)
+		if err != nil {
+			f.logger.Error("apply the import policies request failed", zap.Error(err), zap.String("id", request.Id), zap.Int("rules", len(rules)))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_COMMIT_IMPORT:
+		var request command.CommitImportRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = operator.CommitImport(request.Id, request.Discard, request.Seed)
+		if err != nil {
+			f.logger.Error("apply the commit import request failed", zap.Error(err), zap.String("request", request.String()))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_DISCARD_IMPORTS:
+		// The imports of the earlier terms are discarded, the current leader has started none of them.
+		err := f.policyOperator.DiscardImports(log.Term)
+		if err != nil {
+			f.logger.Error("apply the discard imports request failed", zap.Error(err), zap.Uint64("term", log.Term))
+		}
+		return err
+	case command.Command_COMMAND_TYPE_SET_SINK_CURSOR:
+		var request command.SetSinkCursorRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		err = f.policyOperator.SetSinkCursor(request.Index)
+		if err != nil {
+			f.logger.Error("apply the set sink cursor request failed", zap.Error(err), zap.Uint64("index", request.Index))
+		}
+		return err
 	case command.Command_COMMAND_TYPE_CLEAR_POLICY:
-		err := f.policyOperator.ClearPolicy()
+		err := operator.ClearPolicy()
 		if err != nil {
 			f.logger.Error("apply the clear policy request failed", zap.Error(err))
 		}
 		return err
+	case command.Command_COMMAND_TYPE_CHECKSUM:
+		checksum, err := f.policyOperator.Checksum()
+		if err != nil {
+			f.logger.Error("apply the checksum request failed", zap.Error(err))
+			return err
+		}
+		f.recordChecksum(log.Index, checksum)
+		return checksum
+	case command.Command_COMMAND_TYPE_VERIFY_CHECKSUM:
+		var request command.VerifyChecksumRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			f.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+			return err
+		}
+		f.verifyChecksum(request.Index, request.Checksum)
+		return nil
 	default:
 		err := fmt.Errorf("unknown command: %v", log)
 		f.logger.Error(err.Error())
@@ -132,16 +473,100 @@ func (f *FSM) Apply(log *raft.Log) interface{} {
 	}
 }
 
+// recordChecksum records the checksum of the state at the given index.
+func (f *FSM) recordChecksum(index uint64, checksum []byte) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	f.checksums = append(f.checksums, checksumRecord{index: index, checksum: checksum})
+	if len(f.checksums) > checksumHistorySize {
+		f.checksums = f.checksums[len(f.checksums)-checksumHistorySize:]
+	}
+}
+
+// verifyChecksum compares the checksum recorded at the given index with the expected checksum.
+// If they are different, the FSM is flagged as diverged.
+func (f *FSM) verifyChecksum(index uint64, expected []byte) {
+	f.l.Lock()
+	defer f.l.Unlock()
+
+	for _, record := range f.checksums {
+		if record.index != index {
+			continue
+		}
+		if !bytes.Equal(record.checksum, expected) {
+			f.diverged = true
+			f.logger.Error("the state has diverged from the leader", zap.Uint64("index", index),
+				zap.Binary("checksum", record.checksum), zap.Binary("expected", expected))
+		}
+		return
+	}
+
+	f.logger.Warn("no checksum is recorded at the index, skip verification", zap.Uint64("index", index))
+}
+
+// LastChecksum returns the latest recorded checksum and its index.
+func (f *FSM) LastChecksum() (uint64, []byte) {
+	f.l.RLock()
+	defer f.l.RUnlock()
+
+	if len(f.checksums) == 0 {
+		return 0, nil
+	}
+	record := f.checksums[len(f.checksums)-1]
+	return record.index, record.checksum
+}
+
+// Diverged checks whether the state has diverged from the leader.
+func (f *FSM) Diverged() bool {
+	f.l.RLock()
+	defer f.l.RUnlock()
+	return f.diverged
+}
+
 // Restore is used to restore an FSM from a snapshot. It is not called
 // concurrently with any other command. The FSM must discard all previous
 // state.
 func (f *FSM) Restore(rc io.ReadCloser) error {
+	f.stateL.Lock()
+	defer f.stateL.Unlock()
+
 	f.logger.Info("start restore")
 	err := f.policyOperator.Restore(rc)
 	if err != nil {
 		f.logger.Error("failed to restore an FSM from a snapshot", zap.Error(err))
+		return err
+	}
+
+	err = f.policyOperator.LoadModel()
+	if err != nil {
+		f.logger.Error("failed to load model after restoring an FSM from a snapshot", zap.Error(err))
+		return err
 	}
-	return err
+
+	err = f.policyOperator.LoadPolicy()
+	if err != nil {
+		f.logger.Error("failed to load policy after restoring an FSM from a snapshot", zap.Error(err))
+		return err
+	}
+
+	err = f.policyOperator.LoadNamespaces()
+	if err != nil {
+		f.logger.Error("failed to load namespaces after restoring an FSM from a snapshot", zap.Error(err))
+		return err
+	}
+
+	// The state is replaced by the snapshot, so the previous checksums are no longer meaningful.
+	f.l.Lock()
+	f.checksums = nil
+	f.diverged = false
+	f.l.Unlock()
+
+	for _, observer := range f.observers {
+		observer.restored()
+	}
+
+	return nil
 }
 
 // Snapshot is used to support log compaction. This call should
@@ -0,0 +1,95 @@
+package store
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/golang/mock/gomock"
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/store/mocks"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestFSM_Restore(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e.EXPECT().GetModel().Return(model.Model{})
+	e.EXPECT().ClearPolicySelf(nil)
+	e.EXPECT().BuildRoleLinks()
+	f, err := NewFSM(dir, e)
+	assert.NoError(t, err)
+
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/", "*"}}).Return([][]string{{"role:admin", "/", "*"}}, nil)
+	err = f.policyOperator.AddPolicies("p", "p", [][]string{{"role:admin", "/", "*"}})
+	assert.NoError(t, err)
+
+	snapshot, err := f.Snapshot()
+	assert.NoError(t, err)
+	sink := raft.NewInmemSnapshotStore()
+	s, err := sink.Create(raft.SnapshotVersionMax, 1, 1, raft.Configuration{}, 0, nil)
+	assert.NoError(t, err)
+	err = snapshot.Persist(s)
+	assert.NoError(t, err)
+
+	_, rc, err := sink.Open(s.ID())
+	assert.NoError(t, err)
+
+	e.EXPECT().GetModel().Return(model.Model{})
+	e.EXPECT().ClearPolicySelf(nil)
+	e.EXPECT().BuildRoleLinks()
+	e.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"role:admin", "/", "*"}})
+	err = f.Restore(rc)
+	assert.NoError(t, err)
+}
+
+func TestFSM_VerifyChecksum(t *testing.T) {
+	ctl := gomock.NewController(t)
+	defer ctl.Finish()
+
+	e := mocks.NewMockIDistributedEnforcer(ctl)
+
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e.EXPECT().GetModel().Return(model.Model{})
+	e.EXPECT().ClearPolicySelf(nil)
+	e.EXPECT().BuildRoleLinks()
+	f, err := NewFSM(dir, e)
+	assert.NoError(t, err)
+
+	data, err := proto.Marshal(&command.Command{Type: command.Command_COMMAND_TYPE_CHECKSUM})
+	assert.NoError(t, err)
+	resp := f.Apply(&raft.Log{Index: 3, Data: data})
+	checksum, ok := resp.([]byte)
+	assert.True(t, ok)
+
+	index, last := f.LastChecksum()
+	assert.Equal(t, uint64(3), index)
+	assert.Equal(t, checksum, last)
+
+	verify := func(checksum []byte) {
+		request, err := proto.Marshal(&command.VerifyChecksumRequest{Index: 3, Checksum: checksum})
+		assert.NoError(t, err)
+		data, err := proto.Marshal(&command.Command{Type: command.Command_COMMAND_TYPE_VERIFY_CHECKSUM, Data: request})
+		assert.NoError(t, err)
+		assert.Nil(t, f.Apply(&raft.Log{Index: 4, Data: data}))
+	}
+
+	verify(checksum)
+	assert.False(t, f.Diverged())
+
+	verify([]byte("unexpected"))
+	assert.True(t, f.Diverged())
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"net"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+const (
+	// muxHeaderTimeout is the timeout to read the header of an accepted connection.
+	muxHeaderTimeout = 10 * time.Second
+)
+
+var errStreamLayerClosed = errors.New("stream layer is closed")
+
+// StreamMux shares a StreamLayer between several raft groups.
+// Each connection starts with a byte that identifies the raft group it belongs to.
+type StreamMux struct {
+	layer raft.StreamLayer
+
+	l      sync.Mutex
+	groups map[byte]*muxStreamLayer
+
+	shutdownCh chan struct{}
+	closeOnce  sync.Once
+
+	logger *zap.Logger
+}
+
+// NewStreamMux returns a StreamMux and starts accepting connections from the StreamLayer.
+func NewStreamMux(layer raft.StreamLayer) *StreamMux {
+	m := &StreamMux{
+		layer:      layer,
+		groups:     make(map[byte]*muxStreamLayer),
+		shutdownCh: make(chan struct{}),
+		logger:     zap.NewExample(),
+	}
+	go m.serve()
+	return m
+}
+
+// Layer returns the StreamLayer of a raft group.
+func (m *StreamMux) Layer(group byte) raft.StreamLayer {
+	m.l.Lock()
+	defer m.l.Unlock()
+
+	layer, ok := m.groups[group]
+	if !ok {
+		layer = &muxStreamLayer{
+			mux:     m,
+			group:   group,
+			connCh:  make(chan net.Conn),
+			closeCh: make(chan struct{}),
+		}
+		m.groups[group] = layer
+	}
+	return layer
+}
+
+// Close closes the StreamLayer, the StreamLayers of the raft groups stop accepting connections.
+func (m *StreamMux) Close() error {
+	var err error
+	m.closeOnce.Do(func() {
+		close(m.shutdownCh)
+		err = m.layer.Close()
+	})
+	return err
+}
+
+// serve accepts connections and dispatches them to the raft groups.
+func (m *StreamMux) serve() {
+	for {
+		conn, err := m.layer.Accept()
+		if err != nil {
+			select {
+			case <-m.shutdownCh:
+				return
+			default:
+			}
+			m.logger.Error("failed to accept a connection", zap.Error(err))
+			time.Sleep(100 * time.Millisecond)
+			continue
+		}
+		go m.handle(conn)
+	}
+}
+
+// handle reads the header of a connection and hands it over to the raft group.
+func (m *StreamMux) handle(conn net.Conn) {
+	var header [1]byte
+	_ = conn.SetReadDeadline(time.Now().Add(muxHeaderTimeout))
+	_, err := conn.Read(header[:])
+	if err != nil {
+		m.logger.Error("failed to read the header of a connection", zap.Error(err), zap.String("remote", conn.RemoteAddr().String()))
+		_ = conn.Close()
+		return
+	}
+	_ = conn.SetReadDeadline(time.Time{})
+
+	m.l.Lock()
+	layer, ok := m.groups[header[0]]
+	m.l.Unlock()
+	if !ok {
+		m.logger.Error("unknown raft group of a connection", zap.Uint8("group", header[0]), zap.String("remote", conn.RemoteAddr().String()))
+		_ = conn.Close()
+		return
+	}
+
+	select {
+	case layer.connCh <- conn:
+	case <-layer.closeCh:
+		_ = conn.Close()
+	case <-m.shutdownCh:
+		_ = conn.Close()
+	}
+}
+
+// muxStreamLayer implements the raft.StreamLayer interface for a raft group of StreamMux.
+type muxStreamLayer struct {
+	mux       *StreamMux
+	group     byte
+	connCh    chan net.Conn
+	closeCh   chan struct{}
+	closeOnce sync.Once
+}
+
+// Dial implements the StreamLayer interface.
+func (l *muxStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	conn, err := l.mux.layer.Dial(address, timeout)
+	if err != nil {
+		return nil, err
+	}
+	_, err = conn.Write([]byte{l.group})
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// Accept implements the net.Listener interface.
+func (l *muxStreamLayer) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.connCh:
+		return conn, nil
+	case <-l.closeCh:
+		return nil, errStreamLayerClosed
+	case <-l.mux.shutdownCh:
+		return nil, errStreamLayerClosed
+	}
+}
+
+// Close implements the net.Listener interface, it does not close the shared StreamLayer.
+func (l *muxStreamLayer) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closeCh)
+	})
+	return nil
+}
+
+// Addr implements the net.Listener interface.
+func (l *muxStreamLayer) Addr() net.Addr {
+	return l.mux.layer.Addr()
+}
@@ -0,0 +1,65 @@
+package store
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+	"github.com/stretchr/testify/assert"
+)
+
+// tcpStreamLayer implements the raft.StreamLayer interface without TLS.
+type tcpStreamLayer struct {
+	net.Listener
+}
+
+func (t *tcpStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	return net.DialTimeout("tcp", string(address), timeout)
+}
+
+func newTCPStreamLayer(t *testing.T) *tcpStreamLayer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	return &tcpStreamLayer{Listener: ln}
+}
+
+func TestStreamMux(t *testing.T) {
+	mux := NewStreamMux(newTCPStreamLayer(t))
+	defer mux.Close()
+
+	layer0 := mux.Layer(0)
+	layer1 := mux.Layer(1)
+	assert.Equal(t, layer0, mux.Layer(0))
+	assert.Equal(t, layer0.Addr(), layer1.Addr())
+
+	conn, err := layer1.Dial(raft.ServerAddress(layer1.Addr().String()), time.Second)
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte("hello"))
+	assert.NoError(t, err)
+	assert.NoError(t, conn.Close())
+
+	accepted, err := layer1.Accept()
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(accepted)
+	assert.NoError(t, err)
+	assert.Equal(t, "hello", string(b))
+
+	// The connection of an unknown group is closed.
+	conn, err = net.Dial("tcp", layer1.Addr().String())
+	assert.NoError(t, err)
+	_, err = conn.Write([]byte{9})
+	assert.NoError(t, err)
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	assert.NoError(t, layer0.Close())
+	_, err = layer0.Accept()
+	assert.Equal(t, errStreamLayerClosed, err)
+
+	assert.NoError(t, mux.Close())
+	_, err = layer1.Accept()
+	assert.Equal(t, errStreamLayerClosed, err)
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/raft"
+	"go.uber.org/zap"
+)
+
+// peersFileName is the name of the file in the data directory that replaces the configuration of the cluster at start.
+const peersFileName = "peers.json"
+
+// peerEntry is a server of peers.json, see raft.ReadConfigJSON.
+type peerEntry struct {
+	ID       string `json:"id"`
+	Address  string `json:"address"`
+	NonVoter bool   `json:"non_voter"`
+}
+
+// WritePeers writes a peers.json to the data directory of a stopped node. At the next start, the node replaces
+// the configuration of the cluster with the servers, keeping its logs and snapshots, then the file is removed.
+// It is used to recover a cluster whose quorum is permanently lost, all the given servers must write the same peers.json.
+func WritePeers(dir string, servers []raft.Server) error {
+	var entries []peerEntry
+	for _, server := range servers {
+		entries = append(entries, peerEntry{
+			ID:       string(server.ID),
+			Address:  string(server.Address),
+			NonVoter: server.Suffrage == raft.Nonvoter,
+		})
+	}
+	b, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(dir, peersFileName)
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, b, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// recoverCluster replaces the configuration of the cluster with the peers.json of the data directory if it exists,
+// it is called before raft is started.
+func (s *Store) recoverCluster(config *raft.Config) error {
+	path := filepath.Join(s.dataDir, peersFileName)
+	_, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	configuration, err := raft.ReadConfigJSON(path)
+	if err != nil {
+		s.logger.Error("failed to read the peers file", zap.Error(err), zap.String("path", path))
+		return err
+	}
+	err = raft.RecoverCluster(config, s.fsm, s.logStore, s.stableStore, s.snapshotStore, s.transport, configuration)
+	if err != nil {
+		s.logger.Error("failed to recover the cluster", zap.Error(err), zap.String("path", path))
+		return err
+	}
+	// The recovery is done once, the later configuration changes must not be overwritten.
+	err = os.Remove(path)
+	if err != nil {
+		s.logger.Error("failed to remove the peers file", zap.Error(err), zap.String("path", path))
+		return err
+	}
+
+	var servers []string
+	for _, server := range configuration.Servers {
+		servers = append(servers, string(server.ID))
+	}
+	s.logger.Warn("recovered the cluster with the peers file", zap.String("path", path), zap.Strings("servers", servers))
+	return nil
+}
@@ -0,0 +1,85 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWritePeers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	servers := []raft.Server{
+		{Suffrage: raft.Voter, ID: "node1", Address: "127.0.0.1:6790"},
+		{Suffrage: raft.Nonvoter, ID: "node2", Address: "127.0.0.1:6780"},
+	}
+	err = WritePeers(dir, servers)
+	assert.NoError(t, err)
+
+	configuration, err := raft.ReadConfigJSON(filepath.Join(dir, peersFileName))
+	assert.NoError(t, err)
+	assert.Equal(t, servers, configuration.Servers)
+}
+
+func TestStore_RecoverCluster(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	eventDir := filepath.Join(dir, "events")
+
+	newStore := func() *Store {
+		e, err := casbin.NewDistributedEnforcer(newTestModel(t))
+		assert.NoError(t, err)
+		s, err := NewStore(&Config{
+			ID:  "node",
+			Dir: dir,
+			NetworkTransportConfig: &raft.NetworkTransportConfig{
+				Stream:  newTCPStreamLayer(t),
+				MaxPool: 5,
+				Timeout: 10 * time.Second,
+			},
+			Enforcer: e,
+			EventLog: &EventLogConfig{Dir: eventDir},
+		})
+		assert.NoError(t, err)
+		return s
+	}
+
+	s := newStore()
+	assert.NoError(t, s.Start(true))
+	assert.NoError(t, s.WaitLeader())
+	err = s.AddPolicies(context.Background(), &command.AddPoliciesRequest{
+		Sec:   "p",
+		PType: "p",
+		Rules: []*command.StringArray{{Items: []string{"alice", "data1", "read"}}},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Stop())
+
+	// The logs replayed by the recovery are not written to the event log, the recovered snapshot is.
+	assert.NoError(t, os.RemoveAll(eventDir))
+	err = WritePeers(dir, []raft.Server{{Suffrage: raft.Voter, ID: "node", Address: "127.0.0.1:6790"}})
+	assert.NoError(t, err)
+	s = newStore()
+	assert.NoError(t, s.Start(false))
+	defer s.Stop()
+
+	r := NewEventLogReader(eventDir, 0)
+	defer r.Close()
+	event, err := r.Next()
+	assert.NoError(t, err)
+	assert.True(t, event.Restored)
+	ok, err := s.Enforce("alice", "data1", "read")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
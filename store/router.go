@@ -0,0 +1,227 @@
+package store
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"sort"
+	"sync"
+
+	"github.com/hashicorp/go-multierror"
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+)
+
+var _ http.Store = &Router{}
+var _ http.NamespacedStore = &Router{}
+var _ http.ShardedStore = &Router{}
+
+// Router spreads the namespaces over several raft groups of the current node.
+// The first group holds the default namespace and the routing table, the routing table
+// overrides the group given by the hash of a namespace, which allows moving a namespace
+// between groups.
+//
+// The methods of the default namespace and the node methods are served by the first group.
+type Router struct {
+	*Store
+	groups []*Store
+
+	// routesL guards routes, the routing table of the first group, which is read again when a route is applied
+	// or a snapshot is restored by the first group.
+	routesL sync.RWMutex
+	routes  map[string]int
+}
+
+// NewRouter returns a Router of the given raft groups.
+func NewRouter(groups []*Store) (*Router, error) {
+	if len(groups) == 0 {
+		return nil, errors.New("groups are not provided")
+	}
+	if len(groups) > 256 {
+		return nil, errors.New("the number of groups cannot exceed 256")
+	}
+	r := &Router{
+		Store:  groups[0],
+		groups: groups,
+	}
+	// The observer is added before the routing table is read, so no route applied in between is missed.
+	groups[0].fsm.addObserver(r)
+	r.refreshRoutes()
+	return r, nil
+}
+
+// applied implements the applyObserver interface, the routing table is read again when a route is applied.
+func (r *Router) applied(log *raft.Log, cmd *command.Command) {
+	if cmd.Type == command.Command_COMMAND_TYPE_SET_ROUTE {
+		r.refreshRoutes()
+	}
+}
+
+// restored implements the applyObserver interface, the routing table is read again from the restored state.
+func (r *Router) restored() {
+	r.refreshRoutes()
+}
+
+// refreshRoutes reads the routing table of the first group, the previous table is kept if it cannot be read.
+func (r *Router) refreshRoutes() {
+	routes, err := r.groups[0].Routes()
+	if err != nil {
+		r.logger.Error("failed to read the routing table", zap.Error(err))
+		return
+	}
+	r.routesL.Lock()
+	defer r.routesL.Unlock()
+	r.routes = routes
+}
+
+// Group implements the http.ShardedStore interface.
+func (r *Router) Group(id int) (http.Store, error) {
+	if id < 0 || id >= len(r.groups) {
+		return nil, errors.Errorf("the group %d does not exist", id)
+	}
+	return r.groups[id], nil
+}
+
+// Groups returns the number of raft groups.
+func (r *Router) Groups() int {
+	return len(r.groups)
+}
+
+// Route returns the raft group of a namespace.
+func (r *Router) Route(namespace string) int {
+	if len(namespace) == 0 {
+		return 0
+	}
+	r.routesL.RLock()
+	group, ok := r.routes[namespace]
+	r.routesL.RUnlock()
+	if ok && group < len(r.groups) {
+		return group
+	}
+	return hashGroup(namespace, len(r.groups))
+}
+
+// RoutingTable implements the http.ShardedStore interface.
+func (r *Router) RoutingTable() (*command.RoutingTable, error) {
+	routes, err := r.groups[0].Routes()
+	if err != nil {
+		return nil, err
+	}
+
+	names := r.Namespaces()
+	for name := range routes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	table := &command.RoutingTable{
+		Groups: int32(len(r.groups)),
+	}
+	for i, name := range names {
+		if i > 0 && names[i-1] == name {
+			continue
+		}
+		group, ok := routes[name]
+		if !ok || group >= len(r.groups) {
+			group = hashGroup(name, len(r.groups))
+		}
+		table.Routes = append(table.Routes, &command.Route{
+			Namespace: name,
+			Group:     int32(group),
+		})
+	}
+	return table, nil
+}
+
+// SetRoute implements the http.ShardedStore interface.
+func (r *Router) SetRoute(ctx context.Context, request *command.SetRouteRequest) error {
+	if request.Group < 0 || int(request.Group) >= len(r.groups) {
+		return errors.Errorf("the group %d does not exist", request.Group)
+	}
+	return r.groups[0].SetRoute(ctx, request)
+}
+
+// WithNamespace implements the http.NamespacedStore interface.
+func (r *Router) WithNamespace(namespace string) http.Store {
+	return r.groups[r.Route(namespace)].WithNamespace(namespace)
+}
+
+// Namespaces implements the http.Store interface, it returns the namespaces of all groups.
+func (r *Router) Namespaces() []string {
+	var names []string
+	for _, group := range r.groups {
+		names = append(names, group.Namespaces()...)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Stop stops all raft groups.
+func (r *Router) Stop() error {
+	var ret error
+	for _, group := range r.groups {
+		err := group.Stop()
+		if err != nil {
+			ret = multierror.Append(ret, err)
+		}
+	}
+	return ret
+}
+
+// hashGroup returns the group given by the hash of a namespace.
+func hashGroup(namespace string, groups int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(namespace))
+	return int(h.Sum32() % uint32(groups))
+}
+
+// Backup implements the http.Store interface, it writes a section for each raft group.
+func (r *Router) Backup(w io.Writer) error {
+	for i, group := range r.groups {
+		err := group.backup(w, i, len(r.groups))
+		if err != nil {
+			return errors.Wrapf(err, "failed to back up the group %d", i)
+		}
+	}
+	return nil
+}
+
+// Restore implements the http.Store interface, it restores the section of each raft group,
+// the current node must be the leader of all groups.
+func (r *Router) Restore(ctx context.Context, reader io.Reader) error {
+	restored := 0
+	for {
+		section, err := ReadBackupSection(reader)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		err = r.restoreSection(ctx, section)
+		section.Close()
+		if err != nil {
+			return err
+		}
+		restored++
+	}
+	if restored != len(r.groups) {
+		return errors.Errorf("the backup has %d sections, expected %d", restored, len(r.groups))
+	}
+	return nil
+}
+
+// restoreSection restores a section to its raft group.
+func (r *Router) restoreSection(ctx context.Context, section *BackupSection) error {
+	if int(section.Header.Groups) != len(r.groups) {
+		return errors.Errorf("the backup has %d groups, the cluster has %d", section.Header.Groups, len(r.groups))
+	}
+	group := int(section.Header.Group)
+	if group < 0 || group >= len(r.groups) {
+		return errors.Errorf("the group %d does not exist", group)
+	}
+	return r.groups[group].restore(ctx, section)
+}
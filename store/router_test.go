@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRouter(t *testing.T) {
+	mux := NewStreamMux(newTCPStreamLayer(t))
+	defer mux.Close()
+
+	modelText := `
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = r.sub == p.sub && r.obj == p.obj && r.act == p.act
+`
+	var groups []*Store
+	for i := 0; i < 2; i++ {
+		dir, err := ioutil.TempDir("", "casbin-hraft-")
+		assert.NoError(t, err)
+		defer os.RemoveAll(dir)
+
+		m, err := model.NewModelFromString(modelText)
+		assert.NoError(t, err)
+		e, err := casbin.NewDistributedEnforcer(m)
+		assert.NoError(t, err)
+
+		s, err := NewStore(&Config{
+			ID:  "node",
+			Dir: dir,
+			NetworkTransportConfig: &raft.NetworkTransportConfig{
+				Stream:  mux.Layer(byte(i)),
+				MaxPool: 5,
+				Timeout: 10 * time.Second,
+			},
+			Enforcer: e,
+		})
+		assert.NoError(t, err)
+		assert.NoError(t, s.Start(true))
+		defer s.Stop()
+		assert.NoError(t, s.WaitLeader())
+		groups = append(groups, s)
+	}
+
+	r, err := NewRouter(groups)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, r.Groups())
+	assert.Equal(t, 0, r.Route(""))
+
+	// Find a namespace for each group.
+	names := make([]string, 2)
+	for i := 0; len(names[0]) == 0 || len(names[1]) == 0; i++ {
+		name := fmt.Sprintf("tenant%d", i)
+		names[hashGroup(name, 2)] = name
+	}
+	for i, name := range names {
+		assert.Equal(t, i, r.Route(name))
+		err = r.WithNamespace(name).CreateNamespace(context.Background(), &command.CreateNamespaceRequest{Namespace: name, Model: modelText})
+		assert.NoError(t, err)
+		assert.Equal(t, []string{name}, groups[i].Namespaces())
+	}
+	expected := append([]string(nil), names...)
+	sort.Strings(expected)
+	assert.Equal(t, expected, r.Namespaces())
+
+	err = r.SetRoute(context.Background(), &command.SetRouteRequest{Namespace: names[0], Group: 2})
+	assert.Error(t, err)
+
+	// The writes to a frozen namespace are rejected until it is unfrozen.
+	source := groups[0].WithNamespace(names[0])
+	rules := []*command.StringArray{{Items: []string{"alice", "/", "GET"}}}
+	err = groups[0].FreezeNamespace(context.Background(), &command.FreezeNamespaceRequest{Namespace: names[0], Frozen: true})
+	assert.NoError(t, err)
+	err = source.AddPolicies(context.Background(), &command.AddPoliciesRequest{Sec: "p", PType: "p", Rules: rules})
+	assert.Equal(t, http.ErrUnavailable, errors.Cause(err))
+	err = groups[0].FreezeNamespace(context.Background(), &command.FreezeNamespaceRequest{Namespace: names[0]})
+	assert.NoError(t, err)
+	err = source.AddPolicies(context.Background(), &command.AddPoliciesRequest{Sec: "p", PType: "p", Rules: rules})
+	assert.NoError(t, err)
+
+	err = r.SetRoute(context.Background(), &command.SetRouteRequest{Namespace: names[0], Group: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Route(names[0]))
+
+	// The cached routing table is refreshed when a route is applied by the first group.
+	err = groups[0].SetRoute(context.Background(), &command.SetRouteRequest{Namespace: names[1], Group: 0})
+	assert.NoError(t, err)
+	assert.Equal(t, 0, r.Route(names[1]))
+	err = groups[0].SetRoute(context.Background(), &command.SetRouteRequest{Namespace: names[1], Group: 1})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, r.Route(names[1]))
+
+	table, err := r.RoutingTable()
+	assert.NoError(t, err)
+	assert.Equal(t, int32(2), table.Groups)
+	for _, route := range table.Routes {
+		assert.Equal(t, int32(1), route.Group)
+	}
+
+	_, err = r.Group(2)
+	assert.Error(t, err)
+	g, err := r.Group(1)
+	assert.NoError(t, err)
+	assert.Equal(t, groups[1], g)
+}
@@ -0,0 +1,556 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/casbin/casbin/v2/persist"
+	"github.com/cenkalti/backoff/v4"
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/pkg/errors"
+	"go.uber.org/zap"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	// sinkQueueSize is the maximum number of applied commands waiting to be mirrored,
+	// the sink saves the whole policy instead when the queue overflows.
+	sinkQueueSize = 4096
+	// sinkCheckInterval is the interval at which the sink checks whether the current node should mirror.
+	sinkCheckInterval = time.Second
+)
+
+var (
+	// sinkCursorKey is the key of the index of the last mirrored log in the stable store.
+	sinkCursorKey = []byte("SinkCursor")
+
+	// errSinkResync means that a command cannot be mirrored alone, the whole policy must be saved.
+	errSinkResync = errors.New("the command cannot be mirrored incrementally")
+)
+
+// sinkEntry is an applied command waiting to be mirrored.
+type sinkEntry struct {
+	index uint64
+	cmd   *command.Command
+}
+
+// Sink mirrors the rules of the default namespace to an external persist.Adapter, such as a SQL adapter,
+// so that the external database eventually matches the cluster.
+//
+// The commands are mirrored one by one after they are applied by FSM. If a command cannot be mirrored,
+// the adapter does not support an operation, or the commands are missing, for example after a snapshot
+// is installed or the queue overflows, the whole policy is saved by persist.Adapter.SavePolicy instead.
+// The failures are retried with an exponential backoff. The index of the last mirrored log is persisted
+// in the stable store of raft, so the mirroring resumes after a restart. When the leader mirrors, the index is also
+// replicated through FSM every sinkCheckInterval, so a new leader resumes from it instead of saving the whole policy,
+// only the commands mirrored since the last replicated index are mirrored again.
+type Sink struct {
+	adapter  persist.Adapter
+	serverID string
+	store    *Store
+
+	l        sync.Mutex
+	queue    []sinkEntry
+	resync   bool
+	cursor   uint64
+	latest   uint64
+	notifyCh chan struct{}
+	doneCh   chan struct{}
+
+	// replicated is the last cursor replicated through FSM, it is guarded by l, see replicateCursor.
+	replicated uint64
+	// drops counts the times the queue lost commands, it is guarded by l. A save only ends the resync if the queue
+	// has not lost commands since it read the policy, see save.
+	drops uint64
+
+	logger *zap.Logger
+}
+
+// newSink returns a Sink of the store, an empty serverID means the leader mirrors.
+func newSink(store *Store, adapter persist.Adapter, serverID string) *Sink {
+	return &Sink{
+		adapter:  adapter,
+		serverID: serverID,
+		store:    store,
+		notifyCh: make(chan struct{}, 1),
+		doneCh:   make(chan struct{}),
+		logger:   zap.NewExample(),
+	}
+}
+
+// loadCursor reads the index of the last mirrored log from the stable store and the replicated one from FSM,
+// the larger one is used. Without a cursor, the sink starts by saving the whole policy.
+func (k *Sink) loadCursor() {
+	cursor, err := k.store.stableStore.GetUint64(sinkCursorKey)
+	if err != nil {
+		cursor = 0
+	}
+	replicated, err := k.store.fsm.policyOperator.SinkCursor()
+	if err != nil {
+		k.logger.Error("failed to read the replicated cursor of the sink", zap.Error(err))
+	}
+	k.l.Lock()
+	defer k.l.Unlock()
+	k.replicated = replicated
+	if replicated > cursor {
+		cursor = replicated
+	}
+	if cursor == 0 {
+		k.resync = true
+		return
+	}
+	k.cursor = cursor
+	k.latest = cursor
+}
+
+// Cursor returns the index of the last mirrored log.
+func (k *Sink) Cursor() uint64 {
+	k.l.Lock()
+	defer k.l.Unlock()
+	return k.cursor
+}
+
+// applied implements the applyObserver interface.
+func (k *Sink) applied(log *raft.Log, cmd *command.Command) {
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	index := log.Index
+	latest := k.latest
+	k.latest = index
+	if cmd.Type == command.Command_COMMAND_TYPE_SET_SINK_CURSOR {
+		k.appliedCursor(index, latest, cmd)
+		return
+	}
+	if index <= k.cursor || len(cmd.Namespace) > 0 {
+		return
+	}
+	// The commands are queued during a resync too, the ones applied after the saved policy are mirrored after it.
+	if len(k.queue) >= sinkQueueSize {
+		k.logger.Warn("the sink queue overflows, the whole policy will be saved", zap.Int("size", len(k.queue)))
+		k.queue = nil
+		k.resync = true
+		k.drops++
+	} else {
+		k.queue = append(k.queue, sinkEntry{index: index, cmd: cmd})
+	}
+	k.notify()
+}
+
+// appliedCursor moves the cursor to a replicated cursor, the caller must hold the lock.
+// If nothing else was waiting to be mirrored, the cursor moves past the log of the replicated cursor itself,
+// which is not replicated again.
+func (k *Sink) appliedCursor(index, latest uint64, cmd *command.Command) {
+	var request command.SetSinkCursorRequest
+	err := proto.Unmarshal(cmd.Data, &request)
+	if err != nil {
+		k.logger.Error("cannot to unmarshal the request", zap.Error(err), zap.ByteString("request", cmd.Data))
+		return
+	}
+	if request.Index > k.cursor {
+		k.cursor = request.Index
+		queue := k.queue[:0]
+		for _, entry := range k.queue {
+			if entry.index > k.cursor {
+				queue = append(queue, entry)
+			}
+		}
+		k.queue = queue
+	}
+	if k.cursor >= latest && len(k.queue) == 0 && !k.resync {
+		k.cursor = index
+	}
+	if k.cursor > k.replicated {
+		k.replicated = k.cursor
+	}
+}
+
+// restored implements the applyObserver interface.
+func (k *Sink) restored() {
+	var index uint64
+	snapshots, err := k.store.snapshotStore.List()
+	if err == nil && len(snapshots) > 0 {
+		index = snapshots[0].Index
+	}
+	replicated, err := k.store.fsm.policyOperator.SinkCursor()
+	if err != nil {
+		k.logger.Error("failed to read the replicated cursor of the sink", zap.Error(err))
+	}
+
+	k.l.Lock()
+	defer k.l.Unlock()
+
+	if replicated > k.cursor {
+		k.cursor = replicated
+	}
+	if replicated > k.replicated {
+		k.replicated = replicated
+	}
+
+	if index > k.latest {
+		k.latest = index
+	}
+	// The commands between the cursor and the snapshot are unknown.
+	if index == 0 || index > k.cursor {
+		k.queue = nil
+		k.resync = true
+		k.drops++
+	}
+	k.notify()
+}
+
+// notify wakes up the mirroring loop, the caller must hold the lock.
+func (k *Sink) notify() {
+	select {
+	case k.notifyCh <- struct{}{}:
+	default:
+	}
+}
+
+// active checks whether the current node should mirror.
+func (k *Sink) active() bool {
+	if len(k.serverID) > 0 {
+		return k.serverID == k.store.serverID
+	}
+	return k.store.raft.State() == raft.Leader
+}
+
+// run mirrors the applied commands until the store is stopped.
+func (k *Sink) run() {
+	defer close(k.doneCh)
+
+	b := backoff.NewExponentialBackOff()
+	b.MaxElapsedTime = 0
+	ticker := time.NewTicker(sinkCheckInterval)
+	defer ticker.Stop()
+
+	var retryAt time.Time
+	for {
+		select {
+		case <-k.store.shutdownCh:
+			return
+		case <-k.notifyCh:
+		case <-ticker.C:
+			k.replicateCursor()
+		}
+		// Wait for the backoff of the last failure.
+		if time.Now().Before(retryAt) || !k.active() {
+			continue
+		}
+
+		err := k.sync()
+		if err != nil {
+			delay := b.NextBackOff()
+			k.logger.Error("failed to mirror the policy to the sink adapter", zap.Error(err), zap.Duration("retry", delay))
+			retryAt = time.Now().Add(delay)
+			continue
+		}
+		retryAt = time.Time{}
+		b.Reset()
+	}
+}
+
+// replicateCursor replicates the cursor through FSM if it has moved since the last replicated one,
+// only the sink of the leader replicates it, the sink of a given server keeps it in its stable store only.
+func (k *Sink) replicateCursor() {
+	if len(k.serverID) > 0 || k.store.raft.State() != raft.Leader {
+		return
+	}
+	k.l.Lock()
+	cursor := k.cursor
+	replicated := k.replicated
+	k.l.Unlock()
+	if cursor <= replicated {
+		return
+	}
+
+	data, err := proto.Marshal(&command.SetSinkCursorRequest{Index: cursor})
+	if err != nil {
+		k.logger.Error("failed to encode the cursor of the sink", zap.Error(err))
+		return
+	}
+	err = k.store.applyProtoMessage(context.Background(), &command.Command{
+		Type: command.Command_COMMAND_TYPE_SET_SINK_CURSOR,
+		Data: data,
+	})
+	if err != nil {
+		k.logger.Error("failed to replicate the cursor of the sink", zap.Uint64("index", cursor), zap.Error(err))
+	}
+}
+
+// sync mirrors the queued commands, or saves the whole policy if it is needed.
+func (k *Sink) sync() error {
+	k.l.Lock()
+	resync := k.resync
+	cursor := k.cursor
+	latest := k.latest
+	// The queue is kept during a resync, save drops the commands of the saved policy from it.
+	var queue []sinkEntry
+	if !resync {
+		queue = k.queue
+		k.queue = nil
+	}
+	k.l.Unlock()
+
+	if !resync {
+		if len(queue) == 0 && cursor >= latest {
+			return nil
+		}
+		index, err := k.mirror(cursor, queue)
+		if index > cursor {
+			k.setCursor(index)
+		}
+		if err == nil {
+			return nil
+		}
+		if err != errSinkResync {
+			k.logger.Warn("failed to mirror a command to the sink adapter, the whole policy will be saved", zap.Error(err))
+		}
+		k.l.Lock()
+		k.resync = true
+		k.l.Unlock()
+	}
+	return k.save()
+}
+
+// mirror applies the queued commands to the adapter, it returns the index of the last mirrored command.
+func (k *Sink) mirror(cursor uint64, queue []sinkEntry) (uint64, error) {
+	for _, entry := range queue {
+		if entry.index <= cursor {
+			continue
+		}
+		err := k.mirrorCommand(entry.cmd)
+		if err != nil {
+			return cursor, err
+		}
+		cursor = entry.index
+	}
+	return cursor, nil
+}
+
+// mirrorCommand applies a command to the adapter.
+func (k *Sink) mirrorCommand(cmd *command.Command) error {
+	switch cmd.Type {
+	case command.Command_COMMAND_TYPE_ADD_POLICIES:
+		var request command.AddPoliciesRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			return err
+		}
+		return k.addPolicies(request.Sec, request.PType, toRules(request.Rules))
+	case command.Command_COMMAND_TYPE_REMOVE_POLICIES:
+		var request command.RemovePoliciesRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			return err
+		}
+		return k.removePolicies(request.Sec, request.PType, toRules(request.Rules))
+	case command.Command_COMMAND_TYPE_REMOVE_FILTERED_POLICY:
+		var request command.RemoveFilteredPolicyRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			return err
+		}
+		return k.adapter.RemoveFilteredPolicy(request.Sec, request.PType, int(request.FieldIndex), request.FieldValues...)
+	case command.Command_COMMAND_TYPE_UPDATE_POLICY:
+		var request command.UpdatePolicyRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			return err
+		}
+		return k.updatePolicy(request.Sec, request.PType, request.OldRule, request.NewRule)
+	case command.Command_COMMAND_TYPE_UPDATE_POLICIES:
+		var request command.UpdatePoliciesRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			return err
+		}
+		oldRules := toRules(request.OldRules)
+		newRules := toRules(request.NewRules)
+		for i := range oldRules {
+			err = k.updatePolicy(request.Sec, request.PType, oldRules[i], newRules[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case command.Command_COMMAND_TYPE_ASSIGN_ROLE:
+		var request command.AssignRoleRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			return err
+		}
+		return k.adapter.AddPolicy("g", request.PType, append([]string{request.User, request.Role}, request.Domain...))
+	case command.Command_COMMAND_TYPE_UNASSIGN_ROLE:
+		var request command.UnassignRoleRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			return err
+		}
+		return k.adapter.RemovePolicy("g", request.PType, append([]string{request.User, request.Role}, request.Domain...))
+	case command.Command_COMMAND_TYPE_IMPORT_POLICIES:
+		var request command.ImportPoliciesRequest
+		err := proto.Unmarshal(cmd.Data, &request)
+		if err != nil {
+			return err
+		}
+		// The staged rules are mirrored when the import is committed.
+		if len(request.Id) > 0 {
+			return nil
+		}
+		for _, rule := range request.Rules {
+			err = k.adapter.AddPolicy(rule.Sec, rule.PType, rule.Rule)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case command.Command_COMMAND_TYPE_CHECKSUM,
+		command.Command_COMMAND_TYPE_VERIFY_CHECKSUM,
+		command.Command_COMMAND_TYPE_CREATE_NAMESPACE,
+		command.Command_COMMAND_TYPE_DELETE_NAMESPACE,
+		command.Command_COMMAND_TYPE_FREEZE_NAMESPACE,
+		command.Command_COMMAND_TYPE_SET_ROUTE,
+		command.Command_COMMAND_TYPE_DISCARD_IMPORTS:
+		// The rules of the default namespace are not changed.
+		return nil
+	default:
+		return errSinkResync
+	}
+}
+
+// addPolicies adds the rules to the adapter, in a batch if it is supported.
+func (k *Sink) addPolicies(sec, pType string, rules [][]string) error {
+	if adapter, ok := k.adapter.(persist.BatchAdapter); ok {
+		return adapter.AddPolicies(sec, pType, rules)
+	}
+	for _, rule := range rules {
+		err := k.adapter.AddPolicy(sec, pType, rule)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removePolicies removes the rules from the adapter, in a batch if it is supported.
+func (k *Sink) removePolicies(sec, pType string, rules [][]string) error {
+	if adapter, ok := k.adapter.(persist.BatchAdapter); ok {
+		return adapter.RemovePolicies(sec, pType, rules)
+	}
+	for _, rule := range rules {
+		err := k.adapter.RemovePolicy(sec, pType, rule)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// updatePolicy replaces a rule in the adapter, it is a removal and an addition if the update is not supported.
+func (k *Sink) updatePolicy(sec, pType string, oldRule, newRule []string) error {
+	if adapter, ok := k.adapter.(persist.UpdatableAdapter); ok {
+		return adapter.UpdatePolicy(sec, pType, oldRule, newRule)
+	}
+	err := k.adapter.RemovePolicy(sec, pType, oldRule)
+	if err != nil {
+		return err
+	}
+	return k.adapter.AddPolicy(sec, pType, newRule)
+}
+
+// save saves the whole policy of the default namespace to the adapter.
+// The commands applied while the policy is saved stay in the queue and are mirrored after it, unless the queue
+// lost commands in the meantime, then the resync goes on.
+func (k *Sink) save() error {
+	var index, drops uint64
+	var rules []Rule
+	var defs model.Model
+	err := k.store.fsm.View(func() error {
+		k.l.Lock()
+		index = k.latest
+		drops = k.drops
+		k.l.Unlock()
+
+		var err error
+		_, rules, err = k.store.fsm.policyOperator.Export()
+		if err != nil {
+			return err
+		}
+		defs = k.store.fsm.policyOperator.enforcer.GetModel()
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// The rules are saved with an empty copy of the policy definitions.
+	m := model.NewModel()
+	for _, sec := range []string{"p", "g"} {
+		var keys []string
+		for key := range defs[sec] {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			m.AddDef(sec, key, defs[sec][key].Value)
+		}
+	}
+	for _, rule := range rules {
+		if _, ok := m[rule.Sec][rule.PType]; !ok {
+			m.AddDef(rule.Sec, rule.PType, "")
+		}
+		m[rule.Sec][rule.PType].Policy = append(m[rule.Sec][rule.PType].Policy, rule.Rule)
+	}
+
+	err = k.adapter.SavePolicy(m)
+	if err != nil {
+		return err
+	}
+
+	k.l.Lock()
+	if k.drops == drops {
+		k.resync = false
+	}
+	queue := k.queue[:0]
+	for _, entry := range k.queue {
+		if entry.index > index {
+			queue = append(queue, entry)
+		}
+	}
+	k.queue = queue
+	if len(k.queue) > 0 || k.resync {
+		k.notify()
+	}
+	k.l.Unlock()
+	k.setCursor(index)
+	k.logger.Info("saved the whole policy to the sink adapter", zap.Uint64("index", index), zap.Int("rules", len(rules)))
+	return nil
+}
+
+// setCursor sets and persists the index of the last mirrored log.
+func (k *Sink) setCursor(index uint64) {
+	k.l.Lock()
+	if index > k.cursor {
+		k.cursor = index
+	}
+	k.l.Unlock()
+
+	err := k.store.stableStore.SetUint64(sinkCursorKey, index)
+	if err != nil {
+		k.logger.Error("failed to persist the cursor of the sink", zap.Uint64("index", index), zap.Error(err))
+	}
+}
+
+// toRules converts the rules of a request.
+func toRules(items []*command.StringArray) [][]string {
+	var rules [][]string
+	for _, item := range items {
+		rules = append(rules, item.GetItems())
+	}
+	return rules
+}
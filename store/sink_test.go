@@ -0,0 +1,293 @@
+package store
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
+	"github.com/hashicorp/raft"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/protobuf/proto"
+)
+
+// recordingAdapter records the rules written by the sink.
+type recordingAdapter struct {
+	l     sync.Mutex
+	rules map[string][]string
+	saves int
+	fail  bool
+}
+
+func (a *recordingAdapter) LoadPolicy(model model.Model) error {
+	return nil
+}
+
+func (a *recordingAdapter) SavePolicy(model model.Model) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.fail {
+		return errors.New("the adapter is unavailable")
+	}
+	a.saves++
+	a.rules = make(map[string][]string)
+	for _, sec := range []string{"p", "g"} {
+		for pType, ast := range model[sec] {
+			for _, rule := range ast.Policy {
+				a.rules[Rule{Sec: sec, PType: pType, Rule: rule}.key()] = rule
+			}
+		}
+	}
+	return nil
+}
+
+func (a *recordingAdapter) AddPolicy(sec string, pType string, rule []string) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.fail {
+		return errors.New("the adapter is unavailable")
+	}
+	a.rules[Rule{Sec: sec, PType: pType, Rule: rule}.key()] = rule
+	return nil
+}
+
+func (a *recordingAdapter) RemovePolicy(sec string, pType string, rule []string) error {
+	a.l.Lock()
+	defer a.l.Unlock()
+	if a.fail {
+		return errors.New("the adapter is unavailable")
+	}
+	delete(a.rules, Rule{Sec: sec, PType: pType, Rule: rule}.key())
+	return nil
+}
+
+func (a *recordingAdapter) RemoveFilteredPolicy(sec string, pType string, fieldIndex int, fieldValues ...string) error {
+	return errors.New("not implemented")
+}
+
+func (a *recordingAdapter) state() (int, int) {
+	a.l.Lock()
+	defer a.l.Unlock()
+	return len(a.rules), a.saves
+}
+
+func TestSink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	e, err := casbin.NewDistributedEnforcer(m)
+	assert.NoError(t, err)
+
+	adapter := &recordingAdapter{rules: make(map[string][]string)}
+	s, err := NewStore(&Config{
+		ID:  "node",
+		Dir: dir,
+		NetworkTransportConfig: &raft.NetworkTransportConfig{
+			Stream:  newTCPStreamLayer(t),
+			MaxPool: 5,
+			Timeout: 10 * time.Second,
+		},
+		Enforcer:    e,
+		SinkAdapter: adapter,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start(true))
+	defer s.Stop()
+	assert.NoError(t, s.WaitLeader())
+
+	latest := func() uint64 {
+		s.sink.l.Lock()
+		defer s.sink.l.Unlock()
+		return s.sink.latest
+	}
+	waitCursor := func() uint64 {
+		index := latest()
+		assert.Eventually(t, func() bool {
+			return s.sink.Cursor() >= index
+		}, 10*time.Second, 10*time.Millisecond)
+		return index
+	}
+
+	// The sink starts by saving the whole policy.
+	assert.Eventually(t, func() bool {
+		_, saves := adapter.state()
+		return saves == 1
+	}, 10*time.Second, 10*time.Millisecond)
+	rules, saves := adapter.state()
+	assert.Equal(t, 0, rules)
+	assert.Equal(t, 1, saves)
+
+	// The rules are mirrored one by one.
+	err = s.AddPolicies(context.Background(), &command.AddPoliciesRequest{
+		Sec:   "p",
+		PType: "p",
+		Rules: []*command.StringArray{{Items: []string{"alice", "data1", "read"}}, {Items: []string{"bob", "data2", "write"}}},
+	})
+	assert.NoError(t, err)
+	err = s.AssignRole(context.Background(), &command.AssignRoleRequest{PType: "g", User: "alice", Role: "admin"})
+	assert.NoError(t, err)
+	err = s.UpdatePolicy(context.Background(), &command.UpdatePolicyRequest{
+		Sec:     "p",
+		PType:   "p",
+		OldRule: []string{"bob", "data2", "write"},
+		NewRule: []string{"bob", "data2", "read"},
+	})
+	assert.NoError(t, err)
+	index := waitCursor()
+	rules, saves = adapter.state()
+	assert.Equal(t, 3, rules)
+	assert.Equal(t, 1, saves)
+	assert.Contains(t, adapter.rules, Rule{Sec: "p", PType: "p", Rule: []string{"bob", "data2", "read"}}.key())
+
+	cursor, err := s.stableStore.GetUint64(sinkCursorKey)
+	assert.NoError(t, err)
+	assert.Equal(t, index, cursor)
+
+	// The cursor is replicated, so a new leader resumes the mirroring from it.
+	assert.Eventually(t, func() bool {
+		replicated, err := s.fsm.policyOperator.SinkCursor()
+		return err == nil && replicated >= index
+	}, 10*time.Second, 10*time.Millisecond)
+	waitCursor()
+
+	// A command that the adapter does not support falls back to saving the whole policy.
+	err = s.RemoveFilteredPolicy(context.Background(), &command.RemoveFilteredPolicyRequest{Sec: "p", PType: "p", FieldIndex: 0, FieldValues: []string{"bob"}})
+	assert.NoError(t, err)
+	waitCursor()
+	rules, saves = adapter.state()
+	assert.Equal(t, 2, rules)
+	assert.Equal(t, 2, saves)
+
+	// The failures are retried until the adapter recovers.
+	adapter.l.Lock()
+	adapter.fail = true
+	adapter.l.Unlock()
+	err = s.ClearPolicy(context.Background())
+	assert.NoError(t, err)
+	time.Sleep(2 * time.Second)
+	assert.True(t, s.sink.Cursor() < latest())
+	adapter.l.Lock()
+	adapter.fail = false
+	adapter.l.Unlock()
+	waitCursor()
+	rules, _ = adapter.state()
+	assert.Equal(t, 0, rules)
+}
+
+// blockingAdapter blocks SavePolicy until release is closed.
+type blockingAdapter struct {
+	*recordingAdapter
+	saving  chan struct{}
+	release chan struct{}
+}
+
+func (a *blockingAdapter) SavePolicy(model model.Model) error {
+	select {
+	case a.saving <- struct{}{}:
+	default:
+	}
+	<-a.release
+	return a.recordingAdapter.SavePolicy(model)
+}
+
+func TestSink_WriteDuringSave(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e, err := casbin.NewDistributedEnforcer(newTestModel(t))
+	assert.NoError(t, err)
+
+	adapter := &blockingAdapter{
+		recordingAdapter: &recordingAdapter{rules: make(map[string][]string)},
+		saving:           make(chan struct{}, 1),
+		release:          make(chan struct{}),
+	}
+	s, err := NewStore(&Config{
+		ID:  "node",
+		Dir: dir,
+		NetworkTransportConfig: &raft.NetworkTransportConfig{
+			Stream:  newTCPStreamLayer(t),
+			MaxPool: 5,
+			Timeout: 10 * time.Second,
+		},
+		Enforcer:    e,
+		SinkAdapter: adapter,
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start(true))
+	defer s.Stop()
+	assert.NoError(t, s.WaitLeader())
+
+	// The sink starts by saving the whole policy, a write is applied after the policy is read.
+	select {
+	case <-adapter.saving:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the sink does not save the whole policy")
+	}
+	err = s.AddPolicies(context.Background(), &command.AddPoliciesRequest{
+		Sec:   "p",
+		PType: "p",
+		Rules: []*command.StringArray{{Items: []string{"alice", "data1", "read"}}},
+	})
+	assert.NoError(t, err)
+	close(adapter.release)
+
+	// The write is mirrored after the save.
+	assert.Eventually(t, func() bool {
+		adapter.l.Lock()
+		defer adapter.l.Unlock()
+		_, ok := adapter.rules[Rule{Sec: "p", PType: "p", Rule: []string{"alice", "data1", "read"}}.key()]
+		return ok
+	}, 10*time.Second, 10*time.Millisecond)
+	_, saves := adapter.state()
+	assert.Equal(t, 1, saves)
+}
+
+func TestSink_ReplicatedCursor(t *testing.T) {
+	k := newSink(&Store{}, &recordingAdapter{rules: make(map[string][]string)}, "")
+	setCursor := func(index uint64) *command.Command {
+		data, err := proto.Marshal(&command.SetSinkCursorRequest{Index: index})
+		assert.NoError(t, err)
+		return &command.Command{Type: command.Command_COMMAND_TYPE_SET_SINK_CURSOR, Data: data}
+	}
+	add := &command.Command{Type: command.Command_COMMAND_TYPE_ADD_POLICIES}
+
+	// The commands mirrored by the leader are dropped from the queue of a follower.
+	k.applied(&raft.Log{Index: 1}, add)
+	k.applied(&raft.Log{Index: 2}, add)
+	k.applied(&raft.Log{Index: 3}, setCursor(1))
+	assert.Equal(t, uint64(1), k.Cursor())
+	assert.Len(t, k.queue, 1)
+	assert.Equal(t, uint64(2), k.queue[0].index)
+
+	// Once everything is mirrored, the cursor moves past the log of the replicated cursor.
+	k.applied(&raft.Log{Index: 4}, setCursor(3))
+	assert.Equal(t, uint64(4), k.Cursor())
+	assert.Empty(t, k.queue)
+	assert.Equal(t, uint64(4), k.replicated)
+}
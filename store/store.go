@@ -6,12 +6,15 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/cenkalti/backoff/v4"
 	"github.com/pkg/errors"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/persist"
 	"github.com/nodece/casbin-hraft-dispatcher/command"
 	"github.com/nodece/casbin-hraft-dispatcher/http"
 	"google.golang.org/protobuf/proto"
@@ -31,6 +34,7 @@ const (
 )
 
 var _ http.Store = &Store{}
+var _ http.NamespacedStore = &Store{}
 
 // Store is responsible for synchronization policy and storage policy by Raft protocol.
 type Store struct {
@@ -45,10 +49,31 @@ type Store struct {
 	snapshotStore          raft.SnapshotStore
 	logStore               raft.LogStore
 	stableStore            raft.StableStore
-	fms                    raft.FSM
+	fsm                    *FSM
 	boltStore              *raftboltdb.BoltStore
 
 	enforcer casbin.IDistributedEnforcer
+	// onModelChange is called after a replicated model is set on enforcer.
+	onModelChange func(e casbin.IDistributedEnforcer)
+
+	checksumInterval time.Duration
+	shutdownCh       chan struct{}
+	// stopped stops the store once, it is shared by the namespaced copies of the store.
+	stopped *stopState
+
+	// sink mirrors the rules to an external adapter, it is nil if the mirroring is disabled.
+	sink *Sink
+
+	eventLogConfig *EventLogConfig
+	// eventLog writes the applied commands to segment files, it is nil if it is disabled.
+	eventLog *EventLog
+
+	// namespace is the namespace that the commands of this Store are applied to,
+	// it is empty for the default namespace.
+	namespace string
+
+	// inFlight holds a slot for each write that is being applied, it is nil if the writes are not bounded.
+	inFlight chan struct{}
 
 	// inMemory is used for testing.
 	inMemory bool
@@ -61,6 +86,22 @@ type Config struct {
 	Dir                    string
 	NetworkTransportConfig *raft.NetworkTransportConfig
 	Enforcer               casbin.IDistributedEnforcer
+	// ChecksumInterval is the interval at which the leader verifies the state of all nodes.
+	// Zero disables the verification.
+	ChecksumInterval time.Duration
+	// SinkAdapter is the external adapter that the rules of the default namespace are mirrored to.
+	// Nil disables the mirroring.
+	SinkAdapter persist.Adapter
+	// SinkServerID is the ID of the node that mirrors the rules, empty means the leader mirrors.
+	SinkServerID string
+	// EventLog writes the applied commands to segment files if it is not nil.
+	EventLog *EventLogConfig
+	// MaxInFlight is the maximum number of writes that are being applied at once, a batch counts as one write,
+	// the writes over it fail with the overloaded code instead of waiting. Zero means no limit.
+	MaxInFlight int
+	// OnModelChange is called after a replicated model is set on Enforcer, which resets its watcher,
+	// effector and flags, so they can be set again. Nil means nothing is set again.
+	OnModelChange func(e casbin.IDistributedEnforcer)
 }
 
 // NewStore return a instance of Store.
@@ -71,6 +112,17 @@ func NewStore(config *Config) (*Store, error) {
 		logger:                 zap.NewExample(),
 		networkTransportConfig: config.NetworkTransportConfig,
 		enforcer:               config.Enforcer,
+		onModelChange:          config.OnModelChange,
+		checksumInterval:       config.ChecksumInterval,
+		shutdownCh:             make(chan struct{}),
+		stopped:                &stopState{},
+		eventLogConfig:         config.EventLog,
+	}
+	if config.SinkAdapter != nil {
+		s.sink = newSink(s, config.SinkAdapter, config.SinkServerID)
+	}
+	if config.MaxInFlight > 0 {
+		s.inFlight = make(chan struct{}, config.MaxInFlight)
 	}
 
 	return s, nil
@@ -119,11 +171,36 @@ func (s *Store) Start(enableBootstrap bool) error {
 		s.stableStore = boltDB
 	}
 
-	fsm, err := NewFSM(s.dataDir, s.enforcer)
+	fsm, err := newFSM(s.dataDir, s.enforcer, s.onModelChange)
 	if err != nil {
 		s.logger.Error("failed to new fsm", zap.Error(err))
 		return err
 	}
+	s.fsm = fsm
+
+	// The recovery replays the logs into FSM and takes a snapshot of the result, the observers are not notified of
+	// that replay, they are notified when raft restores the snapshot below.
+	if !s.inMemory {
+		err = s.recoverCluster(config)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The observer must be set before raft restores the snapshot.
+	if s.sink != nil {
+		s.sink.loadCursor()
+		fsm.observers = append(fsm.observers, s.sink)
+	}
+	if s.eventLogConfig != nil {
+		eventLog, err := newEventLog(*s.eventLogConfig, s.snapshotStore)
+		if err != nil {
+			s.logger.Error("failed to open the event log", zap.Error(err), zap.String("dir", s.eventLogConfig.Dir))
+			return err
+		}
+		s.eventLog = eventLog
+		fsm.observers = append(fsm.observers, eventLog)
+	}
 
 	ra, err := raft.NewRaft(config, fsm, s.logStore, s.stableStore, s.snapshotStore, s.transport)
 	if err != nil {
@@ -131,6 +208,9 @@ func (s *Store) Start(enableBootstrap bool) error {
 		return err
 	}
 	s.raft = ra
+	if s.sink != nil {
+		go s.sink.run()
+	}
 
 	if enableBootstrap {
 		configuration := raft.Configuration{
@@ -148,12 +228,34 @@ func (s *Store) Start(enableBootstrap bool) error {
 			return f.Error()
 		}
 	}
+	if s.checksumInterval > 0 {
+		go s.runChecksumMonitor()
+	}
+	go s.runLeaderMonitor()
+
 	s.logger.Info(fmt.Sprintf("listening and serving Raft on %s", transport.LocalAddr()))
 	return nil
 }
 
-// Stop is used to close the raft node, which always returns nil.
+// Stop is used to close the raft node. It can be called more than once, the calls after the first one
+// return the result of the first one.
 func (s *Store) Stop() error {
+	s.stopped.once.Do(func() {
+		s.stopped.err = s.stop()
+	})
+	return s.stopped.err
+}
+
+// stopState is the result of the stop of a store, which is returned by every call of Stop.
+type stopState struct {
+	once sync.Once
+	err  error
+}
+
+// stop closes the raft node and the databases.
+func (s *Store) stop() error {
+	close(s.shutdownCh)
+
 	var result error
 	shutdown := s.raft.Shutdown()
 	if shutdown.Error() != nil {
@@ -161,6 +263,11 @@ func (s *Store) Stop() error {
 		result = multierror.Append(result, shutdown.Error())
 	}
 
+	// The sink uses the stable store until it exits.
+	if s.sink != nil {
+		<-s.sink.doneCh
+	}
+
 	if !s.inMemory {
 		err := s.boltStore.Close()
 		if err != nil {
@@ -169,6 +276,20 @@ func (s *Store) Stop() error {
 		}
 	}
 
+	err := s.fsm.policyOperator.Close()
+	if err != nil {
+		s.logger.Error("failed to close the policy database", zap.Error(err))
+		result = multierror.Append(result, err)
+	}
+
+	if s.eventLog != nil {
+		err = s.eventLog.Close()
+		if err != nil {
+			s.logger.Error("failed to close the event log", zap.Error(err))
+			result = multierror.Append(result, err)
+		}
+	}
+
 	return result
 }
 
@@ -221,99 +342,624 @@ func (s *Store) DataDir() string {
 	return s.dataDir
 }
 
-// applyProtoMessage applies a proto message.
-func (s *Store) applyProtoMessage(m proto.Message) error {
-	cmd, err := proto.Marshal(m)
+// applyTimeout returns the timeout of a raft operation, which is raftTimeout shortened to the deadline of ctx.
+func applyTimeout(ctx context.Context) (time.Duration, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	timeout := raftTimeout
+	if deadline, ok := ctx.Deadline(); ok {
+		left := time.Until(deadline)
+		if left <= 0 {
+			return 0, context.DeadlineExceeded
+		}
+		if left < timeout {
+			timeout = left
+		}
+	}
+	return timeout, nil
+}
+
+// waitFuture waits for a raft future until ctx is done.
+// The operation is not cancelled by ctx, an entry that has been enqueued may still be applied after ctx is done.
+func waitFuture(ctx context.Context, f raft.Future) error {
+	if ctx.Done() == nil {
+		return raftError(f.Error())
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f.Error()
+	}()
+	select {
+	case err := <-done:
+		if err == raft.ErrEnqueueTimeout && ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return raftError(err)
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// raftError returns the errors of raft that tell whether a request has been applied with their codes of http.Error.
+// The other errors are returned as they are.
+func raftError(err error) error {
+	switch err {
+	case raft.ErrNotLeader, raft.ErrLeadershipTransferInProgress:
+		return http.NewError(http.ErrorCodeNotLeader, err.Error())
+	case raft.ErrEnqueueTimeout:
+		return http.NewError(http.ErrorCodeTimeout, err.Error())
+	}
+	return err
+}
+
+// fsmError returns an error returned by FSM with its code of http.Error, the command has not changed the state.
+// The recorded result of a command with a request ID is already an *http.Error.
+func fsmError(err error) error {
+	if e, ok := err.(*http.Error); ok {
+		return e
+	}
+	if errors.Cause(err) == errNamespaceExists {
+		return http.NewError(http.ErrorCodeConflict, err.Error())
+	}
+	if errors.Cause(err) == errNamespaceFrozen {
+		return http.NewError(http.ErrorCodeUnavailable, err.Error())
+	}
+	return http.NewError(http.ErrorCodeRejected, err.Error())
+}
+
+// acquire takes a slot of the in-flight writes, the returned function releases it.
+// It fails with the overloaded code if all slots are taken.
+func (s *Store) acquire() (func(), error) {
+	if s.inFlight == nil {
+		return func() {}, nil
+	}
+	select {
+	case s.inFlight <- struct{}{}:
+		return func() { <-s.inFlight }, nil
+	default:
+		return nil, http.NewError(http.ErrorCodeOverloaded, fmt.Sprintf("%d writes are being applied", cap(s.inFlight)))
+	}
+}
+
+// applyProtoMessage applies a proto message, the error returned by FSM is returned as well.
+// It fails without applying the message if too many writes are being applied, see Config.MaxInFlight.
+func (s *Store) applyProtoMessage(ctx context.Context, m proto.Message) error {
+	release, err := s.acquire()
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	resp, _, err := s.applyProtoMessageWithResponse(ctx, m)
 	if err != nil {
 		return err
 	}
-	return s.raft.Apply(cmd, raftTimeout).Error()
+	if err, ok := resp.(error); ok {
+		return fsmError(err)
+	}
+	return nil
+}
+
+// applyProtoMessageWithResponse applies a proto message, returns the response of FSM and the index of the log.
+// The entry is enqueued within the timeout of applyTimeout, and then it is waited until ctx is done.
+func (s *Store) applyProtoMessageWithResponse(ctx context.Context, m proto.Message) (interface{}, uint64, error) {
+	cmd, err := proto.Marshal(m)
+	if err != nil {
+		return nil, 0, err
+	}
+	timeout, err := applyTimeout(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	f := s.raft.Apply(cmd, timeout)
+	if err := waitFuture(ctx, f); err != nil {
+		return nil, 0, err
+	}
+	return f.Response(), f.Index(), nil
 }
 
 // AddPolicy implements the http.Store interface.
-func (s *Store) AddPolicies(request *command.AddPoliciesRequest) error {
+func (s *Store) AddPolicies(ctx context.Context, request *command.AddPoliciesRequest) error {
+	if err := s.validateStringArrays(request.Sec, request.PType, request.Rules); err != nil {
+		return err
+	}
 	data, err := proto.Marshal(request)
 	if err != nil {
 		return err
 	}
 	cmd := &command.Command{
-		Type: command.Command_COMMAND_TYPE_ADD_POLICIES,
-		Data: data,
+		Type:      command.Command_COMMAND_TYPE_ADD_POLICIES,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
 	}
-	return s.applyProtoMessage(cmd)
+	return s.applyProtoMessage(ctx, cmd)
 }
 
 // RemovePolicies implements the http.Store interface.
-func (s *Store) RemovePolicies(request *command.RemovePoliciesRequest) error {
+func (s *Store) RemovePolicies(ctx context.Context, request *command.RemovePoliciesRequest) error {
+	if err := s.validateStringArrays(request.Sec, request.PType, request.Rules); err != nil {
+		return err
+	}
 	data, err := proto.Marshal(request)
 	if err != nil {
 		return err
 	}
 	cmd := &command.Command{
-		Type: command.Command_COMMAND_TYPE_REMOVE_POLICIES,
-		Data: data,
+		Type:      command.Command_COMMAND_TYPE_REMOVE_POLICIES,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
 	}
-	return s.applyProtoMessage(cmd)
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// ApplyBatch implements the http.Store interface.
+// The entries of the writes are enqueued one after another without waiting, so that raft appends and replicates
+// them together, and then they are waited until ctx is done. The batch takes one slot of Config.MaxInFlight like
+// a single write, so that a full batch is never split by the limit, all writes fail with the overloaded code if
+// there is no slot.
+func (s *Store) ApplyBatch(ctx context.Context, request *command.BatchRequest) []error {
+	errs := make([]error, len(request.Items))
+	timeout, err := applyTimeout(ctx)
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	release, err := s.acquire()
+	if err != nil {
+		for i := range errs {
+			errs[i] = err
+		}
+		return errs
+	}
+	defer release()
+
+	futures := make([]raft.ApplyFuture, len(request.Items))
+	for i, item := range request.Items {
+		cmd, err := s.batchCommand(item)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		b, err := proto.Marshal(cmd)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+		futures[i] = s.raft.Apply(b, timeout)
+	}
+
+	for i, f := range futures {
+		if f == nil {
+			continue
+		}
+		if err := waitFuture(ctx, f); err != nil {
+			errs[i] = err
+			continue
+		}
+		if err, ok := f.Response().(error); ok {
+			errs[i] = fsmError(err)
+		}
+	}
+	return errs
+}
+
+// batchCommand returns the command of a write of a batch.
+func (s *Store) batchCommand(item *command.BatchItem) (*command.Command, error) {
+	var (
+		t       command.Command_Type
+		request proto.Message
+	)
+	var err error
+	switch {
+	case item.AddPolicies != nil && item.RemovePolicies == nil:
+		t, request = command.Command_COMMAND_TYPE_ADD_POLICIES, item.AddPolicies
+		err = s.validateStringArrays(item.AddPolicies.Sec, item.AddPolicies.PType, item.AddPolicies.Rules)
+	case item.RemovePolicies != nil && item.AddPolicies == nil:
+		t, request = command.Command_COMMAND_TYPE_REMOVE_POLICIES, item.RemovePolicies
+		err = s.validateStringArrays(item.RemovePolicies.Sec, item.RemovePolicies.PType, item.RemovePolicies.Rules)
+	default:
+		return nil, http.NewError(http.ErrorCodeInvalidRequest, "a write of a batch must set exactly one request")
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+	return &command.Command{
+		Type:      t,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: item.RequestId,
+	}, nil
 }
 
 // RemoveFilteredPolicy implements the http.Store interface.
-func (s *Store) RemoveFilteredPolicy(request *command.RemoveFilteredPolicyRequest) error {
+func (s *Store) RemoveFilteredPolicy(ctx context.Context, request *command.RemoveFilteredPolicyRequest) error {
+	if err := s.validateFilter(request.Sec, request.PType, int(request.FieldIndex), request.FieldValues); err != nil {
+		return err
+	}
 	data, err := proto.Marshal(request)
 	if err != nil {
 		return err
 	}
 	cmd := &command.Command{
-		Type: command.Command_COMMAND_TYPE_REMOVE_FILTERED_POLICY,
-		Data: data,
+		Type:      command.Command_COMMAND_TYPE_REMOVE_FILTERED_POLICY,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
 	}
-	return s.applyProtoMessage(cmd)
+	return s.applyProtoMessage(ctx, cmd)
 }
 
 // UpdatePolicy implements the http.Store interface.
-func (s *Store) UpdatePolicy(request *command.UpdatePolicyRequest) error {
+func (s *Store) UpdatePolicy(ctx context.Context, request *command.UpdatePolicyRequest) error {
+	if err := s.validateRules(request.Sec, request.PType, request.OldRule, request.NewRule); err != nil {
+		return err
+	}
 	data, err := proto.Marshal(request)
 	if err != nil {
 		return err
 	}
 	cmd := &command.Command{
-		Type: command.Command_COMMAND_TYPE_UPDATE_POLICY,
-		Data: data,
+		Type:      command.Command_COMMAND_TYPE_UPDATE_POLICY,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
 	}
-	return s.applyProtoMessage(cmd)
+	return s.applyProtoMessage(ctx, cmd)
 }
 
 // UpdatePolicies implements the http.Store interface.
-func (s *Store) UpdatePolicies(request *command.UpdatePoliciesRequest) error {
+func (s *Store) UpdatePolicies(ctx context.Context, request *command.UpdatePoliciesRequest) error {
+	if err := s.validateStringArrays(request.Sec, request.PType, request.OldRules); err != nil {
+		return err
+	}
+	if err := s.validateStringArrays(request.Sec, request.PType, request.NewRules); err != nil {
+		return err
+	}
 	data, err := proto.Marshal(request)
 	if err != nil {
 		return err
 	}
 	cmd := &command.Command{
-		Type: command.Command_COMMAND_TYPE_UPDATE_POLICIES,
-		Data: data,
+		Type:      command.Command_COMMAND_TYPE_UPDATE_POLICIES,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// MovePolicy implements the http.Store interface.
+func (s *Store) MovePolicy(ctx context.Context, request *command.MovePolicyRequest) error {
+	if err := s.validateRules(request.Sec, request.PType, request.Rule); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_MOVE_POLICY,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// SetModel implements the http.Store interface.
+func (s *Store) SetModel(ctx context.Context, request *command.SetModelRequest) error {
+	err := validateModel(request.Text)
+	if err != nil {
+		return err
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_SET_MODEL,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// Model implements the http.Store interface.
+func (s *Store) Model() (string, error) {
+	operator, err := s.fsm.policyOperator.Namespace(s.namespace)
+	if err != nil {
+		return "", err
+	}
+	return operator.Model()
+}
+
+// AssignRole implements the http.Store interface.
+func (s *Store) AssignRole(ctx context.Context, request *command.AssignRoleRequest) error {
+	if err := s.validateRules("g", request.PType, append([]string{request.User, request.Role}, request.Domain...)); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_ASSIGN_ROLE,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// UnassignRole implements the http.Store interface.
+func (s *Store) UnassignRole(ctx context.Context, request *command.UnassignRoleRequest) error {
+	if err := s.validateRules("g", request.PType, append([]string{request.User, request.Role}, request.Domain...)); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_UNASSIGN_ROLE,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// DeleteRole implements the http.Store interface.
+func (s *Store) DeleteRole(ctx context.Context, request *command.DeleteRoleRequest) error {
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_DELETE_ROLE,
+		Data:      data,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// GetUsersForRole implements the http.Store interface.
+func (s *Store) GetUsersForRole(role string, domain ...string) ([]string, error) {
+	operator, err := s.fsm.policyOperator.Namespace(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	return operator.GetUsersForRole(role, domain...)
+}
+
+// GetRolesForUser implements the http.Store interface.
+func (s *Store) GetRolesForUser(user string, domain ...string) ([]string, error) {
+	operator, err := s.fsm.policyOperator.Namespace(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	return operator.GetRolesForUser(user, domain...)
+}
+
+// GetImplicitRolesForUser implements the http.Store interface.
+func (s *Store) GetImplicitRolesForUser(user string, domain ...string) ([]string, error) {
+	operator, err := s.fsm.policyOperator.Namespace(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	return operator.GetImplicitRolesForUser(user, domain...)
+}
+
+// Enforce implements the http.Store interface.
+func (s *Store) Enforce(rvals ...interface{}) (bool, error) {
+	operator, err := s.fsm.policyOperator.Namespace(s.namespace)
+	if err != nil {
+		return false, err
+	}
+	return operator.Enforce(rvals...)
+}
+
+// WithNamespace implements the http.NamespacedStore interface.
+func (s *Store) WithNamespace(namespace string) http.Store {
+	c := *s
+	c.namespace = namespace
+	return &c
+}
+
+// CreateNamespace implements the http.Store interface.
+func (s *Store) CreateNamespace(ctx context.Context, request *command.CreateNamespaceRequest) error {
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_CREATE_NAMESPACE,
+		Data:      data,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// DeleteNamespace implements the http.Store interface.
+func (s *Store) DeleteNamespace(ctx context.Context, request *command.DeleteNamespaceRequest) error {
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_DELETE_NAMESPACE,
+		Data:      data,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// FreezeNamespace implements the http.Store interface.
+func (s *Store) FreezeNamespace(ctx context.Context, request *command.FreezeNamespaceRequest) error {
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_FREEZE_NAMESPACE,
+		Data:      data,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// Namespaces implements the http.Store interface.
+func (s *Store) Namespaces() []string {
+	return s.fsm.policyOperator.Namespaces()
+}
+
+// Export implements the http.Store interface.
+func (s *Store) Export() (*command.NamespaceExport, error) {
+	operator, err := s.fsm.policyOperator.Namespace(s.namespace)
+	if err != nil {
+		return nil, err
+	}
+	text, rules, err := operator.Export()
+	if err != nil {
+		return nil, err
+	}
+	export := &command.NamespaceExport{
+		Namespace: s.namespace,
+		Model:     text,
+	}
+	for _, rule := range rules {
+		export.Rules = append(export.Rules, &command.PolicyRule{
+			Sec:   rule.Sec,
+			PType: rule.PType,
+			Rule:  rule.Rule,
+		})
+	}
+	return export, nil
+}
+
+// ImportPolicies implements the http.Store interface.
+func (s *Store) ImportPolicies(ctx context.Context, request *command.ImportPoliciesRequest) error {
+	if err := s.validateImport(request.Rules); err != nil {
+		return err
+	}
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_IMPORT_POLICIES,
+		Data:      data,
+		Namespace: s.namespace,
 	}
-	return s.applyProtoMessage(cmd)
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// CommitImport implements the http.Store interface.
+func (s *Store) CommitImport(ctx context.Context, request *command.CommitImportRequest) error {
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_COMMIT_IMPORT,
+		Data:      data,
+		Namespace: s.namespace,
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// Seeded returns whether the cluster has been seeded with the policies of an initial adapter.
+// The current node must be the leader, the entries of the previous leaders are applied first.
+func (s *Store) Seeded() (bool, error) {
+	err := s.raft.Barrier(raftTimeout).Error()
+	if err != nil {
+		return false, err
+	}
+	return s.fsm.policyOperator.Seeded()
+}
+
+// SetRoute routes a namespace to a raft group.
+func (s *Store) SetRoute(ctx context.Context, request *command.SetRouteRequest) error {
+	data, err := proto.Marshal(request)
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type:      command.Command_COMMAND_TYPE_SET_ROUTE,
+		Data:      data,
+		RequestId: http.RequestID(ctx),
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// Routes returns the routes that are set by SetRoute.
+func (s *Store) Routes() (map[string]int, error) {
+	return s.fsm.policyOperator.Routes()
 }
 
 // ClearPolicy implements the http.Store interface.
-func (s *Store) ClearPolicy() error {
+func (s *Store) ClearPolicy(ctx context.Context) error {
 	cmd := &command.Command{
-		Type: command.Command_COMMAND_TYPE_CLEAR_POLICY,
-		Data: nil,
+		Type:      command.Command_COMMAND_TYPE_CLEAR_POLICY,
+		Data:      nil,
+		Namespace: s.namespace,
+		RequestId: http.RequestID(ctx),
 	}
-	return s.applyProtoMessage(cmd)
+	return s.applyProtoMessage(ctx, cmd)
 }
 
 // JoinNode implements the http.Store interface.
-func (s *Store) JoinNode(serverID string, address string) error {
-	i := s.raft.AddVoter(raft.ServerID(serverID), raft.ServerAddress(address), 0, 0)
-	return i.Error()
+func (s *Store) JoinNode(ctx context.Context, serverID string, address string) error {
+	timeout, err := applyTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	return waitFuture(ctx, s.raft.AddVoter(raft.ServerID(serverID), raft.ServerAddress(address), 0, timeout))
 }
 
 // RemoveNode implements the http.Store interface.
-func (s *Store) RemoveNode(serverID string) error {
-	i := s.raft.RemoveServer(raft.ServerID(serverID), 0, 0)
-	return i.Error()
+func (s *Store) RemoveNode(ctx context.Context, serverID string) error {
+	timeout, err := applyTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	return waitFuture(ctx, s.raft.RemoveServer(raft.ServerID(serverID), 0, timeout))
+}
+
+// TransferLeadership implements the http.Store interface.
+// An empty serverID transfers the leadership to the most up-to-date follower.
+func (s *Store) TransferLeadership(ctx context.Context, serverID string) error {
+	if len(serverID) == 0 {
+		return waitFuture(ctx, s.raft.LeadershipTransfer())
+	}
+
+	future := s.raft.GetConfiguration()
+	err := future.Error()
+	if err != nil {
+		return err
+	}
+	for _, server := range future.Configuration().Servers {
+		if server.ID == raft.ServerID(serverID) {
+			return waitFuture(ctx, s.raft.LeadershipTransferToServer(server.ID, server.Address))
+		}
+	}
+	return http.NewError(http.ErrorCodeInvalidRequest, fmt.Sprintf("the node %s does not exist", serverID))
+}
+
+// IsLeader reports whether the current node is the leader, unlike Leader it does not wait for an election.
+func (s *Store) IsLeader() bool {
+	return s.raft.State() == raft.Leader
 }
 
 // Leader implements the http.Store interface.
@@ -321,3 +967,144 @@ func (s *Store) Leader() (bool, string) {
 	_ = s.WaitLeader()
 	return s.raft.State() == raft.Leader, string(s.raft.Leader())
 }
+
+// VerifyChecksum asks all nodes to calculate the checksum of their state at the same log index,
+// and then to compare it with the checksum of the leader. The node whose checksum is different is flagged as diverged.
+func (s *Store) VerifyChecksum() error {
+	ctx := context.Background()
+	resp, index, err := s.applyProtoMessageWithResponse(ctx, &command.Command{
+		Type: command.Command_COMMAND_TYPE_CHECKSUM,
+	})
+	if err != nil {
+		return err
+	}
+	checksum, ok := resp.([]byte)
+	if !ok {
+		if err, ok := resp.(error); ok {
+			return err
+		}
+		return errors.Errorf("unexpected checksum response: %v", resp)
+	}
+
+	data, err := proto.Marshal(&command.VerifyChecksumRequest{
+		Index:    index,
+		Checksum: checksum,
+	})
+	if err != nil {
+		return err
+	}
+	cmd := &command.Command{
+		Type: command.Command_COMMAND_TYPE_VERIFY_CHECKSUM,
+		Data: data,
+	}
+	return s.applyProtoMessage(ctx, cmd)
+}
+
+// runChecksumMonitor periodically verifies the state of all nodes when the current node is the leader.
+func (s *Store) runChecksumMonitor() {
+	ticker := time.NewTicker(s.checksumInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.shutdownCh:
+			return
+		case <-ticker.C:
+			if s.raft.State() != raft.Leader {
+				continue
+			}
+			err := s.VerifyChecksum()
+			if err != nil {
+				s.logger.Error("failed to verify the checksum", zap.Error(err))
+			}
+		}
+	}
+}
+
+// runLeaderMonitor discards the imports left behind by the previous leaders when the current node becomes the leader.
+func (s *Store) runLeaderMonitor() {
+	for {
+		select {
+		case <-s.shutdownCh:
+			return
+		case leader := <-s.raft.LeaderCh():
+			if !leader {
+				continue
+			}
+			err := s.discardStaleImports()
+			if err != nil {
+				s.logger.Error("failed to discard the stale imports", zap.Error(err))
+			}
+		}
+	}
+}
+
+// discardStaleImports discards the imports that were started before the current term, their leader stopped
+// before committing or discarding them, so their staged rules would be kept forever.
+// Nothing is applied if there is no such import.
+func (s *Store) discardStaleImports() error {
+	term, err := strconv.ParseUint(s.raft.Stats()["term"], 10, 64)
+	if err != nil {
+		return err
+	}
+	// The entries of the previous leaders are applied first, so their imports are seen.
+	err = s.raft.Barrier(raftTimeout).Error()
+	if err != nil {
+		return err
+	}
+	stale, err := s.fsm.policyOperator.HasStaleImports(term)
+	if err != nil || !stale {
+		return err
+	}
+	return s.applyProtoMessage(context.Background(), &command.Command{Type: command.Command_COMMAND_TYPE_DISCARD_IMPORTS})
+}
+
+// Status implements the http.Store interface.
+// The members are the servers of the latest cluster configuration that the node knows.
+func (s *Store) Status() *command.NodeStatus {
+	checksumIndex, checksum := s.fsm.LastChecksum()
+	status := &command.NodeStatus{
+		Id:            s.serverID,
+		Address:       string(s.transport.LocalAddr()),
+		Leader:        string(s.raft.Leader()),
+		AppliedIndex:  s.raft.AppliedIndex(),
+		ChecksumIndex: checksumIndex,
+		Checksum:      checksum,
+		Diverged:      s.fsm.Diverged(),
+	}
+
+	future := s.raft.GetConfiguration()
+	if err := future.Error(); err != nil {
+		s.logger.Error("failed to get the cluster configuration", zap.Error(err))
+		return status
+	}
+	for _, server := range future.Configuration().Servers {
+		status.Members = append(status.Members, &command.NodeMember{
+			Id:       string(server.ID),
+			Address:  string(server.Address),
+			Suffrage: server.Suffrage.String(),
+		})
+	}
+	return status
+}
+
+// Repair implements the http.Store interface.
+// It restores the cluster from a snapshot of the leader, which forces the followers to install the snapshot.
+func (s *Store) Repair(ctx context.Context) error {
+	f := s.raft.Snapshot()
+	if err := waitFuture(ctx, f); err != nil && err != raft.ErrNothingNewToSnapshot {
+		return err
+	}
+
+	meta, rc, err := s.openLatestSnapshot()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	timeout, err := applyTimeout(ctx)
+	if err != nil {
+		return err
+	}
+	return raftError(s.raft.Restore(meta, rc, timeout))
+}
@@ -1,19 +1,24 @@
 package store
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"io/ioutil"
 	"os"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/casbin/casbin/v2"
+	"github.com/casbin/casbin/v2/model"
 
 	"github.com/golang/mock/gomock"
 	"github.com/hashicorp/raft"
 	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
 	"github.com/nodece/casbin-hraft-dispatcher/store/mocks"
+	"github.com/pkg/errors"
 	. "github.com/smartystreets/goconvey/convey"
 	"github.com/stretchr/testify/assert"
 )
@@ -45,6 +50,28 @@ func GetTLSConfig() (*tls.Config, error) {
 	return config, nil
 }
 
+// newTestModel returns the model of the rules of the tests, it holds no policy.
+func newTestModel(t *testing.T) model.Model {
+	m, err := model.NewModelFromString(`
+[request_definition]
+r = sub, obj, act
+
+[policy_definition]
+p = sub, obj, act
+
+[role_definition]
+g = _, _
+
+[policy_effect]
+e = some(where (p.eft == allow))
+
+[matchers]
+m = g(r.sub, p.sub) && r.obj == p.obj && r.act == p.act
+`)
+	assert.NoError(t, err)
+	return m
+}
+
 func TestStore_SingleNode(t *testing.T) {
 	ctl := gomock.NewController(t)
 	defer ctl.Finish()
@@ -54,6 +81,10 @@ func TestStore_SingleNode(t *testing.T) {
 
 	raftAddress := GetLocalIP() + ":6790"
 
+	// The model is read when the store loads the policy and when it validates the writes.
+	enforcer.EXPECT().GetModel().Return(newTestModel(t)).AnyTimes()
+	enforcer.EXPECT().ClearPolicySelf(nil).Return(nil)
+	enforcer.EXPECT().BuildRoleLinks().Return(nil)
 	store, err := newStore(enforcer, raftID, raftAddress, true)
 	assert.NoError(t, err)
 	defer store.Stop()
@@ -79,7 +110,7 @@ func TestStore_SingleNode(t *testing.T) {
 			}
 
 			enforcer.EXPECT().AddPoliciesSelf(nil, sec, pType, originalRules).Return(originalRules, nil)
-			err := store.AddPolicies(request)
+			err := store.AddPolicies(context.Background(), request)
 			So(err, ShouldBeNil)
 		})
 
@@ -99,7 +130,7 @@ func TestStore_SingleNode(t *testing.T) {
 			}
 
 			enforcer.EXPECT().RemovePoliciesSelf(nil, sec, pType, originalRules).Return(originalRules, nil)
-			err := store.RemovePolicies(request)
+			err := store.RemovePolicies(context.Background(), request)
 			So(err, ShouldBeNil)
 		})
 
@@ -118,7 +149,7 @@ func TestStore_SingleNode(t *testing.T) {
 			}
 
 			enforcer.EXPECT().RemoveFilteredPolicySelf(nil, sec, pType, fieldIndex, fieldValues).Return(effected, nil)
-			err := store.RemoveFilteredPolicy(request)
+			err := store.RemoveFilteredPolicy(context.Background(), request)
 			So(err, ShouldBeNil)
 		})
 
@@ -136,16 +167,134 @@ func TestStore_SingleNode(t *testing.T) {
 			}
 
 			enforcer.EXPECT().UpdatePolicySelf(nil, sec, pType, oldRule, newRule).Return(true, nil)
-			err := store.UpdatePolicy(request)
+			err := store.UpdatePolicy(context.Background(), request)
 			So(err, ShouldBeNil)
 		})
 
 		Convey("ClearPolicy()", func() {
 			enforcer.EXPECT().ClearPolicySelf(nil).Return(nil)
-			err := store.ClearPolicy()
+			err := store.ClearPolicy(context.Background())
 			So(err, ShouldBeNil)
 		})
 
+		Convey("MovePolicy() of a rule that does not exist", func() {
+			err := store.MovePolicy(context.Background(), &command.MovePolicyRequest{
+				Sec:   "p",
+				PType: "p",
+				Rule:  []string{"bob", "/", "GET"},
+			})
+			So(errors.Cause(err), ShouldEqual, http.ErrRejected)
+			So(err.Error(), ShouldEqual, "the rule does not exist")
+		})
+
+		Convey("ApplyBatch()", func() {
+			rules := []*command.StringArray{{Items: []string{"bob", "/", "GET"}}}
+			gomock.InOrder(
+				enforcer.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"bob", "/", "GET"}}).Return([][]string{{"bob", "/", "GET"}}, nil),
+				enforcer.EXPECT().RemovePoliciesSelf(nil, "p", "p", [][]string{{"bob", "/", "GET"}}).Return([][]string{{"bob", "/", "GET"}}, nil),
+			)
+			errs := store.ApplyBatch(context.Background(), &command.BatchRequest{
+				Items: []*command.BatchItem{
+					{AddPolicies: &command.AddPoliciesRequest{Sec: "p", PType: "p", Rules: rules}},
+					{},
+					{RemovePolicies: &command.RemovePoliciesRequest{Sec: "p", PType: "p", Rules: rules}},
+				},
+			})
+			So(errs, ShouldHaveLength, 3)
+			So(errs[0], ShouldBeNil)
+			So(errors.Cause(errs[1]), ShouldEqual, http.ErrInvalidRequest)
+			So(errs[2], ShouldBeNil)
+		})
+
+		Convey("AddPolicies() with a request ID", func() {
+			request := &command.AddPoliciesRequest{
+				Sec:   "p",
+				PType: "p",
+				Rules: []*command.StringArray{{Items: []string{"eve", "/", "GET"}}},
+			}
+			enforcer.EXPECT().AddPoliciesSelf(nil, "p", "p", [][]string{{"eve", "/", "GET"}}).Return([][]string{{"eve", "/", "GET"}}, nil).Times(1)
+
+			ctx := http.WithRequestID(context.Background(), "add-eve")
+			err := store.AddPolicies(ctx, request)
+			So(err, ShouldBeNil)
+			err = store.AddPolicies(ctx, request)
+			So(err, ShouldBeNil)
+			// The ID of a different write is a conflict, the write is not applied.
+			err = store.AddPolicies(ctx, &command.AddPoliciesRequest{
+				Sec:   "p",
+				PType: "p",
+				Rules: []*command.StringArray{{Items: []string{"mallory", "/", "GET"}}},
+			})
+			So(errors.Cause(err), ShouldEqual, http.ErrConflict)
+
+			mallory := []*command.StringArray{{Items: []string{"mallory", "/", "GET"}}}
+			enforcer.EXPECT().RemovePoliciesSelf(nil, "p", "p", [][]string{{"mallory", "/", "GET"}}).Return(nil, errors.New("not found")).Times(1)
+			ctx = http.WithRequestID(context.Background(), "remove-mallory")
+			err = store.RemovePolicies(ctx, &command.RemovePoliciesRequest{Sec: "p", PType: "p", Rules: mallory})
+			So(err, ShouldNotBeNil)
+			retry := store.RemovePolicies(ctx, &command.RemovePoliciesRequest{Sec: "p", PType: "p", Rules: mallory})
+			So(retry, ShouldNotBeNil)
+			So(retry.Error(), ShouldEqual, err.Error())
+
+			enforcer.EXPECT().RemovePoliciesSelf(nil, "p", "p", [][]string{{"eve", "/", "GET"}}).Return([][]string{{"eve", "/", "GET"}}, nil).Times(1)
+			ctx = http.WithRequestID(context.Background(), "remove-eve")
+			err = store.RemovePolicies(ctx, &command.RemovePoliciesRequest{Sec: "p", PType: "p", Rules: request.Rules})
+			So(err, ShouldBeNil)
+			err = store.RemovePolicies(ctx, &command.RemovePoliciesRequest{Sec: "p", PType: "p", Rules: request.Rules})
+			So(err, ShouldBeNil)
+		})
+
+		Convey("Writes with invalid rules", func() {
+			rules := func(rules ...[]string) []*command.StringArray {
+				var items []*command.StringArray
+				for _, rule := range rules {
+					items = append(items, &command.StringArray{Items: rule})
+				}
+				return items
+			}
+			tooMany := make([][]string, http.MaxRulesPerRequest+1)
+			for i := range tooMany {
+				tooMany[i] = []string{"alice", "/", "GET"}
+			}
+
+			// None of the writes reaches the enforcer.
+			errs := []error{
+				store.AddPolicies(context.Background(), &command.AddPoliciesRequest{Sec: "p", PType: "p2", Rules: rules([]string{"alice", "/", "GET"})}),
+				store.AddPolicies(context.Background(), &command.AddPoliciesRequest{Sec: "r", PType: "r", Rules: rules([]string{"alice", "/", "GET"})}),
+				store.AddPolicies(context.Background(), &command.AddPoliciesRequest{Sec: "p", PType: "p", Rules: rules([]string{"alice", "/"})}),
+				store.AddPolicies(context.Background(), &command.AddPoliciesRequest{Sec: "p", PType: "p"}),
+				store.AddPolicies(context.Background(), &command.AddPoliciesRequest{Sec: "p", PType: "p", Rules: rules(tooMany...)}),
+				store.RemovePolicies(context.Background(), &command.RemovePoliciesRequest{Sec: "g", PType: "g", Rules: rules([]string{"alice", "admin", "domain1"})}),
+				store.RemoveFilteredPolicy(context.Background(), &command.RemoveFilteredPolicyRequest{Sec: "p", PType: "p", FieldIndex: 2, FieldValues: []string{"GET", "x"}}),
+				store.UpdatePolicy(context.Background(), &command.UpdatePolicyRequest{Sec: "p", PType: "p", OldRule: []string{"alice", "/", "GET"}, NewRule: []string{"alice"}}),
+				store.AssignRole(context.Background(), &command.AssignRoleRequest{PType: "g", User: "alice", Role: "admin", Domain: []string{"domain1"}}),
+				store.ImportPolicies(context.Background(), &command.ImportPoliciesRequest{Rules: []*command.PolicyRule{{Sec: "g", PType: "g2", Rule: []string{"alice", "admin"}}}}),
+				store.SetModel(context.Background(), &command.SetModelRequest{Text: "[request_definition]"}),
+			}
+			for _, err := range errs {
+				So(errors.Cause(err), ShouldEqual, http.ErrInvalidRequest)
+			}
+			So(errs[2].Error(), ShouldEqual, "the rule [alice /] has 2 fields, p of section p has 3")
+
+			batchErrs := store.ApplyBatch(context.Background(), &command.BatchRequest{
+				Items: []*command.BatchItem{{AddPolicies: &command.AddPoliciesRequest{Sec: "p", PType: "p", Rules: rules([]string{"alice"})}}},
+			})
+			So(batchErrs, ShouldHaveLength, 1)
+			So(errors.Cause(batchErrs[0]), ShouldEqual, http.ErrInvalidRequest)
+		})
+
+		Convey("ClearPolicy() with a done context", func() {
+			ctx, cancel := context.WithCancel(context.Background())
+			cancel()
+			err := store.ClearPolicy(ctx)
+			So(err, ShouldEqual, context.Canceled)
+
+			ctx, cancel = context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+			defer cancel()
+			err = store.JoinNode(ctx, "node2", "127.0.0.1:6999")
+			So(err == context.DeadlineExceeded, ShouldBeTrue)
+		})
+
 		Convey("ID()", func() {
 			assert.Equal(t, raftID, store.ID())
 			So(store.ID(), ShouldEqual, raftID)
@@ -166,7 +315,108 @@ func TestStore_SingleNode(t *testing.T) {
 			ok := store.IsInitializedCluster()
 			So(ok, ShouldBeTrue)
 		})
+
+		Convey("VerifyChecksum()", func() {
+			err := store.VerifyChecksum()
+			So(err, ShouldBeNil)
+
+			status := store.Status()
+			So(status.Id, ShouldEqual, raftID)
+			So(status.Leader, ShouldEqual, raftAddress)
+			So(status.ChecksumIndex, ShouldBeGreaterThan, 0)
+			So(status.Checksum, ShouldNotBeEmpty)
+			So(status.Diverged, ShouldBeFalse)
+			So(status.Members, ShouldHaveLength, 1)
+			So(status.Members[0].Id, ShouldEqual, raftID)
+			So(status.Members[0].Address, ShouldEqual, raftAddress)
+			So(status.Members[0].Suffrage, ShouldEqual, "Voter")
+		})
+
+		Convey("Repair()", func() {
+			enforcer.EXPECT().ClearPolicySelf(nil).Return(nil)
+			enforcer.EXPECT().BuildRoleLinks().Return(nil)
+			err := store.Repair(context.Background())
+			So(err, ShouldBeNil)
+		})
+	})
+}
+
+func TestStore_MaxInFlight(t *testing.T) {
+	s, err := NewStore(&Config{MaxInFlight: 1})
+	assert.NoError(t, err)
+
+	release, err := s.acquire()
+	assert.NoError(t, err)
+
+	// the namespaces share the slots of the raft group.
+	_, err = s.WithNamespace("tenant1").(*Store).acquire()
+	assert.Equal(t, http.ErrOverloaded, errors.Cause(err))
+	assert.Equal(t, http.ErrorCodeOverloaded, http.CodeOf(err))
+
+	// a batch takes one slot, all its writes fail if there is none.
+	errs := s.ApplyBatch(context.Background(), &command.BatchRequest{Items: []*command.BatchItem{
+		{AddPolicies: &command.AddPoliciesRequest{Sec: "p", PType: "p"}},
+		{RemovePolicies: &command.RemovePoliciesRequest{Sec: "p", PType: "p"}},
+	}})
+	assert.Len(t, errs, 2)
+	for _, err := range errs {
+		assert.Equal(t, http.ErrOverloaded, errors.Cause(err))
+	}
+
+	release()
+	release, err = s.WithNamespace("tenant1").(*Store).acquire()
+	assert.NoError(t, err)
+	release()
+
+	s, err = NewStore(&Config{})
+	assert.NoError(t, err)
+	for i := 0; i < 3; i++ {
+		_, err = s.acquire()
+		assert.NoError(t, err)
+	}
+}
+
+func TestStore_DiscardStaleImports(t *testing.T) {
+	dir, err := ioutil.TempDir("", "casbin-hraft-")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	e, err := casbin.NewDistributedEnforcer(newTestModel(t))
+	assert.NoError(t, err)
+	s, err := NewStore(&Config{
+		ID:  "node",
+		Dir: dir,
+		NetworkTransportConfig: &raft.NetworkTransportConfig{
+			Stream:  newTCPStreamLayer(t),
+			MaxPool: 5,
+			Timeout: 10 * time.Second,
+		},
+		Enforcer: e,
 	})
+	assert.NoError(t, err)
+	assert.NoError(t, s.Start(true))
+	defer s.Stop()
+	assert.NoError(t, s.WaitLeader())
+
+	// The import staged in an earlier term is left behind by a stopped leader.
+	err = s.fsm.policyOperator.ImportPolicies("stale", 0, []Rule{{Sec: "p", PType: "p", Rule: []string{"alice", "/", "GET"}}})
+	assert.NoError(t, err)
+	err = s.ImportPolicies(context.Background(), &command.ImportPoliciesRequest{Id: "current", Rules: []*command.PolicyRule{{Sec: "p", PType: "p", Rule: []string{"bob", "/", "GET"}}}})
+	assert.NoError(t, err)
+
+	assert.NoError(t, s.discardStaleImports())
+	term, err := strconv.ParseUint(s.raft.Stats()["term"], 10, 64)
+	assert.NoError(t, err)
+	stale, err := s.fsm.policyOperator.HasStaleImports(term)
+	assert.NoError(t, err)
+	assert.False(t, stale)
+
+	// The import of the current leader is kept.
+	err = s.CommitImport(context.Background(), &command.CommitImportRequest{Id: "current"})
+	assert.NoError(t, err)
+	ok, err := s.Enforce("bob", "/", "GET")
+	assert.NoError(t, err)
+	assert.True(t, ok)
 }
 
 func TestStore_MultipleNode(t *testing.T) {
@@ -187,16 +437,22 @@ func TestStore_MultipleNode(t *testing.T) {
 	leaderID := "node-leader"
 	followerID := "node-follower"
 
+	leaderEnforcer.EXPECT().GetModel().Return(newTestModel(t)).AnyTimes()
+	leaderEnforcer.EXPECT().ClearPolicySelf(nil).Return(nil)
+	leaderEnforcer.EXPECT().BuildRoleLinks().Return(nil)
 	leaderStore, err := newStore(leaderEnforcer, leaderID, leaderAddress, true)
 	assert.NoError(t, err)
 
 	err = leaderStore.WaitLeader()
 	assert.NoError(t, err)
 
+	followerEnforcer.EXPECT().GetModel().Return(model.Model{})
+	followerEnforcer.EXPECT().ClearPolicySelf(nil).Return(nil)
+	followerEnforcer.EXPECT().BuildRoleLinks().Return(nil)
 	followerStore, err := newStore(followerEnforcer, followerID, followerAddress, false)
 	assert.NoError(t, err)
 
-	err = leaderStore.JoinNode(followerStore.ID(), followerStore.Address())
+	err = leaderStore.JoinNode(context.Background(), followerStore.ID(), followerStore.Address())
 	assert.NoError(t, err)
 
 	err = followerStore.WaitLeader()
@@ -220,7 +476,7 @@ func TestStore_MultipleNode(t *testing.T) {
 
 			leaderEnforcer.EXPECT().AddPoliciesSelf(nil, sec, pType, originalRules).Return(originalRules, nil)
 			followerEnforcer.EXPECT().AddPoliciesSelf(nil, sec, pType, originalRules).Return(originalRules, nil)
-			err := leaderStore.AddPolicies(request)
+			err := leaderStore.AddPolicies(context.Background(), request)
 			So(err, ShouldBeNil)
 
 			// Waiting for synchronization data to follow node.
@@ -244,7 +500,7 @@ func TestStore_MultipleNode(t *testing.T) {
 
 			leaderEnforcer.EXPECT().RemovePoliciesSelf(nil, sec, pType, originalRules).Return(originalRules, nil)
 			followerEnforcer.EXPECT().RemovePoliciesSelf(nil, sec, pType, originalRules).Return(originalRules, nil)
-			err := leaderStore.RemovePolicies(request)
+			err := leaderStore.RemovePolicies(context.Background(), request)
 			So(err, ShouldBeNil)
 
 			// Waiting for synchronization data to follow node.
@@ -267,7 +523,7 @@ func TestStore_MultipleNode(t *testing.T) {
 
 			leaderEnforcer.EXPECT().RemoveFilteredPolicySelf(nil, sec, pType, fieldIndex, fieldValues).Return(effected, nil)
 			followerEnforcer.EXPECT().RemoveFilteredPolicySelf(nil, sec, pType, fieldIndex, fieldValues).Return(effected, nil)
-			err := leaderStore.RemoveFilteredPolicy(request)
+			err := leaderStore.RemoveFilteredPolicy(context.Background(), request)
 			So(err, ShouldBeNil)
 
 			// Waiting for synchronization data to follow node.
@@ -289,7 +545,7 @@ func TestStore_MultipleNode(t *testing.T) {
 
 			leaderEnforcer.EXPECT().UpdatePolicySelf(nil, sec, pType, oldRule, newRule).Return(true, nil)
 			followerEnforcer.EXPECT().UpdatePolicySelf(nil, sec, pType, oldRule, newRule).Return(true, nil)
-			err := leaderStore.UpdatePolicy(request)
+			err := leaderStore.UpdatePolicy(context.Background(), request)
 			So(err, ShouldBeNil)
 
 			// Waiting for synchronization data to follow node.
@@ -299,7 +555,7 @@ func TestStore_MultipleNode(t *testing.T) {
 		Convey("ClearPolicy()", func() {
 			leaderEnforcer.EXPECT().ClearPolicySelf(nil).Return(nil)
 			followerEnforcer.EXPECT().ClearPolicySelf(nil).Return(nil)
-			err := leaderStore.ClearPolicy()
+			err := leaderStore.ClearPolicy(context.Background())
 			So(err, ShouldBeNil)
 
 			// Waiting for synchronization data to follow node.
@@ -327,7 +583,7 @@ func TestStore_MultipleNode(t *testing.T) {
 		})
 
 		Convey("RemoveNode()", func() {
-			err := leaderStore.RemoveNode(followerAddress)
+			err := leaderStore.RemoveNode(context.Background(), followerAddress)
 			So(err, ShouldBeNil)
 		})
 
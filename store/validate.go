@@ -0,0 +1,122 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/casbin/casbin/v2/model"
+	"github.com/nodece/casbin-hraft-dispatcher/command"
+	"github.com/nodece/casbin-hraft-dispatcher/http"
+	"github.com/pkg/errors"
+)
+
+// fields returns the number of fields of the rules of pType in section sec, which are the tokens of a policy
+// definition or the arguments of a role definition of the model.
+func (p *PolicyOperator) fields(sec, pType string) (int, error) {
+	if sec != "p" && sec != "g" {
+		return 0, errors.Errorf("%s is not a policy section", sec)
+	}
+
+	p.l.RLock()
+	defer p.l.RUnlock()
+
+	ast, ok := p.enforcer.GetModel()[sec][pType]
+	if !ok {
+		return 0, errors.Errorf("the model does not define %s in section %s", pType, sec)
+	}
+	if sec == "g" {
+		return strings.Count(ast.Value, "_"), nil
+	}
+	return len(ast.Tokens), nil
+}
+
+// invalidRequest returns an error of the invalid_request code with a formatted message.
+func invalidRequest(format string, args ...interface{}) error {
+	return http.NewError(http.ErrorCodeInvalidRequest, fmt.Sprintf(format, args...))
+}
+
+// fieldsOf returns the number of fields of pType in section sec of the model of the namespace.
+// It returns -1 if the namespace does not exist, then the write is rejected by the FSM.
+func (s *Store) fieldsOf(sec, pType string) (int, error) {
+	operator, err := s.fsm.policyOperator.Namespace(s.namespace)
+	if err != nil {
+		return -1, nil
+	}
+	n, err := operator.fields(sec, pType)
+	if err != nil {
+		return 0, http.NewError(http.ErrorCodeInvalidRequest, err.Error())
+	}
+	return n, nil
+}
+
+// validateRules checks the rules of a write before it is proposed to raft, so the log only holds the rules that
+// the model defines. There must be 1 to http.MaxRulesPerRequest rules, each with a field for each token of pType.
+func (s *Store) validateRules(sec, pType string, rules ...[]string) error {
+	if len(rules) == 0 {
+		return invalidRequest("no rules are given")
+	}
+	if len(rules) > http.MaxRulesPerRequest {
+		return invalidRequest("%d rules are given, at most %d rules can be written at once", len(rules), http.MaxRulesPerRequest)
+	}
+	n, err := s.fieldsOf(sec, pType)
+	if err != nil || n < 0 {
+		return err
+	}
+	for _, rule := range rules {
+		if len(rule) != n {
+			return invalidRequest("the rule %v has %d fields, %s of section %s has %d", rule, len(rule), pType, sec, n)
+		}
+	}
+	return nil
+}
+
+// validateStringArrays checks the rules of a write that are given as string arrays, see validateRules.
+func (s *Store) validateStringArrays(sec, pType string, rules []*command.StringArray) error {
+	items := make([][]string, len(rules))
+	for i, rule := range rules {
+		items[i] = rule.GetItems()
+	}
+	return s.validateRules(sec, pType, items...)
+}
+
+// validateFilter checks that the field values of a filter are within the fields of pType.
+func (s *Store) validateFilter(sec, pType string, fieldIndex int, fieldValues []string) error {
+	n, err := s.fieldsOf(sec, pType)
+	if err != nil || n < 0 {
+		return err
+	}
+	if fieldIndex < 0 || fieldIndex+len(fieldValues) > n {
+		return invalidRequest("the field values from index %d do not fit in the %d fields of %s of section %s", fieldIndex, n, pType, sec)
+	}
+	return nil
+}
+
+// validateImport checks the rules of an import chunk, see validateRules. The size of a chunk is limited by the importer.
+func (s *Store) validateImport(rules []*command.PolicyRule) error {
+	fields := make(map[string]int)
+	for _, rule := range rules {
+		key := rule.Sec + "." + rule.PType
+		n, ok := fields[key]
+		if !ok {
+			var err error
+			n, err = s.fieldsOf(rule.Sec, rule.PType)
+			if err != nil || n < 0 {
+				return err
+			}
+			fields[key] = n
+		}
+		if len(rule.Rule) != n {
+			return invalidRequest("the rule %v has %d fields, %s of section %s has %d", rule.Rule, len(rule.Rule), rule.PType, rule.Sec, n)
+		}
+	}
+	return nil
+}
+
+// validateModel checks that the model text can be parsed, so an invalid model is not proposed to raft.
+func validateModel(text string) error {
+	_, err := model.NewModelFromString(text)
+	if err != nil {
+		return invalidRequest("invalid model: %s", err)
+	}
+	return nil
+}